@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/dknowles2/gdrive_sync/gdrive"
+	"github.com/dknowles2/gdrive_sync/uploader"
+)
+
+var (
+	accounts             = flag.String("accounts", "", "Comma-separated name=token_file pairs of extra Google accounts to spread uploads across; each needs its own --output_dir folder, in a shared drive with name=token_file;shared_drive=id")
+	accountPolicy        = flag.String("account_policy", uploader.RoundRobin, "How uploads are spread across --accounts: \"round_robin\", or \"fill\" to use each account until --account_fill_threshold")
+	destinations         = flag.String("destinations", "", "Comma-separated name=folder destinations every file is also uploaded to, in the same account or, with name=folder;token=token_file, another, and in a shared drive with ;shared_drive=id; files are only removed once every destination has them")
+	accountFillThreshold = flag.Float64("account_fill_threshold", 0.95, "With --account_policy=fill, the fraction of an account's storage quota to fill before moving to the next")
+)
+
+// extraAccounts parses --accounts and authorizes each account.
+func extraAccounts(ctx context.Context) ([]uploader.Account, error) {
+	if *accounts == "" {
+		return nil, nil
+	}
+	var as []uploader.Account
+	seen := map[string]bool{uploader.DefaultAccount: true}
+	for _, spec := range strings.Split(*accounts, ",") {
+		i := strings.Index(spec, "=")
+		if i <= 0 || i == len(spec)-1 {
+			return nil, fmt.Errorf("invalid --accounts entry %q, want name=token_file", spec)
+		}
+		opts := strings.Split(spec[i+1:], ";")
+		name, tokenPath := spec[:i], opts[0]
+		if tokenPath == "" {
+			return nil, fmt.Errorf("invalid --accounts entry %q, want name=token_file", spec)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate account name %q", name)
+		}
+		seen[name] = true
+		cfg := driveConfig(*credsFile, tokenPath)
+		for _, o := range opts[1:] {
+			if !strings.HasPrefix(o, "shared_drive=") || len(o) == len("shared_drive=") {
+				return nil, fmt.Errorf("invalid --accounts entry %q: unknown option %q", spec, o)
+			}
+			cfg.SharedDriveId = strings.TrimPrefix(o, "shared_drive=")
+		}
+		d, err := cfg.Service(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("account %s: %w", name, err)
+		}
+		as = append(as, uploader.Account{Name: name, Drive: d})
+	}
+	return as, nil
+}
+
+// parseDestinations parses name=folder[;token=token_file][;shared_drive=id]
+// destinations of p. Those in another account or drive than p's output
+// folder are authorized with p's credentials.
+func parseDestinations(ctx context.Context, p syncPair) ([]uploader.Destination, error) {
+	var ds []uploader.Destination
+	for _, spec := range p.Destinations {
+		i := strings.Index(spec, "=")
+		if i <= 0 || i == len(spec)-1 {
+			return nil, fmt.Errorf("invalid destination %q, want name=folder", spec)
+		}
+		opts := strings.Split(spec[i+1:], ";")
+		dst := uploader.Destination{Name: spec[:i], Folder: opts[0]}
+		var tokenPath, sharedDrive string
+		for _, o := range opts[1:] {
+			switch {
+			case strings.HasPrefix(o, "token=") && len(o) > len("token="):
+				tokenPath = strings.TrimPrefix(o, "token=")
+			case strings.HasPrefix(o, "shared_drive=") && len(o) > len("shared_drive="):
+				sharedDrive = strings.TrimPrefix(o, "shared_drive=")
+			default:
+				return nil, fmt.Errorf("invalid destination %q: unknown option %q", spec, o)
+			}
+		}
+		if dst.Folder == "" {
+			return nil, fmt.Errorf("invalid destination %q, want name=folder", spec)
+		}
+		// Otherwise the destination is found with the Uploader's own
+		// service.
+		if tokenPath != "" || sharedDrive != p.SharedDriveId {
+			if tokenPath == "" {
+				tokenPath = p.TokenFile
+			}
+			cfg := driveConfig(p.CredsFile, tokenPath)
+			cfg.SharedDriveId = sharedDrive
+			d, err := cfg.Service(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("destination %s: %w", dst.Name, err)
+			}
+			dst.Drive = d
+		}
+		ds = append(ds, dst)
+	}
+	return ds, nil
+}
+
+// accountServices returns a Drive service for every configured account,
+// keyed by the name recorded in the manifest ("" for the default account).
+func accountServices(ctx context.Context) (map[string]*gdrive.Drive, error) {
+	d, err := flagPair().driveConfig().Service(ctx)
+	if err != nil {
+		return nil, err
+	}
+	services := map[string]*gdrive.Drive{"": d, uploader.DefaultAccount: d}
+	extra, err := extraAccounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range extra {
+		services[a.Name] = a.Drive
+	}
+	return services, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+
+	"github.com/dknowles2/gdrive_sync/gdrive"
+)
+
+var (
+	authFlow = flag.String("auth_flow", gdrive.AuthFlowBrowser, "How to authorize a new token: browser (consent page redirecting to localhost) or device (a short code entered on another device, which Google only allows for the drive.file and drive.appdata scopes, so it is refused for the full Drive scope)")
+	authPort = flag.Int("auth_port", 0, "Port for the temporary localhost listener that receives the OAuth redirect (0 picks a free one)")
+)
+
+// auth implements the "auth" command, which authorizes gdrive_sync with a
+// Google account and saves the token, replacing any that is there. It is
+// for first-time setup and for accounts whose token has been revoked.
+func auth(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("auth", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		return errors.New("usage: auth [TOKEN_FILE]")
+	}
+	tokenPath := *tokenFile
+	if fs.NArg() == 1 {
+		tokenPath = fs.Arg(0)
+	}
+	if tokenPath == gdrive.StdinPath {
+		return errors.New("auth needs a token file to save to, not stdin")
+	}
+	if err := driveConfig(*credsFile, tokenPath, daemonScopes()...).Authorize(ctx); err != nil {
+		return err
+	}
+	log.Printf("Authorized; token saved to %s", tokenPath)
+	return nil
+}
+
+// driveConfig authorizes with credsPath and the token cached at tokenPath.
+// $GDRIVE_SYNC_CREDENTIALS and $GDRIVE_SYNC_TOKEN stand in for --creds_file
+// and --token_file only, not other accounts' files.
+func driveConfig(credsPath, tokenPath string, scopes ...string) gdrive.Config {
+	return gdrive.Config{
+		CredentialsFile:    credsPath,
+		CredentialsFromEnv: credsPath == *credsFile,
+		TokenFile:          tokenPath,
+		TokenFromEnv:       tokenPath == *tokenFile,
+		Scopes:             scopes,
+		AuthFlow:           *authFlow,
+		AuthPort:           *authPort,
+		ChaosRate:          *chaosRate,
+		ChaosMaxDelay:      *chaosMaxDelay,
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/dknowles2/gdrive_sync/gcs"
+	"github.com/dknowles2/gdrive_sync/s3"
+	"github.com/dknowles2/gdrive_sync/uploader"
+)
+
+var (
+	s3Bucket    = flag.String("s3_bucket", "", "Also copy every uploaded file into this S3 or S3-compatible (e.g. MinIO) bucket, at its path beneath --output_dir")
+	s3Endpoint  = flag.String("s3_endpoint", "", "URL of the S3-compatible service, e.g. https://minio.local:9000 (default AWS in --s3_region)")
+	s3Region    = flag.String("s3_region", "us-east-1", "Region of --s3_bucket")
+	s3Prefix    = flag.String("s3_prefix", "", "Prefix of the keys of objects copied to --s3_bucket, e.g. scans/")
+	s3AccessKey = flag.String("s3_access_key", "", "Access key for --s3_bucket (default $AWS_ACCESS_KEY_ID)")
+	s3SecretKey = flag.String("s3_secret_key", "", "Secret key for --s3_bucket (default $AWS_SECRET_ACCESS_KEY)")
+	gcsBucket   = flag.String("gcs_bucket", "", "Also copy every uploaded file into this Google Cloud Storage bucket, using the application default credentials")
+	gcsPrefix   = flag.String("gcs_prefix", "", "Prefix of the names of objects copied to --gcs_bucket, e.g. scans/")
+	useDrive    = flag.Bool("drive", true, "Upload to Drive; with --drive=false files are only copied to --s3_bucket and --gcs_bucket")
+)
+
+// backends returns the storage every file is copied to besides Drive.
+func backends(ctx context.Context) ([]uploader.Backend, error) {
+	var bs []uploader.Backend
+	if b := s3Backend(); b != nil {
+		if err := b.Check(); err != nil {
+			return nil, fmt.Errorf("invalid --s3_bucket: %w", err)
+		}
+		bs = append(bs, b)
+	}
+	if *gcsBucket != "" {
+		b, err := gcs.New(ctx, *gcsBucket, *gcsPrefix)
+		if err != nil {
+			return nil, err
+		}
+		bs = append(bs, b)
+	}
+	if !*useDrive && len(bs) == 0 {
+		return nil, errors.New("--drive=false needs --s3_bucket or --gcs_bucket")
+	}
+	return bs, nil
+}
+
+// s3Backend returns the bucket the --s3_* flags describe, or nil without
+// --s3_bucket.
+func s3Backend() *s3.Bucket {
+	if *s3Bucket == "" {
+		return nil
+	}
+	b := &s3.Bucket{
+		Endpoint:  *s3Endpoint,
+		Region:    *s3Region,
+		Bucket:    *s3Bucket,
+		Prefix:    *s3Prefix,
+		AccessKey: *s3AccessKey,
+		SecretKey: *s3SecretKey,
+	}
+	if b.AccessKey == "" {
+		b.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if b.SecretKey == "" {
+		b.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	return b
+}
+
+// backendPorts returns the ports the backends are reached on.
+func backendPorts() []uint64 {
+	var ports []uint64
+	if b := s3Backend(); b != nil {
+		if u, err := b.EndpointURL(); err == nil {
+			ports = append(ports, urlPort(u.Scheme, u.Host))
+		}
+	}
+	return ports
+}
+
+// urlPort returns the port of host, or the default one for scheme.
+func urlPort(scheme, host string) uint64 {
+	if _, port, err := net.SplitHostPort(host); err == nil {
+		n, _ := strconv.ParseUint(port, 10, 16)
+		return n
+	}
+	if scheme == "http" {
+		return 80
+	}
+	return 443
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/gdrive"
+	"github.com/dustin/go-humanize"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+type benchResult struct {
+	chunkSize   int64
+	concurrency int
+	bytes       int64
+	elapsed     time.Duration
+	failures    int
+}
+
+func (r benchResult) throughput() float64 {
+	return float64(r.bytes) / r.elapsed.Seconds()
+}
+
+// bench uploads synthetic files to a scratch folder using a matrix of chunk
+// sizes and concurrency levels and recommends the fastest combination.
+func bench(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	folder := fs.String("folder", "gdrive_sync bench", "Scratch Drive folder to create for the benchmark")
+	sizes := fs.String("sizes", "1MB,16MB,64MB", "Comma-separated sizes of the synthetic files")
+	chunkSizes := fs.String("chunk_sizes", "1MiB,8MiB,16MiB,32MiB", "Comma-separated resumable upload chunk sizes to try")
+	concurrency := fs.String("concurrency", "1,2,4", "Comma-separated numbers of parallel uploads to try")
+	keep := fs.Bool("keep", false, "Keep the scratch folder instead of deleting it afterwards")
+	fs.Parse(args)
+
+	fileSizes, err := parseSizes(*sizes)
+	if err != nil {
+		return err
+	}
+	chunks, err := parseSizes(*chunkSizes)
+	if err != nil {
+		return err
+	}
+	var levels []int
+	for _, c := range strings.Split(*concurrency, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(c))
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid concurrency %q", c)
+		}
+		levels = append(levels, n)
+	}
+
+	service, err := flagPair().driveConfig().Service(ctx)
+	if err != nil {
+		return err
+	}
+	folderId, err := gdrive.CreateFolder(service, *folder, "")
+	if err != nil {
+		return err
+	}
+	if !*keep {
+		defer func() {
+			if err := service.Files.Delete(folderId).SupportsAllDrives(true).Do(); err != nil {
+				log.Printf("failed to delete scratch folder %s: %s", *folder, err)
+			}
+		}()
+	}
+
+	latency, err := measureLatency(service, folderId, 5)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("API latency (median of 5 metadata requests): %s\n\n", latency.Round(time.Millisecond))
+
+	var results []benchResult
+	for _, cs := range chunks {
+		for _, c := range levels {
+			log.Printf("Benchmarking chunk size %s with %d parallel uploads...", humanize.IBytes(uint64(cs)), c)
+			results = append(results, runBench(ctx, service, folderId, fileSizes, cs, c))
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CHUNK SIZE\tCONCURRENCY\tUPLOADED\tELAPSED\tTHROUGHPUT\tFAILURES")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s/s\t%d\n",
+			humanize.IBytes(uint64(r.chunkSize)), r.concurrency, humanize.Bytes(uint64(r.bytes)),
+			r.elapsed.Round(time.Millisecond), humanize.Bytes(uint64(r.throughput())), r.failures)
+	}
+	w.Flush()
+
+	best, ok := recommend(results)
+	if !ok {
+		return fmt.Errorf("every benchmark upload failed")
+	}
+	fmt.Printf("\nRecommended: chunk size %s, %d parallel uploads (%s/s)\n",
+		humanize.IBytes(uint64(best.chunkSize)), best.concurrency, humanize.Bytes(uint64(best.throughput())))
+	return nil
+}
+
+func parseSizes(s string) ([]int64, error) {
+	var sizes []int64
+	for _, v := range strings.Split(s, ",") {
+		n, err := humanize.ParseBytes(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid size %q: %w", v, err)
+		}
+		sizes = append(sizes, int64(n))
+	}
+	return sizes, nil
+}
+
+func measureLatency(d *gdrive.Drive, folderId string, n int) (time.Duration, error) {
+	var samples []time.Duration
+	for i := 0; i < n; i++ {
+		start := time.Now()
+		if _, err := d.Files.Get(folderId).SupportsAllDrives(true).Fields("id").Do(); err != nil {
+			return 0, fmt.Errorf("unable to query scratch folder: %w", err)
+		}
+		samples = append(samples, time.Since(start))
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return samples[len(samples)/2], nil
+}
+
+// runBench uploads one synthetic file of each size per worker.
+func runBench(ctx context.Context, d *gdrive.Drive, folderId string, sizes []int64, chunkSize int64, concurrency int) benchResult {
+	r := benchResult{chunkSize: chunkSize, concurrency: concurrency}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for _, size := range sizes {
+				f := &drive.File{
+					Name:    fmt.Sprintf("bench-%s-c%d-w%d-%d", humanize.IBytes(uint64(chunkSize)), concurrency, worker, size),
+					Parents: []string{folderId},
+				}
+				body := io.LimitReader(rand.Reader, size)
+				_, err := d.Files.Create(f).SupportsAllDrives(true).Media(body, googleapi.ChunkSize(int(chunkSize))).Context(ctx).Fields("id").Do()
+				mu.Lock()
+				if err != nil {
+					log.Printf("benchmark upload failed: %s", err)
+					r.failures++
+				} else {
+					r.bytes += size
+				}
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	r.elapsed = time.Since(start)
+	return r
+}
+
+// recommend picks the result with the highest throughput, preferring lower
+// concurrency and smaller chunks (less memory) when within 5% of the best.
+func recommend(results []benchResult) (benchResult, bool) {
+	var best benchResult
+	found := false
+	for _, r := range results {
+		if r.failures > 0 || r.bytes == 0 {
+			continue
+		}
+		if !found || r.throughput() > best.throughput() {
+			best = r
+			found = true
+		}
+	}
+	if !found {
+		return best, false
+	}
+	threshold := best.throughput() * 0.95
+	for _, r := range results {
+		if r.failures > 0 || r.bytes == 0 || r.throughput() < threshold {
+			continue
+		}
+		if r.concurrency < best.concurrency || (r.concurrency == best.concurrency && r.chunkSize < best.chunkSize) {
+			best = r
+		}
+	}
+	return best, true
+}
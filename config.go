@@ -0,0 +1,288 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/gdrive"
+	"github.com/dknowles2/gdrive_sync/uploader"
+	"gopkg.in/yaml.v2"
+)
+
+var configFile = flag.String("config", "", "YAML file defining several input directory to Drive folder pairs to sync from one daemon; see syncPair for the keys")
+
+// syncPair is one input directory uploaded to one Drive folder. In the
+// --config file, unset keys take the value of the corresponding flag.
+//
+//	pairs:
+//	  - input_dir: /share/Scans
+//	    output_dir: Incoming Scans
+//	    recursive: true
+//	    ignore: ["*.tmp"]
+//	    archive_dir: /share/Scans.uploaded
+//	    routes: ["invoice_*.pdf=Finance/Invoices;ocr=en"]
+//	    permissions:
+//	      - email: spouse@example.com
+//	        role: writer
+//	      - email: accountant@example.com
+//	        pattern: invoice_*.pdf
+//	  - input_dir: /share/Photos
+//	    output_dir: Camera
+//	    token_file: /data/photos-token.json
+//	    delete: false
+type syncPair struct {
+	InputDir    string   `yaml:"input_dir"`
+	OutputDir   string   `yaml:"output_dir"`
+	CredsFile   string   `yaml:"creds_file"`
+	TokenFile   string   `yaml:"token_file"`
+	Recursive   *bool    `yaml:"recursive"`
+	MaxDepth    *int     `yaml:"max_depth"`
+	Flatten     *bool    `yaml:"flatten"`
+	Mirror      *bool    `yaml:"mirror"`
+	ExcludeDirs []string `yaml:"exclude_dirs"`
+	Ignore      []string `yaml:"ignore"`
+	// Delete is whether files are deleted once uploaded.
+	Delete          *bool `yaml:"delete"`
+	UploadOnStartup *bool `yaml:"upload_on_startup"`
+	// PollInterval rescans the directory instead of relying on change
+	// notifications, for network shares.
+	PollInterval *time.Duration `yaml:"poll_interval"`
+	// ArchiveDir is where uploaded files are moved instead of deleted.
+	ArchiveDir string `yaml:"archive_dir"`
+	// OutputFolderId names the Drive folder by ID instead of OutputDir.
+	OutputFolderId string `yaml:"output_folder_id"`
+	// SharedDriveId is the shared drive OutputDir is in. Like OutputDir,
+	// it isn't taken from the flags.
+	SharedDriveId string `yaml:"shared_drive_id"`
+	// Routes are pattern=folder rules, as for --routes.
+	Routes []string `yaml:"routes"`
+	// Include and Exclude filter the files uploaded, as for --include and
+	// --exclude.
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+	// Compress are pattern=format rules, as for --compress.
+	Compress []string `yaml:"compress"`
+	// Permissions share the uploaded files, in addition to --share_link and
+	// --share_with.
+	Permissions []permissionSpec `yaml:"permissions"`
+	// Destinations are name=folder[;token=token_file][;shared_drive=id]
+	// folders every file is also uploaded to, as for --destinations.
+	Destinations []string `yaml:"destinations"`
+}
+
+// permissionSpec shares the uploaded files matching Pattern (every file if
+// it's empty) with Email, or with anyone who has the link if it is
+// "anyone", as Role: reader (the default), commenter or writer.
+type permissionSpec struct {
+	Email   string `yaml:"email"`
+	Role    string `yaml:"role"`
+	Pattern string `yaml:"pattern"`
+}
+
+type config struct {
+	// Permissions are the permissions of pairs that don't set their own.
+	Permissions []permissionSpec `yaml:"permissions"`
+	Pairs       []syncPair       `yaml:"pairs"`
+}
+
+// flagPair returns the pair described by the command-line flags.
+func flagPair() syncPair {
+	p := syncPair{
+		InputDir:        *inputDir,
+		OutputDir:       outputFolder(),
+		SharedDriveId:   *sharedDriveId,
+		CredsFile:       *credsFile,
+		TokenFile:       *tokenFile,
+		Recursive:       recursive,
+		MaxDepth:        maxDepth,
+		Flatten:         flatten,
+		Mirror:          mirror,
+		Include:         *include,
+		Exclude:         *exclude,
+		Delete:          deleteAfterUpload,
+		UploadOnStartup: uploadOnStartup,
+		PollInterval:    pollInterval,
+		ArchiveDir:      *archiveDir,
+	}
+	if *excludeDirs != "" {
+		p.ExcludeDirs = strings.Split(*excludeDirs, ",")
+	}
+	if *ignore != "" {
+		p.Ignore = strings.Split(*ignore, ",")
+	}
+	if *routes != "" {
+		p.Routes = strings.Split(*routes, ",")
+	}
+	if *compress != "" {
+		p.Compress = strings.Split(*compress, ",")
+	}
+	if *destinations != "" {
+		p.Destinations = strings.Split(*destinations, ",")
+	}
+	return p
+}
+
+// driveConfig authorizes with the pair's credentials and token, confined to
+// its shared drive.
+func (p syncPair) driveConfig(scopes ...string) gdrive.Config {
+	c := driveConfig(p.CredsFile, p.TokenFile, scopes...)
+	c.SharedDriveId = p.SharedDriveId
+	return c
+}
+
+// parseRoutes parses pattern=folder routing rules. The folder may be
+// followed by ";convert" to import matching files as Google Docs, or
+// ";ocr=LANG" to do so with OCR in that language, in which case it may be
+// empty to keep the output folder. An empty folder followed by ";photos"
+// sends matching files to the Photos album instead.
+func parseRoutes(specs []string) ([]uploader.Route, error) {
+	var rs []uploader.Route
+	for _, spec := range specs {
+		i := strings.Index(spec, "=")
+		if i <= 0 || i == len(spec)-1 {
+			return nil, fmt.Errorf("invalid route %q, want pattern=folder", spec)
+		}
+		opts := strings.Split(spec[i+1:], ";")
+		r := uploader.Route{Pattern: spec[:i], Folder: opts[0]}
+		for _, o := range opts[1:] {
+			switch {
+			case o == "convert":
+				r.Convert = true
+			case strings.HasPrefix(o, "ocr=") && len(o) > len("ocr="):
+				r.Convert, r.OCRLanguage = true, strings.TrimPrefix(o, "ocr=")
+			case o == "photos":
+				r.Photos = true
+			default:
+				return nil, fmt.Errorf("invalid route %q: unknown option %q", spec, o)
+			}
+		}
+		if r.Folder == "" && !r.Convert && !r.Photos {
+			return nil, fmt.Errorf("invalid route %q, want pattern=folder", spec)
+		}
+		rs = append(rs, r)
+	}
+	return rs, nil
+}
+
+// parseCompress parses pattern=format compression rules.
+func parseCompress(specs []string) ([]uploader.Compression, error) {
+	var cs []uploader.Compression
+	for _, spec := range specs {
+		i := strings.LastIndex(spec, "=")
+		if i <= 0 || i == len(spec)-1 {
+			return nil, fmt.Errorf("invalid compression %q, want pattern=format", spec)
+		}
+		cs = append(cs, uploader.Compression{Pattern: spec[:i], Format: spec[i+1:]})
+	}
+	return cs, nil
+}
+
+// permissions converts permission specs for the uploader.
+func permissions(specs []permissionSpec) []uploader.Permission {
+	var ps []uploader.Permission
+	for _, s := range specs {
+		ps = append(ps, uploader.Permission{Pattern: s.Pattern, Email: s.Email, Role: s.Role})
+	}
+	return ps
+}
+
+// outputFolder returns what to pass gdrive.GetFolderId for the --output_dir
+// folder: its name, or a URL of --output_folder_id.
+func outputFolder() string {
+	if *outputFolderId != "" {
+		return gdrive.FolderURL(*outputFolderId)
+	}
+	return *outputDir
+}
+
+// syncPairs returns the pairs to sync: those in --config, or the one given
+// by the flags.
+func syncPairs() ([]syncPair, error) {
+	def := flagPair()
+	if *configFile == "" {
+		return []syncPair{def}, nil
+	}
+	b, err := ioutil.ReadFile(*configFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read --config: %w", err)
+	}
+	var c config
+	if err := yaml.UnmarshalStrict(b, &c); err != nil {
+		return nil, fmt.Errorf("unable to parse --config: %w", err)
+	}
+	if len(c.Pairs) == 0 {
+		return nil, fmt.Errorf("no pairs in %s", *configFile)
+	}
+	seen := make(map[string]bool)
+	for i := range c.Pairs {
+		p := &c.Pairs[i]
+		if p.OutputFolderId != "" {
+			p.OutputDir = gdrive.FolderURL(p.OutputFolderId)
+		}
+		if p.InputDir == "" || (p.OutputDir == "" && *useDrive) {
+			return nil, fmt.Errorf("pair %d in %s needs input_dir and output_dir (or output_folder_id)", i+1, *configFile)
+		}
+		if seen[p.InputDir] {
+			return nil, fmt.Errorf("input_dir %s appears twice in %s", p.InputDir, *configFile)
+		}
+		seen[p.InputDir] = true
+		if p.CredsFile == "" {
+			p.CredsFile = def.CredsFile
+		}
+		if p.TokenFile == "" {
+			p.TokenFile = def.TokenFile
+		}
+		if p.Recursive == nil {
+			p.Recursive = def.Recursive
+		}
+		if p.MaxDepth == nil {
+			p.MaxDepth = def.MaxDepth
+		}
+		if p.Flatten == nil {
+			p.Flatten = def.Flatten
+		}
+		if p.Mirror == nil {
+			p.Mirror = def.Mirror
+		}
+		if p.ExcludeDirs == nil {
+			p.ExcludeDirs = def.ExcludeDirs
+		}
+		if p.Ignore == nil {
+			p.Ignore = def.Ignore
+		}
+		if p.Delete == nil {
+			p.Delete = def.Delete
+		}
+		if p.UploadOnStartup == nil {
+			p.UploadOnStartup = def.UploadOnStartup
+		}
+		if p.PollInterval == nil {
+			p.PollInterval = def.PollInterval
+		}
+		if p.ArchiveDir == "" {
+			p.ArchiveDir = def.ArchiveDir
+		}
+		if p.Routes == nil {
+			p.Routes = def.Routes
+		}
+		if p.Include == nil {
+			p.Include = def.Include
+		}
+		if p.Exclude == nil {
+			p.Exclude = def.Exclude
+		}
+		if p.Compress == nil {
+			p.Compress = def.Compress
+		}
+		if p.Destinations == nil {
+			p.Destinations = def.Destinations
+		}
+		if p.Permissions == nil {
+			p.Permissions = c.Permissions
+		}
+	}
+	return c.Pairs, nil
+}
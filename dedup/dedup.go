@@ -0,0 +1,94 @@
+// Package dedup collapses repeated messages into periodic summaries so that
+// a persistent failure (network down, folder missing) doesn't flood logs and
+// notification channels.
+package dedup
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Summary reports how many times a message was suppressed.
+type Summary struct {
+	Key        string
+	Suppressed int
+	Window     time.Duration
+}
+
+type entry struct {
+	first      time.Time
+	suppressed int
+}
+
+// Limiter lets the first occurrence of each key through and suppresses
+// repeats until the window since that first occurrence has passed.
+type Limiter struct {
+	window time.Duration
+
+	mu   sync.Mutex
+	seen map[string]*entry
+}
+
+func New(window time.Duration) *Limiter {
+	return &Limiter{window: window, seen: make(map[string]*entry)}
+}
+
+// Allow reports whether a message with the given key should be passed on.
+func (l *Limiter) Allow(key string) bool {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.seen[key]; ok && now.Sub(e.first) < l.window {
+		e.suppressed++
+		return false
+	}
+	l.seen[key] = &entry{first: now}
+	return true
+}
+
+// Expire forgets keys whose window has ended, returning summaries for those
+// that had repeats suppressed, oldest first.
+func (l *Limiter) Expire() []Summary {
+	return l.expire(false)
+}
+
+// Flush is like Expire but ends every window immediately, for use at
+// shutdown.
+func (l *Limiter) Flush() []Summary {
+	return l.expire(true)
+}
+
+func (l *Limiter) expire(all bool) []Summary {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	type expired struct {
+		first time.Time
+		s     Summary
+	}
+	var out []expired
+	for k, e := range l.seen {
+		if !all && now.Sub(e.first) < l.window {
+			continue
+		}
+		if e.suppressed > 0 {
+			out = append(out, expired{e.first, Summary{Key: k, Suppressed: e.suppressed, Window: l.window}})
+		}
+		delete(l.seen, k)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].first.Before(out[j].first) })
+	summaries := make([]Summary, len(out))
+	for i, e := range out {
+		summaries[i] = e.s
+	}
+	return summaries
+}
+
+// CheckInterval is how often owners of a Limiter should call Expire.
+func (l *Limiter) CheckInterval() time.Duration {
+	if d := l.window / 10; d > time.Second {
+		return d
+	}
+	return time.Second
+}
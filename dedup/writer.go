@@ -0,0 +1,74 @@
+package dedup
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/logging"
+)
+
+// Writer suppresses repeated records on their way to a logging.Logger and
+// periodically logs how many were suppressed. It is an io.Writer for the
+// standard logger, which should be configured without flags, and also takes
+// leveled records through Log.
+type Writer struct {
+	out     *logging.Logger
+	limiter *Limiter
+	done    chan struct{}
+}
+
+func NewWriter(l *logging.Logger, window time.Duration) *Writer {
+	dw := &Writer{
+		out:     l,
+		limiter: New(window),
+		done:    make(chan struct{}),
+	}
+	go dw.run()
+	return dw
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		w.Log(logging.Info, string(line))
+	}
+	return len(p), nil
+}
+
+// Log passes on a record unless its message was logged within the window.
+// Its fields, such as a correlation ID, don't make it a different record.
+func (w *Writer) Log(level logging.Level, msg string, fields ...logging.Field) {
+	if w.limiter.Allow(msg) {
+		w.out.Log(level, msg, fields...)
+	}
+}
+
+func (w *Writer) run() {
+	t := time.NewTicker(w.limiter.CheckInterval())
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			w.flush()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *Writer) flush() {
+	w.write(w.limiter.Expire())
+}
+
+func (w *Writer) write(summaries []Summary) {
+	for _, s := range summaries {
+		w.out.Log(logging.Info, fmt.Sprintf("%q occurred %d more times in the last %s", s.Key, s.Suppressed, s.Window))
+	}
+}
+
+// Close stops the background summaries and writes any pending ones.
+func (w *Writer) Close() error {
+	close(w.done)
+	w.write(w.limiter.Flush())
+	return nil
+}
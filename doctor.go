@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/gdrive"
+	"github.com/dknowles2/gdrive_sync/uploader"
+	"google.golang.org/api/drive/v3"
+)
+
+// doctorProbeAddr is dialled to tell whether the Drive API can be reached.
+const doctorProbeAddr = "www.googleapis.com:443"
+
+// finding is the outcome of one of doctor's checks. A warning doesn't
+// stop the daemon from working, but may well stop it working well.
+type finding struct {
+	check string
+	err   error
+	warn  bool
+	fix   string
+}
+
+// doctor implements the "doctor" command, which checks the setup the flags
+// and --config describe and suggests how to fix what's wrong with it.
+func doctor(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		return errors.New("usage: doctor")
+	}
+	pairs, err := syncPairs()
+	if err != nil {
+		return err
+	}
+	var findings []finding
+	report := func(f finding) {
+		findings = append(findings, f)
+		switch {
+		case f.err == nil:
+			fmt.Printf("[ OK ] %s\n", f.check)
+			return
+		case f.warn:
+			fmt.Printf("[WARN] %s: %s\n", f.check, f.err)
+		default:
+			fmt.Printf("[FAIL] %s: %s\n", f.check, f.err)
+		}
+		if f.fix != "" {
+			fmt.Printf("       Fix: %s\n", f.fix)
+		}
+	}
+
+	online := true
+	if *useDrive {
+		f := checkNetwork(ctx)
+		online = f.err == nil
+		report(f)
+	}
+	for _, p := range pairs {
+		report(checkInputDir(p))
+		if !*useDrive {
+			continue
+		}
+		cfg := p.driveConfig(daemonScopes()...)
+		f, ok := checkCredentials(cfg, p.CredsFile)
+		report(f)
+		if !ok {
+			continue
+		}
+		f, ok = checkToken(ctx, cfg, p.TokenFile, online)
+		report(f)
+		if !ok || !online {
+			continue
+		}
+		report(checkOutputDir(ctx, cfg, p))
+	}
+	if f, ok := checkWatches(pairs); ok {
+		report(f)
+	}
+	report(checkOpenHandles(ctx))
+
+	var failed, warned int
+	for _, f := range findings {
+		switch {
+		case f.err == nil:
+		case f.warn:
+			warned++
+		default:
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(findings))
+	}
+	if warned > 0 {
+		fmt.Printf("All checks passed, with %d warnings\n", warned)
+	} else {
+		fmt.Println("All checks passed")
+	}
+	return nil
+}
+
+func checkNetwork(ctx context.Context) finding {
+	f := finding{check: "Network connection to the Drive API (" + doctorProbeAddr + ")"}
+	d := net.Dialer{Timeout: 10 * time.Second}
+	c, err := d.DialContext(ctx, "tcp", doctorProbeAddr)
+	if err != nil {
+		f.err = err
+		f.fix = "check the network connection, DNS and any firewall or proxy: uploads need HTTPS to " + doctorProbeAddr
+		return f
+	}
+	c.Close()
+	return f
+}
+
+func checkInputDir(p syncPair) finding {
+	f := finding{check: "Input directory " + p.InputDir}
+	d, err := os.Open(p.InputDir)
+	if err == nil {
+		_, err = d.Readdirnames(1)
+		d.Close()
+		if err == io.EOF {
+			err = nil
+		}
+	}
+	switch {
+	case err == nil:
+	case os.IsNotExist(err):
+		f.err, f.fix = err, "create it, or point --input_dir (or input_dir in --config) at the directory files are saved to"
+	case os.IsPermission(err):
+		f.err, f.fix = err, "let the user the daemon runs as (see --run_as) read it"
+	default:
+		f.err = err
+	}
+	return f
+}
+
+// checkCredentials reports whether the credentials file parses.
+func checkCredentials(cfg gdrive.Config, path string) (finding, bool) {
+	f := finding{check: "Credentials file " + path}
+	if _, err := cfg.OAuthConfig(); err != nil {
+		f.err = err
+		f.fix = "create an OAuth client ID of type \"Desktop app\" in the Google Cloud console, download its JSON and point --creds_file at it"
+		return f, false
+	}
+	return f, true
+}
+
+// checkToken reports whether the token is there and, if online, can be
+// refreshed.
+func checkToken(ctx context.Context, cfg gdrive.Config, path string, online bool) (finding, bool) {
+	f := finding{check: "Token " + path}
+	tok, err := cfg.Token()
+	if err != nil {
+		f.err = err
+		f.fix = fmt.Sprintf("run %q to authorize this machine", "gdrive_sync auth")
+		return f, false
+	}
+	if tok.RefreshToken == "" && !tok.Valid() {
+		f.err = errors.New("the token has expired and has no refresh token")
+		f.fix = fmt.Sprintf("run %q again to get a new token", "gdrive_sync auth")
+		return f, false
+	}
+	if !online {
+		f.check += " (not refreshed while offline)"
+		return f, true
+	}
+	conf, err := cfg.OAuthConfig()
+	if err != nil {
+		f.err = err
+		return f, false
+	}
+	// A token that's still valid isn't refreshed, so make it look expired.
+	tok.Expiry = time.Now().Add(-time.Minute)
+	if _, err := conf.TokenSource(ctx, tok).Token(); err != nil {
+		f.err = fmt.Errorf("unable to refresh the token: %w", err)
+		f.fix = fmt.Sprintf("the token was revoked, or the OAuth client changed; run %q again", "gdrive_sync auth")
+		return f, false
+	}
+	return f, true
+}
+
+// checkOutputDir reports whether the Drive folder exists and files can be
+// added to it.
+func checkOutputDir(ctx context.Context, cfg gdrive.Config, p syncPair) finding {
+	f := finding{check: "Drive folder " + p.OutputDir}
+	d, err := cfg.Service(ctx)
+	if err != nil {
+		f.err = err
+		return f
+	}
+	id := p.OutputFolderId
+	if id != "" {
+		f.check = "Drive folder " + id
+	} else {
+		id, err = gdrive.GetFolderId(d, p.OutputDir)
+	}
+	if errors.Is(err, gdrive.ErrFolderNotFound) {
+		if *createOutputDir {
+			return f
+		}
+		f.err = err
+		f.fix = "create the folder in Drive, or pass --create_output_dir"
+		return f
+	} else if err != nil {
+		f.err = err
+		return f
+	}
+	var folder *drive.File
+	if folder, err = d.Files.Get(id).SupportsAllDrives(true).Fields("capabilities(canAddChildren)").Context(ctx).Do(); err != nil {
+		f.err = err
+		return f
+	}
+	if folder.Capabilities == nil || !folder.Capabilities.CanAddChildren {
+		f.err = errors.New("the authorized account can't add files to it")
+		f.fix = "share the folder with the authorized account as an editor, or pick a folder it owns"
+	}
+	return f
+}
+
+// checkOpenHandles reports whether --stability_strategy=open can tell
+// whether files are open. It's only a warning when that isn't used.
+func checkOpenHandles(ctx context.Context) finding {
+	f := finding{check: "Detecting open files"}
+	if err := uploader.CheckOpenHandles(ctx); err != nil {
+		f.err = err
+		f.warn = !strings.Contains(*stabilityStrategy, "open")
+		f.fix = openHandlesFix
+		if f.warn {
+			f.fix += ", before using --stability_strategy=open"
+		}
+	}
+	return f
+}
+
+// watchedDirs counts the directories watching p takes, stopping at limit.
+func watchedDirs(p syncPair, limit int) int {
+	if !*p.Recursive && !*p.Mirror {
+		return 1
+	}
+	n := 0
+	root := filepath.Clean(p.InputDir)
+	filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.IsDir() {
+			return nil
+		}
+		if rel, _ := filepath.Rel(root, path); *p.MaxDepth > 0 && rel != "." && strings.Count(filepath.ToSlash(rel), "/")+1 > *p.MaxDepth {
+			return filepath.SkipDir
+		}
+		if n++; n >= limit {
+			return errors.New("enough")
+		}
+		return nil
+	})
+	return n
+}
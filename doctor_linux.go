@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxUserWatchesFile = "/proc/sys/fs/inotify/max_user_watches"
+	openHandlesFix     = "run as root, or as the user that writes the files, so their /proc/PID/fd can be read"
+)
+
+// checkWatches reports whether the inotify watch limit covers the
+// directories the pairs watch. It reports false if there's nothing to
+// check, because every pair polls.
+func checkWatches(pairs []syncPair) (finding, bool) {
+	f := finding{check: "inotify watch limit (" + maxUserWatchesFile + ")"}
+	b, err := ioutil.ReadFile(maxUserWatchesFile)
+	if err != nil {
+		f.err, f.warn = err, true
+		return f, true
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		f.err, f.warn = fmt.Errorf("unable to parse it: %w", err), true
+		return f, true
+	}
+	n, watching := 0, false
+	for _, p := range pairs {
+		if *p.PollInterval > 0 {
+			continue
+		}
+		watching = true
+		n += watchedDirs(p, limit+1)
+	}
+	if !watching {
+		return f, false
+	}
+	f.check += fmt.Sprintf(": needs %d of %d", n, limit)
+	switch {
+	case n > limit:
+		f.err = fmt.Errorf("%d directories need watching, over the limit of %d", n, limit)
+	case n > limit/2:
+		// Other programs need watches too.
+		f.err, f.warn = fmt.Errorf("%d directories need watching, over half the limit of %d", n, limit), true
+	default:
+		return f, true
+	}
+	f.fix = fmt.Sprintf("raise it with \"sysctl fs.inotify.max_user_watches=%d\" (and in /etc/sysctl.d to keep it), or use --poll_interval", 2*n)
+	return f, true
+}
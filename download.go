@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dknowles2/gdrive_sync/gdrive"
+	"github.com/dustin/go-humanize"
+	"google.golang.org/api/drive/v3"
+)
+
+// download fetches a single file, given as a path beneath --output_dir or as
+// a Drive file ID, to a local path.
+func download(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("download", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: download <drive-path-or-id> <local-path>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("a Drive path or ID and a local path are required")
+	}
+	src, dst := fs.Arg(0), fs.Arg(1)
+
+	service, err := flagPair().driveConfig().Service(ctx)
+	if err != nil {
+		return err
+	}
+	f, err := findRemote(ctx, service, src)
+	if err != nil {
+		return err
+	}
+	if gdrive.IsFolder(f) {
+		return fmt.Errorf("%s is a folder", src)
+	}
+	if strings.HasPrefix(f.MimeType, "application/vnd.google-apps.") {
+		return fmt.Errorf("%s is a Google Docs editor file (%s) and cannot be downloaded directly", src, f.MimeType)
+	}
+	if fi, err := os.Stat(dst); err == nil && fi.IsDir() {
+		dst = filepath.Join(dst, f.Name)
+	}
+	if err := downloadTo(ctx, service, f.Id, dst); err != nil {
+		return err
+	}
+	log.Printf("Downloaded %s (%s) to %s", f.Name, humanize.Bytes(uint64(f.Size)), dst)
+	return nil
+}
+
+// findRemote looks up src as a path beneath --output_dir, falling back to
+// treating it as a file ID.
+func findRemote(ctx context.Context, d *gdrive.Drive, src string) (*drive.File, error) {
+	folderId, err := gdrive.GetFolderId(d, outputFolder())
+	if err == nil {
+		if f, err := gdrive.FindFile(ctx, d, folderId, src); err == nil {
+			return f, nil
+		}
+	}
+	f, err := d.Files.Get(src).SupportsAllDrives(true).Fields("id", "name", "mimeType", "size").Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("no file at path or with ID %q: %w", src, err)
+	}
+	return f, nil
+}
+
+// downloadTo writes the file to a temporary file next to dst and renames it
+// into place, so an interrupted download never leaves a partial file behind.
+func downloadTo(ctx context.Context, d *gdrive.Drive, id, dst string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := gdrive.Download(ctx, d, id, tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
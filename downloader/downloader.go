@@ -0,0 +1,466 @@
+// Package downloader pulls new files from a Drive folder down into a local
+// directory, the opposite direction to the uploader.
+package downloader
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/gdrive"
+	"github.com/dknowles2/gdrive_sync/statefile"
+	"github.com/dustin/go-humanize"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// DefaultInterval is how often the Drive folder is checked for new files.
+const DefaultInterval = time.Minute
+
+// googleAppsPrefix starts the MIME types of Docs editor files, which can
+// only be exported, not downloaded.
+const googleAppsPrefix = "application/vnd.google-apps."
+
+// Options configure a Downloader.
+type Options struct {
+	// Interval is how often the folder is checked; DefaultInterval if zero.
+	// With PushAddress it only matters if notifications go missing.
+	Interval time.Duration
+	// Recursive also downloads the files in subfolders, into subdirectories
+	// of the same names.
+	Recursive bool
+	// StateFile, if set, remembers which files were downloaded, so that
+	// files deleted locally are not downloaded again after a restart, and
+	// where the changes feed was read up to, so the folder needn't be listed
+	// again. Without it a file is downloaded again if it is missing locally.
+	StateFile string
+	// PushAddress, if set, is the public HTTPS URL at which Drive notifies
+	// the Downloader, served by ServeHTTP, of changes as they happen.
+	PushAddress string
+}
+
+// Downloader downloads the files added to or changed in a Drive folder into
+// a local directory. Files that gdrive_sync uploaded are never downloaded,
+// so the same folder can be both uploaded to and downloaded from.
+//
+// The folder is listed once, and after that only the Drive changes feed is
+// read.
+type Downloader struct {
+	d        *gdrive.Drive
+	folderId string
+	dir      string
+	opts     Options
+	// poke asks Run to check for changes now.
+	poke   chan struct{}
+	secret string // the channel token of push notifications
+
+	mu    sync.Mutex
+	state state
+}
+
+// state is what is saved in the state file.
+type state struct {
+	// PageToken is where the changes feed was read up to; empty if the
+	// folder hasn't been listed yet.
+	PageToken string `json:"page_token"`
+	// Folders are the slash-separated paths beneath the download directory
+	// of the folders downloaded from, by Drive folder ID.
+	Folders map[string]string     `json:"folders"`
+	Files   map[string]downloaded `json:"files"` // by Drive file ID
+}
+
+// downloaded is the version of a file that was last downloaded.
+type downloaded struct {
+	Path         string    `json:"path"`
+	MD5          string    `json:"md5"`
+	ModifiedTime time.Time `json:"modified_time"`
+}
+
+// New returns a Downloader from the Drive folder named by folder, given as
+// for gdrive.GetFolderId, into dir.
+func New(d *gdrive.Drive, folder, dir string, opts Options) (*Downloader, error) {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	folderId, err := gdrive.GetFolderId(d, folder)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find download folder: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create download directory: %w", err)
+	}
+	secret, err := randomId()
+	if err != nil {
+		return nil, err
+	}
+	dl := &Downloader{
+		d:        d,
+		folderId: folderId,
+		dir:      dir,
+		opts:     opts,
+		poke:     make(chan struct{}, 1),
+		secret:   secret,
+		state:    state{Files: make(map[string]downloaded)},
+	}
+	if opts.StateFile != "" {
+		data, err := ioutil.ReadFile(opts.StateFile)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			return nil, fmt.Errorf("unable to read download state: %w", err)
+		default:
+			// Losing the state only means listing the folder again.
+			if err := json.Unmarshal(data, &dl.state); err != nil {
+				log.Printf("Ignoring unreadable download state %s: %s", opts.StateFile, err)
+				dl.state = state{}
+			}
+			if dl.state.Files == nil {
+				dl.state.Files = make(map[string]downloaded)
+			}
+		}
+	}
+	// The changes are only followed for the folder they were read for.
+	if rel, ok := dl.state.Folders[folderId]; !ok || rel != "" {
+		dl.state.PageToken = ""
+	}
+	return dl, nil
+}
+
+// Run checks the folder for new files every Interval, and when Drive
+// notifies it of changes, until ctx is done.
+func (dl *Downloader) Run(ctx context.Context) error {
+	log.Printf("Downloading new files from Drive into %s", dl.dir)
+	if dl.opts.PushAddress != "" {
+		go dl.watch(ctx)
+	}
+	t := time.NewTicker(dl.opts.Interval)
+	defer t.Stop()
+	for {
+		if err := dl.Sync(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("failed to download from Drive: %s", err)
+		}
+		select {
+		case <-t.C:
+		case <-dl.poke:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// ServeHTTP receives Drive's push notifications of changes.
+func (dl *Downloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || r.Header.Get("X-Goog-Channel-Token") != dl.secret {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	// The first notification only confirms the channel.
+	if r.Header.Get("X-Goog-Resource-State") != "sync" {
+		select {
+		case dl.poke <- struct{}{}:
+		default:
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+const (
+	// channelLifetime is how long push channels are asked to last; Drive
+	// may make them shorter.
+	channelLifetime = 24 * time.Hour
+	// channelRenewal is how long before a channel expires it is replaced.
+	channelRenewal = 10 * time.Minute
+)
+
+// watch keeps a push channel open until ctx is done, retrying every
+// Interval if Drive won't open one.
+func (dl *Downloader) watch(ctx context.Context) {
+	var ch *drive.Channel
+	defer func() {
+		if ch != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := gdrive.StopChannel(ctx, dl.d, ch); err != nil {
+				log.Printf("failed to stop Drive push notifications: %s", err)
+			}
+		}
+	}()
+	for {
+		wait := dl.opts.Interval
+		token, err := gdrive.StartPageToken(ctx, dl.d)
+		var id string
+		if err == nil {
+			id, err = randomId()
+		}
+		if err == nil {
+			var next *drive.Channel
+			next, err = gdrive.WatchChanges(ctx, dl.d, token, id, dl.opts.PushAddress, dl.secret, time.Now().Add(channelLifetime))
+			if err == nil {
+				// Drive notifies every open channel, so the old one can go.
+				if ch != nil {
+					gdrive.StopChannel(ctx, dl.d, ch)
+				}
+				ch = next
+				expiry := time.Unix(0, ch.Expiration*int64(time.Millisecond))
+				log.Printf("Receiving Drive push notifications at %s until %s", dl.opts.PushAddress, expiry.Format(time.RFC3339))
+				if wait = time.Until(expiry) - channelRenewal; wait < time.Minute {
+					wait = time.Minute
+				}
+			}
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("%s; checking every %s", err, dl.opts.Interval)
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+	}
+}
+
+func randomId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Sync downloads the files that are new or changed since they were last
+// downloaded.
+func (dl *Downloader) Sync(ctx context.Context) error {
+	dl.mu.Lock()
+	token := dl.state.PageToken
+	dl.mu.Unlock()
+	if token == "" {
+		return dl.fullSync(ctx)
+	}
+	changes, next, err := gdrive.ListChanges(ctx, dl.d, token)
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) && (gerr.Code == http.StatusBadRequest || gerr.Code == http.StatusNotFound) {
+		log.Printf("Drive changes token is no longer valid; listing the folder again")
+		return dl.fullSync(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	for _, c := range changes {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := dl.apply(ctx, c); err != nil {
+			return err
+		}
+	}
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.state.PageToken = next
+	return dl.saveLocked()
+}
+
+// fullSync lists the whole folder, and then follows changes from when it
+// started.
+func (dl *Downloader) fullSync(ctx context.Context) error {
+	token, err := gdrive.StartPageToken(ctx, dl.d)
+	if err != nil {
+		return err
+	}
+	dl.mu.Lock()
+	dl.state.Folders = map[string]string{dl.folderId: ""}
+	dl.mu.Unlock()
+	if err := dl.syncFolder(ctx, dl.folderId, ""); err != nil {
+		return err
+	}
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.state.PageToken = token
+	return dl.saveLocked()
+}
+
+// apply downloads the file a change is about if it is in a folder
+// downloaded from. Files deleted in Drive are left alone locally.
+func (dl *Downloader) apply(ctx context.Context, c *drive.Change) error {
+	f := c.File
+	gone := c.Removed || f == nil || f.Trashed
+	if c.FileId == dl.folderId {
+		if gone {
+			log.Printf("WARNING: the Drive folder downloaded into %s was deleted", dl.dir)
+		}
+		return nil
+	}
+	dl.mu.Lock()
+	_, known := dl.state.Folders[c.FileId]
+	parent, inside := "", false
+	if !gone {
+		for _, p := range f.Parents {
+			if parent, inside = dl.state.Folders[p]; inside {
+				break
+			}
+		}
+	}
+	if known && (!inside || gone) {
+		// Deleted or moved out of the folder.
+		delete(dl.state.Folders, c.FileId)
+	}
+	dl.mu.Unlock()
+	if gone || !inside {
+		return nil
+	}
+	name := path.Join(parent, localName(f.Name))
+	if gdrive.IsFolder(f) {
+		if !dl.opts.Recursive {
+			return nil
+		}
+		dl.mu.Lock()
+		dl.state.Folders[f.Id] = name
+		dl.mu.Unlock()
+		if known {
+			return nil
+		}
+		// A folder moved in arrives with its contents.
+		return dl.syncFolder(ctx, f.Id, name)
+	}
+	dl.consider(ctx, f, name)
+	return nil
+}
+
+// syncFolder syncs the folder with the given ID to the slash-separated path
+// rel beneath the download directory.
+func (dl *Downloader) syncFolder(ctx context.Context, folderId, rel string) error {
+	q := fmt.Sprintf("'%s' in parents and trashed = false", folderId)
+	files, err := gdrive.Search(ctx, dl.d, q, "files(id,name,mimeType,size,md5Checksum,modifiedTime,appProperties)")
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		name := path.Join(rel, localName(f.Name))
+		if !gdrive.IsFolder(f) {
+			dl.consider(ctx, f, name)
+			continue
+		}
+		if dl.opts.Recursive {
+			dl.mu.Lock()
+			dl.state.Folders[f.Id] = name
+			dl.mu.Unlock()
+			if err := dl.syncFolder(ctx, f.Id, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// consider downloads f to name if it's a file that is downloaded and is new
+// or changed.
+func (dl *Downloader) consider(ctx context.Context, f *drive.File, name string) {
+	if strings.HasPrefix(f.MimeType, googleAppsPrefix) || f.AppProperties[gdrive.AppPropertyUploader] == gdrive.AppName {
+		return
+	}
+	modified, _ := time.Parse(time.RFC3339, f.ModifiedTime)
+	if !dl.changed(f, name, modified) {
+		return
+	}
+	if err := dl.download(ctx, f, name, modified); err != nil {
+		log.Printf("failed to download %s: %s", name, err)
+	}
+}
+
+// localName makes a Drive file name safe to use as a local one.
+func localName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == 0 {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == ".." {
+		name = "_" + name
+	}
+	return name
+}
+
+// changed reports whether f, to be downloaded to name, differs from what
+// was last downloaded.
+func (dl *Downloader) changed(f *drive.File, name string, modified time.Time) bool {
+	dl.mu.Lock()
+	d, ok := dl.state.Files[f.Id]
+	dl.mu.Unlock()
+	if ok {
+		return d.MD5 != f.Md5Checksum || !d.ModifiedTime.Equal(modified)
+	}
+	// Without a record, a local copy of the same size and age counts, so
+	// restarting without a state file doesn't fetch everything again.
+	fi, err := os.Stat(filepath.Join(dl.dir, filepath.FromSlash(name)))
+	return err != nil || fi.Size() != f.Size || !fi.ModTime().Equal(modified)
+}
+
+// download writes f to name beneath the download directory via a
+// temporary file, so a partial download never appears there, giving it f's
+// modification time.
+func (dl *Downloader) download(ctx context.Context, f *drive.File, name string, modified time.Time) error {
+	dst := filepath.Join(dl.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := gdrive.Download(ctx, dl.d, f.Id, tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if !modified.IsZero() {
+		if err := os.Chtimes(tmp.Name(), modified, modified); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+	log.Printf("Downloaded %s (%s) to %s", f.Name, humanize.Bytes(uint64(f.Size)), dst)
+	return dl.remember(f.Id, downloaded{Path: name, MD5: f.Md5Checksum, ModifiedTime: modified})
+}
+
+func (dl *Downloader) remember(id string, d downloaded) error {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.state.Files[id] = d
+	return dl.saveLocked()
+}
+
+func (dl *Downloader) saveLocked() error {
+	if dl.opts.StateFile == "" {
+		return nil
+	}
+	data, err := json.Marshal(dl.state)
+	if err != nil {
+		return err
+	}
+	if err := statefile.Write(dl.opts.StateFile, data, 0600); err != nil {
+		return fmt.Errorf("unable to write download state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/dknowles2/gdrive_sync/downloader"
+)
+
+var (
+	downloadDir       = flag.String("download_dir", "", "Also download files added to the Drive folder into this directory; files gdrive_sync uploaded are left alone")
+	downloadFolder    = flag.String("download_folder", "", "Drive folder name, path or URL that --download_dir is filled from (default --output_dir)")
+	downloadInterval  = flag.Duration("download_interval", downloader.DefaultInterval, "How often to check Drive for files to download into --download_dir")
+	downloadRecursive = flag.Bool("download_recursive", false, "Also download files in subfolders of --download_folder, into subdirectories")
+	downloadState     = flag.String("download_state_file", "", "Remember the files downloaded into --download_dir, and how far through the Drive changes feed downloads got, in this file so neither starts over after a restart")
+	downloadPushURL   = flag.String("download_push_url", "", "Public HTTPS URL proxied to /drive/changes on --http_addr, for Drive to notify of changes to download as they happen instead of waiting for --download_interval")
+)
+
+// newDownloader returns the Downloader the --download_* flags describe, or
+// nil without --download_dir.
+func newDownloader(ctx context.Context, pairs []syncPair) (*downloader.Downloader, error) {
+	if *downloadDir == "" {
+		return nil, nil
+	}
+	if *downloadPushURL != "" && *httpAddr == "" && *probeAddr == "" {
+		return nil, errors.New("--download_push_url needs --http_addr to receive notifications on")
+	}
+	// The uploader would send downloaded files straight back.
+	for _, p := range pairs {
+		if within(p.InputDir, *downloadDir) {
+			return nil, fmt.Errorf("--download_dir %s is inside input directory %s", *downloadDir, p.InputDir)
+		}
+	}
+	folder := *downloadFolder
+	if folder == "" {
+		folder = outputFolder()
+	}
+	def := flagPair()
+	d, err := def.driveConfig(daemonScopes()...).Service(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create drive service: %w", err)
+	}
+	return downloader.New(d, folder, *downloadDir, downloader.Options{
+		Interval:    *downloadInterval,
+		Recursive:   *downloadRecursive,
+		StateFile:   *downloadState,
+		PushAddress: *downloadPushURL,
+	})
+}
+
+// within reports whether path is root or inside it.
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/notify"
+)
+
+var (
+	smtpServer   = flag.String("smtp_server", "", "SMTP server (host:port) to send email notifications through")
+	smtpUsername = flag.String("smtp_username", "", "User name to log in to --smtp_server with")
+	smtpPassword = flag.String("smtp_password", "", "Password to log in to --smtp_server with")
+	emailFrom    = flag.String("email_from", "", "Sender address of email notifications")
+	emailTo      = flag.String("email_to", "", "Comma-separated addresses to send email notifications to")
+	emailAlerts  = flag.Bool("email_alerts", true, "Email failures, inactivity, a reached transfer cap and network loss as they happen")
+	emailDigest  = flag.String("email_digest_at", "", "Email a daily digest of uploaded files, bytes transferred and errors at this local time, e.g. 18:00")
+)
+
+// emailNotifier returns the Email notifier the --smtp_* and --email_* flags
+// describe, or nil without --smtp_server, and when the digest is due each
+// day (negative for no digest).
+func emailNotifier() (*notify.Email, time.Duration, error) {
+	if *smtpServer == "" {
+		return nil, -1, nil
+	}
+	if _, _, err := net.SplitHostPort(*smtpServer); err != nil {
+		return nil, -1, fmt.Errorf("invalid --smtp_server: %w", err)
+	}
+	if *emailFrom == "" || *emailTo == "" {
+		return nil, -1, fmt.Errorf("--smtp_server needs --email_from and --email_to")
+	}
+	at := time.Duration(-1)
+	if *emailDigest != "" {
+		t, err := time.Parse("15:04", *emailDigest)
+		if err != nil {
+			return nil, -1, fmt.Errorf("invalid --email_digest_at %q: want HH:MM", *emailDigest)
+		}
+		at = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	e := &notify.Email{
+		Addr:     *smtpServer,
+		Username: *smtpUsername,
+		Password: *smtpPassword,
+		From:     *emailFrom,
+		To:       strings.Split(*emailTo, ","),
+		Alerts:   *emailAlerts,
+	}
+	return e, at, nil
+}
+
+// smtpPort returns the port of --smtp_server, or 0 without one.
+func smtpPort() uint64 {
+	_, port, err := net.SplitHostPort(*smtpServer)
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.ParseUint(port, 10, 16)
+	return n
+}
@@ -0,0 +1,99 @@
+// Package events defines the machine-readable pipeline events emitted by the
+// uploader and a sink that writes them as newline-delimited JSON.
+package events
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// Type identifies a pipeline transition.
+type Type string
+
+const (
+	Discovered Type = "discovered"
+	Waiting    Type = "waiting"
+	Uploading  Type = "uploading"
+	Progress   Type = "progress"
+	Uploaded   Type = "uploaded"
+	Deleted    Type = "deleted"
+	Archived   Type = "archived"
+	Failed     Type = "failed"
+	Inactive   Type = "inactive"
+	// Paused is emitted when the monthly transfer cap is reached. Bytes is
+	// the amount uploaded this month and Size the cap.
+	Paused Type = "paused"
+	// Offline is emitted when uploads from the input directory in File start
+	// being queued because Drive can't be reached; Error says why.
+	// BacklogCleared follows once they have all been dealt with, Size being
+	// how many there were.
+	Offline        Type = "offline"
+	BacklogCleared Type = "backlog_cleared"
+)
+
+// Event is a single pipeline transition for a file.
+type Event struct {
+	Time         time.Time `json:"time"`
+	Type         Type      `json:"type"`
+	Id           string    `json:"id,omitempty"`
+	File         string    `json:"file,omitempty"`
+	Bytes        int64     `json:"bytes,omitempty"`
+	Size         int64     `json:"size,omitempty"`
+	DriveFileId  string    `json:"drive_file_id,omitempty"`
+	PhotosItemId string    `json:"photos_item_id,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	// Link is the link to an uploaded file that was shared.
+	Link string `json:"link,omitempty"`
+}
+
+// Sink receives pipeline events.
+type Sink interface {
+	Emit(Event)
+}
+
+// Func is a Sink that calls a function with each event.
+type Func func(Event)
+
+func (f Func) Emit(e Event) {
+	f(e)
+}
+
+// Writer is a Sink that writes each event as one line of JSON.
+type Writer struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{enc: json.NewEncoder(w)}
+}
+
+func (w *Writer) Emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.enc.Encode(e); err != nil {
+		log.Printf("failed to write event: %s", err)
+	}
+}
+
+// Multi returns a Sink that forwards every event to each of sinks.
+func Multi(sinks ...Sink) Sink {
+	return multi(sinks)
+}
+
+type multi []Sink
+
+func (m multi) Emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	for _, s := range m {
+		s.Emit(e)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/dknowles2/gdrive_sync/gdrive"
+	"google.golang.org/api/drive/v3"
+)
+
+// fsck compares a local archive directory against the --output_dir Drive
+// folder by name, size and MD5.
+func fsck(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fsck <archive-dir>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("an archive directory is required")
+	}
+	dir := fs.Arg(0)
+
+	service, err := flagPair().driveConfig().Service(ctx)
+	if err != nil {
+		return err
+	}
+	folderId, err := gdrive.GetFolderId(service, outputFolder())
+	if err != nil {
+		return err
+	}
+	remoteFiles, err := gdrive.ListFolder(ctx, service, folderId)
+	if err != nil {
+		return err
+	}
+	remote := make(map[string][]*drive.File)
+	for _, f := range remoteFiles {
+		remote[f.Name] = append(remote[f.Name], f)
+	}
+
+	local, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list archive directory: %w", err)
+	}
+	problems := 0
+	seen := make(map[string]bool)
+	for _, fi := range local {
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		seen[fi.Name()] = true
+		candidates := remote[fi.Name()]
+		if len(candidates) == 0 {
+			fmt.Printf("missing_remote\t%s\n", fi.Name())
+			problems++
+			continue
+		}
+		sum := ""
+		matched := false
+		for _, rf := range candidates {
+			if rf.Size != fi.Size() {
+				continue
+			}
+			if sum == "" {
+				if sum, err = gdrive.FileMD5(filepath.Join(dir, fi.Name())); err != nil {
+					return err
+				}
+			}
+			if rf.Md5Checksum == sum {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fmt.Printf("mismatch\t%s\n", fi.Name())
+			problems++
+		}
+	}
+	for name := range remote {
+		if !seen[name] {
+			fmt.Printf("missing_local\t%s\n", name)
+			problems++
+		}
+	}
+	log.Printf("Compared %d local and %d remote files: %d problems", len(seen), len(remoteFiles), problems)
+	if problems > 0 {
+		return fmt.Errorf("%d inconsistencies found", problems)
+	}
+	return nil
+}
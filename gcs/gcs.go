@@ -0,0 +1,48 @@
+// Package gcs uploads objects to a Google Cloud Storage bucket.
+package gcs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+)
+
+// Bucket is a bucket objects are uploaded into, authorized with the
+// application default credentials: $GOOGLE_APPLICATION_CREDENTIALS, the
+// gcloud user, or the metadata server of the VM it runs on.
+type Bucket struct {
+	bucket string
+	prefix string
+	s      *storage.Service
+}
+
+// New returns a Bucket uploading objects named prefix+key into bucket.
+func New(ctx context.Context, bucket, prefix string) (*Bucket, error) {
+	s, err := storage.NewService(ctx, option.WithScopes(storage.DevstorageReadWriteScope))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create Cloud Storage client: %w", err)
+	}
+	return &Bucket{bucket: bucket, prefix: prefix, s: s}, nil
+}
+
+// Name returns the name of the backend.
+func (b *Bucket) Name() string {
+	return "gcs"
+}
+
+// Put uploads size bytes from r as the object at the slash-separated key
+// beneath the prefix, returning its gs:// URL. Errors are *googleapi.Error.
+func (b *Bucket) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (string, error) {
+	name := strings.TrimPrefix(b.prefix+key, "/")
+	obj := &storage.Object{Name: name, ContentType: contentType}
+	o, err := b.s.Objects.Insert(b.bucket, obj).Media(r, googleapi.ContentType(contentType)).Fields("name").Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("unable to upload to gs://%s/%s: %w", b.bucket, name, err)
+	}
+	return fmt.Sprintf("gs://%s/%s", b.bucket, o.Name), nil
+}
@@ -0,0 +1,242 @@
+package gdrive
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// The ways of authorizing a new token: a consent page in a browser that
+// redirects to localhost, or a short code entered on another device, for
+// headless machines.
+const (
+	AuthFlowBrowser = "browser"
+	AuthFlowDevice  = "device"
+)
+
+// Authorize runs the OAuth consent flow for c's credentials file and saves
+// the new token to its token file, replacing any token already there.
+func (c Config) Authorize(ctx context.Context) error {
+	config, err := c.OAuthConfig()
+	if err != nil {
+		return err
+	}
+	_, err = c.getNewToken(ctx, config, c.TokenFile)
+	return err
+}
+
+// getNewToken authorizes with c's flow and saves the token to path.
+func (c Config) getNewToken(ctx context.Context, config *oauth2.Config, path string) (*oauth2.Token, error) {
+	switch c.AuthFlow {
+	case "", AuthFlowBrowser:
+		return getTokenFromWeb(ctx, config, c.AuthPort, path)
+	case AuthFlowDevice:
+		return getTokenFromDevice(ctx, config, path)
+	}
+	return nil, fmt.Errorf("unknown auth flow %q", c.AuthFlow)
+}
+
+// getTokenFromWeb sends the user to the consent page with a redirect to a
+// temporary listener on localhost, which captures the authorization code.
+// When the browser is on another machine the redirect can't reach the
+// listener, so the URL it ends up at (or just the code) may be pasted on
+// stdin instead.
+func getTokenFromWeb(ctx context.Context, config *oauth2.Config, port int, path string) (*oauth2.Token, error) {
+	l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen for the OAuth redirect: %w", err)
+	}
+	defer l.Close()
+	c := *config
+	c.RedirectURL = fmt.Sprintf("http://localhost:%d/", l.Addr().(*net.TCPAddr).Port)
+	state, err := randomState()
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make(chan string, 2)
+	errs := make(chan error, 2)
+	done := make(chan struct{})
+	defer close(done)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Browsers also ask for things like /favicon.ico, and anything
+		// else on the machine can reach the listener; only a redirect
+		// carrying our state ends the flow.
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		q := r.URL.Query()
+		if q.Get("state") != state {
+			http.Error(w, "state mismatch in OAuth redirect", http.StatusBadRequest)
+			return
+		}
+		code, err := codeFromRedirect(q, state)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			select {
+			case errs <- err:
+			default:
+			}
+			return
+		}
+		fmt.Fprintln(w, "gdrive_sync is authorized. You can close this window.")
+		select {
+		case codes <- code:
+		default:
+		}
+	})}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	authURL := c.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce)
+	fmt.Printf("Go to the following link in your browser to authorize gdrive_sync:\n%v\n", authURL)
+	fmt.Printf("If the browser is on another machine, paste the address it is redirected to (or the code) here.\n")
+	openBrowser(authURL)
+	go readPastedCode(state, codes, done)
+
+	var code string
+	select {
+	case code = <-codes:
+	case err := <-errs:
+		return nil, fmt.Errorf("authorization failed: %w", err)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	token, err := c.Exchange(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve token from web %w", err)
+	}
+	if err := saveToken(path, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// readPastedCode sends the first code pasted on stdin to codes, giving up
+// once done is closed. A read already waiting on a terminal can't be
+// interrupted, so the line that ends it is dropped.
+func readPastedCode(state string, codes chan<- string, done <-chan struct{}) {
+	// Ends the read where stdin supports deadlines, as pipes do, and
+	// clears the deadline again for whatever reads stdin next.
+	var mu sync.Mutex
+	finished := false
+	go func() {
+		<-done
+		mu.Lock()
+		defer mu.Unlock()
+		if !finished {
+			os.Stdin.SetReadDeadline(time.Now())
+		}
+	}()
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		finished = true
+		os.Stdin.SetReadDeadline(time.Time{})
+	}()
+	s := bufio.NewScanner(os.Stdin)
+	for s.Scan() {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		code, err := codeFromPaste(line, state)
+		if err != nil {
+			fmt.Printf("%s; try again\n", err)
+			continue
+		}
+		select {
+		case codes <- code:
+		case <-done:
+		}
+		return
+	}
+}
+
+func saveToken(path string, token *oauth2.Token) error {
+	log.Printf("Saving credential file to: %s\n", path)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to cache oauth token: %w", err)
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(token)
+}
+
+func randomState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// codeFromRedirect returns the authorization code in the query of the
+// redirect back from the consent page.
+func codeFromRedirect(q url.Values, state string) (string, error) {
+	if e := q.Get("error"); e != "" {
+		return "", errors.New(e)
+	}
+	if q.Get("state") != state {
+		return "", errors.New("state mismatch in OAuth redirect")
+	}
+	code := q.Get("code")
+	if code == "" {
+		return "", errors.New("no code in OAuth redirect")
+	}
+	return code, nil
+}
+
+// codeFromPaste accepts either the full redirect URL or a bare code.
+func codeFromPaste(s, state string) (string, error) {
+	if !strings.Contains(s, "://") {
+		return s, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("not a URL: %w", err)
+	}
+	return codeFromRedirect(u.Query(), state)
+}
+
+// openBrowser tries to show u in the desktop's browser, silently doing
+// nothing where there isn't one.
+func openBrowser(u string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", u)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", u)
+	default:
+		if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			return
+		}
+		cmd = exec.Command("xdg-open", u)
+	}
+	if cmd.Start() == nil {
+		go cmd.Wait()
+	}
+}
@@ -0,0 +1,80 @@
+package gdrive
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// changeFields is what is fetched about each changed file.
+const changeFields = "changes(fileId,removed,file(id,name,mimeType,size,md5Checksum,modifiedTime,parents,trashed,appProperties))"
+
+// StartPageToken returns the token from which the changes feed reports
+// changes made from now on, in d's shared drive if it has one.
+func StartPageToken(ctx context.Context, d *Drive) (string, error) {
+	call := d.Changes.GetStartPageToken().SupportsAllDrives(true).Context(ctx)
+	if id := d.SharedDriveId; id != "" {
+		call = call.DriveId(id)
+	}
+	r, err := call.Do()
+	if err != nil {
+		return "", fmt.Errorf("unable to get Drive changes token: %w", err)
+	}
+	return r.StartPageToken, nil
+}
+
+// ListChanges returns the changes since the page token, oldest first, and
+// the token to list the changes after them from.
+func ListChanges(ctx context.Context, d *Drive, token string) ([]*drive.Change, string, error) {
+	var changes []*drive.Change
+	for {
+		call := d.Changes.List(token).
+			SupportsAllDrives(true).
+			IncludeItemsFromAllDrives(true).
+			IncludeRemoved(true).
+			PageSize(1000).
+			Fields("nextPageToken", "newStartPageToken", changeFields).
+			Context(ctx)
+		if id := d.SharedDriveId; id != "" {
+			call = call.DriveId(id)
+		}
+		r, err := call.Do()
+		if err != nil {
+			return nil, "", fmt.Errorf("unable to list Drive changes: %w", err)
+		}
+		changes = append(changes, r.Changes...)
+		if r.NextPageToken == "" {
+			return changes, r.NewStartPageToken, nil
+		}
+		token = r.NextPageToken
+	}
+}
+
+// WatchChanges asks Drive to POST to address whenever there are changes
+// after the page token, until expiry. secret is sent back in the
+// X-Goog-Channel-Token header of every notification.
+func WatchChanges(ctx context.Context, d *Drive, token, id, address, secret string, expiry time.Time) (*drive.Channel, error) {
+	ch := &drive.Channel{
+		Id:         id,
+		Type:       "web_hook",
+		Address:    address,
+		Token:      secret,
+		Expiration: expiry.UnixNano() / int64(time.Millisecond),
+	}
+	call := d.Changes.Watch(token, ch).SupportsAllDrives(true).IncludeItemsFromAllDrives(true).IncludeRemoved(true).Context(ctx)
+	if id := d.SharedDriveId; id != "" {
+		call = call.DriveId(id)
+	}
+	ch, err := call.Do()
+	if err != nil {
+		return nil, fmt.Errorf("unable to watch Drive changes: %w", err)
+	}
+	return ch, nil
+}
+
+// StopChannel stops the notifications sent to a channel.
+func StopChannel(ctx context.Context, d *Drive, ch *drive.Channel) error {
+	return d.Channels.Stop(&drive.Channel{Id: ch.Id, ResourceId: ch.ResourceId}).Context(ctx).Do()
+}
@@ -0,0 +1,132 @@
+package gdrive
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// chaosClient returns a client authorized with token whose requests, token
+// refreshes included, go through a chaosTransport.
+func chaosClient(ctx context.Context, config *oauth2.Config, token *oauth2.Token, rate float64, maxDelay time.Duration) *http.Client {
+	t := newChaosTransport(http.DefaultTransport, rate, maxDelay)
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: t})
+	src := &chaosTokenSource{config: config, ctx: ctx, src: config.TokenSource(ctx, token)}
+	t.expire = src.expire
+	return &http.Client{Transport: &oauth2.Transport{Base: t, Source: src}}
+}
+
+// chaosTransport randomly fails, slows or de-authorizes a proportion rate of
+// requests, for exercising retry, alerting and quarantine setups.
+type chaosTransport struct {
+	base     http.RoundTripper
+	rate     float64
+	maxDelay time.Duration
+	// expire makes the client's cached access token expire.
+	expire func()
+}
+
+func newChaosTransport(base http.RoundTripper, rate float64, maxDelay time.Duration) *chaosTransport {
+	log.Printf("WARNING: chaos mode enabled; %.0f%% of Drive API requests will be faulted", rate*100)
+	rand.Seed(time.Now().UnixNano())
+	return &chaosTransport{base: base, rate: rate, maxDelay: maxDelay}
+}
+
+func (t *chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rand.Float64() >= t.rate {
+		return t.base.RoundTrip(req)
+	}
+	switch rand.Intn(3) {
+	case 0:
+		log.Printf("chaos: failing %s %s", req.Method, req.URL.Path)
+		closeBody(req)
+		return fakeResponse(req, http.StatusServiceUnavailable, "backendError"), nil
+	case 1:
+		d := time.Duration(rand.Int63n(int64(t.maxDelay) + 1))
+		log.Printf("chaos: delaying %s %s by %s", req.Method, req.URL.Path, d)
+		select {
+		case <-time.After(d):
+		case <-req.Context().Done():
+			closeBody(req)
+			return nil, req.Context().Err()
+		}
+		return t.base.RoundTrip(req)
+	default:
+		// The request already carries the token, so it's the next one
+		// that has to refresh it.
+		log.Printf("chaos: expiring token after %s %s", req.Method, req.URL.Path)
+		if t.expire != nil {
+			t.expire()
+		}
+		return t.base.RoundTrip(req)
+	}
+}
+
+// closeBody closes the body of a request that won't be sent, as a
+// RoundTripper must.
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+// chaosTokenSource is a client's token source, which a chaosTransport can
+// make forget its access token.
+type chaosTokenSource struct {
+	config *oauth2.Config
+	ctx    context.Context
+
+	mu   sync.Mutex
+	src  oauth2.TokenSource
+	last *oauth2.Token
+}
+
+func (s *chaosTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	src := s.src
+	s.mu.Unlock()
+	tok, err := src.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.last = tok
+	s.mu.Unlock()
+	return tok, nil
+}
+
+// expire replaces the cached token with an expired copy, so that it is
+// refreshed before the next request. It doesn't call the source, which may
+// be in the middle of that refresh.
+func (s *chaosTokenSource) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last == nil {
+		return
+	}
+	expired := *s.last
+	expired.Expiry = time.Now().Add(-time.Minute)
+	s.src = s.config.TokenSource(s.ctx, &expired)
+}
+
+func fakeResponse(req *http.Request, code int, reason string) *http.Response {
+	body := fmt.Sprintf(`{"error":{"code":%d,"message":"chaos: %s","errors":[{"reason":%q}]}}`, code, http.StatusText(code), reason)
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		StatusCode: code,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+		Request:    req,
+	}
+}
@@ -0,0 +1,141 @@
+package gdrive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/drive/v3"
+)
+
+// Google's endpoint for the OAuth device authorization grant (RFC 8628).
+const deviceCodeURL = "https://oauth2.googleapis.com/device/code"
+
+const deviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// deviceScopes are the scopes Google allows with the device flow. The full
+// Drive scope gdrive_sync needs, and the Photos scopes, aren't among them.
+var deviceScopes = map[string]bool{
+	drive.DriveFileScope:    true,
+	drive.DriveAppdataScope: true,
+	"openid":                true,
+	"email":                 true,
+	"profile":               true,
+}
+
+type deviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURL string `json:"verification_url"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+	Error           string `json:"error"`
+	Description     string `json:"error_description"`
+}
+
+type deviceToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	Error        string `json:"error"`
+	Description  string `json:"error_description"`
+}
+
+// getTokenFromDevice authorizes with the device flow, for machines without a
+// browser: it prints a short code to enter at a URL on any other device and
+// polls until the user approves. The OAuth client must be of the "TVs and
+// Limited Input devices" type, and every scope must be one Google allows
+// with the flow.
+func getTokenFromDevice(ctx context.Context, config *oauth2.Config, path string) (*oauth2.Token, error) {
+	for _, scope := range config.Scopes {
+		if !deviceScopes[scope] {
+			return nil, fmt.Errorf("the device flow can't authorize scope %s, which Google only grants through a browser; use --auth_flow=browser and paste the redirected URL if the browser is on another machine", scope)
+		}
+	}
+	var dc deviceCode
+	err := postForm(ctx, deviceCodeURL, url.Values{
+		"client_id": {config.ClientID},
+		"scope":     {strings.Join(config.Scopes, " ")},
+	}, &dc)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start device authorization: %w", err)
+	}
+	if dc.Error != "" {
+		return nil, fmt.Errorf("unable to start device authorization: %s: %s", dc.Error, dc.Description)
+	}
+	fmt.Printf("To authorize gdrive_sync, visit %s on any device and enter the code: %s\n", dc.VerificationURL, dc.UserCode)
+
+	interval := time.Duration(dc.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(dc.ExpiresIn) * time.Second)
+	for time.Now().Before(deadline) {
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		var dt deviceToken
+		err := postForm(ctx, config.Endpoint.TokenURL, url.Values{
+			"client_id":     {config.ClientID},
+			"client_secret": {config.ClientSecret},
+			"device_code":   {dc.DeviceCode},
+			"grant_type":    {deviceGrantType},
+		}, &dt)
+		if err != nil {
+			return nil, fmt.Errorf("unable to poll for device authorization: %w", err)
+		}
+		switch dt.Error {
+		case "":
+			token := &oauth2.Token{
+				AccessToken:  dt.AccessToken,
+				RefreshToken: dt.RefreshToken,
+				TokenType:    dt.TokenType,
+				Expiry:       time.Now().Add(time.Duration(dt.ExpiresIn) * time.Second),
+			}
+			if err := saveToken(path, token); err != nil {
+				return nil, err
+			}
+			return token, nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, fmt.Errorf("device authorization failed: %s: %s", dt.Error, dt.Description)
+		}
+	}
+	return nil, errors.New("device authorization expired before it was approved")
+}
+
+// postForm POSTs form to u and decodes the JSON response into v. OAuth
+// error responses are decoded too, so only transport and parse failures are
+// returned as errors.
+func postForm(ctx context.Context, u string, form url.Values, v interface{}) error {
+	req, err := http.NewRequest("POST", u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("%s: %s", res.Status, body)
+	}
+	return nil
+}
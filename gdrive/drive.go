@@ -0,0 +1,43 @@
+package gdrive
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/drive/v3"
+)
+
+// Drive is a Drive service along with what the Drive library keeps private
+// or doesn't know about: the HTTP client, since resumable sessions have to be
+// driven by hand to outlive the process and the status page reports on the
+// OAuth token, and the shared drive the service is confined to.
+type Drive struct {
+	*drive.Service
+
+	// Client is the HTTP client the service sends its requests with.
+	Client *http.Client
+
+	// SharedDriveId is the ID of the shared drive to work in, or "" for the
+	// account's own My Drive.
+	SharedDriveId string
+}
+
+// TokenExpiry returns when the access token srv is using expires, refreshing
+// it first if it already has. An error means the saved refresh token no
+// longer works and the account needs authorizing again.
+func TokenExpiry(srv *Drive) (time.Time, error) {
+	if srv.Client == nil {
+		return time.Time{}, errors.New("not a service from gdrive.New")
+	}
+	t, ok := srv.Client.Transport.(*oauth2.Transport)
+	if !ok {
+		return time.Time{}, errors.New("service is not authorized with OAuth")
+	}
+	tok, err := t.Source.Token()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return tok.Expiry, nil
+}
@@ -0,0 +1,757 @@
+// Package fakedrive is an in-memory Drive serving the part of the Drive v3
+// API gdrive_sync uses: folder lookup and creation, listing with queries,
+// simple, multipart and resumable uploads, downloads, trashing and
+// deleting, permissions, revisions, the storage quota and the changes
+// feed. It runs behind an httptest server, so anything taking a
+// *gdrive.Drive can be exercised without Google:
+//
+//	fd := fakedrive.New()
+//	defer fd.Close()
+//	service, err := fd.Service(ctx)
+//	...
+//	fd.FailNext(http.StatusServiceUnavailable, 2)
+//
+// File contents and metadata can be set up and inspected directly.
+package fakedrive
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/gdrive"
+	"google.golang.org/api/drive/v3"
+)
+
+// RootId is the ID of the root of My Drive, which is also its alias "root".
+const RootId = "root"
+
+// appData is the ID of the hidden application data folder.
+const appData = "appDataFolder"
+
+// DefaultQuota is the storage limit a new Server reports.
+const DefaultQuota = 15 << 30
+
+// timeLayout is how Drive formats times.
+const timeLayout = "2006-01-02T15:04:05.000Z"
+
+type file struct {
+	meta     drive.File
+	content  []byte
+	seq      int
+	revision int
+}
+
+// Server is a fake Drive.
+type Server struct {
+	// Fault, if set, is called before every request is handled. A nonzero
+	// status fails the request with that status instead, as Drive would.
+	Fault func(r *http.Request) int
+
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	files    map[string]*file
+	seq      int
+	sessions map[string]*session
+	changes  []*drive.Change
+	failNext []int
+	requests []string
+	quota    int64
+}
+
+// New starts a fake Drive holding an empty My Drive.
+func New() *Server {
+	s := &Server{
+		files:    make(map[string]*file),
+		sessions: make(map[string]*session),
+		quota:    DefaultQuota,
+	}
+	now := time.Now().UTC().Format(timeLayout)
+	for _, id := range []string{RootId, appData} {
+		s.files[id] = &file{meta: drive.File{
+			Id:           id,
+			Name:         "My Drive",
+			MimeType:     gdrive.FolderMimeType,
+			CreatedTime:  now,
+			ModifiedTime: now,
+		}}
+	}
+	s.srv = httptest.NewServer(s)
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// URL is the server's base URL.
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+// Service returns a Drive service talking to s, usable for resumable
+// uploads like those from gdrive.New.
+func (s *Server) Service(ctx context.Context) (*gdrive.Drive, error) {
+	return gdrive.New(ctx, gdrive.WithHTTPClient(s.srv.Client()), gdrive.WithEndpoint(s.srv.URL+"/drive/v3/"))
+}
+
+// FailNext fails the next n requests with status.
+func (s *Server) FailNext(status, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.failNext = append(s.failNext, status)
+	}
+}
+
+// Requests returns the method and path of every request served so far,
+// such as "POST /upload/drive/v3/files".
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// SetQuota sets the storage limit; uploads that would exceed it fail.
+func (s *Server) SetQuota(limit int64) {
+	s.mu.Lock()
+	s.quota = limit
+	s.mu.Unlock()
+}
+
+// AddFolder creates a folder named name in parentId and returns its ID.
+func (s *Server) AddFolder(parentId, name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := s.create(&drive.File{Name: name, MimeType: gdrive.FolderMimeType, Parents: []string{parentId}}, nil)
+	if err != nil {
+		panic(err)
+	}
+	return f.meta.Id
+}
+
+// AddFile creates a file named name in parentId and returns its ID.
+func (s *Server) AddFile(parentId, name string, content []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := s.create(&drive.File{Name: name, Parents: []string{parentId}}, content)
+	if err != nil {
+		panic(err)
+	}
+	return f.meta.Id
+}
+
+// File returns the metadata of the file with the given ID.
+func (s *Server) File(id string) (*drive.File, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[id]
+	if !ok {
+		return nil, false
+	}
+	return f.copy(), true
+}
+
+// Content returns the contents of the file with the given ID.
+func (s *Server) Content(id string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[id]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), f.content...), true
+}
+
+// Find returns the untrashed file named name in parentId.
+func (s *Server) Find(parentId, name string) (*drive.File, bool) {
+	for _, f := range s.Children(parentId) {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// Children returns the untrashed files in parentId, oldest first.
+func (s *Server) Children(parentId string) []*drive.File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var fs []*file
+	for _, f := range s.files {
+		if !f.meta.Trashed && f.inParent(parentId) {
+			fs = append(fs, f)
+		}
+	}
+	sort.Slice(fs, func(i, j int) bool { return fs[i].seq < fs[j].seq })
+	var out []*drive.File
+	for _, f := range fs {
+		out = append(out, f.copy())
+	}
+	return out
+}
+
+func (f *file) copy() *drive.File {
+	m := f.meta
+	m.Parents = append([]string(nil), m.Parents...)
+	m.AppProperties = copyMap(m.AppProperties)
+	m.Properties = copyMap(m.Properties)
+	m.Permissions = append([]*drive.Permission(nil), m.Permissions...)
+	return &m
+}
+
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func (f *file) inParent(id string) bool {
+	for _, p := range f.meta.Parents {
+		if p == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *file) isFolder() bool {
+	return f.meta.MimeType == gdrive.FolderMimeType
+}
+
+// inAppData reports whether f is in the application data folder, which
+// only listings of that space see.
+func (s *Server) inAppData(f *file) bool {
+	for depth := 0; depth < 100 && len(f.meta.Parents) > 0; depth++ {
+		p := f.meta.Parents[0]
+		if p == appData {
+			return true
+		}
+		if f = s.files[p]; f == nil {
+			return false
+		}
+	}
+	return false
+}
+
+// create adds a file with metadata m and, unless it is nil, content. s.mu
+// must be held.
+func (s *Server) create(m *drive.File, content []byte) (*file, error) {
+	parents := m.Parents
+	if len(parents) == 0 {
+		parents = []string{RootId}
+	}
+	for _, p := range parents {
+		pf, ok := s.files[p]
+		if !ok {
+			return nil, notFound(p)
+		}
+		if !pf.isFolder() {
+			return nil, &apiError{http.StatusBadRequest, "invalidParent", "Parent " + p + " is not a folder."}
+		}
+	}
+	if err := s.checkQuota(int64(len(content))); err != nil {
+		return nil, err
+	}
+	s.seq++
+	id := fmt.Sprintf("fake%06d", s.seq)
+	now := time.Now().UTC().Format(timeLayout)
+	f := &file{seq: s.seq, meta: drive.File{
+		Id:            id,
+		Kind:          "drive#file",
+		Name:          m.Name,
+		MimeType:      m.MimeType,
+		Description:   m.Description,
+		Parents:       append([]string(nil), parents...),
+		AppProperties: copyMap(m.AppProperties),
+		Properties:    copyMap(m.Properties),
+		CreatedTime:   now,
+		ModifiedTime:  m.ModifiedTime,
+		Starred:       m.Starred,
+	}}
+	if f.meta.Name == "" {
+		f.meta.Name = "Untitled"
+	}
+	if f.meta.MimeType == "" {
+		f.meta.MimeType = "application/octet-stream"
+	}
+	if f.meta.ModifiedTime == "" {
+		f.meta.ModifiedTime = now
+	}
+	if f.isFolder() {
+		f.meta.WebViewLink = "https://drive.google.com/drive/folders/" + id
+	} else {
+		f.meta.WebViewLink = "https://drive.google.com/file/d/" + id + "/view"
+		f.setContent(content)
+	}
+	s.files[id] = f
+	s.record(f, false)
+	return f, nil
+}
+
+// setContent replaces f's contents, making a new revision.
+func (f *file) setContent(content []byte) {
+	f.content = append([]byte(nil), content...)
+	f.revision++
+	f.meta.HeadRevisionId = strconv.Itoa(f.revision)
+	f.meta.Version = int64(f.revision)
+	if strings.HasPrefix(f.meta.MimeType, "application/vnd.google-apps.") {
+		// Google Docs have neither a size nor a checksum.
+		return
+	}
+	sum := md5.Sum(content)
+	f.meta.Md5Checksum = hex.EncodeToString(sum[:])
+	f.meta.Size = int64(len(content))
+}
+
+// update applies the fields present in raw from m, and the addParents and
+// removeParents parameters, to f. s.mu must be held.
+func (s *Server) update(f *file, m *drive.File, raw map[string]json.RawMessage, add, remove string) error {
+	for k := range raw {
+		switch k {
+		case "name":
+			f.meta.Name = m.Name
+		case "mimeType":
+			f.meta.MimeType = m.MimeType
+		case "description":
+			f.meta.Description = m.Description
+		case "modifiedTime":
+			f.meta.ModifiedTime = m.ModifiedTime
+		case "trashed":
+			f.meta.Trashed = m.Trashed
+		case "starred":
+			f.meta.Starred = m.Starred
+		case "appProperties":
+			f.meta.AppProperties = mergeProperties(f.meta.AppProperties, raw[k])
+		case "properties":
+			f.meta.Properties = mergeProperties(f.meta.Properties, raw[k])
+		case "parents":
+			return &apiError{http.StatusForbidden, "fieldNotWritable", "The parents field is not directly writable in update requests. Use the addParents and removeParents parameters instead."}
+		}
+	}
+	if remove != "" {
+		for _, p := range strings.Split(remove, ",") {
+			for i, q := range f.meta.Parents {
+				if q == p {
+					f.meta.Parents = append(f.meta.Parents[:i], f.meta.Parents[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+	if add != "" {
+		for _, p := range strings.Split(add, ",") {
+			pf, ok := s.files[p]
+			if !ok {
+				return notFound(p)
+			}
+			if !pf.isFolder() {
+				return &apiError{http.StatusBadRequest, "invalidParent", "Parent " + p + " is not a folder."}
+			}
+			if !f.inParent(p) {
+				f.meta.Parents = append(f.meta.Parents, p)
+			}
+		}
+	}
+	return nil
+}
+
+// mergeProperties applies a JSON object of properties to m, null values
+// deleting them.
+func mergeProperties(m map[string]string, raw json.RawMessage) map[string]string {
+	var props map[string]*string
+	json.Unmarshal(raw, &props)
+	if m == nil {
+		m = make(map[string]string)
+	}
+	for k, v := range props {
+		if v == nil {
+			delete(m, k)
+		} else {
+			m[k] = *v
+		}
+	}
+	return m
+}
+
+// remove deletes f and everything in it. s.mu must be held.
+func (s *Server) remove(f *file) {
+	if f.isFolder() {
+		for _, c := range s.files {
+			if c.inParent(f.meta.Id) && len(c.meta.Parents) == 1 {
+				s.remove(c)
+			}
+		}
+	}
+	delete(s.files, f.meta.Id)
+	s.record(f, true)
+}
+
+// record adds a change to the changes feed. s.mu must be held.
+func (s *Server) record(f *file, removed bool) {
+	c := &drive.Change{
+		Kind:       "drive#change",
+		ChangeType: "file",
+		FileId:     f.meta.Id,
+		Removed:    removed,
+		Time:       time.Now().UTC().Format(timeLayout),
+	}
+	if !removed {
+		c.File = f.copy()
+	}
+	s.changes = append(s.changes, c)
+}
+
+// usage is the bytes all files take up. s.mu must be held.
+func (s *Server) usage() int64 {
+	var n int64
+	for _, f := range s.files {
+		n += int64(len(f.content))
+	}
+	return n
+}
+
+// checkQuota fails if n more bytes would exceed the quota. s.mu must be
+// held.
+func (s *Server) checkQuota(n int64) error {
+	if s.quota > 0 && s.usage()+n > s.quota {
+		return &apiError{http.StatusForbidden, "storageQuotaExceeded", "The user's Drive storage quota has been exceeded."}
+	}
+	return nil
+}
+
+// apiError is an error in the JSON form Drive returns.
+type apiError struct {
+	code    int
+	reason  string
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func notFound(id string) *apiError {
+	return &apiError{http.StatusNotFound, "notFound", "File not found: " + id + "."}
+}
+
+// faultReasons are the reasons Drive gives with each status of an injected
+// fault.
+var faultReasons = map[int]string{
+	http.StatusBadRequest:          "badRequest",
+	http.StatusUnauthorized:        "authError",
+	http.StatusForbidden:           "userRateLimitExceeded",
+	http.StatusNotFound:            "notFound",
+	http.StatusTooManyRequests:     "rateLimitExceeded",
+	http.StatusInternalServerError: "internalError",
+	http.StatusBadGateway:          "backendError",
+	http.StatusServiceUnavailable:  "backendError",
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	e, ok := err.(*apiError)
+	if !ok {
+		e = &apiError{http.StatusBadRequest, "badRequest", err.Error()}
+	}
+	body := map[string]interface{}{"error": map[string]interface{}{
+		"code":    e.code,
+		"message": e.message,
+		"errors":  []map[string]string{{"domain": "global", "reason": e.reason, "message": e.message}},
+	}}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(e.code)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(v)
+}
+
+// decodeMetadata decodes a JSON file resource, also returning which fields
+// it has.
+func decodeMetadata(b []byte) (*drive.File, map[string]json.RawMessage, error) {
+	m := &drive.File{}
+	raw := make(map[string]json.RawMessage)
+	if len(strings.TrimSpace(string(b))) == 0 {
+		return m, raw, nil
+	}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, nil, &apiError{http.StatusBadRequest, "parseError", "Parse Error"}
+	}
+	json.Unmarshal(b, &raw)
+	return m, raw, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	status := 0
+	if len(s.failNext) > 0 {
+		status, s.failNext = s.failNext[0], s.failNext[1:]
+	}
+	s.mu.Unlock()
+	if status == 0 && s.Fault != nil {
+		status = s.Fault(r)
+	}
+	if status != 0 {
+		ioutil.ReadAll(r.Body)
+		reason, ok := faultReasons[status]
+		if !ok {
+			reason = "unknown"
+		}
+		writeError(w, &apiError{status, reason, http.StatusText(status)})
+		return
+	}
+
+	p := r.URL.Path
+	switch {
+	case strings.HasPrefix(p, sessionPath):
+		s.serveSession(w, r, strings.TrimPrefix(p, sessionPath))
+	case p == "/upload/drive/v3/files" && r.Method == "POST":
+		s.serveUpload(w, r, "")
+	case strings.HasPrefix(p, "/upload/drive/v3/files/") && r.Method == "PATCH":
+		s.serveUpload(w, r, strings.TrimPrefix(p, "/upload/drive/v3/files/"))
+	case strings.HasPrefix(p, "/drive/v3/"):
+		s.serveAPI(w, r, strings.Split(strings.TrimPrefix(p, "/drive/v3/"), "/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request, path []string) {
+	route := r.Method + " " + path[0]
+	if len(path) > 1 {
+		route += "/" + path[1]
+		if path[0] == "files" {
+			route = r.Method + " files/{id}"
+			if len(path) > 2 {
+				route += "/" + path[2]
+			}
+		}
+	}
+	switch route {
+	case "GET files":
+		s.list(w, r)
+	case "POST files":
+		body, _ := ioutil.ReadAll(r.Body)
+		m, _, err := decodeMetadata(body)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		f, err := s.create(m, nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, f.copy())
+	case "GET files/{id}":
+		s.mu.Lock()
+		f, ok := s.files[path[1]]
+		var v interface{}
+		var content []byte
+		if ok {
+			v, content = f.copy(), f.content
+		}
+		s.mu.Unlock()
+		switch {
+		case !ok:
+			writeError(w, notFound(path[1]))
+		case r.URL.Query().Get("alt") == "media":
+			if f.isFolder() || strings.HasPrefix(f.meta.MimeType, "application/vnd.google-apps.") {
+				writeError(w, &apiError{http.StatusForbidden, "fileNotDownloadable", "Only files with binary content can be downloaded."})
+				return
+			}
+			w.Header().Set("Content-Type", f.meta.MimeType)
+			w.Write(content)
+		default:
+			writeJSON(w, v)
+		}
+	case "PATCH files/{id}":
+		body, _ := ioutil.ReadAll(r.Body)
+		m, raw, err := decodeMetadata(body)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		f, ok := s.files[path[1]]
+		if !ok {
+			writeError(w, notFound(path[1]))
+			return
+		}
+		q := r.URL.Query()
+		if err := s.update(f, m, raw, q.Get("addParents"), q.Get("removeParents")); err != nil {
+			writeError(w, err)
+			return
+		}
+		s.record(f, false)
+		writeJSON(w, f.copy())
+	case "DELETE files/{id}":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		f, ok := s.files[path[1]]
+		if !ok || f.meta.Id == RootId || f.meta.Id == appData {
+			writeError(w, notFound(path[1]))
+			return
+		}
+		s.remove(f)
+		w.WriteHeader(http.StatusNoContent)
+	case "POST files/{id}/permissions":
+		var perm drive.Permission
+		if err := json.NewDecoder(r.Body).Decode(&perm); err != nil {
+			writeError(w, &apiError{http.StatusBadRequest, "parseError", "Parse Error"})
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		f, ok := s.files[path[1]]
+		if !ok {
+			writeError(w, notFound(path[1]))
+			return
+		}
+		perm.Kind = "drive#permission"
+		perm.Id = fmt.Sprintf("perm%d", len(f.meta.Permissions)+1)
+		f.meta.Permissions = append(f.meta.Permissions, &perm)
+		writeJSON(w, &perm)
+	case "PATCH files/{id}/revisions":
+		var rev drive.Revision
+		json.NewDecoder(r.Body).Decode(&rev)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		f, ok := s.files[path[1]]
+		if !ok || len(path) < 4 || path[3] != f.meta.HeadRevisionId {
+			writeError(w, &apiError{http.StatusNotFound, "notFound", "Revision not found."})
+			return
+		}
+		rev.Kind, rev.Id = "drive#revision", path[3]
+		writeJSON(w, &rev)
+	case "GET about":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		writeJSON(w, &drive.About{
+			Kind:         "drive#about",
+			StorageQuota: &drive.AboutStorageQuota{Limit: s.quota, Usage: s.usage(), UsageInDrive: s.usage()},
+			User:         &drive.User{Kind: "drive#user", DisplayName: "Fake User", EmailAddress: "fake@example.com", Me: true},
+		})
+	case "GET changes/startPageToken":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		writeJSON(w, &drive.StartPageToken{Kind: "drive#startPageToken", StartPageToken: strconv.Itoa(len(s.changes) + 1)})
+	case "GET changes":
+		s.listChanges(w, r)
+	case "POST changes/watch", "POST channels/stop":
+		var ch drive.Channel
+		json.NewDecoder(r.Body).Decode(&ch)
+		if path[0] == "channels" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		ch.Kind, ch.ResourceId = "api#channel", "fake-changes"
+		writeJSON(w, &ch)
+	default:
+		writeError(w, &apiError{http.StatusNotFound, "notFound", "Not found: " + r.Method + " " + r.URL.Path})
+	}
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	match, err := parseQuery(q.Get("q"))
+	if err != nil {
+		writeError(w, &apiError{http.StatusBadRequest, "invalid", "Invalid Value: " + err.Error()})
+		return
+	}
+	less, err := ordering(q.Get("orderBy"))
+	if err != nil {
+		writeError(w, &apiError{http.StatusBadRequest, "invalid", "Invalid Value: " + err.Error()})
+		return
+	}
+	appDataSpace := strings.Contains(q.Get("spaces"), appData)
+
+	s.mu.Lock()
+	var fs []*file
+	for _, f := range s.files {
+		if f.meta.Id == RootId || f.meta.Id == appData || s.inAppData(f) != appDataSpace {
+			continue
+		}
+		if match(f) {
+			fs = append(fs, f)
+		}
+	}
+	sort.Slice(fs, func(i, j int) bool { return less(fs[i], fs[j]) })
+	var files []*drive.File
+	for _, f := range fs {
+		files = append(files, f.copy())
+	}
+	s.mu.Unlock()
+
+	start, _ := strconv.Atoi(q.Get("pageToken"))
+	if start < 0 || start > len(files) {
+		start = len(files)
+	}
+	end := start + pageSize(q)
+	res := &drive.FileList{Kind: "drive#fileList"}
+	if end < len(files) {
+		res.NextPageToken = strconv.Itoa(end)
+	} else {
+		end = len(files)
+	}
+	res.Files = files[start:end]
+	writeJSON(w, res)
+}
+
+func (s *Server) listChanges(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	start, err := strconv.Atoi(q.Get("pageToken"))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil || start < 1 || start > len(s.changes)+1 {
+		writeError(w, &apiError{http.StatusBadRequest, "invalid", "Invalid Value: pageToken"})
+		return
+	}
+	size := pageSize(q)
+	end := start - 1 + size
+	res := &drive.ChangeList{Kind: "drive#changeList"}
+	if end >= len(s.changes) {
+		end = len(s.changes)
+		res.NewStartPageToken = strconv.Itoa(len(s.changes) + 1)
+	} else {
+		res.NextPageToken = strconv.Itoa(end + 1)
+	}
+	res.Changes = s.changes[start-1 : end]
+	writeJSON(w, res)
+}
+
+// pageSize is the pageSize parameter, bounded as Drive bounds it.
+func pageSize(q url.Values) int {
+	n, err := strconv.Atoi(q.Get("pageSize"))
+	if err != nil || n <= 0 {
+		return 100
+	}
+	if n > 1000 {
+		return 1000
+	}
+	return n
+}
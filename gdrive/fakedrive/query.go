@@ -0,0 +1,342 @@
+package fakedrive
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// match reports whether a file satisfies a query.
+type match func(f *file) bool
+
+// parseQuery parses the Drive search query language: comparisons of name,
+// mimeType, description, trashed, starred, createdTime and modifiedTime,
+// "'id' in parents", "appProperties has { key='k' and value='v' }",
+// combined with and, or, not and parentheses.
+func parseQuery(q string) (match, error) {
+	if strings.TrimSpace(q) == "" {
+		return func(*file) bool { return true }, nil
+	}
+	toks, err := tokenize(q)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	m, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q in query", p.toks[p.pos].text)
+	}
+	return m, nil
+}
+
+type token struct {
+	text   string
+	quoted bool
+}
+
+func tokenize(q string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(q); {
+		c := q[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '\'':
+			var b strings.Builder
+			i++
+			for ; i < len(q) && q[i] != '\''; i++ {
+				if q[i] == '\\' && i+1 < len(q) {
+					i++
+				}
+				b.WriteByte(q[i])
+			}
+			if i == len(q) {
+				return nil, fmt.Errorf("unterminated string in query %q", q)
+			}
+			i++
+			toks = append(toks, token{text: b.String(), quoted: true})
+		case strings.IndexByte("(){}", c) >= 0:
+			toks = append(toks, token{text: string(c)})
+			i++
+		case strings.IndexByte("=!<>", c) >= 0:
+			j := i + 1
+			if j < len(q) && q[j] == '=' {
+				j++
+			}
+			toks = append(toks, token{text: q[i:j]})
+			i = j
+		case unicode.IsLetter(rune(c)):
+			j := i
+			for j < len(q) && (unicode.IsLetter(rune(q[j])) || unicode.IsDigit(rune(q[j]))) {
+				j++
+			}
+			toks = append(toks, token{text: q[i:j]})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected %q in query %q", c, q)
+		}
+	}
+	return toks, nil
+}
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek(s string) bool {
+	return p.pos < len(p.toks) && !p.toks[p.pos].quoted && p.toks[p.pos].text == s
+}
+
+// expect consumes the tokens ss.
+func (p *parser) expect(ss ...string) error {
+	for _, s := range ss {
+		if !p.peek(s) {
+			return fmt.Errorf("expected %q in query", s)
+		}
+		p.pos++
+	}
+	return nil
+}
+
+func (p *parser) next() (token, error) {
+	if p.pos == len(p.toks) {
+		return token{}, fmt.Errorf("query ends early")
+	}
+	t := p.toks[p.pos]
+	p.pos++
+	return t, nil
+}
+
+func (p *parser) str() (string, error) {
+	t, err := p.next()
+	if err != nil {
+		return "", err
+	}
+	if !t.quoted {
+		return "", fmt.Errorf("expected a string in query, got %q", t.text)
+	}
+	return t.text, nil
+}
+
+func (p *parser) or() (match, error) {
+	m, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("or") {
+		p.pos++
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l := m
+		m = func(f *file) bool { return l(f) || r(f) }
+	}
+	return m, nil
+}
+
+func (p *parser) and() (match, error) {
+	m, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("and") {
+		p.pos++
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := m
+		m = func(f *file) bool { return l(f) && r(f) }
+	}
+	return m, nil
+}
+
+func (p *parser) unary() (match, error) {
+	switch {
+	case p.peek("not"):
+		p.pos++
+		m, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(f *file) bool { return !m(f) }, nil
+	case p.peek("("):
+		p.pos++
+		m, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return m, p.expect(")")
+	}
+	return p.cond()
+}
+
+func (p *parser) cond() (match, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if t.quoted {
+		// 'id' in parents
+		if err := p.expect("in", "parents"); err != nil {
+			return nil, err
+		}
+		id := t.text
+		return func(f *file) bool { return f.inParent(id) }, nil
+	}
+	field := t.text
+	if field == "appProperties" || field == "properties" {
+		return p.has(field)
+	}
+	op, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	v, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	switch field {
+	case "trashed", "starred":
+		if op.text != "=" && op.text != "!=" || v.quoted || v.text != "true" && v.text != "false" {
+			return nil, fmt.Errorf("invalid %s condition in query", field)
+		}
+		want := (v.text == "true") == (op.text == "=")
+		if field == "starred" {
+			return func(f *file) bool { return f.meta.Starred == want }, nil
+		}
+		return func(f *file) bool { return f.meta.Trashed == want }, nil
+	case "name", "mimeType", "description", "fullText":
+		if !v.quoted {
+			return nil, fmt.Errorf("expected a string for %s in query", field)
+		}
+		get := func(f *file) string {
+			switch field {
+			case "name":
+				return f.meta.Name
+			case "mimeType":
+				return f.meta.MimeType
+			case "description":
+				return f.meta.Description
+			}
+			return f.meta.Name + "\n" + f.meta.Description + "\n" + string(f.content)
+		}
+		switch op.text {
+		case "=":
+			return func(f *file) bool { return get(f) == v.text }, nil
+		case "!=":
+			return func(f *file) bool { return get(f) != v.text }, nil
+		case "contains":
+			return func(f *file) bool { return strings.Contains(get(f), v.text) }, nil
+		}
+		return nil, fmt.Errorf("invalid operator %q for %s in query", op.text, field)
+	case "createdTime", "modifiedTime":
+		want, err := time.Parse(time.RFC3339, v.text)
+		if err != nil || !v.quoted {
+			return nil, fmt.Errorf("invalid time %q in query", v.text)
+		}
+		cmp, ok := comparisons[op.text]
+		if !ok {
+			return nil, fmt.Errorf("invalid operator %q for %s in query", op.text, field)
+		}
+		return func(f *file) bool {
+			s := f.meta.CreatedTime
+			if field == "modifiedTime" {
+				s = f.meta.ModifiedTime
+			}
+			t, err := time.Parse(time.RFC3339, s)
+			return err == nil && cmp(t, want)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown field %q in query", field)
+}
+
+var comparisons = map[string]func(a, b time.Time) bool{
+	"=":  func(a, b time.Time) bool { return a.Equal(b) },
+	"!=": func(a, b time.Time) bool { return !a.Equal(b) },
+	"<":  func(a, b time.Time) bool { return a.Before(b) },
+	"<=": func(a, b time.Time) bool { return !a.After(b) },
+	">":  func(a, b time.Time) bool { return a.After(b) },
+	">=": func(a, b time.Time) bool { return !a.Before(b) },
+}
+
+// has parses "has { key='k' and value='v' }" after appProperties or
+// properties.
+func (p *parser) has(field string) (match, error) {
+	if err := p.expect("has", "{", "key", "="); err != nil {
+		return nil, err
+	}
+	key, err := p.str()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("and", "value", "="); err != nil {
+		return nil, err
+	}
+	value, err := p.str()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("}"); err != nil {
+		return nil, err
+	}
+	return func(f *file) bool {
+		props := f.meta.AppProperties
+		if field == "properties" {
+			props = f.meta.Properties
+		}
+		v, ok := props[key]
+		return ok && v == value
+	}, nil
+}
+
+// ordering returns how to sort files for an orderBy parameter such as
+// "folder,name desc".
+func ordering(orderBy string) (func(a, b *file) bool, error) {
+	type key struct {
+		less func(a, b *file) bool
+		desc bool
+	}
+	var keys []key
+	for _, k := range strings.Split(orderBy, ",") {
+		fields := strings.Fields(k)
+		if len(fields) == 0 {
+			continue
+		}
+		var less func(a, b *file) bool
+		switch fields[0] {
+		case "name", "name_natural":
+			less = func(a, b *file) bool { return a.meta.Name < b.meta.Name }
+		case "createdTime":
+			less = func(a, b *file) bool { return a.seq < b.seq }
+		case "modifiedTime":
+			less = func(a, b *file) bool { return a.meta.ModifiedTime < b.meta.ModifiedTime }
+		case "folder":
+			less = func(a, b *file) bool { return a.isFolder() && !b.isFolder() }
+		default:
+			return nil, fmt.Errorf("unsupported orderBy %q", k)
+		}
+		keys = append(keys, key{less, len(fields) > 1 && fields[1] == "desc"})
+	}
+	return func(a, b *file) bool {
+		for _, k := range keys {
+			x, y := a, b
+			if k.desc {
+				x, y = b, a
+			}
+			if k.less(x, y) {
+				return true
+			}
+			if k.less(y, x) {
+				return false
+			}
+		}
+		return a.seq < b.seq
+	}, nil
+}
@@ -0,0 +1,86 @@
+package fakedrive
+
+import (
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestParseQuery(t *testing.T) {
+	report := &file{meta: drive.File{
+		Name:          "report's final.pdf",
+		MimeType:      "application/pdf",
+		Parents:       []string{"folder"},
+		CreatedTime:   "2020-05-01T10:00:00.000Z",
+		ModifiedTime:  "2020-06-01T10:00:00.000Z",
+		AppProperties: map[string]string{"md5": "abc"},
+	}}
+	photo := &file{meta: drive.File{
+		Name:        "photo.jpg",
+		MimeType:    "image/jpeg",
+		Parents:     []string{"root"},
+		Trashed:     true,
+		Starred:     true,
+		CreatedTime: "2021-01-01T00:00:00.000Z",
+		Properties:  map[string]string{"md5": "abc"},
+	}}
+	for _, tc := range []struct {
+		q             string
+		report, photo bool
+	}{
+		{"", true, true},
+		{"name = 'photo.jpg'", false, true},
+		{`name = 'report\'s final.pdf'`, true, false},
+		{"name != 'photo.jpg'", true, false},
+		{"name contains 'final'", true, false},
+		{"mimeType = 'image/jpeg'", false, true},
+		{"'folder' in parents", true, false},
+		{"trashed = false", true, false},
+		{"trashed != false", false, true},
+		{"starred = true", false, true},
+		{"createdTime < '2021-01-01T00:00:00Z'", true, false},
+		{"createdTime >= '2021-01-01T00:00:00Z'", false, true},
+		{"modifiedTime > '2020-01-01T00:00:00Z'", true, false},
+		{"appProperties has { key='md5' and value='abc' }", true, false},
+		{"properties has { key='md5' and value='abc' }", false, true},
+		{"appProperties has { key='md5' and value='xyz' }", false, false},
+		{"not trashed = true", true, false},
+		{"trashed = true or 'folder' in parents", true, true},
+		{"trashed = false and 'root' in parents", false, false},
+		{"(trashed = true or starred = true) and mimeType = 'image/jpeg'", false, true},
+		{"trashed = true or starred = false and name = 'nope'", false, true},
+	} {
+		m, err := parseQuery(tc.q)
+		if err != nil {
+			t.Errorf("parseQuery(%q): %s", tc.q, err)
+			continue
+		}
+		if got := m(report); got != tc.report {
+			t.Errorf("parseQuery(%q) matches the report = %t, want %t", tc.q, got, tc.report)
+		}
+		if got := m(photo); got != tc.photo {
+			t.Errorf("parseQuery(%q) matches the photo = %t, want %t", tc.q, got, tc.photo)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, q := range []string{
+		"name = 'unterminated",
+		"name = photo.jpg",
+		"name < 'a'",
+		"trashed = maybe",
+		"createdTime < 'yesterday'",
+		"size > 10",
+		"'folder' in children",
+		"(trashed = true",
+		"trashed = true)",
+		"trashed = true and",
+		"appProperties has { key='md5' }",
+		"name = 'a' # comment",
+	} {
+		if _, err := parseQuery(q); err == nil {
+			t.Errorf("parseQuery(%q) succeeded, want an error", q)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package fakedrive
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// sessionPath is where resumable upload sessions live.
+const sessionPath = "/upload/resumable/"
+
+// session is a resumable upload in progress.
+type session struct {
+	id          string // of the file being updated, or "" to create one
+	meta        *drive.File
+	raw         map[string]json.RawMessage
+	query       url.Values
+	contentType string
+	data        []byte
+}
+
+// serveUpload handles a media upload creating a file, or replacing the
+// contents of file id.
+func (s *Server) serveUpload(w http.ResponseWriter, r *http.Request, id string) {
+	q := r.URL.Query()
+	var meta []byte
+	var media []byte
+	var contentType string
+	switch q.Get("uploadType") {
+	case "media":
+		media, _ = ioutil.ReadAll(r.Body)
+		contentType = r.Header.Get("Content-Type")
+	case "multipart":
+		var err error
+		if meta, media, contentType, err = readMultipart(r); err != nil {
+			writeError(w, &apiError{http.StatusBadRequest, "badContent", err.Error()})
+			return
+		}
+	case "resumable":
+		meta, _ = ioutil.ReadAll(r.Body)
+		m, raw, err := decodeMetadata(meta)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		s.mu.Lock()
+		if _, ok := s.files[id]; id != "" && !ok {
+			s.mu.Unlock()
+			writeError(w, notFound(id))
+			return
+		}
+		s.seq++
+		sid := strconv.Itoa(s.seq)
+		s.sessions[sid] = &session{id: id, meta: m, raw: raw, query: q, contentType: r.Header.Get("X-Upload-Content-Type")}
+		s.mu.Unlock()
+		w.Header().Set("Location", "http://"+r.Host+sessionPath+sid)
+		w.WriteHeader(http.StatusOK)
+		return
+	default:
+		writeError(w, &apiError{http.StatusBadRequest, "invalid", "Invalid Value: uploadType"})
+		return
+	}
+	m, raw, err := decodeMetadata(meta)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := s.finish(&session{id: id, meta: m, raw: raw, query: q, contentType: contentType, data: media})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, f.copy())
+}
+
+// finish creates or updates the file an upload is for. s.mu must be held.
+func (s *Server) finish(u *session) (*file, error) {
+	if u.id == "" {
+		if u.meta.MimeType == "" {
+			u.meta.MimeType, _, _ = mime.ParseMediaType(u.contentType)
+		}
+		return s.create(u.meta, u.data)
+	}
+	f, ok := s.files[u.id]
+	if !ok {
+		return nil, notFound(u.id)
+	}
+	if err := s.checkQuota(int64(len(u.data) - len(f.content))); err != nil {
+		return nil, err
+	}
+	if err := s.update(f, u.meta, u.raw, u.query.Get("addParents"), u.query.Get("removeParents")); err != nil {
+		return nil, err
+	}
+	f.setContent(u.data)
+	if _, ok := u.raw["modifiedTime"]; !ok {
+		f.meta.ModifiedTime = time.Now().UTC().Format(timeLayout)
+	}
+	s.record(f, false)
+	return f, nil
+}
+
+// serveSession handles a chunk of a resumable upload, or a query of how
+// much of it has arrived.
+func (s *Server) serveSession(w http.ResponseWriter, r *http.Request, sid string) {
+	if r.Method != "PUT" && r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	start, total, err := parseContentRange(r.Header.Get("Content-Range"))
+	if err != nil {
+		writeError(w, &apiError{http.StatusBadRequest, "badRequest", err.Error()})
+		return
+	}
+	body, rerr := ioutil.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.sessions[sid]
+	if !ok {
+		writeError(w, &apiError{http.StatusNotFound, "notFound", "No such upload session."})
+		return
+	}
+	if start >= 0 {
+		if start > int64(len(u.data)) {
+			writeError(w, &apiError{http.StatusBadRequest, "badRequest", fmt.Sprintf("chunk starts at %d but only %d bytes have arrived", start, len(u.data))})
+			return
+		}
+		// Keep what arrived of an interrupted chunk, as Drive does.
+		u.data = append(u.data[:start], body...)
+	}
+	if rerr == nil && total >= 0 && int64(len(u.data)) >= total {
+		u.data = u.data[:total]
+		f, err := s.finish(u)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		delete(s.sessions, sid)
+		writeJSON(w, f.copy())
+		return
+	}
+	if len(u.data) > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(u.data)-1))
+	}
+	// The Drive client asks for 200 with the status in a header, so its
+	// HTTP client doesn't take 308 for a redirect.
+	if r.Header.Get("X-GUploader-No-308") == "yes" {
+		w.Header().Set("X-Http-Status-Code-Override", "308")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.WriteHeader(http.StatusPermanentRedirect)
+}
+
+// parseContentRange parses "bytes START-END/TOTAL" or "bytes */TOTAL",
+// where TOTAL may be "*". Unknown parts are -1.
+func parseContentRange(h string) (start, total int64, err error) {
+	start, total = -1, -1
+	if h == "" {
+		return start, total, nil
+	}
+	i := strings.IndexByte(h, '/')
+	if !strings.HasPrefix(h, "bytes ") || i < 0 {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", h)
+	}
+	rng, tot := h[len("bytes "):i], h[i+1:]
+	if tot != "*" {
+		if total, err = strconv.ParseInt(tot, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("invalid Content-Range %q", h)
+		}
+	}
+	if rng != "*" {
+		j := strings.IndexByte(rng, '-')
+		if j < 0 {
+			return 0, 0, fmt.Errorf("invalid Content-Range %q", h)
+		}
+		if start, err = strconv.ParseInt(rng[:j], 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("invalid Content-Range %q", h)
+		}
+	}
+	return start, total, nil
+}
+
+// readMultipart splits a multipart/related upload into its metadata and
+// media.
+func readMultipart(r *http.Request) (meta, media []byte, contentType string, err error) {
+	mt, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mt, "multipart/") {
+		return nil, nil, "", errors.New("multipart upload without a multipart body")
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	var parts [][]byte
+	for len(parts) < 2 {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, "", err
+		}
+		var b bytes.Buffer
+		if _, err := io.Copy(&b, p); err != nil {
+			return nil, nil, "", err
+		}
+		parts = append(parts, b.Bytes())
+		if len(parts) == 2 {
+			contentType = p.Header.Get("Content-Type")
+		}
+	}
+	if len(parts) != 2 {
+		return nil, nil, "", errors.New("multipart upload needs metadata and media parts")
+	}
+	return parts[0], parts[1], contentType, nil
+}
@@ -3,55 +3,262 @@ package gdrive
 import (
 	"context"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
 )
 
-// TODO(dknowles): Start a web server to do the OAuth exchange redirect.
+// FolderMimeType is the MIME type Drive uses for folders.
+const FolderMimeType = "application/vnd.google-apps.folder"
 
-var tokenFile = flag.String("token_file", "/data/token.json", "Path to the token.json cache")
+// AppName is recorded on every uploaded file so they can be found later.
+const AppName = "gdrive_sync"
 
-func New(ctx context.Context, credsFile string) (*drive.Service, error) {
-	b, err := ioutil.ReadFile(credsFile)
+// Version is the version of this tool recorded on uploaded files. Release
+// builds set it with -ldflags "-X
+// github.com/dknowles2/gdrive_sync/gdrive.Version=..."; otherwise it is the
+// module version, if the binary was built from one.
+var Version = ""
+
+// AppVersion returns Version, or the module version, or "devel".
+func AppVersion() string {
+	if Version != "" {
+		return Version
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		return bi.Main.Version
+	}
+	return "devel"
+}
+
+// AppPropertyUploader is the appProperties key identifying files uploaded by
+// this tool.
+const AppPropertyUploader = "uploader"
+
+// Provenance appProperties keys recording where an uploaded file came from:
+// its slash-separated path relative to the watched directory, its local
+// modification time in RFC 3339 format, the format it was compressed in
+// before upload if it was, the hostname of the machine it was uploaded
+// from, the hex MD5 of the local file, the version of this tool, and when
+// it was uploaded in RFC 3339 format (its createdTime is when the local file
+// was created, where that is known).
+const (
+	AppPropertyPath        = "path"
+	AppPropertyMtime       = "mtime"
+	AppPropertyCompression = "compression"
+	AppPropertyHost        = "host"
+	AppPropertyMD5         = "md5"
+	AppPropertyVersion     = "version"
+	AppPropertyUploaded    = "uploaded"
+)
+
+// MaxAppPropertySize is the most bytes Drive allows in an appProperties key
+// and value combined.
+const MaxAppPropertySize = 124
+
+// Config says how to authorize with Drive.
+type Config struct {
+	// CredentialsFile is the OAuth client's credentials.json, or StdinPath.
+	CredentialsFile string
+	// CredentialsFromEnv reads the credentials from
+	// $GDRIVE_SYNC_CREDENTIALS instead, if it is set.
+	CredentialsFromEnv bool
+	// TokenFile caches the account's access and refresh tokens, or is
+	// StdinPath. If it doesn't exist, the account is authorized by
+	// Authorize's flow and the new token saved there.
+	TokenFile string
+	// TokenFromEnv reads the token from $GDRIVE_SYNC_TOKEN instead, if it
+	// is set.
+	TokenFromEnv bool
+	// Scopes are requested in addition to the Drive scope.
+	Scopes []string
+	// HTTPClient, if set, is used as is instead of authorizing with the
+	// files above, for callers doing their own authorization and for
+	// tests.
+	HTTPClient *http.Client
+	// Endpoint, if set, replaces the Drive API's base URL, e.g.
+	// "http://localhost:8080/drive/v3/" for an emulator.
+	Endpoint string
+	// SharedDriveId confines the service's folder and file searches to one
+	// shared drive (Team Drive), in which new top-level folders are made.
+	SharedDriveId string
+	// AuthFlow is how a new token is authorized: AuthFlowBrowser (the
+	// default) or AuthFlowDevice. AuthPort is the port the browser flow
+	// listens for the OAuth redirect on; zero picks a free one.
+	AuthFlow string
+	AuthPort int
+	// ChaosRate, for exercising retries and alerting, is the probability
+	// of injecting a fault into each Drive API request, delays lasting up
+	// to ChaosMaxDelay.
+	ChaosRate     float64
+	ChaosMaxDelay time.Duration
+}
+
+// Option sets a field of a Config.
+type Option func(*Config)
+
+func WithCredentialsFile(path string) Option {
+	return func(c *Config) { c.CredentialsFile = path }
+}
+
+func WithCredentialsFromEnv() Option {
+	return func(c *Config) { c.CredentialsFromEnv = true }
+}
+
+func WithTokenFile(path string) Option {
+	return func(c *Config) { c.TokenFile = path }
+}
+
+func WithTokenFromEnv() Option {
+	return func(c *Config) { c.TokenFromEnv = true }
+}
+
+// WithScopes adds to the scopes requested.
+func WithScopes(scopes ...string) Option {
+	return func(c *Config) { c.Scopes = append(c.Scopes, scopes...) }
+}
+
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Config) { c.HTTPClient = hc }
+}
+
+func WithEndpoint(url string) Option {
+	return func(c *Config) { c.Endpoint = url }
+}
+
+func WithSharedDrive(id string) Option {
+	return func(c *Config) { c.SharedDriveId = id }
+}
+
+// WithAuthFlow sets how a new token is authorized, and the port the browser
+// flow listens on.
+func WithAuthFlow(flow string, port int) Option {
+	return func(c *Config) { c.AuthFlow, c.AuthPort = flow, port }
+}
+
+// WithChaos injects faults into a proportion rate of Drive API requests.
+func WithChaos(rate float64, maxDelay time.Duration) Option {
+	return func(c *Config) { c.ChaosRate, c.ChaosMaxDelay = rate, maxDelay }
+}
+
+// New returns a Drive service configured by opts.
+func New(ctx context.Context, opts ...Option) (*Drive, error) {
+	var c Config
+	for _, o := range opts {
+		o(&c)
+	}
+	return c.Service(ctx)
+}
+
+// NewClient returns an authorized HTTP client configured by opts.
+func NewClient(ctx context.Context, opts ...Option) (*http.Client, error) {
+	var c Config
+	for _, o := range opts {
+		o(&c)
+	}
+	return c.Client(ctx)
+}
+
+// Service returns a Drive service authorized as c says.
+func (c Config) Service(ctx context.Context) (*Drive, error) {
+	client, err := c.Client(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read client secret file: %w", err)
+		return nil, err
 	}
 
-	// If modifying these scopes, delete your previously saved token.json.
-	config, err := google.ConfigFromJSON(b, drive.DriveScope)
+	opts := []option.ClientOption{option.WithHTTPClient(client)}
+	if c.Endpoint != "" {
+		opts = append(opts, option.WithEndpoint(c.Endpoint))
+	}
+	srv, err := drive.NewService(ctx, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("unable to parse client secret file to config: %w", err)
+		return nil, fmt.Errorf("unable to retrieve Drive client: %w", err)
+	}
+	return &Drive{Service: srv, Client: client, SharedDriveId: c.SharedDriveId}, nil
+}
+
+// Client returns an HTTP client authorized for the Drive scope plus
+// c.Scopes.
+func (c Config) Client(ctx context.Context) (*http.Client, error) {
+	if c.HTTPClient != nil {
+		return c.HTTPClient, nil
+	}
+	config, err := c.OAuthConfig()
+	if err != nil {
+		return nil, err
 	}
 
 	// The file token.json stores the user's access and refresh tokens, and is
 	// created automatically when the authorization flow completes for the first
 	// time.
-	token, err := getTokenFromFile()
-	if err != nil {
-		token, err = getTokenFromWeb(ctx, config)
+	token, ok, err := tokenFromSecrets(c)
+	if ok {
+		// There's nowhere to save a new token, so don't start the web flow.
+		if err != nil {
+			return nil, fmt.Errorf("unable to read token: %w", err)
+		}
+	} else if c.TokenFile == "" {
+		return nil, errors.New("no token file configured")
+	} else if token, err = getTokenFromFile(c.TokenFile); err != nil {
+		token, err = c.getNewToken(ctx, config, c.TokenFile)
 		if err != nil {
 			return nil, err
 		}
 	}
-	client := config.Client(ctx, token)
+	if c.ChaosRate > 0 {
+		return chaosClient(ctx, config, token, c.ChaosRate, c.ChaosMaxDelay), nil
+	}
+	return config.Client(ctx, token), nil
+}
 
-	srv, err := drive.New(client)
+// OAuthConfig returns the OAuth client in c's credentials file, for the
+// Drive scope plus c.Scopes.
+func (c Config) OAuthConfig() (*oauth2.Config, error) {
+	b, err := readCredentials(c)
 	if err != nil {
-		return nil, fmt.Errorf("unable to retrieve Drive client: %w", err)
+		return nil, fmt.Errorf("unable to read client secret file: %w", err)
+	}
+	// If modifying these scopes, delete your previously saved token.json.
+	config, err := google.ConfigFromJSON(b, append([]string{drive.DriveScope}, c.Scopes...)...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse client secret file to config: %w", err)
 	}
+	return config, nil
+}
 
-	return srv, nil
+// Token returns the saved token c says to authorize with, without starting
+// the authorization flow if there is none.
+func (c Config) Token() (*oauth2.Token, error) {
+	token, ok, err := tokenFromSecrets(c)
+	if !ok {
+		if c.TokenFile == "" {
+			return nil, errors.New("no token file configured")
+		}
+		token, err = getTokenFromFile(c.TokenFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read token: %w", err)
+	}
+	return token, nil
 }
 
-func getTokenFromFile() (*oauth2.Token, error) {
-	f, err := os.Open(*tokenFile)
+func getTokenFromFile(path string) (*oauth2.Token, error) {
+	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
@@ -61,40 +268,312 @@ func getTokenFromFile() (*oauth2.Token, error) {
 	return tok, err
 }
 
-func getTokenFromWeb(ctx context.Context, config *oauth2.Config) (*oauth2.Token, error) {
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	fmt.Printf("Go to the following link in your browser then type the authorization code: \n%v\n", authURL)
-
-	var authCode string
-	if _, err := fmt.Scan(&authCode); err != nil {
-		return nil, fmt.Errorf("unable to read authorization code %w", err)
+// GetFolderId returns the ID of the folder named n, or of the folder n
+// links to if it is a Drive folder URL. A slash-separated path such as
+// "Household/Scans" is resolved one folder at a time from the root of My
+// Drive (or d's shared drive), rather than matched by name
+// anywhere.
+func GetFolderId(d *Drive, n string) (string, error) {
+	if id, ok := FolderIdFromURL(n); ok {
+		f, err := d.Files.Get(id).SupportsAllDrives(true).Fields("id,mimeType").Do()
+		if err != nil {
+			return "", fmt.Errorf("unable to retrieve Drive folder %s: %w", id, err)
+		}
+		if !IsFolder(f) {
+			return "", fmt.Errorf("%s is not a folder", n)
+		}
+		return f.Id, nil
+	}
+	if strings.Contains(strings.Trim(n, "/"), "/") {
+		return ResolvePath(context.Background(), d, rootId(d), n)
 	}
+	q := fmt.Sprintf("name = '%s' and mimeType = '%s' and trashed = false", EscapeQuery(n), FolderMimeType)
+	r, err := ListFiles(d, q).Fields("files(id,name)").Do()
+	if err != nil {
+		return "", fmt.Errorf("unable to retrieve Drive folder: %w", err)
+	}
+	for _, f := range r.Files {
+		if f.Name == n {
+			return f.Id, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrFolderNotFound, n)
+}
+
+// ErrFolderNotFound is returned by GetFolderId and ResolvePath when there is
+// no folder of the given name.
+var ErrFolderNotFound = errors.New("unable to find folder")
+
+type folderKey struct {
+	d    *Drive
+	name string
+}
 
-	token, err := config.Exchange(ctx, authCode)
+// folderIds caches the IDs EnsureFolderId returns.
+var folderIds sync.Map // folderKey -> string
+
+// EnsureFolderId is like GetFolderId, but if there is no folder n it is
+// created, along with any missing parents when n is a slash-separated path,
+// in d's shared drive or My Drive.
+func EnsureFolderId(ctx context.Context, d *Drive, n string) (string, error) {
+	k := folderKey{d, n}
+	if id, ok := folderIds.Load(k); ok {
+		return id.(string), nil
+	}
+	id, err := GetFolderId(d, n)
+	if errors.Is(err, ErrFolderNotFound) {
+		if id, err = EnsurePath(ctx, d, rootId(d), n); err == nil {
+			log.Printf("Created Drive folder %s", n)
+		}
+	}
 	if err != nil {
-		return nil, fmt.Errorf("unable to retrieve token from web %w", err)
+		return "", err
+	}
+	folderIds.Store(k, id)
+	return id, nil
+}
+
+// FolderIds returns the IDs of the folders names, by name, each looked up
+// by GetFolderId or, if create is set, EnsureFolderId.
+func FolderIds(ctx context.Context, d *Drive, names []string, create bool) (map[string]string, error) {
+	ids := make(map[string]string, len(names))
+	for _, n := range names {
+		if _, ok := ids[n]; ok {
+			continue
+		}
+		var id string
+		var err error
+		if create {
+			id, err = EnsureFolderId(ctx, d, n)
+		} else {
+			id, err = GetFolderId(d, n)
+		}
+		if err != nil {
+			return nil, err
+		}
+		ids[n] = id
+	}
+	return ids, nil
+}
+
+// rootId returns the ID of the folder new top-level folders are created in.
+func rootId(d *Drive) string {
+	if id := d.SharedDriveId; id != "" {
+		return id
+	}
+	return "root"
+}
+
+// FolderURL returns the Drive web URL of the folder with the given ID, which
+// GetFolderId accepts in place of a folder name.
+func FolderURL(id string) string {
+	return "https://drive.google.com/drive/folders/" + id
+}
+
+// FolderIdFromURL returns the folder ID in a Drive URL such as
+// https://drive.google.com/drive/folders/ID or
+// https://drive.google.com/open?id=ID.
+func FolderIdFromURL(s string) (string, bool) {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || !strings.HasSuffix(u.Host, "drive.google.com") {
+		return "", false
+	}
+	if id := u.Query().Get("id"); id != "" {
+		return id, true
 	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i, p := range parts {
+		if p == "folders" && i+1 < len(parts) && parts[i+1] != "" {
+			return parts[i+1], true
+		}
+	}
+	return "", false
+}
 
-	log.Printf("Saving credential file to: %s\n", *tokenFile)
-	f, err := os.OpenFile(*tokenFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+// CreateFolder creates a folder named n. If parent is non-empty the folder is
+// created inside it, otherwise in the root of My Drive.
+func CreateFolder(d *Drive, n, parent string) (string, error) {
+	f := &drive.File{
+		Name:     n,
+		MimeType: FolderMimeType,
+	}
+	if parent != "" {
+		f.Parents = []string{parent}
+	}
+	r, err := d.Files.Create(f).SupportsAllDrives(true).Fields("id").Do()
 	if err != nil {
-		return nil, fmt.Errorf("unable to cache oauth token: %w", err)
+		return "", fmt.Errorf("unable to create folder %s: %w", n, err)
 	}
-	defer f.Close()
-	json.NewEncoder(f).Encode(token)
-	return token, nil
+	return r.Id, nil
 }
 
-func GetFolderId(d *drive.Service, n string) (string, error) {
-	q := fmt.Sprintf("name=\"%s\" and mimeType=\"application/vnd.google-apps.folder\"", n)
-	r, err := d.Files.List().Q(q).Do()
+// FileFields is the partial response requested for file lookups that don't
+// ask for anything more specific.
+const FileFields googleapi.Field = "files(id,name,md5Checksum,parents)"
+
+// Search returns every file matching the Drive query q, following pagination.
+// Only the given fields of each page are returned, FileFields by default.
+func Search(ctx context.Context, d *Drive, q string, fields ...googleapi.Field) ([]*drive.File, error) {
+	var files []*drive.File
+	if len(fields) == 0 {
+		fields = []googleapi.Field{FileFields}
+	}
+	call := ListFiles(d, q).PageSize(1000).Fields(append([]googleapi.Field{"nextPageToken"}, fields...)...)
+	err := call.Pages(ctx, func(r *drive.FileList) error {
+		files = append(files, r.Files...)
+		return nil
+	})
 	if err != nil {
-		return "", fmt.Errorf("unable to retrieve Drive folder: %w", err)
+		return nil, fmt.Errorf("unable to search Drive: %w", err)
 	}
-	for _, f := range r.Files {
-		if f.Name == n {
-			return f.Id, nil
+	return files, nil
+}
+
+// ListFiles starts a files.list call for the Drive query q, searching d's
+// shared drive if it has one.
+func ListFiles(d *Drive, q string) *drive.FilesListCall {
+	call := d.Files.List().Q(q).SupportsAllDrives(true)
+	if id := d.SharedDriveId; id != "" {
+		call = call.Corpora("drive").DriveId(id).IncludeItemsFromAllDrives(true)
+	}
+	return call
+}
+
+// UploadedQuery returns a Drive query matching files uploaded by this tool.
+func UploadedQuery() string {
+	return fmt.Sprintf("appProperties has { key='%s' and value='%s' } and trashed = false", AppPropertyUploader, AppName)
+}
+
+// UploadTime returns when f, fetched with its appProperties and createdTime,
+// was uploaded. Files uploaded before that was recorded were created in
+// Drive when they were uploaded.
+func UploadTime(f *drive.File) (time.Time, error) {
+	if s := f.AppProperties[AppPropertyUploaded]; s != "" {
+		return time.Parse(time.RFC3339, s)
+	}
+	return time.Parse(time.RFC3339, f.CreatedTime)
+}
+
+// Trash moves the file with the given ID to the Drive trash.
+func Trash(d *Drive, id string) error {
+	_, err := d.Files.Update(id, &drive.File{Trashed: true}).SupportsAllDrives(true).Fields("id").Do()
+	return err
+}
+
+// ListFolder returns the non-folder, non-trashed files directly inside the
+// folder with the given ID.
+func ListFolder(ctx context.Context, d *Drive, folderId string) ([]*drive.File, error) {
+	q := fmt.Sprintf("'%s' in parents and trashed = false and mimeType != '%s'", folderId, FolderMimeType)
+	return Search(ctx, d, q, "files(id,name,size,md5Checksum,modifiedTime)")
+}
+
+// ListChildren returns every non-trashed file and folder directly inside the
+// folder with the given ID.
+func ListChildren(ctx context.Context, d *Drive, folderId string) ([]*drive.File, error) {
+	q := fmt.Sprintf("'%s' in parents and trashed = false", folderId)
+	return Search(ctx, d, q, "files(id,name,mimeType,size,md5Checksum,modifiedTime)")
+}
+
+// FilesNamed returns the non-folder, non-trashed files called name directly
+// inside the folder with the given ID.
+func FilesNamed(ctx context.Context, d *Drive, folderId, name string) ([]*drive.File, error) {
+	q := fmt.Sprintf("name = '%s' and '%s' in parents and trashed = false and mimeType != '%s'", EscapeQuery(name), folderId, FolderMimeType)
+	return Search(ctx, d, q, "files(id,name,size,md5Checksum,headRevisionId,appProperties)")
+}
+
+// ResolvePath returns the ID of the folder at the slash-separated path p
+// beneath the folder with ID parentId. An empty path resolves to parentId.
+func ResolvePath(ctx context.Context, d *Drive, parentId, p string) (string, error) {
+	id := parentId
+	for _, name := range strings.Split(p, "/") {
+		if name == "" || name == "." {
+			continue
 		}
+		child, err := findFolder(ctx, d, id, name)
+		if err != nil {
+			return "", err
+		}
+		if child == "" {
+			return "", fmt.Errorf("%w: %s", ErrFolderNotFound, p)
+		}
+		id = child
+	}
+	return id, nil
+}
+
+// EnsurePath is like ResolvePath but creates any folders that are missing.
+func EnsurePath(ctx context.Context, d *Drive, parentId, p string) (string, error) {
+	id := parentId
+	for _, name := range strings.Split(p, "/") {
+		if name == "" || name == "." {
+			continue
+		}
+		child, err := findFolder(ctx, d, id, name)
+		if err != nil {
+			return "", err
+		}
+		if child == "" {
+			if child, err = CreateFolder(d, name, id); err != nil {
+				return "", err
+			}
+		}
+		id = child
+	}
+	return id, nil
+}
+
+// findFolder returns the ID of the folder named name directly inside the
+// folder with ID parentId, or "" if there is none.
+func findFolder(ctx context.Context, d *Drive, parentId, name string) (string, error) {
+	q := fmt.Sprintf("name = '%s' and '%s' in parents and mimeType = '%s' and trashed = false", EscapeQuery(name), parentId, FolderMimeType)
+	r, err := ListFiles(d, q).Fields("files(id)").Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("unable to retrieve Drive folder %s: %w", name, err)
+	}
+	if len(r.Files) == 0 {
+		return "", nil
+	}
+	return r.Files[0].Id, nil
+}
+
+// EscapeQuery escapes s for use inside a single-quoted Drive query string.
+func EscapeQuery(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
+
+// IsFolder reports whether f is a Drive folder.
+func IsFolder(f *drive.File) bool {
+	return f.MimeType == FolderMimeType
+}
+
+// FindFile returns the file at the slash-separated path p beneath the folder
+// with ID parentId.
+func FindFile(ctx context.Context, d *Drive, parentId, p string) (*drive.File, error) {
+	dir, name := path.Split(strings.Trim(p, "/"))
+	folderId, err := ResolvePath(ctx, d, parentId, dir)
+	if err != nil {
+		return nil, err
+	}
+	q := fmt.Sprintf("name = '%s' and '%s' in parents and trashed = false", EscapeQuery(name), folderId)
+	r, err := ListFiles(d, q).Fields("files(id,name,mimeType,size,md5Checksum,modifiedTime)").Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve Drive file %s: %w", p, err)
+	}
+	if len(r.Files) == 0 {
+		return nil, fmt.Errorf("unable to find file: %s", p)
+	}
+	return r.Files[0], nil
+}
+
+// Download writes the contents of the binary file with the given ID to w.
+func Download(ctx context.Context, d *Drive, id string, w io.Writer) error {
+	resp, err := d.Files.Get(id).SupportsAllDrives(true).Context(ctx).Download()
+	if err != nil {
+		return fmt.Errorf("unable to download %s: %w", id, err)
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("unable to download %s: %w", id, err)
 	}
-	return "", fmt.Errorf("unable to find folder: %s", n)
+	return nil
 }
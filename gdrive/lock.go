@@ -0,0 +1,151 @@
+package gdrive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/leader"
+	"google.golang.org/api/drive/v3"
+)
+
+// lockSettle is how long a new holder waits after writing a lock before
+// checking that no other instance overwrote it. Drive has no conditional
+// writes, so the last writer wins and everyone else backs off.
+const lockSettle = 5 * time.Second
+
+// Lock is a lease held in a file in the Drive appDataFolder, which lets
+// instances that share nothing but a Google account agree that exactly one
+// of them is active. It requires the drive.appdata scope.
+//
+// Instances' clocks may disagree, so a lock is only judged expired by the
+// lock file's modifiedTime, which Drive sets: once it has stayed the same
+// for Duration, as timed by this instance, the holder has stopped renewing.
+type Lock struct {
+	Drive *Drive
+	// Name of the lock file in the appDataFolder.
+	Name string
+	// Identity of this instance, usually its hostname.
+	Identity string
+	// Duration is how long the lock is valid without a heartbeat. The holder
+	// renews it every third of that, and stops acting after two thirds
+	// without a successful renewal, so a standby that takes over after the
+	// full duration never overlaps with it.
+	Duration time.Duration
+
+	// The lock file's modifiedTime when last seen held by another instance,
+	// and when that was by this instance's clock.
+	seenModified string
+	seenAt       time.Time
+}
+
+type lockState struct {
+	Holder string `json:"holder"`
+	// Renewed is for people reading the lock; expiry isn't judged by it.
+	Renewed time.Time `json:"renewed"`
+}
+
+// Lead blocks until the lock is acquired and runs fn while holding it. If the
+// lock is lost, fn's context is cancelled and Lead returns an error once fn
+// returns.
+func (l *Lock) Lead(ctx context.Context, fn func(context.Context) error) error {
+	ll := &leader.Lease{
+		Name:       "lock " + l.Name,
+		Identity:   l.Identity,
+		Duration:   l.Duration,
+		TryAcquire: l.tryAcquire,
+		Release:    l.release,
+	}
+	return ll.Lead(ctx, fn)
+}
+
+// read returns the lock file's ID, modifiedTime and contents. If racing
+// instances created several, the oldest is the lock.
+func (l *Lock) read(ctx context.Context) (string, string, lockState, error) {
+	var st lockState
+	q := fmt.Sprintf("name = '%s' and trashed = false", EscapeQuery(l.Name))
+	r, err := l.Drive.Files.List().Spaces("appDataFolder").Q(q).OrderBy("createdTime").
+		Fields("files(id,modifiedTime)").Context(ctx).Do()
+	if err != nil {
+		return "", "", st, fmt.Errorf("unable to find lock: %w", err)
+	}
+	if len(r.Files) == 0 {
+		return "", "", st, nil
+	}
+	id := r.Files[0].Id
+	var buf bytes.Buffer
+	if err := Download(ctx, l.Drive, id, &buf); err != nil {
+		return "", "", st, err
+	}
+	// An unreadable lock is treated as free.
+	json.Unmarshal(buf.Bytes(), &st)
+	return id, r.Files[0].ModifiedTime, st, nil
+}
+
+func (l *Lock) write(ctx context.Context, id string, st lockState) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		f := &drive.File{Name: l.Name, Parents: []string{"appDataFolder"}, MimeType: "application/json"}
+		_, err = l.Drive.Files.Create(f).Media(bytes.NewReader(b)).Fields("id").Context(ctx).Do()
+	} else {
+		_, err = l.Drive.Files.Update(id, &drive.File{}).Media(bytes.NewReader(b)).Fields("id").Context(ctx).Do()
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write lock: %w", err)
+	}
+	return nil
+}
+
+// tryAcquire renews the lock, or takes it if it is free or has expired,
+// reporting whether this instance holds it.
+func (l *Lock) tryAcquire(ctx context.Context) (bool, error) {
+	id, modified, st, err := l.read(ctx)
+	if err != nil {
+		return false, err
+	}
+	held := st.Holder == l.Identity
+	if !held && st.Holder != "" {
+		if modified != l.seenModified {
+			l.seenModified, l.seenAt = modified, time.Now()
+		}
+		if time.Since(l.seenAt) < l.Duration {
+			return false, nil
+		}
+	}
+	if err := l.write(ctx, id, lockState{Holder: l.Identity, Renewed: time.Now()}); err != nil {
+		return false, err
+	}
+	if held {
+		return true, nil
+	}
+	select {
+	case <-time.After(lockSettle):
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+	_, _, st, err = l.read(ctx)
+	if err != nil {
+		return false, err
+	}
+	return st.Holder == l.Identity, nil
+}
+
+// release frees the lock so a standby can take over without waiting for it
+// to expire.
+func (l *Lock) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	id, _, st, err := l.read(ctx)
+	if err != nil || id == "" || st.Holder != l.Identity {
+		return
+	}
+	if err := l.write(ctx, id, lockState{}); err != nil {
+		log.Printf("failed to release lock %s: %s", l.Name, err)
+	}
+}
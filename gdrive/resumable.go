@@ -0,0 +1,130 @@
+package gdrive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// ErrUploadExpired is returned when Drive no longer knows a resumable
+// upload session, which happens about a week after it was started.
+var ErrUploadExpired = errors.New("upload session expired")
+
+// UploadSession is a Drive resumable upload. Its URI can be saved and the
+// upload continued with ResumeUpload from another process.
+type UploadSession struct {
+	client *http.Client
+	URI    string
+	Size   int64
+}
+
+// StartUpload begins a resumable upload of size bytes creating metadata f.
+// fields selects what the final Send returns about the new file.
+func StartUpload(ctx context.Context, srv *Drive, f *drive.File, size int64, fields string) (*UploadSession, error) {
+	if srv.Client == nil {
+		return nil, errors.New("resumable uploads need a service from gdrive.New")
+	}
+	body, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	u := strings.Replace(srv.BasePath, "/drive/v3/", "/upload/drive/v3/", 1) + "files?uploadType=resumable&supportsAllDrives=true&fields=" + fields
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+	if f.MimeType != "" {
+		req.Header.Set("X-Upload-Content-Type", f.MimeType)
+	}
+	res, err := srv.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if err := googleapi.CheckResponse(res); err != nil {
+		return nil, err
+	}
+	loc := res.Header.Get("Location")
+	if loc == "" {
+		return nil, errors.New("no session URI in resumable upload response")
+	}
+	return &UploadSession{client: srv.Client, URI: loc, Size: size}, nil
+}
+
+// ResumeUpload returns the session at uri, started by StartUpload with srv.
+func ResumeUpload(srv *Drive, uri string, size int64) (*UploadSession, error) {
+	if srv.Client == nil {
+		return nil, errors.New("resumable uploads need a service from gdrive.New")
+	}
+	return &UploadSession{client: srv.Client, URI: uri, Size: size}, nil
+}
+
+// Offset asks Drive how many bytes of the upload it has. If it has them all
+// the created file is returned too.
+func (s *UploadSession) Offset(ctx context.Context) (int64, *drive.File, error) {
+	return s.put(ctx, nil, "bytes */"+strconv.FormatInt(s.Size, 10))
+}
+
+// Send uploads the n bytes of r that start at offset. It returns the offset
+// Drive has reached, which may be short of offset+n, and once the last byte
+// is in, the created file.
+func (s *UploadSession) Send(ctx context.Context, r io.Reader, offset, n int64) (int64, *drive.File, error) {
+	rng := fmt.Sprintf("bytes %d-%d/%d", offset, offset+n-1, s.Size)
+	return s.put(ctx, io.LimitReader(r, n), rng)
+}
+
+func (s *UploadSession) put(ctx context.Context, body io.Reader, contentRange string) (int64, *drive.File, error) {
+	req, err := http.NewRequest("PUT", s.URI, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Range", contentRange)
+	if body == nil {
+		req.ContentLength = 0
+	}
+	res, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		var f drive.File
+		if err := json.NewDecoder(res.Body).Decode(&f); err != nil {
+			return 0, nil, fmt.Errorf("unable to decode uploaded file: %w", err)
+		}
+		return s.Size, &f, nil
+	case http.StatusPermanentRedirect:
+		io.Copy(ioutil.Discard, res.Body)
+		return rangeEnd(res.Header.Get("Range")), nil, nil
+	case http.StatusNotFound, http.StatusGone:
+		return 0, nil, ErrUploadExpired
+	}
+	return 0, nil, googleapi.CheckResponse(res)
+}
+
+// rangeEnd returns the offset after a "bytes=0-N" Range header, or 0 if
+// there is none yet.
+func rangeEnd(h string) int64 {
+	i := strings.LastIndexByte(h, '-')
+	if i < 0 {
+		return 0
+	}
+	n, err := strconv.ParseInt(h[i+1:], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n + 1
+}
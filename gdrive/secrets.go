@@ -0,0 +1,103 @@
+package gdrive
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// Environment variables that may hold the base64-encoded contents of the
+// credentials and token files, so that containers and secret managers never
+// need to put them on disk.
+const (
+	CredentialsEnv = "GDRIVE_SYNC_CREDENTIALS"
+	TokenEnv       = "GDRIVE_SYNC_TOKEN"
+)
+
+// StdinPath may be given as the credentials or token file to read it from
+// stdin instead. If both are read from stdin, the credentials JSON must come
+// first, followed by the token JSON.
+const StdinPath = "-"
+
+var (
+	secretsMu    sync.Mutex
+	stdinDecoder *json.Decoder
+	stdinCreds   []byte
+	stdinToken   *oauth2.Token
+)
+
+// readCredentials returns the OAuth client configuration JSON, from the
+// environment, stdin or the credentials file as c allows.
+func readCredentials(c Config) ([]byte, error) {
+	if v := os.Getenv(CredentialsEnv); c.CredentialsFromEnv && v != "" {
+		b, err := decodeEnv(CredentialsEnv, v)
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+	if c.CredentialsFile != StdinPath {
+		return ioutil.ReadFile(c.CredentialsFile)
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	if stdinCreds == nil {
+		var raw json.RawMessage
+		if err := nextStdinValue(&raw); err != nil {
+			return nil, fmt.Errorf("reading credentials from stdin: %w", err)
+		}
+		stdinCreds = raw
+	}
+	return stdinCreds, nil
+}
+
+// tokenFromSecrets returns the token from the environment or stdin, as c
+// allows. ok is false if neither is configured and the token file should be
+// used.
+func tokenFromSecrets(c Config) (tok *oauth2.Token, ok bool, err error) {
+	if v := os.Getenv(TokenEnv); c.TokenFromEnv && v != "" {
+		b, err := decodeEnv(TokenEnv, v)
+		if err != nil {
+			return nil, true, err
+		}
+		tok = &oauth2.Token{}
+		return tok, true, json.Unmarshal(b, tok)
+	}
+	if c.TokenFile != StdinPath {
+		return nil, false, nil
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	if stdinToken == nil {
+		tok := &oauth2.Token{}
+		if err := nextStdinValue(tok); err != nil {
+			return nil, true, fmt.Errorf("reading token from stdin: %w", err)
+		}
+		stdinToken = tok
+	}
+	return stdinToken, true, nil
+}
+
+// nextStdinValue decodes the next JSON value from stdin. secretsMu must be
+// held.
+func nextStdinValue(v interface{}) error {
+	if stdinDecoder == nil {
+		stdinDecoder = json.NewDecoder(os.Stdin)
+	}
+	return stdinDecoder.Decode(v)
+}
+
+func decodeEnv(name, v string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+	if err != nil {
+		return nil, fmt.Errorf("$%s is not valid base64: %w", name, err)
+	}
+	return bytes.TrimSpace(b), nil
+}
@@ -5,6 +5,9 @@ go 1.14
 require (
 	github.com/dustin/go-humanize v1.0.0
 	github.com/fsnotify/fsnotify v1.4.9
+	golang.org/x/image v0.0.0-20190802002840-cff245a6509b
 	golang.org/x/oauth2 v0.0.0-20201208152858-08078c50e5b5
+	golang.org/x/sys v0.0.0-20201201145000-ef89a241ccb3
 	google.golang.org/api v0.36.0
+	gopkg.in/yaml.v2 v2.2.2
 )
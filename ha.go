@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/gdrive"
+	"google.golang.org/api/drive/v3"
+)
+
+var (
+	haLock         = flag.String("ha_lock", "", "Name of a lock file in the Drive appDataFolder; instances sharing it run active/standby so only one uploads (needs a token with the drive.appdata scope)")
+	haIdentity     = flag.String("ha_identity", "", "Name this instance holds --ha_lock as (default the hostname)")
+	haLockDuration = flag.Duration("ha_lock_duration", time.Minute, "How long after the active instance's last heartbeat a standby takes over")
+)
+
+// leadDrive runs fn only while holding --ha_lock.
+func leadDrive(ctx context.Context, p *probes, fn func(context.Context) error) error {
+	d, err := driveConfig(*credsFile, *tokenFile, drive.DriveAppdataScope).Service(ctx)
+	if err != nil {
+		return err
+	}
+	identity := *haIdentity
+	if identity == "" {
+		if identity, err = os.Hostname(); err != nil {
+			return fmt.Errorf("--ha_identity is required: %w", err)
+		}
+	}
+	p.set(nil, true)
+	log.Printf("Standing by for lock %s as %s...", *haLock, identity)
+	l := &gdrive.Lock{Drive: d, Name: *haLock, Identity: identity, Duration: *haLockDuration}
+	return l.Lead(ctx, func(ctx context.Context) error {
+		p.set(nil, false)
+		return fn(ctx)
+	})
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/journal"
+	"github.com/dknowles2/gdrive_sync/manifest"
+)
+
+// historyEntry is the stable, exported shape of an upload record. It is kept
+// separate from manifest.Record so the on-disk format can change without
+// breaking spreadsheets and audit scripts built on the export.
+type historyEntry struct {
+	UploadedAt  time.Time `json:"uploaded_at"`
+	LocalPath   string    `json:"local_path"`
+	Name        string    `json:"name"`
+	SizeBytes   int64     `json:"size_bytes"`
+	MD5         string    `json:"md5"`
+	DriveFileId string    `json:"drive_file_id"`
+	FolderId    string    `json:"drive_folder_id"`
+	WebLink     string    `json:"web_link"`
+}
+
+var historyColumns = []string{"uploaded_at", "local_path", "name", "size_bytes", "md5", "drive_file_id", "drive_folder_id", "web_link"}
+
+func (e historyEntry) row() []string {
+	return []string{
+		e.UploadedAt.Format(time.RFC3339),
+		e.LocalPath,
+		e.Name,
+		strconv.FormatInt(e.SizeBytes, 10),
+		e.MD5,
+		e.DriveFileId,
+		e.FolderId,
+		e.WebLink,
+	}
+}
+
+const historyUsage = `usage: history export [--format=csv|json] [--since DATE] [--output FILE]
+       history attempts [--status STATUS] [--since DATE] [PATH_GLOB]`
+
+// history implements the "history" command family.
+func history(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New(historyUsage)
+	}
+	switch args[0] {
+	case "export":
+		return historyExport(args[1:])
+	case "attempts":
+		return historyAttempts(args[1:])
+	default:
+		return errors.New(historyUsage)
+	}
+}
+
+// historyExport writes the successful uploads in the manifest.
+func historyExport(args []string) error {
+	fs := flag.NewFlagSet("history export", flag.ExitOnError)
+	format := fs.String("format", "csv", "Output format: csv or json")
+	since := fs.String("since", "", "Only export uploads on or after this date (YYYY-MM-DD or RFC 3339)")
+	output := fs.String("output", "-", "File to write the export to (\"-\" for stdout)")
+	fs.Parse(args)
+
+	if *manifestFile == "" {
+		return errors.New("--manifest_file is required")
+	}
+	var after time.Time
+	if *since != "" {
+		var err error
+		if after, err = parseDate(*since); err != nil {
+			return err
+		}
+	}
+	records, err := manifest.Load(*manifestFile)
+	if err != nil {
+		return err
+	}
+	var entries []historyEntry
+	for _, r := range records {
+		if r.Time.Before(after) {
+			continue
+		}
+		entries = append(entries, historyEntry{
+			UploadedAt:  r.Time,
+			LocalPath:   r.Path,
+			Name:        r.Name,
+			SizeBytes:   r.Size,
+			MD5:         r.MD5,
+			DriveFileId: r.DriveFileId,
+			FolderId:    r.FolderId,
+			WebLink:     fmt.Sprintf("https://drive.google.com/file/d/%s/view", r.DriveFileId),
+		})
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	switch *format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(historyColumns)
+		for _, e := range entries {
+			cw.Write(e.row())
+		}
+		cw.Flush()
+		return cw.Error()
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if entries == nil {
+			entries = []historyEntry{}
+		}
+		return enc.Encode(entries)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+}
+
+// historyAttempts lists the upload attempts in the journal, oldest first.
+func historyAttempts(args []string) error {
+	fs := flag.NewFlagSet("history attempts", flag.ExitOnError)
+	status := fs.String("status", "", "Only list attempts that reached this status: started, uploaded, failed, retrying, abandoned, requeued or removed")
+	since := fs.String("since", "", "Only list attempts on or after this date (YYYY-MM-DD or RFC 3339)")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		return errors.New(historyUsage)
+	}
+	pattern := fs.Arg(0)
+
+	if *journalFile == "" {
+		return errors.New("--journal_file is required")
+	}
+	var after time.Time
+	if *since != "" {
+		var err error
+		if after, err = parseDate(*since); err != nil {
+			return err
+		}
+	}
+	entries, err := journal.Load(*journalFile)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tSTATUS\tSIZE\tPATH\tDETAIL")
+	for _, e := range entries {
+		if e.Time.Before(after) || (*status != "" && string(e.Status) != *status) {
+			continue
+		}
+		if pattern != "" {
+			if ok, err := filepath.Match(pattern, e.Path); err != nil {
+				return fmt.Errorf("bad path glob: %w", err)
+			} else if !ok {
+				continue
+			}
+		}
+		detail := e.Error
+		if e.DriveFileId != "" {
+			detail = e.DriveFileId
+		} else if e.PhotosItemId != "" {
+			detail = e.PhotosItemId
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", e.Time.Local().Format("2006-01-02 15:04:05"), e.Status, e.Size, e.Path, detail)
+	}
+	return w.Flush()
+}
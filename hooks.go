@@ -0,0 +1,15 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/dknowles2/gdrive_sync/uploader"
+)
+
+var (
+	preUploadCommand = flag.String("pre_upload_command", "", "Shell command run on each file before it is uploaded, e.g. to deskew, compress or virus scan it in place; the file is in $GDRIVE_SYNC_FILE")
+	preUploadPolicy  = flag.String("pre_upload_policy", uploader.PreUploadFail, "What to do when --pre_upload_command fails: fail (count it as a failed upload), skip (leave the file until it changes) or ignore (upload it anyway)")
+	successCommand   = flag.String("success_command", "", "Shell command run after each file is uploaded, e.g. to print a label; $GDRIVE_SYNC_FILE, $GDRIVE_SYNC_DRIVE_FILE_ID and $GDRIVE_SYNC_LINK describe the upload")
+	failureCommand   = flag.String("failure_command", "", "Shell command run after an upload fails, e.g. to beep the scanner; $GDRIVE_SYNC_FILE and $GDRIVE_SYNC_ERROR say what failed")
+	hookTimeout      = flag.Duration("hook_timeout", uploader.DefaultHookTimeout, "Kill --pre_upload_command, --success_command and --failure_command after this long")
+)
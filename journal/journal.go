@@ -0,0 +1,214 @@
+// Package journal records every upload attempt in an append-only file of
+// newline-delimited JSON and keeps the latest state of each file in memory,
+// so a restarted daemon knows what it already uploaded.
+package journal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Status is where an upload attempt got to.
+type Status string
+
+const (
+	Started  Status = "started"
+	Uploaded Status = "uploaded"
+	Failed   Status = "failed"
+	// Removed means the uploaded file was deleted or archived locally.
+	Removed Status = "removed"
+	// Abandoned means the file failed too often and is quarantined: it is
+	// no longer tried until it changes or is requeued.
+	Abandoned Status = "abandoned"
+	// Retrying means the file has failed Failures times in a row and will
+	// be tried again after a backoff.
+	Retrying Status = "retrying"
+	// Requeued means a quarantined file was put back to be tried again.
+	Requeued Status = "requeued"
+)
+
+// Entry is one step of an upload attempt. Size and ModTime describe the
+// local file when the attempt started.
+type Entry struct {
+	Time         time.Time `json:"time"`
+	Path         string    `json:"path"`
+	Status       Status    `json:"status"`
+	Size         int64     `json:"size"`
+	ModTime      time.Time `json:"mtime"`
+	MD5          string    `json:"md5,omitempty"`
+	DriveFileId  string    `json:"drive_file_id,omitempty"`
+	PhotosItemId string    `json:"photos_item_id,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	// Compression is the format the file was compressed in before upload,
+	// if it was. MD5 and UploadedSize then describe the compressed copy in
+	// Drive, and OriginalMD5 the local file.
+	Compression  string `json:"compression,omitempty"`
+	UploadedSize int64  `json:"uploaded_size,omitempty"`
+	OriginalMD5  string `json:"original_md5,omitempty"`
+	// Link is the link to the uploaded file, if it was shared.
+	Link string `json:"link,omitempty"`
+	// Destinations are the IDs of the copies uploaded to the other
+	// destinations, and where those copied to backends are, by name.
+	Destinations map[string]string `json:"destinations,omitempty"`
+	// Failures is how many uploads of the file have failed in a row, for
+	// Retrying.
+	Failures int `json:"failures,omitempty"`
+	// Quarantine is where an Abandoned file was moved to, if it was.
+	Quarantine string `json:"quarantine,omitempty"`
+}
+
+// Journal appends entries to a journal file. Entries appended by other
+// processes, such as the quarantine command, are picked up as they appear.
+type Journal struct {
+	mu      sync.Mutex
+	path    string
+	f       *os.File
+	size    int64 // bytes of the file applied to latest
+	latest  map[string]Entry
+	retries map[string]Entry
+}
+
+// Open loads the journal at path, creating it if needed, and opens it for
+// appending. A torn last line left by a crash mid-write is cut off.
+func Open(path string) (*Journal, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to read journal: %w", err)
+	}
+	if n := bytes.LastIndexByte(data, '\n') + 1; n < len(data) {
+		if err := os.Truncate(path, int64(n)); err != nil {
+			return nil, fmt.Errorf("unable to repair journal: %w", err)
+		}
+		data = data[:n]
+	}
+	entries, err := parse(path, data)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open journal: %w", err)
+	}
+	j := &Journal{path: path, f: f, size: int64(len(data)), latest: make(map[string]Entry), retries: make(map[string]Entry)}
+	for _, e := range entries {
+		j.apply(e)
+	}
+	return j, nil
+}
+
+// apply makes e the latest entry for its path. j.mu must be held, unless j
+// is being opened.
+func (j *Journal) apply(e Entry) {
+	p := filepath.Clean(e.Path)
+	j.latest[p] = e
+	switch e.Status {
+	case Retrying:
+		j.retries[p] = e
+	case Uploaded, Abandoned, Requeued, Removed:
+		delete(j.retries, p)
+	}
+}
+
+// refresh applies the entries appended since the journal was last read,
+// including those of other processes. j.mu must be held.
+func (j *Journal) refresh() {
+	fi, err := j.f.Stat()
+	if err != nil || fi.Size() <= j.size {
+		return
+	}
+	r, err := os.Open(j.path)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	data := make([]byte, fi.Size()-j.size)
+	n, _ := r.ReadAt(data, j.size)
+	data = data[:bytes.LastIndexByte(data[:n], '\n')+1]
+	entries, err := parse(j.path, data)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		j.apply(e)
+	}
+	j.size += int64(len(data))
+}
+
+// Append writes e to the journal and syncs it to disk.
+func (j *Journal) Append(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.refresh()
+	j.apply(e)
+	// The entry is read back by the next refresh, like those of other
+	// processes, which keeps j.size at the start of a line however the
+	// appends interleave.
+	if _, err := j.f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("unable to write journal: %w", err)
+	}
+	return j.f.Sync()
+}
+
+// Last returns the most recent entry for path.
+func (j *Journal) Last(path string) (Entry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.refresh()
+	e, ok := j.latest[filepath.Clean(path)]
+	return e, ok
+}
+
+// Retries returns the Retrying entry recording how often path has failed
+// since it was last uploaded, quarantined or requeued.
+func (j *Journal) Retries(path string) (Entry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.refresh()
+	e, ok := j.retries[filepath.Clean(path)]
+	return e, ok
+}
+
+func (j *Journal) Close() error {
+	return j.f.Close()
+}
+
+// Load reads every entry in the journal at path, oldest first, ignoring a
+// torn last line.
+func Load(path string) ([]Entry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read journal: %w", err)
+	}
+	if n := bytes.LastIndexByte(data, '\n') + 1; n < len(data) {
+		data = data[:n]
+	}
+	return parse(path, data)
+}
+
+func parse(path string, data []byte) ([]Entry, error) {
+	var entries []Entry
+	for line, b := range bytes.Split(data, []byte("\n")) {
+		if len(b) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(b, &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line+1, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
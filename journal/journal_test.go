@@ -0,0 +1,95 @@
+package journal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempJournal(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "journal.jsonl")
+}
+
+func TestOpenRepairsTornLine(t *testing.T) {
+	path := tempJournal(t)
+	good := `{"time":"2024-05-01T10:00:00Z","path":"/in/a.pdf","status":"uploaded"}` + "\n"
+	if err := ioutil.WriteFile(path, []byte(good+`{"time":"2024-05-01T10:01:00Z","pa`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	j, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer j.Close()
+	if e, ok := j.Last("/in/a.pdf"); !ok || e.Status != Uploaded {
+		t.Errorf("Last(/in/a.pdf) = %+v, %t; want the uploaded entry", e, ok)
+	}
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != good {
+		t.Errorf("journal holds %q after repair, want %q", got, good)
+	}
+
+	// Appends start on a fresh line.
+	if err := j.Append(Entry{Path: "/in/b.pdf", Status: Started}); err != nil {
+		t.Fatalf("Append: %s", err)
+	}
+	entries, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %s", err)
+	}
+	if len(entries) != 2 || entries[1].Path != "/in/b.pdf" {
+		t.Errorf("Load = %+v, want the repaired entry then the new one", entries)
+	}
+}
+
+func TestRefreshSeesOtherWriters(t *testing.T) {
+	path := tempJournal(t)
+	j, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer j.Close()
+	if err := j.Append(Entry{Path: "/in/a.pdf", Status: Retrying, Failures: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := j.Retries("/in/a.pdf"); !ok {
+		t.Fatal("Retries(/in/a.pdf) found nothing after a Retrying entry")
+	}
+
+	// Another process, like the quarantine command, requeues the file,
+	// and is midway through writing another entry.
+	other, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open again: %s", err)
+	}
+	if err := other.Append(Entry{Path: "/in/a.pdf", Status: Requeued}); err != nil {
+		t.Fatal(err)
+	}
+	other.Close()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"path":"/in/b.pdf",`)
+	f.Close()
+
+	if e, ok := j.Last("/in/a.pdf"); !ok || e.Status != Requeued {
+		t.Errorf("Last(/in/a.pdf) = %+v, %t; want the other process's requeue", e, ok)
+	}
+	if _, ok := j.Retries("/in/a.pdf"); ok {
+		t.Error("Retries(/in/a.pdf) still found the count after the requeue")
+	}
+	if _, ok := j.Last("/in/b.pdf"); ok {
+		t.Error("Last(/in/b.pdf) found the half-written entry")
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/downloader"
+	"github.com/dknowles2/gdrive_sync/kube"
+	"github.com/dknowles2/gdrive_sync/uploader"
+	"github.com/fsnotify/fsnotify"
+)
+
+var (
+	configDir     = flag.String("config_dir", "", "Directory holding one file per flag, named after it, such as a mounted ConfigMap; flags given on the command line win, and changes reload the uploader")
+	stateDir      = flag.String("state_dir", "", "Directory for persistent state such as a PersistentVolumeClaim mount; defaults --token_file, --manifest_file and --transfer_state_file to files in it")
+	probeAddr     = flag.String("probe_addr", "", "Serve liveness (/healthz) and readiness (/readyz) probes, and /status, on this address, e.g. :8080")
+	httpAddr      = flag.String("http_addr", "", "Serve /healthz, /readyz and a JSON /status page on this address, e.g. 127.0.0.1:8080 for Docker healthchecks; may be used with or instead of --probe_addr")
+	drainTimeout  = flag.Duration("drain_timeout", 0, "On SIGTERM or reload, wait this long for running uploads to finish before stopping (set below terminationGracePeriodSeconds)")
+	leaderLease   = flag.String("leader_lease", "", "Name of a Kubernetes Lease in the pod's namespace; only the replica holding it uploads, so replicas may share a volume")
+	leaseDuration = flag.Duration("lease_duration", 15*time.Second, "How long --leader_lease is held without renewal")
+)
+
+// cmdlineFlags records the flags given on the command line, which
+// --config_dir and --state_dir must not override. configFlags records those
+// set from --config_dir.
+var cmdlineFlags, configFlags = map[string]bool{}, map[string]bool{}
+
+// readConfigDir returns the flag values in dir, keyed by file name, and a
+// snapshot of them for detecting changes.
+func readConfigDir(dir string) (map[string]string, []byte, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read --config_dir: %w", err)
+	}
+	values := make(map[string]string)
+	var snapshot bytes.Buffer
+	for _, fi := range files {
+		// ConfigMap volumes keep their data in hidden ..data directories
+		// and link the keys to them.
+		if strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, nil, err
+		}
+		values[fi.Name()] = strings.TrimSpace(string(b))
+		fmt.Fprintf(&snapshot, "%s=%s\n", fi.Name(), b)
+	}
+	return values, snapshot.Bytes(), nil
+}
+
+// applyConfigDir sets each flag not given on the command line from the file
+// of the same name in dir, and resets flags whose files have been removed.
+// It returns a snapshot for watchConfigDir.
+func applyConfigDir(dir string) ([]byte, error) {
+	values, snapshot, err := readConfigDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for name := range configFlags {
+		if _, ok := values[name]; !ok {
+			flag.Set(name, flag.Lookup(name).DefValue)
+			delete(configFlags, name)
+		}
+	}
+	for name, v := range values {
+		if cmdlineFlags[name] {
+			continue
+		}
+		if flag.Lookup(name) == nil || name == "config_dir" {
+			log.Printf("Ignoring unknown flag %q in --config_dir", name)
+			continue
+		}
+		if l, ok := flag.Lookup(name).Value.(*listFlag); ok {
+			// Each line of the file is one value of a repeatable flag.
+			*l = nil
+			for _, line := range strings.Split(v, "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					l.Set(line)
+				}
+			}
+		} else if err := flag.Set(name, v); err != nil {
+			return nil, fmt.Errorf("invalid %s in --config_dir: %w", name, err)
+		}
+		configFlags[name] = true
+	}
+	return snapshot, nil
+}
+
+// applyStateDir points state files not set explicitly into dir.
+func applyStateDir(dir string) {
+	for name, file := range map[string]string{
+		"token_file":          "token.json",
+		"manifest_file":       "manifest.jsonl",
+		"journal_file":        "journal.jsonl",
+		"sessions_file":       "sessions.json",
+		"offline_queue_file":  "offline.json",
+		"download_state_file": "downloads.json",
+		"transfer_state_file": "transfer.json",
+	} {
+		if !cmdlineFlags[name] && !configFlags[name] {
+			flag.Set(name, filepath.Join(dir, file))
+		}
+	}
+}
+
+// watchConfigDir calls changed once the contents of dir differ from
+// snapshot, and then returns.
+func watchConfigDir(ctx context.Context, dir string, snapshot []byte, changed func()) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("Not watching --config_dir for changes: %s", err)
+		return
+	}
+	defer w.Close()
+	if err := w.Add(dir); err != nil {
+		log.Printf("Not watching --config_dir for changes: %s", err)
+		return
+	}
+	for {
+		select {
+		case <-w.Events:
+		case err := <-w.Errors:
+			log.Printf("error watching --config_dir: %s", err)
+			continue
+		case <-ctx.Done():
+			return
+		}
+		// Let a ConfigMap update finish swapping its links.
+		time.Sleep(time.Second)
+		if _, b, err := readConfigDir(dir); err == nil && !bytes.Equal(b, snapshot) {
+			log.Printf("Configuration in %s changed; reloading", dir)
+			changed()
+			return
+		}
+	}
+}
+
+// probes tracks the state reported by the liveness and readiness endpoints.
+type probes struct {
+	mu       sync.Mutex
+	us       []*uploader.Uploader
+	standby  bool
+	stopping bool
+	// dl receives Drive's push notifications, if there is a Downloader.
+	dl *downloader.Downloader
+}
+
+func (p *probes) set(us []*uploader.Uploader, standby bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.us, p.standby = us, standby
+}
+
+// readyLocked reports whether every uploader is watching.
+func (p *probes) readyLocked() bool {
+	for _, u := range p.us {
+		if !u.Ready() {
+			return false
+		}
+	}
+	return len(p.us) > 0
+}
+
+func (p *probes) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopping = true
+}
+
+// serve starts the probe and status server. Liveness only needs the process to be
+// responsive; readiness needs the uploader watching, or this replica to be
+// waiting for the leader lease, so standbys don't block rollouts.
+func (p *probes) serve(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("unable to listen on %s: %w", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		switch {
+		case p.stopping:
+			http.Error(w, "stopping", http.StatusServiceUnavailable)
+		case p.standby:
+			fmt.Fprintln(w, "standby")
+		case p.readyLocked():
+			fmt.Fprintln(w, "ok")
+		default:
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+		}
+	})
+	mux.HandleFunc("/status", p.status)
+	mux.HandleFunc("/drive/changes", p.driveChanges)
+	go http.Serve(l, mux)
+	return nil
+}
+
+func (p *probes) setDownloader(dl *downloader.Downloader) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dl = dl
+}
+
+// driveChanges passes Drive's push notifications to the Downloader.
+func (p *probes) driveChanges(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	dl := p.dl
+	p.mu.Unlock()
+	if dl == nil {
+		http.NotFound(w, r)
+		return
+	}
+	dl.ServeHTTP(w, r)
+}
+
+// status serves what every uploader is doing as JSON, for debugging.
+func (p *probes) status(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	us := p.us
+	st := struct {
+		Standby   bool              `json:"standby"`
+		Stopping  bool              `json:"stopping"`
+		Uploaders []uploader.Status `json:"uploaders"`
+	}{Standby: p.standby, Stopping: p.stopping, Uploaders: []uploader.Status{}}
+	p.mu.Unlock()
+	for _, u := range us {
+		st.Uploaders = append(st.Uploaders, u.Status())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(st)
+}
+
+// lead runs fn only while holding --leader_lease.
+func lead(ctx context.Context, p *probes, fn func(context.Context) error) error {
+	c, err := kube.InCluster()
+	if err != nil {
+		return fmt.Errorf("--leader_lease: %w", err)
+	}
+	identity := os.Getenv("POD_NAME")
+	if identity == "" {
+		if identity, err = os.Hostname(); err != nil {
+			return err
+		}
+	}
+	p.set(nil, true)
+	log.Printf("Waiting for lease %s as %s...", *leaderLease, identity)
+	e := &kube.Elector{Client: c, Name: *leaderLease, Identity: identity, Duration: *leaseDuration}
+	return e.Lead(ctx, func(ctx context.Context) error {
+		p.set(nil, false)
+		return fn(ctx)
+	})
+}
@@ -0,0 +1,124 @@
+// Package kube is a minimal client for the Kubernetes API server, using the
+// service account mounted into every pod. It implements just enough to hold
+// a coordination.k8s.io Lease for leader election.
+package kube
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// ErrNotInCluster is returned by InCluster outside a Kubernetes pod.
+var ErrNotInCluster = errors.New("not running in a Kubernetes pod")
+
+// Client talks to the API server of the cluster the process runs in.
+type Client struct {
+	host      string
+	tokenPath string
+	hc        *http.Client
+	// Namespace is the pod's namespace.
+	Namespace string
+}
+
+// InCluster returns a Client authenticated as the pod's service account.
+func InCluster() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, ErrNotInCluster
+	}
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificates in service account CA")
+	}
+	ns, err := ioutil.ReadFile(serviceAccountDir + "/namespace")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read service account namespace: %w", err)
+	}
+	return &Client{
+		host:      "https://" + net.JoinHostPort(host, port),
+		tokenPath: serviceAccountDir + "/token",
+		hc: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+		Namespace: strings.TrimSpace(string(ns)),
+	}, nil
+}
+
+// StatusError is an unsuccessful API server response.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("kubernetes API error %d: %s", e.Code, e.Message)
+}
+
+// IsStatus reports whether err is a StatusError with the given code.
+func IsStatus(err error, code int) bool {
+	var se *StatusError
+	return errors.As(err, &se) && se.Code == code
+}
+
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, c.host+path, &body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	// The token is rotated on disk, so read it for every request.
+	token, err := ioutil.ReadFile(c.tokenPath)
+	if err != nil {
+		return fmt.Errorf("unable to read service account token: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		var status struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(b, &status)
+		if status.Message == "" {
+			status.Message = resp.Status
+		}
+		return &StatusError{Code: resp.StatusCode, Message: status.Message}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
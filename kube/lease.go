@@ -0,0 +1,151 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/leader"
+)
+
+// MicroTime is a Kubernetes timestamp with microsecond precision.
+type MicroTime struct {
+	time.Time
+}
+
+const microTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+func (t MicroTime) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return []byte(`"` + t.UTC().Format(microTimeFormat) + `"`), nil
+}
+
+func (t *MicroTime) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		t.Time = time.Time{}
+		return nil
+	}
+	v, err := time.Parse(`"`+time.RFC3339Nano+`"`, string(b))
+	t.Time = v
+	return err
+}
+
+// Lease is a coordination.k8s.io/v1 Lease.
+type Lease struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   ObjectMeta `json:"metadata"`
+	Spec       LeaseSpec  `json:"spec"`
+}
+
+type ObjectMeta struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type LeaseSpec struct {
+	HolderIdentity       string    `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int       `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          MicroTime `json:"acquireTime,omitempty"`
+	RenewTime            MicroTime `json:"renewTime,omitempty"`
+	LeaseTransitions     int       `json:"leaseTransitions"`
+}
+
+// Elector holds a Lease so that only one replica acts at a time.
+type Elector struct {
+	Client *Client
+	// Name of the Lease in the client's namespace.
+	Name string
+	// Identity of this replica, usually the pod name.
+	Identity string
+	// Duration is how long the lease is valid without renewal. It's renewed
+	// every third of that.
+	Duration time.Duration
+}
+
+func (e *Elector) path() string {
+	return fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases", e.Client.Namespace)
+}
+
+// Lead blocks until the lease is acquired and then runs fn, renewing the
+// lease until fn returns. If the lease is lost, fn's context is cancelled and
+// Lead returns an error once fn returns.
+func (e *Elector) Lead(ctx context.Context, fn func(context.Context) error) error {
+	l := &leader.Lease{
+		Name:       "lease " + e.Name,
+		Identity:   e.Identity,
+		Duration:   e.Duration,
+		TryAcquire: e.tryAcquire,
+		Release:    e.release,
+	}
+	return l.Lead(ctx, fn)
+}
+
+// tryAcquire creates, renews or takes over the lease, reporting whether this
+// replica holds it.
+func (e *Elector) tryAcquire(ctx context.Context) (bool, error) {
+	now := MicroTime{time.Now()}
+	var l Lease
+	err := e.Client.do(ctx, http.MethodGet, e.path()+"/"+e.Name, nil, &l)
+	if IsStatus(err, http.StatusNotFound) {
+		l = Lease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   ObjectMeta{Name: e.Name, Namespace: e.Client.Namespace},
+			Spec: LeaseSpec{
+				HolderIdentity:       e.Identity,
+				LeaseDurationSeconds: int(e.Duration / time.Second),
+				AcquireTime:          now,
+				RenewTime:            now,
+			},
+		}
+		err = e.Client.do(ctx, http.MethodPost, e.path(), &l, nil)
+		if IsStatus(err, http.StatusConflict) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+	expires := l.Spec.RenewTime.Add(time.Duration(l.Spec.LeaseDurationSeconds) * time.Second)
+	held := l.Spec.HolderIdentity == e.Identity
+	if !held && l.Spec.HolderIdentity != "" && now.Before(expires) {
+		return false, nil
+	}
+	if !held {
+		l.Spec.HolderIdentity = e.Identity
+		l.Spec.AcquireTime = now
+		l.Spec.LeaseTransitions++
+	}
+	l.Spec.LeaseDurationSeconds = int(e.Duration / time.Second)
+	l.Spec.RenewTime = now
+	// The resourceVersion makes this fail if another replica got there first.
+	err = e.Client.do(ctx, http.MethodPut, e.path()+"/"+e.Name, &l, nil)
+	if IsStatus(err, http.StatusConflict) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// release gives up the lease so another replica can take over immediately.
+func (e *Elector) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var l Lease
+	if err := e.Client.do(ctx, http.MethodGet, e.path()+"/"+e.Name, nil, &l); err != nil {
+		return
+	}
+	if l.Spec.HolderIdentity != e.Identity {
+		return
+	}
+	l.Spec.HolderIdentity = ""
+	if err := e.Client.do(ctx, http.MethodPut, e.path()+"/"+e.Name, &l, nil); err != nil {
+		log.Printf("failed to release lease %s: %s", e.Name, err)
+	}
+}
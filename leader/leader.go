@@ -0,0 +1,89 @@
+// Package leader runs code only while holding a lease that has to be kept
+// renewed, whatever stores the lease: a Kubernetes Lease or a file in
+// Drive.
+package leader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Lease is a lease only one instance can hold at a time.
+type Lease struct {
+	// Name describes the lease in log messages, such as "lease gdrive-sync".
+	Name string
+	// Identity of this instance, usually its hostname or pod name.
+	Identity string
+	// Duration is how long the lease is valid without renewal. It's renewed
+	// every third of that.
+	Duration time.Duration
+	// TryAcquire takes the lease if it is free or has expired, or renews it
+	// if this instance holds it, reporting whether this instance holds it.
+	TryAcquire func(ctx context.Context) (bool, error)
+	// Release gives up the lease so another instance can take over without
+	// waiting for it to expire.
+	Release func()
+}
+
+// Lead blocks until the lease is acquired and then runs fn, renewing the
+// lease until fn returns. If the lease is lost, fn's context is cancelled and
+// Lead returns an error once fn returns.
+func (l *Lease) Lead(ctx context.Context, fn func(context.Context) error) error {
+	retry := l.Duration / 3
+	for {
+		ok, err := l.TryAcquire(ctx)
+		if err != nil {
+			log.Printf("failed to acquire %s: %s", l.Name, err)
+		}
+		if ok {
+			break
+		}
+		select {
+		case <-time.After(retry):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	log.Printf("Acquired %s as %s", l.Name, l.Identity)
+
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lost := make(chan struct{})
+	go func() {
+		renewed := time.Now()
+		for {
+			select {
+			case <-time.After(retry):
+			case <-lctx.Done():
+				return
+			}
+			ok, err := l.TryAcquire(lctx)
+			if ok {
+				renewed = time.Now()
+				continue
+			}
+			if err != nil {
+				log.Printf("failed to renew %s: %s", l.Name, err)
+			}
+			// Someone else holds it, or we've failed to renew it for so
+			// long that they may. A standby may take it once Duration has
+			// passed since the last renewal, and this loop only wakes every
+			// Duration/3, so step down a third early to never overlap.
+			if err == nil || time.Since(renewed) > 2*l.Duration/3 {
+				close(lost)
+				cancel()
+				return
+			}
+		}
+	}()
+	err := fn(lctx)
+	select {
+	case <-lost:
+		return fmt.Errorf("lost %s", l.Name)
+	default:
+	}
+	l.Release()
+	return err
+}
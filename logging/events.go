@@ -0,0 +1,105 @@
+package logging
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/events"
+)
+
+// maxTracked bounds how many discovered files EventLogger remembers the
+// discovery time of. Files skipped as already uploaded, parked or backing
+// off end without an event saying so, and would otherwise stay forever.
+const maxTracked = 10000
+
+// EventLogger is an events.Sink that logs each pipeline event as a record
+// with the file, size, Drive file ID and, once a file is done, how long it
+// took since it was discovered.
+type EventLogger struct {
+	l *Logger
+
+	mu    sync.Mutex
+	start map[string]time.Time // by correlation ID
+}
+
+func NewEventLogger(l *Logger) *EventLogger {
+	return &EventLogger{l: l, start: make(map[string]time.Time)}
+}
+
+func (el *EventLogger) Emit(e events.Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	level := Debug
+	switch e.Type {
+	case events.Uploaded, events.BacklogCleared:
+		level = Info
+	case events.Paused, events.Inactive, events.Offline:
+		level = Warn
+	case events.Failed:
+		level = Error
+	}
+	var fields []Field
+	if e.Id != "" {
+		fields = append(fields, Field{"id", e.Id})
+	}
+	if e.File != "" {
+		fields = append(fields, Field{"file", e.File})
+	}
+	if e.Size != 0 {
+		fields = append(fields, Field{"size", e.Size})
+	}
+	if e.Bytes != 0 {
+		fields = append(fields, Field{"bytes", e.Bytes})
+	}
+	if d, ok := el.elapsed(e); ok {
+		fields = append(fields, Field{"duration", d.Round(time.Millisecond).Seconds()})
+	}
+	if e.DriveFileId != "" {
+		fields = append(fields, Field{"drive_file_id", e.DriveFileId})
+	}
+	if e.PhotosItemId != "" {
+		fields = append(fields, Field{"photos_item_id", e.PhotosItemId})
+	}
+	if e.Error != "" {
+		fields = append(fields, Field{"error", e.Error})
+	}
+	el.l.Log(level, "event "+string(e.Type), fields...)
+}
+
+// elapsed tracks when each file was discovered and returns how long it has
+// been once it is uploaded or fails.
+func (el *EventLogger) elapsed(e events.Event) (time.Duration, bool) {
+	if e.Id == "" {
+		return 0, false
+	}
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	switch e.Type {
+	case events.Discovered:
+		if len(el.start) >= maxTracked {
+			el.forgetOldest()
+		}
+		el.start[e.Id] = e.Time
+	case events.Uploaded, events.Failed:
+		start, ok := el.start[e.Id]
+		delete(el.start, e.Id)
+		return e.Time.Sub(start), ok
+	case events.Deleted, events.Archived:
+		// A file found to be uploaded already ends with its removal.
+		delete(el.start, e.Id)
+	}
+	return 0, false
+}
+
+// forgetOldest drops the file discovered longest ago. el.mu must be held.
+func (el *EventLogger) forgetOldest() {
+	var oldest string
+	var at time.Time
+	for id, t := range el.start {
+		if oldest == "" || t.Before(at) {
+			oldest, at = id, t
+		}
+	}
+	delete(el.start, oldest)
+}
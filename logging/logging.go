@@ -0,0 +1,148 @@
+// Package logging writes leveled, structured log records as logfmt-style
+// text or JSON lines, for ingestion by Loki, Elastic and the like. It can
+// stand in as the standard logger's output, turning each line into a record.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a record. The values match log/slog's.
+type Level int
+
+const (
+	Debug Level = -4
+	Info  Level = 0
+	Warn  Level = 4
+	Error Level = 8
+)
+
+func (l Level) String() string {
+	switch {
+	case l <= Debug:
+		return "DEBUG"
+	case l < Warn:
+		return "INFO"
+	case l < Error:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// ParseLevel parses a level name such as "info", in any case.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return Debug, nil
+	case "info":
+		return Info, nil
+	case "warn", "warning":
+		return Warn, nil
+	case "error":
+		return Error, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// Field is a key and value attached to a record.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger writes records at or above its level to w.
+type Logger struct {
+	level Level
+	json  bool
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// New returns a Logger writing in format, "text" or "json".
+func New(w io.Writer, format string, level Level) (*Logger, error) {
+	switch format {
+	case "text", "json":
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+	return &Logger{w: w, json: format == "json", level: level}, nil
+}
+
+// Enabled reports whether records at level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+// Log writes a record.
+func (l *Logger) Log(level Level, msg string, fields ...Field) {
+	if !l.Enabled(level) {
+		return
+	}
+	all := append([]Field{
+		{"time", time.Now().UTC().Format(time.RFC3339Nano)},
+		{"level", level.String()},
+		{"msg", msg},
+	}, fields...)
+	var buf bytes.Buffer
+	if l.json {
+		writeJSON(&buf, all)
+	} else {
+		writeText(&buf, all)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(buf.Bytes())
+}
+
+func writeJSON(buf *bytes.Buffer, fields []Field) {
+	buf.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(f.Key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(f.Value)
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(f.Value))
+		}
+		buf.Write(v)
+	}
+	buf.WriteString("}\n")
+}
+
+func writeText(buf *bytes.Buffer, fields []Field) {
+	for i, f := range fields {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(f.Key)
+		buf.WriteByte('=')
+		s := fmt.Sprint(f.Value)
+		if s == "" || strings.ContainsAny(s, " \"=\t\n") {
+			s = strconv.Quote(s)
+		}
+		buf.WriteString(s)
+	}
+	buf.WriteByte('\n')
+}
+
+// Write logs each line of p, as written by a standard logger without flags,
+// as an info record. Code that knows the level of what it logs should call
+// Log instead.
+func (l *Logger) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		l.Log(Info, line)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/dknowles2/gdrive_sync/uploader"
+	"github.com/dustin/go-humanize"
+)
+
+// lowMemoryThreshold is the amount of RAM below which the low-memory profile
+// is used automatically.
+const lowMemoryThreshold = 1 << 30
+
+// Settings used by the low-memory profile.
+const (
+	lowMemoryChunkSize   = 1 << 20
+	lowMemoryConcurrency = 1
+	lowMemoryQueuedFiles = 100
+	lowMemoryGCPercent   = 50
+)
+
+// savedGCPercent is the garbage collector target from before the profile
+// was applied, or 0 while it isn't.
+var savedGCPercent int
+
+// useLowMemory reports whether the low-memory profile should be applied,
+// either because --low_memory was set, on the command line or in
+// --config_dir, or because the machine is small.
+func useLowMemory() bool {
+	if cmdlineFlags["low_memory"] || configFlags["low_memory"] {
+		return *lowMemory
+	}
+	total := totalMemory()
+	if total > 0 && total < lowMemoryThreshold {
+		log.Printf("Only %s of RAM detected; using the low-memory profile", humanize.IBytes(total))
+		return true
+	}
+	return false
+}
+
+// applyLowMemory makes the garbage collector more aggressive so the heap
+// stays close to the live set, and shrinks the upload buffer, concurrency
+// and queue in opts where they were left at their defaults. A limit set
+// explicitly is kept.
+func applyLowMemory(opts *uploader.Options) {
+	if prev := debug.SetGCPercent(lowMemoryGCPercent); savedGCPercent == 0 {
+		savedGCPercent = prev
+	}
+	if opts.ChunkSize == 0 {
+		opts.ChunkSize = lowMemoryChunkSize
+		opts.AdaptiveChunkSize = false
+	}
+	if opts.MaxConcurrentUploads == 0 {
+		opts.MaxConcurrentUploads = lowMemoryConcurrency
+	}
+	if opts.MaxQueuedFiles == 0 {
+		opts.MaxQueuedFiles = lowMemoryQueuedFiles
+	}
+}
+
+// restoreGCPercent undoes applyLowMemory's garbage collector target, for a
+// reload that turns the profile off.
+func restoreGCPercent() {
+	if savedGCPercent != 0 {
+		debug.SetGCPercent(savedGCPercent)
+		savedGCPercent = 0
+	}
+}
+
+// totalMemory returns the total RAM in bytes, or 0 if it can't be determined.
+func totalMemory() uint64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kb << 10
+		}
+	}
+	return 0
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/gdrive"
+	"github.com/dustin/go-humanize"
+)
+
+// ls lists the contents of the --output_dir folder, or of a folder beneath
+// it.
+func ls(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("ls", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ls [path]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	service, err := flagPair().driveConfig().Service(ctx)
+	if err != nil {
+		return err
+	}
+	folderId, err := gdrive.GetFolderId(service, outputFolder())
+	if err != nil {
+		return err
+	}
+	if folderId, err = gdrive.ResolvePath(ctx, service, folderId, fs.Arg(0)); err != nil {
+		return err
+	}
+	files, err := gdrive.ListChildren(ctx, service, folderId)
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if gdrive.IsFolder(files[i]) != gdrive.IsFolder(files[j]) {
+			return gdrive.IsFolder(files[i])
+		}
+		return files[i].Name < files[j].Name
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSIZE\tMODIFIED\tID")
+	for _, f := range files {
+		name, size := f.Name, humanize.Bytes(uint64(f.Size))
+		if gdrive.IsFolder(f) {
+			name, size = name+"/", "-"
+		}
+		modified := f.ModifiedTime
+		if t, err := time.Parse(time.RFC3339, f.ModifiedTime); err == nil {
+			modified = t.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, size, modified, f.Id)
+	}
+	return w.Flush()
+}
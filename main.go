@@ -2,34 +2,813 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/dknowles2/gdrive_sync/dedup"
+	"github.com/dknowles2/gdrive_sync/events"
 	"github.com/dknowles2/gdrive_sync/gdrive"
+	"github.com/dknowles2/gdrive_sync/journal"
+	"github.com/dknowles2/gdrive_sync/logging"
+	"github.com/dknowles2/gdrive_sync/manifest"
+	"github.com/dknowles2/gdrive_sync/notify"
+	"github.com/dknowles2/gdrive_sync/photos"
 	"github.com/dknowles2/gdrive_sync/uploader"
+	"github.com/dustin/go-humanize"
+	"google.golang.org/api/drive/v3"
 )
 
 var (
-	inputDir        = flag.String("input_dir", "/share/Scans", "Directory to watch for new files to upload")
-	outputDir       = flag.String("output_dir", "Incoming Scans", "Drive folder where files should be uploaded")
-	credsFile       = flag.String("creds_file", "/data/credentials.json", "credentials.json file")
-	uploadOnStartup = flag.Bool("upload_on_startup", true, "When true, upload files in --input_dir on startup")
+	inputDir          = flag.String("input_dir", "/share/Scans", "Directory to watch for new files to upload")
+	outputDir         = flag.String("output_dir", "Incoming Scans", "Drive folder where files should be uploaded: a name, a path from the root of My Drive such as \"Household/Scans\", or a folder URL")
+	dryRun            = flag.Bool("dry_run", false, "Log what would be uploaded, replaced, deleted or archived, after the usual stability, routing and duplicate checks, without writing to Drive or touching local files")
+	createOutputDir   = flag.Bool("create_output_dir", false, "Create --output_dir in Drive if it doesn't exist, including the parents of a path like \"Scans/Incoming/2024\"")
+	outputFolderId    = flag.String("output_folder_id", "", "ID of the Drive folder to upload to, instead of finding --output_dir by name; a folder URL also works as --output_dir")
+	credsFile         = flag.String("creds_file", "/data/credentials.json", "credentials.json file (\"-\" to read it from stdin, or set $GDRIVE_SYNC_CREDENTIALS)")
+	tokenFile         = flag.String("token_file", "/data/token.json", "Path to the token.json cache (\"-\" to read it from stdin, or set $GDRIVE_SYNC_TOKEN)")
+	sharedDriveId     = flag.String("shared_drive_id", "", "ID of the shared drive (Team Drive) --output_dir is in; folder searches are confined to it. Other accounts and destinations set their own with \";shared_drive=id\"")
+	uploadOnStartup   = flag.Bool("upload_on_startup", true, "When true, upload files in --input_dir on startup")
+	deleteAfterUpload = flag.Bool("delete", true, "Delete files once they are uploaded; when false they are kept (and re-uploaded on startup unless --upload_on_startup=false)")
+	remotePathTmpl    = flag.String("remote_path_template", "", "Go template for the path beneath --output_dir each file is uploaded to, e.g. \"{{.Year}}/{{.Month}}/{{.Name}}\"; fields are Name, Stem, Ext, Dir, Year, Month, Day and Time (the file's modification time). Folders are created as needed")
+	routes            = flag.String("routes", "", "Comma-separated pattern=folder rules sending matching files to other Drive folders, e.g. \"invoice_*.pdf=Finance/Invoices,photo_*.jpg=Photos/Scans\"; patterns are globs or, prefixed with re:, regular expressions, and the first match wins. Append \";convert\" to a folder to import matching files as Google Docs, or \";ocr=LANG\" to do so with OCR in that language (the folder may then be empty), or give no folder and \";photos\" to send matching files to --photos_album")
+	compress          = flag.String("compress", "", "Comma-separated pattern=format rules compressing matching files before upload, e.g. \"*.tif=gzip\"; format is gzip or zip, patterns are as for --routes, and the first match wins")
+	archiveDir        = flag.String("archive_dir", "", "Move files here once they are uploaded instead of deleting them")
+	archiveLayout     = flag.String("archive_layout", "", "With --archive_dir, a Go time layout naming a dated subfolder to archive into, e.g. \"2006/01\"")
+	ignore            = flag.String("ignore", "", "Comma-separated globs of file names never to upload, e.g. \"*.tmp,Thumbs.db\"")
+	include           = listVar("include", "Only upload files matching this glob, or regular expression prefixed with re:; may be repeated. Globs without a slash match the file name, others the path relative to --input_dir")
+	exclude           = listVar("exclude", "Never upload files matching this glob or re: regular expression, as for --include; may be repeated")
+	recursive         = flag.Bool("recursive", false, "Also watch subdirectories of --input_dir")
+	maxDepth          = flag.Int("max_depth", 0, "With --recursive, the deepest subdirectory level to watch (0 for unlimited)")
+	excludeDirs       = flag.String("exclude_dirs", "@eaDir", "With --recursive, comma-separated globs of directory names or relative paths to skip")
+	flatten           = flag.Bool("flatten", false, "With --recursive, upload files from subdirectories straight into --output_dir instead of matching subfolders")
+	mirror            = flag.Bool("mirror", false, "Recreate the directory tree under --input_dir as folders under --output_dir, including empty directories; implies --recursive")
+	debounce          = flag.Duration("debounce", 2*time.Second, "How long a file must go without change events before it is queued for upload, coalescing the many writes scanners make")
+	pollInterval      = flag.Duration("poll_interval", 0, "Find new files by rescanning --input_dir this often instead of relying on change notifications, for SMB/NFS shares where they never fire (0 disables)")
+	chunkSize         = flag.String("chunk_size", "", "Resumable upload chunk size, e.g. 8MiB, which is also the per-upload memory buffer (default 16MiB, 1MiB with --low_memory); \"auto\" tunes it from measured throughput, buffering up to 128MiB per upload")
+	maxUploads        = flag.Int("max_concurrent_uploads", 0, "Maximum number of files uploaded at once (0 for unlimited, 1 with --low_memory)")
+	maxQueued         = flag.Int("max_queued_files", 0, "Maximum number of files waiting to stabilize or upload at once; more are kept by name only until there is room (0 for unlimited, 100 with --low_memory)")
+	lowMemory         = flag.Bool("low_memory", false, "Use small buffers and serial uploads for small devices; enabled automatically with under 1GiB of RAM")
+	inactivityAlert   = flag.Duration("inactivity_alert", 0, "Log an alert when no files have been uploaded for this long, on pairs that saw uploads on 5 of the last 7 days (0 disables)")
+	photosAlbum       = flag.String("photos_album", "", "Google Photos album for image files; empty disables the Photos destination")
+	photosPatterns    = flag.String("photos_patterns", "*.jpg,*.jpeg,*.png,*.gif,*.heic,*.webp", "Comma-separated globs of files, matching no --routes, sent to --photos_album instead of Drive; empty to only send those routed there")
+	ocrCommand        = flag.String("ocr_command", "", "Command run on each file whose output is attached as Drive indexable text, e.g. \"tesseract {} -\"")
+	thumbPatterns     = flag.String("thumbnail_patterns", "", "Comma-separated globs of files Drive can't preview that get a generated thumbnail, e.g. \"*.tif,*.bmp\"")
+	thumbCommand      = flag.String("thumbnail_command", "", "Command that writes a PNG or JPEG preview of the file {} to stdout, for formats that can't be decoded directly")
+	failedDir         = flag.String("failed_dir", "", "Move files here once they have failed to upload --max_failures times, quarantining them instead of retrying them forever")
+	maxFailures       = flag.Int("max_failures", uploader.DefaultMaxFailures, "How many failed uploads of a file in a row to allow, retrying with a backoff in between, before quarantining it: moving it to --failed_dir (or, with --journal_file, marking it abandoned). See the quarantine command")
+	maxAttempts       = flag.Int("max_attempts", uploader.DefaultMaxAttempts, "How many times to try an upload failing with rate limiting or transient Drive errors, with exponential backoff, before parking it to try again later")
+	progressInterval  = flag.Duration("progress_interval", uploader.DefaultProgressInterval, "How often to log an upload's progress, with its rate and time left, unless --progress_percent more of the file was sent sooner")
+	progressPercent   = flag.Float64("progress_percent", uploader.DefaultProgressPercent, "Also log an upload's progress whenever another this many percent of the file has been sent")
+	stallTimeout      = flag.Duration("stall_timeout", 10*time.Minute, "Abort an upload that sends nothing for this long, to be tried again later like any failed upload (0 disables)")
+	uploadTimeout     = flag.Duration("upload_timeout", 0, "Abort an upload still not done after this long, transient retries included, to be tried again later like any failed upload (0 disables)")
+	onDuplicate       = flag.String("on_duplicate", uploader.DuplicateSkip, "When the Drive folder already has a file of the same name but different contents: skip (upload alongside it), rename, overwrite, or version (overwrite, keeping the old contents as a revision). Identical copies are never uploaded again")
+	shareLink         = flag.Bool("share_link", false, "Let anyone with the link view each uploaded file, and include the link in the log, notifications and journal")
+	shareWith         = flag.String("share_with", "", "Comma-separated email addresses to share each uploaded file with")
+	shareRole         = flag.String("share_role", "reader", "Access given by --share_link and --share_with: reader, commenter or writer")
+	shareNotify       = flag.Bool("share_notify", false, "Have Drive email the --share_with addresses when a file is shared with them")
+	sessionsFile      = flag.String("sessions_file", "", "Save resumable upload sessions in this file so large uploads continue after a restart instead of starting over")
+	offlineFile       = flag.String("offline_queue_file", "", "Save the uploads waiting for the network to come back in this file, so they are still retried after a restart")
+	journalFile       = flag.String("journal_file", "", "Record every upload attempt in this file, and don't upload again files it shows were already uploaded")
+	manifestFile      = flag.String("manifest_file", "", "Append a record of every upload to this file, for use by verify")
+	runAs             = flag.String("run_as", "", "When started as root, switch to this user[:group] after opening the watch directory and state files")
+	sandbox           = flag.Bool("sandbox", false, "Linux only: restrict filesystem and network access to what the daemon needs using Landlock")
+	sandboxPaths      = flag.String("sandbox_paths", "", "Comma-separated extra paths the sandboxed daemon may write to")
+	notifyCommand     = flag.String("notify_command", "", "Shell command run for each upload, failure and inactivity notification; the message is on stdin and in $NOTIFY_TEXT")
+	webhookURL        = flag.String("webhook_url", "", "POST a JSON payload (event, file, name, size, drive_file_id, web_link, error) to this URL whenever a file is uploaded or fails")
+	slackWebhookURL   = flag.String("slack_webhook_url", "", "Post notifications to this Slack incoming webhook, with a link to each uploaded file")
+	slackEvents       = flag.String("slack_events", "uploaded,failed", "Comma-separated notification types posted to --slack_webhook_url: uploaded, failed, inactive, paused, offline, backlog_cleared")
+	discordWebhookURL = flag.String("discord_webhook_url", "", "Post notifications to this Discord webhook, with a link to each uploaded file")
+	discordEvents     = flag.String("discord_events", "uploaded,failed", "Comma-separated notification types posted to --discord_webhook_url, as for --slack_events")
+	notifyTemplates   = flag.String("notify_templates", "", "File of Go templates overriding notification messages; define \"uploaded\", \"failed\", \"inactive\", \"paused\", \"offline\" or \"backlog_cleared\"")
+	desktopNotify     = flag.Bool("desktop_notifications", false, "Show a desktop notification for every upload and failure, for workstation installs")
+	logFormat         = flag.String("log_format", "text", "Log format: text (logfmt-style key=value) or json")
+	logFile           = flag.String("log_file", "", "Append the log to this file instead of writing it to stderr")
+	logLevel          = flag.String("log_level", "info", "Least severe level to log: debug, info, warn or error")
+	dedupWindow       = flag.Duration("dedup_window", 5*time.Minute, "Collapse repeated log lines and failure notifications within this window into one summary (0 disables)")
+	monthlyCap        = flag.String("monthly_cap", "", "Pause uploads once this much has been uploaded in a calendar month, e.g. 20GiB; empty for no cap")
+	transferState     = flag.String("transfer_state_file", "/data/transfer.json", "Where --monthly_cap usage is kept across restarts")
+	uploadWindows     = listVar("upload_window", "Only start uploads within this time of the week, in local time, like 22:00-06:00 or \"Mon-Fri 09:00-17:30\"; may be repeated. Files found outside every window are queued until one opens")
+	quietHours        = listVar("quiet_hours", "Never start uploads within this time of the week, given as for --upload_window, e.g. for video calls; may be repeated")
+	triggerSuffix     = flag.String("trigger_suffix", "", "Only upload a file once a companion file with this suffix appears, e.g. \".ready\" for document.pdf.ready")
+	mountRoot         = flag.String("mount_root", "", "Linux only: also upload from removable media and network shares mounted beneath this directory, e.g. /media")
+	eventsFile        = flag.String("events_file", "", "Append newline-delimited JSON pipeline events to this file (\"-\" for stdout)")
+	eventsFormat      = flag.String("events", "", "Stream pipeline events on stdout for wrapper scripts, logs staying on stderr: jsonl writes one JSON object per event (discovered, waiting, uploading, progress, uploaded, failed, deleted, ...)")
 )
 
+// listFlag is the value of a flag that may be given more than once. An
+// empty value clears it.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(s string) error {
+	if s == "" {
+		*l = nil
+		return nil
+	}
+	*l = append(*l, s)
+	return nil
+}
+
+// listVar defines a flag that collects every value it is given.
+func listVar(name, usage string) *listFlag {
+	l := &listFlag{}
+	flag.Var(l, name, usage)
+	return l
+}
+
+// logger formats everything logged, as set up by --log_format and
+// --log_level.
+var logger *logging.Logger
+
+// commands are the subcommands that may be given after the flags. With no
+// subcommand the uploader daemon runs, as with "run".
+var commands = map[string]func(ctx context.Context, args []string) error{
+	"auth":       auth,
+	"bench":      bench,
+	"doctor":     doctor,
+	"download":   download,
+	"fsck":       fsck,
+	"history":    history,
+	"ls":         ls,
+	"purge":      purge,
+	"quarantine": quarantine,
+	"restore":    restore,
+	"reupload":   reupload,
+	"run":        run,
+	"service":    service,
+	"status":     status,
+	"upload":     upload,
+	"verify":     verify,
+}
+
 func main() {
+	flag.Usage = usage
 	flag.Parse()
+	flag.Visit(func(f *flag.Flag) { cmdlineFlags[f.Name] = true })
+	if *configDir != "" {
+		var err error
+		if configSnapshot, err = applyConfigDir(*configDir); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *stateDir != "" {
+		applyStateDir(*stateDir)
+	}
 	ctx := context.Background()
 
-	service, err := gdrive.New(ctx, *credsFile)
+	name, args := "run", flag.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logOut, err := startView(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if view != nil {
+		defer view.Close()
+	}
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		logOut = f
+	}
+	if logger, err = logging.New(logOut, *logFormat, level); err != nil {
+		log.Fatal(err)
+	}
+	log.SetFlags(0)
+	if *dedupWindow > 0 {
+		w := dedup.NewWriter(logger, *dedupWindow)
+		defer w.Close()
+		log.SetOutput(w)
+		uploader.SetLogger(w)
+	} else {
+		log.SetOutput(logger)
+		uploader.SetLogger(logger)
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		log.Fatalf("Unknown command: %s", name)
+	}
+	if err := cmd(ctx, args); err != nil {
+		log.Fatalf("%s failed: %s", name, err)
+	}
+}
+
+// run implements the "run" command, the uploader daemon. It watches for
+// files and uploads them until it is stopped by a signal, reloading when
+// --config_dir changes.
+func run(ctx context.Context, args []string) (err error) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		return errors.New("usage: run")
+	}
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	// Under the Windows service manager, stop when it says to.
+	stopped, err := serviceControl(stop)
+	if err != nil {
+		return err
+	}
+	defer func() { stopped(err) }()
+
+	p := &probes{}
+	addrs := []string{*probeAddr}
+	if *httpAddr != *probeAddr {
+		addrs = append(addrs, *httpAddr)
+	}
+	for _, addr := range addrs {
+		if addr == "" {
+			continue
+		}
+		if err := p.serve(addr); err != nil {
+			return err
+		}
+	}
+	// Stop gracefully on SIGTERM, which is what a Kubernetes pod or a
+	// service manager sends, and on Ctrl-C.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(sigs)
+	go func() {
+		select {
+		case s := <-sigs:
+			log.Printf("Received %s; shutting down", s)
+			p.stop()
+			stop()
+		case <-ctx.Done():
+		}
+	}()
+
+	snapshot := configSnapshot
+	for {
+		runCtx, cancel := context.WithCancel(ctx)
+		reload := make(chan struct{})
+		if *configDir != "" {
+			go watchConfigDir(runCtx, *configDir, snapshot, func() {
+				close(reload)
+				cancel()
+			})
+		}
+		var err error
+		run := func(ctx context.Context) error { return runDaemon(ctx, p) }
+		switch {
+		case *leaderLease != "":
+			err = lead(runCtx, p, run)
+		case *haLock != "":
+			err = leadDrive(runCtx, p, run)
+		default:
+			err = run(runCtx)
+		}
+		cancel()
+		select {
+		case <-reload:
+			if snapshot, err = applyConfigDir(*configDir); err != nil {
+				return err
+			}
+			if *stateDir != "" {
+				applyStateDir(*stateDir)
+			}
+			continue
+		default:
+		}
+		if ctx.Err() != nil {
+			log.Printf("Stopped")
+			return nil
+		}
+		return err
+	}
+}
+
+// configSnapshot is the --config_dir contents applied at startup.
+var configSnapshot []byte
+
+// privilegesDropped and sandboxed record what runDaemon has already done to
+// the process, since neither can be undone for a reload. lockedPaths are the
+// paths the daemon wrote to when it was done.
+var (
+	privilegesDropped, sandboxed bool
+	lockedPaths                  []string
+)
+
+// runDaemon creates the Uploaders from the flags and runs them until ctx is
+// done or one of them fails. Once privileges are dropped or the sandbox is
+// enabled, a reload that changes the paths written to is refused, since
+// they may no longer be accessible.
+func runDaemon(ctx context.Context, p *probes) error {
+	paths := sandboxWritablePaths()
+	sort.Strings(paths)
+	if (privilegesDropped || sandboxed) && strings.Join(paths, "\x00") != strings.Join(lockedPaths, "\x00") {
+		return errors.New("--config_dir changed the paths the daemon writes to, which needs a restart with --run_as or --sandbox")
+	}
+	us, cleanup, err := newUploaders(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create Uploader: %w", err)
+	}
+	defer cleanup()
+	pairs, err := syncPairs()
+	if err != nil {
+		return err
+	}
+	dl, err := newDownloader(ctx, pairs)
+	if err != nil {
+		return fmt.Errorf("failed to create Downloader: %w", err)
+	}
+	if *runAs != "" && !privilegesDropped {
+		if err := dropPrivileges(*runAs); err != nil {
+			return fmt.Errorf("failed to drop privileges: %w", err)
+		}
+		privilegesDropped = true
+		lockedPaths = paths
+	}
+	if *sandbox && !sandboxed {
+		if err := enableSandbox(paths, sandboxPorts()); err != nil {
+			return fmt.Errorf("failed to enable sandbox: %w", err)
+		}
+		sandboxed = true
+		lockedPaths = paths
+	}
+	p.set(us, false)
+	defer p.set(nil, false)
+	p.setDownloader(dl)
+	defer p.setDownloader(nil)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, len(us)+1)
+	for _, u := range us {
+		go func(u *uploader.Uploader) {
+			errs <- u.Run(ctx)
+		}(u)
+	}
+	running := len(us)
+	if dl != nil {
+		go func() { errs <- dl.Run(ctx) }()
+		running++
+	}
+	var first error
+	for i := 0; i < running; i++ {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+			cancel()
+		}
+	}
+	return first
+}
+
+// newUploaders builds an Uploader for each sync pair from the command-line
+// flags and --config. The returned cleanup function closes the uploaders and
+// any files they write to.
+func newUploaders(ctx context.Context) ([]*uploader.Uploader, func(), error) {
+	var closers []func() error
+	cleanup := func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i]()
+		}
+	}
+	pairs, err := syncPairs()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scopes := daemonScopes()
+	opts := uploader.Options{
+		InactivityAlert:  *inactivityAlert,
+		OCRCommand:       strings.Fields(*ocrCommand),
+		ThumbnailCommand: strings.Fields(*thumbCommand),
+		MountRoot:        *mountRoot,
+		DrainTimeout:     *drainTimeout,
+		TriggerSuffix:    *triggerSuffix,
+		ShareLink:        *shareLink,
+		ShareRole:        *shareRole,
+		ShareNotify:      *shareNotify,
+		PreUploadCommand: *preUploadCommand,
+		PreUploadPolicy:  *preUploadPolicy,
+		SuccessCommand:   *successCommand,
+		FailureCommand:   *failureCommand,
+		HookTimeout:      *hookTimeout,
+	}
+	switch *chunkSize {
+	case "auto":
+		opts.AdaptiveChunkSize = true
+	case "":
+	default:
+		n, err := humanize.ParseBytes(*chunkSize)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid --chunk_size: %w", err)
+		}
+		opts.ChunkSize = int(n)
+	}
+	if *monthlyCap != "" {
+		n, err := humanize.ParseBytes(*monthlyCap)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid --monthly_cap: %w", err)
+		}
+		// The cap is shared by every pair.
+		if opts.Budget, err = uploader.NewBudget(int64(n), *transferState); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(*uploadWindows) > 0 || len(*quietHours) > 0 {
+		if opts.Schedule, err = uploader.NewSchedule(*uploadWindows, *quietHours); err != nil {
+			return nil, nil, fmt.Errorf("invalid --upload_window or --quiet_hours: %w", err)
+		}
+	}
+	if opts.Accounts, err = extraAccounts(ctx); err != nil {
+		return nil, nil, err
+	}
+	opts.AccountPolicy = *accountPolicy
+	opts.OnDuplicate = *onDuplicate
+	opts.CreateOutputDir = *createOutputDir
+	opts.DryRun = *dryRun
+	opts.Debounce = *debounce
+	if opts.Stability, err = stabilityFromFlags(); err != nil {
+		return nil, nil, err
+	}
+	opts.StabilityTimeout = *stabilityTimeout
+	opts.RemotePathTemplate = *remotePathTmpl
+	opts.MaxAttempts = *maxAttempts
+	opts.StallTimeout = *stallTimeout
+	opts.ProgressInterval = *progressInterval
+	opts.ProgressPercent = *progressPercent
+	opts.UploadTimeout = *uploadTimeout
+	opts.FailedDir = *failedDir
+	opts.MaxFailures = *maxFailures
+	opts.AccountFillThreshold = *accountFillThreshold
+	if *maxUploads > 0 {
+		opts.MaxConcurrentUploads = *maxUploads
+	}
+	if *maxQueued > 0 {
+		opts.MaxQueuedFiles = *maxQueued
+	}
+	if useLowMemory() {
+		applyLowMemory(&opts)
+	} else {
+		restoreGCPercent()
+	}
+	if *shareWith != "" {
+		opts.ShareWith = strings.Split(*shareWith, ",")
+	}
+	if opts.Backends, err = backends(ctx); err != nil {
+		return nil, nil, err
+	}
+	if *thumbPatterns != "" {
+		opts.ThumbnailPatterns = strings.Split(*thumbPatterns, ",")
+	}
+	if *manifestFile != "" {
+		if opts.Manifest, err = manifest.Open(*manifestFile); err != nil {
+			return nil, nil, fmt.Errorf("failed to open manifest: %w", err)
+		}
+		closers = append(closers, opts.Manifest.Close)
+	}
+	if *sessionsFile != "" {
+		if opts.Sessions, err = uploader.NewSessions(*sessionsFile); err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+	}
+	if *offlineFile != "" {
+		if opts.OfflineQueue, err = uploader.NewOfflineQueue(*offlineFile); err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+	}
+	if *journalFile != "" {
+		if opts.Journal, err = journal.Open(*journalFile); err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("failed to open journal: %w", err)
+		}
+		closers = append(closers, opts.Journal.Close)
+	}
+	sinks := []events.Sink{logging.NewEventLogger(logger)}
+	switch *eventsFormat {
+	case "":
+	case "jsonl":
+		if *eventsFile != "-" {
+			sinks = append(sinks, events.NewWriter(os.Stdout))
+		}
+	default:
+		cleanup()
+		return nil, nil, fmt.Errorf("unknown --events format %q, want jsonl", *eventsFormat)
+	}
+	switch *eventsFile {
+	case "":
+	case "-":
+		sinks = append(sinks, events.NewWriter(os.Stdout))
+	default:
+		f, err := os.OpenFile(*eventsFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("failed to open events file: %w", err)
+		}
+		closers = append(closers, f.Close)
+		sinks = append(sinks, events.NewWriter(f))
+	}
+	var notifiers []notify.Notifier
+	if *notifyCommand != "" {
+		notifiers = append(notifiers, &notify.Command{Command: *notifyCommand})
+	}
+	if *webhookURL != "" {
+		notifiers = append(notifiers, &notify.Webhook{URL: *webhookURL})
+	}
+	for _, c := range []struct{ service, url, events string }{
+		{notify.Slack, *slackWebhookURL, *slackEvents},
+		{notify.Discord, *discordWebhookURL, *discordEvents},
+	} {
+		if c.url == "" {
+			continue
+		}
+		types, err := notificationTypes(c.events)
+		if err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("invalid --%s_events: %w", c.service, err)
+		}
+		notifiers = append(notifiers, &notify.Chat{Service: c.service, URL: c.url, Events: types})
+	}
+	email, digestAt, err := emailNotifier()
 	if err != nil {
-		log.Fatalf("Failed to create drive service: %s", err)
+		cleanup()
+		return nil, nil, err
+	}
+	if email != nil {
+		notifiers = append(notifiers, email)
+		if digestAt >= 0 {
+			stop := make(chan struct{})
+			go email.RunDigest(digestAt, stop)
+			closers = append(closers, func() error {
+				close(stop)
+				return nil
+			})
+		}
 	}
-	u, err := uploader.New(*inputDir, *outputDir, service)
+	if len(notifiers) > 0 || *desktopNotify {
+		tmpl, err := notify.ParseTemplates(*notifyTemplates)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		if len(notifiers) > 0 {
+			d := notify.New(tmpl, *dedupWindow, notifiers...)
+			closers = append(closers, d.Close)
+			sinks = append(sinks, d)
+		}
+		// Desktop notifications get their own queue so a slow remote
+		// channel never delays them.
+		if *desktopNotify {
+			d := notify.New(tmpl, *dedupWindow, notify.Desktop{})
+			closers = append(closers, d.Close)
+			sinks = append(sinks, d)
+		}
+	}
+	mp, err := mqttPublisher()
 	if err != nil {
-		log.Fatalf("Failed to create Uploader: %v", err)
+		cleanup()
+		return nil, nil, err
+	}
+	if mp != nil {
+		closers = append(closers, mp.Close)
+		sinks = append(sinks, mp)
+	}
+	if view != nil {
+		sinks = append(sinks, view)
+	}
+	if len(sinks) > 0 {
+		opts.Events = events.Multi(sinks...)
+	}
+
+	var us []*uploader.Uploader
+	for i, p := range pairs {
+		if i > 0 {
+			// Mounted media is uploaded once, by the first pair.
+			opts.MountRoot = ""
+		}
+		u, err := newPairUploader(ctx, p, opts, scopes)
+		if err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("%s: %w", p.InputDir, err)
+		}
+		closers = append(closers, func() error {
+			u.Close()
+			return nil
+		})
+		us = append(us, u)
+	}
+	watchUploaders(us)
+	closers = append(closers, func() error {
+		watchUploaders(nil)
+		return nil
+	})
+	return us, cleanup, nil
+}
+
+// newPairUploader builds the Uploader for one sync pair from the options
+// shared by all of them.
+func newPairUploader(ctx context.Context, p syncPair, opts uploader.Options, scopes []string) (*uploader.Uploader, error) {
+	var service *gdrive.Drive
+	var err error
+	if *useDrive {
+		if service, err = p.driveConfig(scopes...).Service(ctx); err != nil {
+			return nil, fmt.Errorf("failed to create drive service: %w", err)
+		}
+	}
+	opts.Recursive = *p.Recursive
+	opts.MaxDepth = *p.MaxDepth
+	opts.Flatten = *p.Flatten
+	opts.Mirror = *p.Mirror
+	opts.ExcludeDirs = p.ExcludeDirs
+	opts.IgnorePatterns = p.Ignore
+	opts.Include = p.Include
+	opts.Exclude = p.Exclude
+	opts.KeepFiles = !*p.Delete
+	opts.SkipInitialUpload = !*p.UploadOnStartup
+	opts.PollInterval = *p.PollInterval
+	opts.ArchiveDir = p.ArchiveDir
+	if opts.Routes, err = parseRoutes(p.Routes); err != nil {
+		return nil, err
+	}
+	if opts.Compress, err = parseCompress(p.Compress); err != nil {
+		return nil, err
+	}
+	opts.Permissions = permissions(p.Permissions)
+	if opts.Destinations, err = parseDestinations(ctx, p); err != nil {
+		return nil, err
+	}
+	opts.ArchiveLayout = *archiveLayout
+	if *photosAlbum != "" {
+		hc, err := p.driveConfig(scopes...).Client(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Photos client: %w", err)
+		}
+		if opts.Photos, err = photos.New(ctx, hc, *photosAlbum); err != nil {
+			return nil, fmt.Errorf("failed to find Photos album: %w", err)
+		}
+		opts.PhotosPatterns = strings.Split(*photosPatterns, ",")
+	}
+	return uploader.New(p.InputDir, p.OutputDir, service, opts)
+}
+
+// daemonScopes returns the OAuth scopes the daemon needs beyond Drive's.
+func daemonScopes() []string {
+	var scopes []string
+	if *photosAlbum != "" {
+		scopes = append(scopes, photos.Scopes...)
 	}
-	defer u.Close()
-	if err := u.Run(ctx); err != nil {
-		log.Fatalf("Run failed: %s", err)
+	if *haLock != "" {
+		// So a new token from the web flow also covers the lock.
+		scopes = append(scopes, drive.DriveAppdataScope)
+	}
+	return scopes
+}
+
+// notificationTypes parses a comma-separated list of event types that have
+// notification templates.
+func notificationTypes(s string) ([]events.Type, error) {
+	var types []events.Type
+	for _, name := range strings.Split(s, ",") {
+		t := events.Type(strings.TrimSpace(name))
+		switch t {
+		case events.Uploaded, events.Failed, events.Inactive, events.Paused, events.Offline, events.BacklogCleared:
+			types = append(types, t)
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown notification type %q", name)
+		}
+	}
+	if len(types) == 0 {
+		return nil, errors.New("no notification types")
+	}
+	return types, nil
+}
+
+// sandboxPorts returns the TCP ports the daemon connects to: HTTPS for the
+// Google APIs, and those of the MQTT broker, SMTP server, webhooks and
+// backends.
+func sandboxPorts() []uint64 {
+	ports := []uint64{443}
+	seen := map[uint64]bool{443: true}
+	for _, p := range append(append([]uint64{mqttPort(), smtpPort()}, webhookPorts()...), backendPorts()...) {
+		if p != 0 && !seen[p] {
+			seen[p] = true
+			ports = append(ports, p)
+		}
+	}
+	return ports
+}
+
+// webhookPorts returns the ports of the webhook URLs notifications are
+// posted to.
+func webhookPorts() []uint64 {
+	var ports []uint64
+	for _, s := range []string{*webhookURL, *slackWebhookURL, *discordWebhookURL} {
+		if s == "" {
+			continue
+		}
+		if u, err := url.Parse(s); err == nil {
+			ports = append(ports, urlPort(u.Scheme, u.Host))
+		}
+	}
+	return ports
+}
+
+// sandboxWritablePaths returns the paths the daemon writes to while running.
+func sandboxWritablePaths() []string {
+	var paths []string
+	pairs, err := syncPairs()
+	if err != nil {
+		pairs = []syncPair{flagPair()}
+	}
+	for _, p := range pairs {
+		paths = append(paths, p.InputDir)
+		if p.ArchiveDir != "" {
+			paths = append(paths, p.ArchiveDir)
+		}
+	}
+	if *mountRoot != "" {
+		paths = append(paths, *mountRoot)
+	}
+	if *failedDir != "" {
+		paths = append(paths, *failedDir)
+	}
+	// Files are compressed into temporary files, and hooks' output is
+	// collected in them.
+	temp := *preUploadCommand != "" || *successCommand != "" || *failureCommand != ""
+	for _, p := range pairs {
+		temp = temp || len(p.Compress) > 0
+	}
+	if temp {
+		paths = append(paths, os.TempDir())
+	}
+	state := ""
+	if *monthlyCap != "" {
+		state = *transferState
+	}
+	if *downloadDir != "" {
+		paths = append(paths, *downloadDir)
+	}
+	for _, f := range []string{*manifestFile, *journalFile, *eventsFile} {
+		if f != "" && f != "-" {
+			paths = append(paths, f)
+		}
+	}
+	// State files are replaced by renaming a new copy over them, which
+	// needs their directories.
+	for _, f := range []string{*sessionsFile, *offlineFile, *downloadState, state} {
+		if f != "" {
+			paths = append(paths, filepath.Dir(f))
+		}
+	}
+	if *sandboxPaths != "" {
+		paths = append(paths, strings.Split(*sandboxPaths, ",")...)
+	}
+	return paths
+}
+
+// Fault injection for exercising retry, alerting and quarantine setups. These
+// flags are deliberately left out of --help; see hiddenFlag.
+var (
+	chaosRate     = flag.Float64("chaos", 0, "Probability (0-1) of injecting a fault into each Drive API request")
+	chaosMaxDelay = flag.Duration("chaos_max_delay", 30*time.Second, "Longest delay injected for slow-transfer faults")
+)
+
+// hiddenFlag reports whether the named flag should be omitted from usage
+// output.
+func hiddenFlag(name string) bool {
+	return strings.HasPrefix(name, "chaos")
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s: [flags] [command [command flags]]\n", os.Args[0])
+	var names []string
+	for name := range commands {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	fmt.Fprintf(flag.CommandLine.Output(), "Commands: %s (default run)\n", strings.Join(names, ", "))
+	flag.VisitAll(func(f *flag.Flag) {
+		if hiddenFlag(f.Name) {
+			return
+		}
+		fmt.Fprintf(flag.CommandLine.Output(), "  -%s\n    \t%s (default %q)\n", f.Name, f.Usage, f.DefValue)
+	})
 }
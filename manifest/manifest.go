@@ -0,0 +1,97 @@
+// Package manifest records every successful upload in an append-only file of
+// newline-delimited JSON, so uploads can later be verified or exported.
+package manifest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Record describes one uploaded file.
+type Record struct {
+	Time        time.Time `json:"time"`
+	Path        string    `json:"path"`
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	MD5         string    `json:"md5"`
+	SHA256      string    `json:"sha256,omitempty"`
+	DriveFileId string    `json:"drive_file_id"`
+	FolderId    string    `json:"folder_id"`
+	// Account is the name of the account holding the file when uploads are
+	// spread across several; empty means the default account.
+	Account string `json:"account,omitempty"`
+	// OriginalMD5 is the checksum of the local file when it was compressed
+	// before upload, MD5 then being that of the compressed copy.
+	OriginalMD5 string `json:"original_md5,omitempty"`
+}
+
+// Manifest appends records to a manifest file.
+type Manifest struct {
+	mu   sync.Mutex
+	f    *os.File
+	path string
+}
+
+// Open opens the manifest at path for appending, creating it if needed.
+func Open(path string) (*Manifest, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open manifest: %w", err)
+	}
+	return &Manifest{f: f, path: path}, nil
+}
+
+func (m *Manifest) Path() string {
+	return m.path
+}
+
+// Append writes r to the manifest and syncs it to disk.
+func (m *Manifest) Append(r Record) error {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("unable to write manifest: %w", err)
+	}
+	return m.f.Sync()
+}
+
+func (m *Manifest) Close() error {
+	return m.f.Close()
+}
+
+// Load reads every record in the manifest at path, oldest first.
+func Load(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open manifest: %w", err)
+	}
+	defer f.Close()
+	var records []Record
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64<<10), 1<<20)
+	for line := 1; s.Scan(); line++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		records = append(records, r)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read manifest: %w", err)
+	}
+	return records, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/dknowles2/gdrive_sync/mqtt"
+)
+
+var (
+	mqttBroker    = flag.String("mqtt_broker", "", "Publish events and health to this MQTT broker, e.g. tcp://homeassistant.local:1883 or mqtts://broker:8883")
+	mqttUsername  = flag.String("mqtt_username", "", "User name to log in to --mqtt_broker with")
+	mqttPassword  = flag.String("mqtt_password", "", "Password to log in to --mqtt_broker with")
+	mqttTopic     = flag.String("mqtt_topic", "gdrive_sync", "Topic under which events, health and availability are published")
+	mqttDiscovery = flag.String("mqtt_discovery_prefix", "homeassistant", "Home Assistant MQTT discovery prefix to publish sensor configs under (empty for none)")
+	mqttNodeId    = flag.String("mqtt_node_id", "", "Identifies this instance to Home Assistant (default the hostname)")
+)
+
+var nonNodeId = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// mqttPublisher returns the Publisher the --mqtt_* flags describe, or nil
+// without --mqtt_broker.
+func mqttPublisher() (*mqtt.Publisher, error) {
+	if *mqttBroker == "" {
+		return nil, nil
+	}
+	if _, _, err := mqtt.BrokerAddr(*mqttBroker); err != nil {
+		return nil, err
+	}
+	node := *mqttNodeId
+	if node == "" {
+		var err error
+		if node, err = os.Hostname(); err != nil {
+			return nil, err
+		}
+	}
+	opts := mqtt.Options{Broker: *mqttBroker, Username: *mqttUsername, Password: *mqttPassword}
+	return mqtt.NewPublisher(opts, *mqttTopic, *mqttDiscovery, nonNodeId.ReplaceAllString(node, "_")), nil
+}
+
+// mqttPort returns the port of --mqtt_broker, or 0 without one.
+func mqttPort() uint64 {
+	if *mqttBroker == "" {
+		return 0
+	}
+	addr, _, err := mqtt.BrokerAddr(*mqttBroker)
+	if err != nil {
+		return 0
+	}
+	_, port, _ := net.SplitHostPort(addr)
+	n, _ := strconv.ParseUint(port, 10, 16)
+	return n
+}
@@ -0,0 +1,301 @@
+// Package mqtt is a minimal MQTT 3.1.1 client, enough to publish status to a
+// broker at QoS 0, and an events.Sink that publishes the uploader's events
+// and health for Home Assistant.
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Packet types, already shifted into the high nibble of the fixed header.
+const (
+	typeConnect    = 1 << 4
+	typeConnAck    = 2 << 4
+	typePublish    = 3 << 4
+	typePingReq    = 12 << 4
+	typeDisconnect = 14 << 4
+)
+
+const (
+	flagCleanSession = 0x02
+	flagWill         = 0x04
+	flagWillRetain   = 0x20
+	flagPassword     = 0x40
+	flagUsername     = 0x80
+	flagRetain       = 0x01
+)
+
+var connAckErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// Message is a message to publish.
+type Message struct {
+	Topic   string
+	Payload []byte
+	Retain  bool
+}
+
+// Options configure a connection to a broker.
+type Options struct {
+	// Broker is the broker's URL: tcp://host[:1883] or mqtt://…, or
+	// ssl://, tls:// or mqtts://host[:8883] for TLS.
+	Broker   string
+	ClientId string
+	Username string
+	Password string
+	// KeepAlive is how often the broker expects to hear from the client;
+	// zero means a minute.
+	KeepAlive time.Duration
+	// Will, if set, is published by the broker if the connection is lost.
+	Will *Message
+}
+
+// BrokerAddr returns the host:port and whether to use TLS for a broker URL.
+func BrokerAddr(broker string) (string, bool, error) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid MQTT broker %q: %w", broker, err)
+	}
+	var port string
+	var useTLS bool
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		port = "1883"
+	case "ssl", "tls", "mqtts":
+		port, useTLS = "8883", true
+	default:
+		return "", false, fmt.Errorf("invalid MQTT broker %q: unknown scheme %q", broker, u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return "", false, fmt.Errorf("invalid MQTT broker %q: no host", broker)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	return net.JoinHostPort(u.Hostname(), port), useTLS, nil
+}
+
+// Client is a connection to an MQTT broker.
+type Client struct {
+	conn net.Conn
+	// mu serializes writes to conn.
+	mu   sync.Mutex
+	done chan struct{}
+	err  error // why done was closed
+}
+
+// Dial connects to the broker and keeps the connection alive until Close is
+// called or it fails, which Done reports.
+func Dial(ctx context.Context, opts Options) (*Client, error) {
+	addr, useTLS, err := BrokerAddr(opts.Broker)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if useTLS {
+		host, _, _ := net.SplitHostPort(addr)
+		tc := tls.Client(conn, &tls.Config{ServerName: host})
+		if err := tc.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+	keepAlive := opts.KeepAlive
+	if keepAlive <= 0 {
+		keepAlive = time.Minute
+	}
+	r := bufio.NewReader(conn)
+	if err := connect(conn, r, opts, keepAlive); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	c := &Client{conn: conn, done: make(chan struct{})}
+	go c.read(r)
+	go c.ping(keepAlive / 2)
+	return c, nil
+}
+
+func connect(w io.Writer, r *bufio.Reader, opts Options, keepAlive time.Duration) error {
+	var flags byte = flagCleanSession
+	var payload []byte
+	payload = appendString(payload, opts.ClientId)
+	if opts.Will != nil {
+		flags |= flagWill
+		if opts.Will.Retain {
+			flags |= flagWillRetain
+		}
+		payload = appendString(payload, opts.Will.Topic)
+		payload = appendBytes(payload, opts.Will.Payload)
+	}
+	if opts.Username != "" {
+		flags |= flagUsername
+		payload = appendString(payload, opts.Username)
+	}
+	if opts.Password != "" {
+		flags |= flagPassword
+		payload = appendString(payload, opts.Password)
+	}
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, flags) // protocol level 4 is MQTT 3.1.1
+	secs := uint16(keepAlive / time.Second)
+	body = append(body, byte(secs>>8), byte(secs))
+	if _, err := w.Write(packet(typeConnect, append(body, payload...))); err != nil {
+		return err
+	}
+
+	typ, ack, err := readPacket(r)
+	if err != nil {
+		return fmt.Errorf("no reply from MQTT broker: %w", err)
+	}
+	if typ&0xf0 != typeConnAck || len(ack) != 2 {
+		return errors.New("unexpected reply from MQTT broker")
+	}
+	if ack[1] != 0 {
+		msg, ok := connAckErrors[ack[1]]
+		if !ok {
+			msg = fmt.Sprintf("refused with code %d", ack[1])
+		}
+		return fmt.Errorf("MQTT broker refused connection: %s", msg)
+	}
+	return nil
+}
+
+// Publish sends m at QoS 0.
+func (c *Client) Publish(m Message) error {
+	var flags byte
+	if m.Retain {
+		flags = flagRetain
+	}
+	body := appendString(nil, m.Topic)
+	return c.write(packet(typePublish|flags, append(body, m.Payload...)))
+}
+
+// Done is closed once the connection has failed or been closed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the connection ended, once Done is closed.
+func (c *Client) Err() error {
+	<-c.done
+	return c.err
+}
+
+// Close disconnects cleanly, so the broker doesn't publish the will.
+func (c *Client) Close() error {
+	c.write(packet(typeDisconnect, nil))
+	return c.conn.Close()
+}
+
+func (c *Client) write(p []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	_, err := c.conn.Write(p)
+	return err
+}
+
+// read discards what the broker sends, which at QoS 0 is only ping
+// responses, until the connection fails.
+func (c *Client) read(r *bufio.Reader) {
+	var err error
+	for err == nil {
+		_, _, err = readPacket(r)
+	}
+	c.err = err
+	c.conn.Close()
+	close(c.done)
+}
+
+func (c *Client) ping(every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := c.write(packet(typePingReq, nil)); err != nil {
+				c.conn.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// packet returns a packet with the given first header byte and body.
+func packet(header byte, body []byte) []byte {
+	p := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		p = append(p, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(p, body...)
+}
+
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, shift := 0, uint(0)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return 0, nil, errors.New("malformed MQTT packet length")
+		}
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	return appendBytes(b, []byte(s))
+}
+
+func appendBytes(b, s []byte) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
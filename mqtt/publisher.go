@@ -0,0 +1,267 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/events"
+)
+
+const (
+	// queueSize bounds how many events may wait for the broker before new
+	// ones are dropped.
+	queueSize = 100
+	// reconnectDelay is how long to wait between attempts to reach the
+	// broker.
+	reconnectDelay = 30 * time.Second
+	dialTimeout    = 10 * time.Second
+)
+
+// Health is the retained state published under <topic>/state.
+type Health struct {
+	// Status is "ok", or after a problem "failing", "offline", "paused" or
+	// "inactive" until the next successful upload.
+	Status      string     `json:"status"`
+	Uploads     int        `json:"uploads"`
+	Failures    int        `json:"failures"`
+	LastUpload  *time.Time `json:"last_upload"`
+	LastFile    string     `json:"last_file"`
+	LastFailure *time.Time `json:"last_failure"`
+	LastError   string     `json:"last_error"`
+}
+
+// Publisher is an events.Sink that publishes every event but progress
+// updates as JSON to <topic>/event, the daemon's Health, retained, to
+// <topic>/state, and "online" or "offline", retained, to <topic>/status.
+// With a discovery prefix it also publishes Home Assistant MQTT discovery
+// configs, so the sensors appear without any configuration.
+type Publisher struct {
+	opts      Options
+	topic     string
+	discovery string
+	node      string
+
+	queue chan events.Event
+	done  chan struct{}
+
+	mu     sync.Mutex
+	health Health
+}
+
+// NewPublisher returns a Publisher that connects to the broker in opts in
+// the background, reconnecting as needed. node identifies this instance to
+// Home Assistant; discoveryPrefix is usually "homeassistant", or empty for
+// no discovery.
+func NewPublisher(opts Options, topic, discoveryPrefix, node string) *Publisher {
+	topic = strings.TrimSuffix(topic, "/")
+	if opts.ClientId == "" {
+		opts.ClientId = "gdrive_sync_" + node
+	}
+	opts.Will = &Message{Topic: topic + "/status", Payload: []byte("offline"), Retain: true}
+	p := &Publisher{
+		opts:      opts,
+		topic:     topic,
+		discovery: strings.TrimSuffix(discoveryPrefix, "/"),
+		node:      node,
+		queue:     make(chan events.Event, queueSize),
+		done:      make(chan struct{}),
+		health:    Health{Status: "ok"},
+	}
+	go p.run()
+	return p
+}
+
+func (p *Publisher) Emit(e events.Event) {
+	if e.Type == events.Progress {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	select {
+	case p.queue <- e:
+	default:
+		log.Printf("MQTT queue full; dropping %s event for %s", e.Type, e.File)
+	}
+}
+
+// Close publishes that the daemon is offline and disconnects.
+func (p *Publisher) Close() error {
+	close(p.queue)
+	<-p.done
+	return nil
+}
+
+func (p *Publisher) run() {
+	defer close(p.done)
+	lastAttempt := time.Now()
+	c := p.connect()
+	defer func() {
+		if c != nil {
+			c.Publish(Message{Topic: p.topic + "/status", Payload: []byte("offline"), Retain: true})
+			c.Close()
+		}
+	}()
+	// The ticker reconnects while no events arrive.
+	t := time.NewTicker(reconnectDelay)
+	defer t.Stop()
+	for {
+		var e events.Event
+		var ok, isEvent bool
+		select {
+		case e, ok = <-p.queue:
+			if !ok {
+				return
+			}
+			isEvent = true
+			p.update(e)
+		case <-t.C:
+		}
+
+		if c != nil {
+			select {
+			case <-c.Done():
+				log.Printf("Lost connection to MQTT broker: %s", c.Err())
+				c = nil
+			default:
+			}
+		}
+		if c == nil && time.Since(lastAttempt) >= reconnectDelay {
+			lastAttempt = time.Now()
+			c = p.connect()
+			// The initial health covers the event just handled.
+			continue
+		}
+		if c == nil || !isEvent {
+			continue
+		}
+		if err := p.publishEvent(c, e); err != nil {
+			log.Printf("failed to publish to MQTT broker: %s", err)
+			c.Close()
+			c = nil
+		}
+	}
+}
+
+// connect connects to the broker and publishes the discovery configs and
+// current health, returning nil if that fails.
+func (p *Publisher) connect() *Client {
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	c, err := Dial(ctx, p.opts)
+	if err != nil {
+		log.Printf("failed to connect to MQTT broker %s: %s", p.opts.Broker, err)
+		return nil
+	}
+	msgs := []Message{{Topic: p.topic + "/status", Payload: []byte("online"), Retain: true}}
+	if p.discovery != "" {
+		msgs = append(msgs, p.discoveryConfigs()...)
+	}
+	msgs = append(msgs, p.healthMessage())
+	for _, m := range msgs {
+		if err := c.Publish(m); err != nil {
+			log.Printf("failed to publish to MQTT broker: %s", err)
+			c.Close()
+			return nil
+		}
+	}
+	log.Printf("Connected to MQTT broker %s", p.opts.Broker)
+	return c
+}
+
+func (p *Publisher) publishEvent(c *Client, e events.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := c.Publish(Message{Topic: p.topic + "/event", Payload: data}); err != nil {
+		return err
+	}
+	switch e.Type {
+	case events.Uploaded, events.Failed, events.Paused, events.Inactive, events.Offline, events.BacklogCleared:
+		return c.Publish(p.healthMessage())
+	}
+	return nil
+}
+
+// update applies e to the health.
+func (p *Publisher) update(e events.Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h := &p.health
+	t := e.Time
+	switch e.Type {
+	case events.Uploaded:
+		h.Status = "ok"
+		h.Uploads++
+		h.LastUpload = &t
+		h.LastFile = e.File
+	case events.BacklogCleared:
+		h.Status = "ok"
+	case events.Failed:
+		h.Status = "failing"
+		h.Failures++
+		h.LastFailure = &t
+		h.LastError = e.Error
+	case events.Offline:
+		h.Status = "offline"
+		h.LastError = e.Error
+	case events.Paused:
+		h.Status = "paused"
+	case events.Inactive:
+		h.Status = "inactive"
+	}
+}
+
+func (p *Publisher) healthMessage() Message {
+	p.mu.Lock()
+	data, _ := json.Marshal(p.health)
+	p.mu.Unlock()
+	return Message{Topic: p.topic + "/state", Payload: data, Retain: true}
+}
+
+// discoveryConfigs returns the Home Assistant discovery messages for the
+// sensors reading the health.
+func (p *Publisher) discoveryConfigs() []Message {
+	device := map[string]interface{}{
+		"identifiers": []string{"gdrive_sync_" + p.node},
+		"name":        "gdrive_sync " + p.node,
+	}
+	sensors := []struct {
+		component, object, name, template string
+		extra                             map[string]interface{}
+	}{
+		{"sensor", "status", "Status", "{{ value_json.status }}", nil},
+		{"sensor", "last_upload", "Last upload", "{{ value_json.last_upload }}", map[string]interface{}{"device_class": "timestamp"}},
+		{"sensor", "last_file", "Last file", "{{ value_json.last_file }}", nil},
+		{"sensor", "uploads", "Uploads", "{{ value_json.uploads }}", map[string]interface{}{"state_class": "total_increasing"}},
+		{"sensor", "failures", "Failures", "{{ value_json.failures }}", map[string]interface{}{"state_class": "total_increasing"}},
+		{"sensor", "last_error", "Last error", "{{ value_json.last_error }}", nil},
+		{"binary_sensor", "problem", "Problem", "{{ 'OFF' if value_json.status == 'ok' else 'ON' }}", map[string]interface{}{"device_class": "problem"}},
+	}
+	var msgs []Message
+	for _, s := range sensors {
+		config := map[string]interface{}{
+			"name":               s.name,
+			"unique_id":          "gdrive_sync_" + p.node + "_" + s.object,
+			"state_topic":        p.topic + "/state",
+			"value_template":     s.template,
+			"availability_topic": p.topic + "/status",
+			"device":             device,
+		}
+		for k, v := range s.extra {
+			config[k] = v
+		}
+		data, _ := json.Marshal(config)
+		msgs = append(msgs, Message{
+			Topic:   p.discovery + "/" + s.component + "/gdrive_sync_" + p.node + "/" + s.object + "/config",
+			Payload: data,
+			Retain:  true,
+		})
+	}
+	return msgs
+}
@@ -0,0 +1,139 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/dknowles2/gdrive_sync/events"
+	"github.com/dustin/go-humanize"
+)
+
+// Chat services a Chat notifier can post to.
+const (
+	Slack   = "slack"
+	Discord = "discord"
+)
+
+// Chat is a Notifier that posts notifications to a Slack or Discord
+// incoming webhook, with the file name linking to it in Drive.
+type Chat struct {
+	Service string // Slack or Discord
+	URL     string
+	// Events are the event types posted; none means uploads and failures.
+	Events []events.Type
+	Client *http.Client // http.DefaultClient if nil
+}
+
+// Colors of the message sidebar, as Discord wants them.
+const (
+	colorGood    = 0x2eb67d
+	colorWarning = 0xecb22e
+	colorDanger  = 0xe01e5a
+)
+
+func (c *Chat) wants(t events.Type) bool {
+	if len(c.Events) == 0 {
+		return t == events.Uploaded || t == events.Failed
+	}
+	for _, e := range c.Events {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Chat) Notify(ctx context.Context, n Notification) error {
+	if !c.wants(n.Event) {
+		return nil
+	}
+	color := colorWarning
+	switch n.Event {
+	case events.Uploaded, events.BacklogCleared:
+		color = colorGood
+	case events.Failed:
+		color = colorDanger
+	}
+	var payload interface{}
+	switch c.Service {
+	case Slack:
+		payload = slackPayload(n, color)
+	case Discord:
+		payload = discordPayload(n, color)
+	default:
+		return fmt.Errorf("unknown chat service %q", c.Service)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", c.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	hc := c.Client
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	res, err := hc.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("%s webhook failed: %w", c.Service, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("%s webhook failed: %s: %s", c.Service, res.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// slackPayload is the message as a Slack attachment, whose title links to
+// the file.
+func slackPayload(n Notification, color int) map[string]interface{} {
+	a := map[string]interface{}{
+		"color":    fmt.Sprintf("#%06x", color),
+		"fallback": n.Text,
+		"text":     n.Text,
+		"ts":       n.Time.Unix(),
+	}
+	if n.Name != "" && n.Name != "." {
+		a["title"] = n.Name
+		if n.Link != "" {
+			a["title_link"] = n.Link
+		}
+	}
+	if n.Event == events.Uploaded && n.Size > 0 {
+		a["fields"] = []map[string]interface{}{
+			{"title": "Size", "value": humanize.Bytes(uint64(n.Size)), "short": true},
+		}
+	}
+	return map[string]interface{}{"attachments": []interface{}{a}}
+}
+
+// discordPayload is the message as a Discord embed, whose title links to
+// the file.
+func discordPayload(n Notification, color int) map[string]interface{} {
+	e := map[string]interface{}{
+		"color":       color,
+		"description": n.Text,
+		"timestamp":   n.Time.UTC().Format("2006-01-02T15:04:05Z"),
+	}
+	if n.Name != "" && n.Name != "." {
+		e["title"] = n.Name
+		if n.Link != "" {
+			e["url"] = n.Link
+		}
+	}
+	if n.Event == events.Uploaded && n.Size > 0 {
+		e["fields"] = []map[string]interface{}{
+			{"name": "Size", "value": humanize.Bytes(uint64(n.Size)), "inline": true},
+		}
+	}
+	return map[string]interface{}{"embeds": []interface{}{e}}
+}
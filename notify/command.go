@@ -0,0 +1,42 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Command is a Notifier that runs a shell command for each notification. The
+// rendered text is written to its stdin and the message fields are passed in
+// NOTIFY_* environment variables.
+type Command struct {
+	Command string
+}
+
+func (c *Command) Notify(ctx context.Context, n Notification) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", c.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", c.Command)
+	}
+	cmd.Env = append(os.Environ(),
+		"NOTIFY_EVENT="+string(n.Event),
+		"NOTIFY_FILE="+n.File,
+		"NOTIFY_NAME="+n.Name,
+		"NOTIFY_SIZE="+strconv.FormatInt(n.Size, 10),
+		"NOTIFY_DURATION="+n.Duration.String(),
+		"NOTIFY_LINK="+n.Link,
+		"NOTIFY_ERROR="+n.Error,
+		"NOTIFY_TEXT="+n.Text,
+	)
+	cmd.Stdin = strings.NewReader(n.Text)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("notify command failed: %w: %s", err, out)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/dknowles2/gdrive_sync/events"
+)
+
+// Desktop is a Notifier that shows upload completions and failures as native
+// desktop notifications: notify-send on Linux and other Unixes, Notification
+// Center on macOS and toasts on Windows. Other events are ignored.
+type Desktop struct{}
+
+// windowsToast shows a toast with the title and text passed in the
+// environment, which avoids quoting them into the script.
+const windowsToast = `
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$x = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$t = $x.GetElementsByTagName('text')
+$t.Item(0).AppendChild($x.CreateTextNode($env:NOTIFY_TITLE)) > $null
+$t.Item(1).AppendChild($x.CreateTextNode($env:NOTIFY_TEXT)) > $null
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('gdrive_sync').Show([Windows.UI.Notifications.ToastNotification]::new($x))
+`
+
+func (Desktop) Notify(ctx context.Context, n Notification) error {
+	var title string
+	switch n.Event {
+	case events.Uploaded:
+		title = "Uploaded " + n.Name
+	case events.Failed:
+		title = "Upload failed: " + n.Name
+	default:
+		return nil
+	}
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.CommandContext(ctx, "osascript", "-e",
+			`display notification (system attribute "NOTIFY_TEXT") with title (system attribute "NOTIFY_TITLE")`)
+	case "windows":
+		cmd = exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", windowsToast)
+	default:
+		urgency := "normal"
+		if n.Event == events.Failed {
+			urgency = "critical"
+		}
+		cmd = exec.CommandContext(ctx, "notify-send", "--app-name=gdrive_sync", "--urgency="+urgency, "--", title, n.Text)
+	}
+	cmd.Env = append(os.Environ(), "NOTIFY_TITLE="+title, "NOTIFY_TEXT="+n.Text)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("desktop notification failed: %w: %s", err, out)
+	}
+	return nil
+}
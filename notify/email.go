@@ -0,0 +1,190 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/events"
+	"github.com/dustin/go-humanize"
+)
+
+// Email is a Notifier that mails problems (failures, inactivity, a reached
+// transfer cap, losing the network) as they happen if Alerts is set, and
+// with RunDigest a daily summary of what was uploaded and what went wrong.
+type Email struct {
+	// Addr is the SMTP server's host:port. STARTTLS is used if the server
+	// offers it, and is required to log in unless the server is local.
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+	Alerts   bool
+
+	mu     sync.Mutex
+	digest digest
+}
+
+// digest accumulates what happened since the last digest was sent.
+type digest struct {
+	since    time.Time
+	uploaded []Message
+	bytes    int64
+	errors   map[string]int // rendered text -> count
+}
+
+// isProblem reports whether notifications of type t are sent as alerts.
+func isProblem(t events.Type) bool {
+	switch t {
+	case events.Failed, events.Inactive, events.Paused, events.Offline:
+		return true
+	}
+	return false
+}
+
+func (e *Email) Notify(ctx context.Context, n Notification) error {
+	e.mu.Lock()
+	if e.digest.since.IsZero() {
+		e.digest.since = time.Now()
+	}
+	switch {
+	case n.Event == events.Uploaded:
+		e.digest.uploaded = append(e.digest.uploaded, n.Message)
+		e.digest.bytes += n.Size
+	case isProblem(n.Event):
+		if e.digest.errors == nil {
+			e.digest.errors = make(map[string]int)
+		}
+		e.digest.errors[n.Text]++
+	}
+	e.mu.Unlock()
+
+	if !e.Alerts || !isProblem(n.Event) {
+		return nil
+	}
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "%s\n\n", n.Text)
+	fmt.Fprintf(&body, "Time: %s\n", n.Time.Format(time.RFC1123))
+	if n.File != "" {
+		fmt.Fprintf(&body, "File: %s\n", n.File)
+	}
+	if n.Error != "" {
+		fmt.Fprintf(&body, "Error: %s\n", n.Error)
+	}
+	return e.send(firstLine(n.Text), body.Bytes())
+}
+
+// RunDigest mails a digest every day at the time of day at (an offset from
+// midnight, local time) until stop is closed. Whatever has happened since
+// the last digest is dropped when stop is closed.
+func (e *Email) RunDigest(at time.Duration, stop <-chan struct{}) {
+	e.mu.Lock()
+	if e.digest.since.IsZero() {
+		e.digest.since = time.Now()
+	}
+	e.mu.Unlock()
+	for {
+		t := time.NewTimer(time.Until(nextDigest(time.Now(), at)))
+		select {
+		case <-t.C:
+		case <-stop:
+			t.Stop()
+			return
+		}
+		if err := e.sendDigest(); err != nil {
+			log.Printf("failed to send email digest: %s", err)
+		}
+	}
+}
+
+// nextDigest returns the first time after now that is at past midnight.
+func nextDigest(now time.Time, at time.Duration) time.Time {
+	y, m, d := now.Date()
+	next := time.Date(y, m, d, 0, 0, 0, 0, now.Location()).Add(at)
+	for !next.After(now) {
+		y, m, d = next.Date()
+		next = time.Date(y, m, d+1, 0, 0, 0, 0, now.Location()).Add(at)
+	}
+	return next
+}
+
+func (e *Email) sendDigest() error {
+	now := time.Now()
+	e.mu.Lock()
+	dg := e.digest
+	e.digest = digest{since: now}
+	e.mu.Unlock()
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "Since %s:\n\n", dg.since.Format(time.RFC1123))
+	if len(dg.uploaded) == 0 {
+		fmt.Fprintf(&body, "No files were uploaded.\n")
+	} else {
+		fmt.Fprintf(&body, "Uploaded %d files (%s):\n", len(dg.uploaded), humanize.Bytes(uint64(dg.bytes)))
+		for _, m := range dg.uploaded {
+			fmt.Fprintf(&body, "  %s  %s (%s)", m.Time.Format("15:04"), m.Name, humanize.Bytes(uint64(m.Size)))
+			if m.Link != "" {
+				fmt.Fprintf(&body, " %s", m.Link)
+			}
+			fmt.Fprintln(&body)
+		}
+	}
+	problems := 0
+	if len(dg.errors) > 0 {
+		var texts []string
+		for text, n := range dg.errors {
+			texts = append(texts, text)
+			problems += n
+		}
+		sort.Strings(texts)
+		fmt.Fprintf(&body, "\nProblems:\n")
+		for _, text := range texts {
+			if n := dg.errors[text]; n > 1 {
+				fmt.Fprintf(&body, "  %s (%d times)\n", text, n)
+			} else {
+				fmt.Fprintf(&body, "  %s\n", text)
+			}
+		}
+	}
+	subject := fmt.Sprintf("gdrive_sync digest: %d files uploaded (%s)", len(dg.uploaded), humanize.Bytes(uint64(dg.bytes)))
+	if problems > 0 {
+		subject += fmt.Sprintf(", %d problems", problems)
+	}
+	return e.send(subject, body.Bytes())
+}
+
+func (e *Email) send(subject string, body []byte) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.Write(bytes.ReplaceAll(body, []byte("\n"), []byte("\r\n")))
+
+	var auth smtp.Auth
+	if e.Username != "" {
+		host, _, _ := net.SplitHostPort(e.Addr)
+		auth = smtp.PlainAuth("", e.Username, e.Password, host)
+	}
+	if err := smtp.SendMail(e.Addr, auth, e.From, e.To, msg.Bytes()); err != nil {
+		return fmt.Errorf("email failed: %w", err)
+	}
+	return nil
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
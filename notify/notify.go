@@ -0,0 +1,219 @@
+// Package notify turns pipeline events into human-readable notifications and
+// delivers them to one or more channels.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/dedup"
+	"github.com/dknowles2/gdrive_sync/events"
+	"github.com/dustin/go-humanize"
+)
+
+// Notification is a rendered message plus the data it was rendered from.
+type Notification struct {
+	Message
+	// Text is the message rendered from the template for the event type.
+	Text string
+}
+
+// Message holds the variables available to notification templates.
+type Message struct {
+	Event    events.Type
+	Time     time.Time
+	File     string
+	Name     string
+	Size     int64
+	Duration time.Duration
+	// DriveFileId and Link identify the uploaded file in Drive.
+	DriveFileId string
+	Link        string
+	Error       string
+}
+
+// Notifier delivers notifications to a single channel.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// DefaultTemplates are used for any event type the user doesn't override.
+const DefaultTemplates = `
+{{define "uploaded"}}Uploaded {{.Name}} ({{bytes .Size}}) in {{.Duration}}{{if .Link}}: {{.Link}}{{end}}{{end}}
+{{define "failed"}}Failed to upload {{.Name}}: {{.Error}}{{end}}
+{{define "inactive"}}No files have been uploaded from {{.File}} recently{{end}}
+{{define "paused"}}Monthly transfer cap of {{bytes .Size}} reached; uploads are paused until next month{{end}}
+{{define "offline"}}Drive is unreachable; uploads from {{.File}} are queued until it is back{{end}}
+{{define "backlog_cleared"}}Drive is reachable again and the {{.Size}} uploads queued from {{.File}} are done{{end}}
+`
+
+var funcs = template.FuncMap{
+	"bytes": func(n int64) string { return humanize.Bytes(uint64(n)) },
+}
+
+// ParseTemplates returns the default templates overridden by any templates
+// defined in the given file. Templates are named after event types, e.g.
+// {{define "failed"}}...{{end}}. An empty path returns just the defaults.
+func ParseTemplates(path string) (*template.Template, error) {
+	t, err := template.New("notify").Funcs(funcs).Parse(DefaultTemplates)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return t, nil
+	}
+	if t, err = t.ParseFiles(path); err != nil {
+		return nil, fmt.Errorf("unable to parse notification templates: %w", err)
+	}
+	return t, nil
+}
+
+// queueSize bounds how many notifications may wait for slow channels before
+// new ones are dropped.
+const queueSize = 100
+
+// Dispatcher is an events.Sink that renders notification-worthy events and
+// sends them to its notifiers in the background.
+type Dispatcher struct {
+	tmpl      *template.Template
+	notifiers []Notifier
+	queue     chan Notification
+	done      chan struct{}
+	dedup     *dedup.Limiter
+	stop      chan struct{}
+
+	mu      sync.Mutex
+	started map[string]time.Time // correlation ID -> discovery time
+}
+
+// New returns a Dispatcher sending to notifiers. If dedupWindow is non-zero,
+// repeats of the same failure within that window are collapsed into a
+// single summary notification.
+func New(tmpl *template.Template, dedupWindow time.Duration, notifiers ...Notifier) *Dispatcher {
+	d := &Dispatcher{
+		tmpl:      tmpl,
+		notifiers: notifiers,
+		queue:     make(chan Notification, queueSize),
+		done:      make(chan struct{}),
+		stop:      make(chan struct{}),
+		started:   make(map[string]time.Time),
+	}
+	go d.run()
+	if dedupWindow > 0 {
+		d.dedup = dedup.New(dedupWindow)
+		go d.summarize()
+	}
+	return d
+}
+
+func (d *Dispatcher) Emit(e events.Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	var dur time.Duration
+	d.mu.Lock()
+	switch e.Type {
+	case events.Discovered:
+		if e.Id != "" {
+			d.started[e.Id] = e.Time
+		}
+	case events.Uploaded, events.Failed:
+		if start, ok := d.started[e.Id]; ok {
+			dur = e.Time.Sub(start).Round(time.Second)
+			delete(d.started, e.Id)
+		}
+	}
+	d.mu.Unlock()
+	if d.tmpl.Lookup(string(e.Type)) == nil {
+		return
+	}
+	if e.Type == events.Failed && d.dedup != nil && !d.dedup.Allow(e.Error) {
+		return
+	}
+
+	m := Message{
+		Event:    e.Type,
+		Time:     e.Time,
+		File:     e.File,
+		Name:     filepath.Base(e.File),
+		Size:     e.Size,
+		Duration: dur,
+		Error:    e.Error,
+	}
+	if e.DriveFileId != "" {
+		m.DriveFileId = e.DriveFileId
+		m.Link = fmt.Sprintf("https://drive.google.com/file/d/%s/view", e.DriveFileId)
+	}
+	if e.Link != "" {
+		m.Link = e.Link
+	}
+	var buf bytes.Buffer
+	if err := d.tmpl.ExecuteTemplate(&buf, string(e.Type), m); err != nil {
+		log.Printf("failed to render %s notification: %s", e.Type, err)
+		return
+	}
+	d.enqueue(Notification{Message: m, Text: buf.String()})
+}
+
+func (d *Dispatcher) enqueue(n Notification) {
+	select {
+	case d.queue <- n:
+	default:
+		log.Printf("notification queue full; dropping %s notification for %s", n.Event, n.File)
+	}
+}
+
+// summarize periodically sends a notification for each failure whose
+// repeats were suppressed.
+func (d *Dispatcher) summarize() {
+	t := time.NewTicker(d.dedup.CheckInterval())
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			d.sendSummaries(d.dedup.Expire())
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) sendSummaries(summaries []dedup.Summary) {
+	for _, s := range summaries {
+		d.enqueue(Notification{
+			Message: Message{Event: events.Failed, Time: time.Now(), Error: s.Key},
+			Text:    fmt.Sprintf("Error %q occurred %d more times in the last %s", s.Key, s.Suppressed, s.Window),
+		})
+	}
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.done)
+	for n := range d.queue {
+		for _, nt := range d.notifiers {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			if err := nt.Notify(ctx, n); err != nil {
+				log.Printf("failed to send notification: %s", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// Close waits for queued notifications, including any pending summaries, to
+// be sent.
+func (d *Dispatcher) Close() error {
+	close(d.stop)
+	if d.dedup != nil {
+		d.sendSummaries(d.dedup.Flush())
+	}
+	close(d.queue)
+	<-d.done
+	return nil
+}
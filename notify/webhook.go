@@ -0,0 +1,76 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/events"
+)
+
+// Webhook is a Notifier that POSTs each notification as JSON to a URL, for
+// automation tools such as n8n or Home Assistant. Only uploads and failures
+// are sent.
+type Webhook struct {
+	URL    string
+	Client *http.Client // http.DefaultClient if nil
+}
+
+// webhookPayload is the JSON body of a webhook request.
+type webhookPayload struct {
+	Event       events.Type `json:"event"`
+	Time        time.Time   `json:"time"`
+	File        string      `json:"file"`
+	Name        string      `json:"name"`
+	Size        int64       `json:"size"`
+	Duration    float64     `json:"duration_seconds,omitempty"`
+	DriveFileId string      `json:"drive_file_id,omitempty"`
+	Link        string      `json:"web_link,omitempty"`
+	Error       string      `json:"error,omitempty"`
+	Text        string      `json:"text"`
+}
+
+func (w *Webhook) Notify(ctx context.Context, n Notification) error {
+	if n.Event != events.Uploaded && n.Event != events.Failed {
+		return nil
+	}
+	body, err := json.Marshal(webhookPayload{
+		Event:       n.Event,
+		Time:        n.Time,
+		File:        n.File,
+		Name:        n.Name,
+		Size:        n.Size,
+		Duration:    n.Duration.Seconds(),
+		DriveFileId: n.DriveFileId,
+		Link:        n.Link,
+		Error:       n.Error,
+		Text:        n.Text,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	c := w.Client
+	if c == nil {
+		c = http.DefaultClient
+	}
+	res, err := c.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("webhook failed: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("webhook failed: %s: %s", res.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
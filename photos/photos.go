@@ -0,0 +1,182 @@
+// Package photos implements a minimal Google Photos Library API client for
+// uploading images into an app-created album.
+package photos
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+)
+
+const baseURL = "https://photoslibrary.googleapis.com/v1"
+
+// Scopes are the OAuth scopes needed to create albums and append media to
+// them. If your token was created without these, delete it and re-authorize.
+var Scopes = []string{
+	"https://www.googleapis.com/auth/photoslibrary.appendonly",
+	"https://www.googleapis.com/auth/photoslibrary.readonly.appcreateddata",
+}
+
+// MediaItem is a subset of the Library API media item resource.
+type MediaItem struct {
+	Id         string `json:"id"`
+	ProductUrl string `json:"productUrl"`
+	Filename   string `json:"filename"`
+}
+
+type album struct {
+	Id    string `json:"id"`
+	Title string `json:"title"`
+}
+
+// Client uploads media into a single album.
+type Client struct {
+	hc      *http.Client
+	albumId string
+}
+
+// New returns a Client that uploads into the app-created album with the
+// given title, creating the album if it doesn't exist yet.
+func New(ctx context.Context, hc *http.Client, albumTitle string) (*Client, error) {
+	c := &Client{hc: hc}
+	id, err := c.findAlbum(ctx, albumTitle)
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		if id, err = c.createAlbum(ctx, albumTitle); err != nil {
+			return nil, err
+		}
+	}
+	c.albumId = id
+	return c, nil
+}
+
+func (c *Client) findAlbum(ctx context.Context, title string) (string, error) {
+	pageToken := ""
+	for {
+		v := url.Values{"excludeNonAppCreatedData": {"true"}, "pageSize": {"50"}}
+		if pageToken != "" {
+			v.Set("pageToken", pageToken)
+		}
+		var r struct {
+			Albums        []album `json:"albums"`
+			NextPageToken string  `json:"nextPageToken"`
+		}
+		if err := c.do(ctx, "GET", baseURL+"/albums?"+v.Encode(), nil, "", &r); err != nil {
+			return "", fmt.Errorf("unable to list Photos albums: %w", err)
+		}
+		for _, a := range r.Albums {
+			if a.Title == title {
+				return a.Id, nil
+			}
+		}
+		if r.NextPageToken == "" {
+			return "", nil
+		}
+		pageToken = r.NextPageToken
+	}
+}
+
+func (c *Client) createAlbum(ctx context.Context, title string) (string, error) {
+	b, err := json.Marshal(map[string]album{"album": {Title: title}})
+	if err != nil {
+		return "", err
+	}
+	var a album
+	if err := c.do(ctx, "POST", baseURL+"/albums", bytes.NewReader(b), "application/json", &a); err != nil {
+		return "", fmt.Errorf("unable to create Photos album %q: %w", title, err)
+	}
+	return a.Id, nil
+}
+
+// Upload sends the contents of r as a new media item named name and adds it
+// to the album.
+func (c *Client) Upload(ctx context.Context, name string, r io.Reader) (*MediaItem, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", baseURL+"/uploads", r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Goog-Upload-Protocol", "raw")
+	if t := mime.TypeByExtension(filepath.Ext(name)); t != "" {
+		req.Header.Set("X-Goog-Upload-Content-Type", t)
+	}
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	token, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("photos upload failed: %s: %s", resp.Status, token)
+	}
+
+	type simpleMediaItem struct {
+		FileName    string `json:"fileName"`
+		UploadToken string `json:"uploadToken"`
+	}
+	type newMediaItem struct {
+		SimpleMediaItem simpleMediaItem `json:"simpleMediaItem"`
+	}
+	b, err := json.Marshal(struct {
+		AlbumId       string         `json:"albumId"`
+		NewMediaItems []newMediaItem `json:"newMediaItems"`
+	}{
+		AlbumId:       c.albumId,
+		NewMediaItems: []newMediaItem{{SimpleMediaItem: simpleMediaItem{FileName: filepath.Base(name), UploadToken: string(token)}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var created struct {
+		NewMediaItemResults []struct {
+			Status struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			} `json:"status"`
+			MediaItem *MediaItem `json:"mediaItem"`
+		} `json:"newMediaItemResults"`
+	}
+	if err := c.do(ctx, "POST", baseURL+"/mediaItems:batchCreate", bytes.NewReader(b), "application/json", &created); err != nil {
+		return nil, fmt.Errorf("unable to create media item: %w", err)
+	}
+	if len(created.NewMediaItemResults) != 1 {
+		return nil, fmt.Errorf("unexpected batchCreate response: %d results", len(created.NewMediaItemResults))
+	}
+	res := created.NewMediaItemResults[0]
+	if res.Status.Code != 0 || res.MediaItem == nil {
+		return nil, fmt.Errorf("unable to create media item: %s", res.Status.Message)
+	}
+	return res.MediaItem, nil
+}
+
+func (c *Client) do(ctx context.Context, method, u string, body io.Reader, contentType string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, b)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
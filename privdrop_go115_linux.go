@@ -0,0 +1,12 @@
+//go:build !go1.16
+// +build !go1.16
+
+package main
+
+import "errors"
+
+// Before Go 1.16, setuid and setgid on Linux only changed the calling
+// thread, so the syscall package refuses them.
+func dropPrivileges(spec string) error {
+	return errors.New("--run_as needs a binary built with Go 1.16 or later")
+}
@@ -0,0 +1,70 @@
+//go:build !windows && (!linux || go1.16)
+// +build !windows
+// +build !linux go1.16
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// dropPrivileges switches the process to the user (and optionally group)
+// given as "user[:group]". It must be called after everything that needs
+// root, such as opening the watch directory and state files, is done.
+func dropPrivileges(spec string) error {
+	if os.Getuid() != 0 {
+		return errors.New("--run_as requires starting as root")
+	}
+	userName, groupName := spec, ""
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		userName, groupName = spec[:i], spec[i+1:]
+	}
+	u, err := user.Lookup(userName)
+	if err != nil {
+		if u, err = user.LookupId(userName); err != nil {
+			return fmt.Errorf("unknown user %q", userName)
+		}
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return err
+	}
+	gidStr := u.Gid
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			if g, err = user.LookupGroupId(groupName); err != nil {
+				return fmt.Errorf("unknown group %q", groupName)
+			}
+		}
+		gidStr = g.Gid
+	}
+	gid, err := strconv.Atoi(gidStr)
+	if err != nil {
+		return err
+	}
+
+	// Order matters: supplementary groups and gid can only be changed while
+	// we are still root.
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid: %w", err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid: %w", err)
+	}
+	if err := syscall.Setuid(0); err == nil {
+		return errors.New("privileges were not dropped: able to regain root")
+	}
+	log.Printf("Dropped privileges to %s (uid=%d, gid=%d)", spec, uid, gid)
+	return nil
+}
@@ -0,0 +1,7 @@
+package main
+
+import "errors"
+
+func dropPrivileges(spec string) error {
+	return errors.New("--run_as is not supported on Windows")
+}
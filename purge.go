@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/gdrive"
+)
+
+// purge trashes files previously uploaded by this tool, selected by upload
+// date and/or name pattern.
+func purge(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+	before := fs.String("before", "", "Trash files uploaded before this date (YYYY-MM-DD or RFC 3339), by the upload time recorded on them; their Drive creation time is that of the local file")
+	pattern := fs.String("pattern", "", "Only trash files whose name matches this glob")
+	dryRun := fs.Bool("dry_run", false, "List the files that would be trashed without trashing them")
+	fs.Parse(args)
+
+	if *before == "" && *pattern == "" {
+		return errors.New("at least one of --before or --pattern is required")
+	}
+	// The upload time is in an appProperty, which Drive can only match
+	// exactly, so the date is compared here.
+	var cutoff time.Time
+	if *before != "" {
+		t, err := parseDate(*before)
+		if err != nil {
+			return err
+		}
+		cutoff = t
+	}
+	if *pattern != "" {
+		if _, err := filepath.Match(*pattern, ""); err != nil {
+			return fmt.Errorf("invalid --pattern: %w", err)
+		}
+	}
+
+	service, err := flagPair().driveConfig().Service(ctx)
+	if err != nil {
+		return err
+	}
+	files, err := gdrive.Search(ctx, service, gdrive.UploadedQuery(), "files(id,name,createdTime,appProperties)")
+	if err != nil {
+		return err
+	}
+	n := 0
+	for _, f := range files {
+		if *pattern != "" {
+			if ok, _ := filepath.Match(*pattern, f.Name); !ok {
+				continue
+			}
+		}
+		uploaded, err := gdrive.UploadTime(f)
+		if err != nil {
+			log.Printf("Skipping %s (%s): unable to tell when it was uploaded: %s", f.Name, f.Id, err)
+			continue
+		}
+		if !cutoff.IsZero() && !uploaded.Before(cutoff) {
+			continue
+		}
+		n++
+		if *dryRun {
+			fmt.Printf("would trash %s (%s, uploaded %s)\n", f.Name, f.Id, uploaded.Format(time.RFC3339))
+			continue
+		}
+		if err := gdrive.Trash(service, f.Id); err != nil {
+			return fmt.Errorf("unable to trash %s: %w", f.Name, err)
+		}
+		log.Printf("Trashed %s (%s)", f.Name, f.Id)
+	}
+	log.Printf("%d files matched", n)
+	return nil
+}
+
+// parseDate accepts either a plain date (interpreted in local time) or a full
+// RFC 3339 timestamp.
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q: want YYYY-MM-DD or RFC 3339", s)
+	}
+	return t, nil
+}
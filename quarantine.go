@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/dknowles2/gdrive_sync/journal"
+)
+
+const quarantineUsage = `usage: quarantine list [PATH_GLOB]
+       quarantine requeue [PATH_GLOB]`
+
+// quarantine implements the "quarantine" command family, for files given up
+// on after --max_failures failed uploads.
+func quarantine(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New(quarantineUsage)
+	}
+	switch args[0] {
+	case "list":
+		return quarantineList(args[1:])
+	case "requeue":
+		return quarantineRequeue(args[1:])
+	default:
+		return errors.New(quarantineUsage)
+	}
+}
+
+// quarantined returns the journal's entries for the files it last recorded
+// as abandoned whose original path matches pattern, oldest first.
+func quarantined(fs *flag.FlagSet, args []string) ([]journal.Entry, error) {
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		return nil, errors.New(quarantineUsage)
+	}
+	pattern := fs.Arg(0)
+	if *journalFile == "" {
+		return nil, errors.New("--journal_file is required")
+	}
+	entries, err := journal.Load(*journalFile)
+	if err != nil {
+		return nil, err
+	}
+	latest := make(map[string]int)
+	for i, e := range entries {
+		latest[filepath.Clean(e.Path)] = i
+	}
+	var matched []journal.Entry
+	for i, e := range entries {
+		if e.Status != journal.Abandoned || latest[filepath.Clean(e.Path)] != i {
+			continue
+		}
+		if pattern != "" {
+			if ok, err := filepath.Match(pattern, e.Path); err != nil {
+				return nil, fmt.Errorf("bad path glob: %w", err)
+			} else if !ok {
+				continue
+			}
+		}
+		matched = append(matched, e)
+	}
+	return matched, nil
+}
+
+// quarantineList lists the quarantined files.
+func quarantineList(args []string) error {
+	entries, err := quarantined(flag.NewFlagSet("quarantine list", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tFAILURES\tPATH\tMOVED TO\tERROR")
+	for _, e := range entries {
+		moved := e.Quarantine
+		if moved == "" {
+			moved = "-"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", e.Time.Local().Format("2006-01-02 15:04:05"), e.Failures, e.Path, moved, e.Error)
+	}
+	return w.Flush()
+}
+
+// quarantineRequeue puts quarantined files back to be uploaded again: those
+// moved to --failed_dir are moved back, and the journal forgets their
+// failures. A running daemon picks up the files moved back; those that were
+// never moved are picked up when it next starts.
+func quarantineRequeue(args []string) error {
+	entries, err := quarantined(flag.NewFlagSet("quarantine requeue", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+	j, err := journal.Open(*journalFile)
+	if err != nil {
+		return err
+	}
+	defer j.Close()
+	var requeued, failed int
+	for _, e := range entries {
+		if err := requeue(j, e); err != nil {
+			log.Printf("Unable to requeue %s: %s", e.Path, err)
+			failed++
+			continue
+		}
+		requeued++
+	}
+	log.Printf("Requeued %d files", requeued)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files could not be requeued", failed, requeued+failed)
+	}
+	return nil
+}
+
+// requeue puts back the file quarantined as e.
+func requeue(j *journal.Journal, e journal.Entry) error {
+	if e.Quarantine != "" {
+		if _, err := os.Lstat(e.Path); !os.IsNotExist(err) {
+			return fmt.Errorf("%s is in the way of moving back %s", e.Path, e.Quarantine)
+		}
+		if err := os.MkdirAll(filepath.Dir(e.Path), 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		if err := os.Rename(e.Quarantine, e.Path); err != nil {
+			return fmt.Errorf("unable to move it back: %w", err)
+		}
+	}
+	fi, err := os.Stat(e.Path)
+	if err != nil {
+		return err
+	}
+	return j.Append(journal.Entry{Path: e.Path, Status: journal.Requeued, Size: fi.Size(), ModTime: fi.ModTime()})
+}
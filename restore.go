@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/gdrive"
+	"github.com/dustin/go-humanize"
+	"google.golang.org/api/drive/v3"
+)
+
+// restore downloads a Drive folder to a local directory, putting files back
+// at the path and modification time they were uploaded with where that was
+// recorded.
+func restore(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	overwrite := fs.Bool("overwrite", false, "Replace local files that differ from Drive instead of skipping them")
+	dryRun := fs.Bool("dry_run", false, "Only print what would be restored")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: restore [flags] <drive-folder> <local-dir>")
+		fmt.Fprintln(fs.Output(), "<drive-folder> is a path beneath --output_dir (\".\" for --output_dir itself) or a folder ID.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("a Drive folder and a local directory are required")
+	}
+	src, dst := fs.Arg(0), fs.Arg(1)
+
+	service, err := flagPair().driveConfig().Service(ctx)
+	if err != nil {
+		return err
+	}
+	folderId, err := findRemoteFolder(ctx, service, src)
+	if err != nil {
+		return err
+	}
+
+	var restored, skipped, failed int
+	err = walkRemote(ctx, service, folderId, "", func(dir string, f *drive.File) {
+		if strings.HasPrefix(f.MimeType, "application/vnd.google-apps.") {
+			log.Printf("Skipping Google Docs editor file %s", path.Join(dir, f.Name))
+			skipped++
+			return
+		}
+		rel := restorePath(dir, f)
+		local := filepath.Join(dst, filepath.FromSlash(rel))
+		if !*overwrite {
+			if fi, err := os.Stat(local); err == nil {
+				if sum, err := gdrive.FileMD5(local); err == nil && sum == f.Md5Checksum && fi.Size() == f.Size {
+					skipped++
+					return
+				}
+				log.Printf("Skipping %s: a different local file exists (use -overwrite to replace it)", local)
+				skipped++
+				return
+			}
+		}
+		if *dryRun {
+			fmt.Printf("%s -> %s\n", path.Join(dir, f.Name), local)
+			restored++
+			return
+		}
+		if err := restoreFile(ctx, service, f, local); err != nil {
+			log.Printf("Failed to restore %s: %s", path.Join(dir, f.Name), err)
+			failed++
+			return
+		}
+		log.Printf("Restored %s (%s)", local, humanize.Bytes(uint64(f.Size)))
+		restored++
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Restored %d files, skipped %d, %d failed", restored, skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d files failed to restore", failed)
+	}
+	return nil
+}
+
+// findRemoteFolder looks up src as a folder path beneath --output_dir,
+// falling back to treating it as a folder ID.
+func findRemoteFolder(ctx context.Context, d *gdrive.Drive, src string) (string, error) {
+	if parentId, err := gdrive.GetFolderId(d, outputFolder()); err == nil {
+		if id, err := gdrive.ResolvePath(ctx, d, parentId, src); err == nil {
+			return id, nil
+		}
+	}
+	f, err := d.Files.Get(src).SupportsAllDrives(true).Fields("id", "mimeType").Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("no folder at path or with ID %q: %w", src, err)
+	}
+	if !gdrive.IsFolder(f) {
+		return "", fmt.Errorf("%s is not a folder", src)
+	}
+	return f.Id, nil
+}
+
+// walkRemote calls fn for every file beneath the folder with the given ID,
+// with dir set to the slash-separated folder path relative to it.
+func walkRemote(ctx context.Context, d *gdrive.Drive, folderId, dir string, fn func(dir string, f *drive.File)) error {
+	q := fmt.Sprintf("'%s' in parents and trashed = false", folderId)
+	files, err := gdrive.Search(ctx, d, q, "files(id,name,mimeType,size,md5Checksum,appProperties)")
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if gdrive.IsFolder(f) {
+			if err := walkRemote(ctx, d, f.Id, path.Join(dir, f.Name), fn); err != nil {
+				return err
+			}
+			continue
+		}
+		fn(dir, f)
+	}
+	return nil
+}
+
+// restorePath returns where f should be restored to, relative to the local
+// directory. The recorded original path is used when it is present and stays
+// inside the directory; otherwise the file keeps its Drive name and folder.
+func restorePath(dir string, f *drive.File) string {
+	fallback := path.Join(dir, f.Name)
+	p, ok := f.AppProperties[gdrive.AppPropertyPath]
+	if !ok {
+		return fallback
+	}
+	p = path.Clean(p)
+	if p == "." || path.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") {
+		return fallback
+	}
+	// A file recorded without directories stays in its Drive folder.
+	if !strings.Contains(p, "/") {
+		return path.Join(dir, p)
+	}
+	return p
+}
+
+// restoreFile downloads f to local, creating parent directories and setting
+// the recorded modification time.
+func restoreFile(ctx context.Context, d *gdrive.Drive, f *drive.File, local string) error {
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return err
+	}
+	if err := downloadTo(ctx, d, f.Id, local); err != nil {
+		return err
+	}
+	if v, ok := f.AppProperties[gdrive.AppPropertyMtime]; ok {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return os.Chtimes(local, t, t)
+		}
+	}
+	return nil
+}
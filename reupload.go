@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/manifest"
+	"github.com/dknowles2/gdrive_sync/uploader"
+)
+
+// reupload sends previously processed files through the normal upload
+// pipeline again, either from paths on the command line or from manifest
+// records found in an archive directory.
+func reupload(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("reupload", flag.ExitOnError)
+	fromManifest := fs.Bool("from_manifest", false, "Select files from --manifest_file records and read them from the single archive directory argument")
+	since := fs.String("since", "", "With --from_manifest, only re-upload files originally uploaded on or after this date")
+	pattern := fs.String("pattern", "", "Only re-upload files whose name matches this glob")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: reupload [flags] <file-or-dir>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("at least one file or directory is required")
+	}
+
+	var files []string
+	var err error
+	if *fromManifest {
+		if fs.NArg() != 1 {
+			return errors.New("--from_manifest takes exactly one archive directory")
+		}
+		files, err = manifestFiles(fs.Arg(0), *since)
+	} else {
+		files, err = expandPaths(fs.Args())
+	}
+	if err != nil {
+		return err
+	}
+
+	if *pattern != "" {
+		var matched []string
+		for _, f := range files {
+			if ok, _ := filepath.Match(*pattern, filepath.Base(f)); ok {
+				matched = append(matched, f)
+			}
+		}
+		files = matched
+	}
+
+	// A lone pair takes files from anywhere. With --config, each file goes
+	// to the folder of the pair whose input or archive directory it is in.
+	us, cleanup, err := newUploaders(ctx)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	pick := make([]*uploader.Uploader, len(files))
+	for i, f := range files {
+		pick[i] = us[0]
+		if len(us) > 1 {
+			if pick[i], err = uploaderFor(us, f); err != nil {
+				return err
+			}
+		}
+	}
+
+	var uploaded, failed int
+	for i, f := range files {
+		if err := pick[i].UploadFile(ctx, f); err != nil {
+			failed++
+			continue
+		}
+		uploaded++
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed to upload", failed, uploaded+failed)
+	}
+	log.Printf("Re-uploaded %d files", uploaded)
+	return nil
+}
+
+// manifestFiles returns the archived copies of files recorded in the
+// manifest, optionally restricted to uploads on or after since.
+func manifestFiles(dir, since string) ([]string, error) {
+	if *manifestFile == "" {
+		return nil, errors.New("--manifest_file is required with --from_manifest")
+	}
+	var after time.Time
+	if since != "" {
+		var err error
+		if after, err = parseDate(since); err != nil {
+			return nil, err
+		}
+	}
+	records, err := manifest.Load(*manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	seen := make(map[string]bool)
+	for _, r := range records {
+		if r.Time.Before(after) || seen[r.Name] {
+			continue
+		}
+		seen[r.Name] = true
+		p := filepath.Join(dir, r.Name)
+		if _, err := os.Stat(p); err != nil {
+			log.Printf("skipping %s: %s", r.Name, err)
+			continue
+		}
+		files = append(files, p)
+	}
+	return files, nil
+}
+
+// expandPaths replaces each directory in paths with the regular files it
+// directly contains.
+func expandPaths(paths []string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		entries, err := ioutil.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+			files = append(files, filepath.Join(p, e.Name()))
+		}
+	}
+	return files, nil
+}
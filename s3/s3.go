@@ -0,0 +1,262 @@
+// Package s3 is a minimal client for S3-compatible object storage, such as
+// AWS S3 or MinIO, enough to upload objects with Signature Version 4.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxObjectSize is the largest object a single upload may create.
+const MaxObjectSize = 5 << 30
+
+// unsignedPayload signs a request without hashing its body first, so files
+// can be streamed.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// Bucket is a bucket objects are uploaded into, addressed path-style
+// (Endpoint/Bucket/key), which both AWS and MinIO accept.
+type Bucket struct {
+	// Backend names the bucket in logs and the journal; "s3" if empty.
+	Backend string
+	// Endpoint is the service's URL, e.g. https://minio.local:9000; empty
+	// means AWS in Region.
+	Endpoint string
+	// Region is what requests are signed for; us-east-1 if empty, which is
+	// also what MinIO expects by default.
+	Region    string
+	Bucket    string
+	Prefix    string // prepended to every key, e.g. "scans/"
+	AccessKey string
+	SecretKey string
+	Client    *http.Client // http.DefaultClient if nil
+}
+
+// Name returns the name of the backend.
+func (b *Bucket) Name() string {
+	if b.Backend == "" {
+		return "s3"
+	}
+	return b.Backend
+}
+
+func (b *Bucket) region() string {
+	if b.Region == "" {
+		return "us-east-1"
+	}
+	return b.Region
+}
+
+// EndpointURL returns the service's URL.
+func (b *Bucket) EndpointURL() (*url.URL, error) {
+	e := b.Endpoint
+	if e == "" {
+		e = fmt.Sprintf("https://s3.%s.amazonaws.com", b.region())
+	}
+	u, err := url.Parse(e)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", e)
+	}
+	return u, nil
+}
+
+// Put uploads size bytes from r as the object at the slash-separated key
+// beneath Prefix, returning the object's URL.
+func (b *Bucket) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (string, error) {
+	if size > MaxObjectSize {
+		return "", fmt.Errorf("%s is too large for a single S3 upload", key)
+	}
+	u, err := b.EndpointURL()
+	if err != nil {
+		return "", err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + b.Bucket + "/" + strings.TrimPrefix(b.Prefix+key, "/")
+	req, err := http.NewRequest("PUT", u.String(), ioutil.NopCloser(r))
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	b.sign(req, unsignedPayload, time.Now())
+	hc := b.Client
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	res, err := hc.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("S3 upload failed: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return "", responseError(res)
+	}
+	return u.String(), nil
+}
+
+// Error is an error response from the service.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("S3 returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("S3 returned %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Temporary reports whether the request may succeed if tried again.
+func (e *Error) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests || e.Code == "SlowDown"
+}
+
+func responseError(res *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+	e := &Error{StatusCode: res.StatusCode}
+	e.Code = xmlElement(body, "Code")
+	e.Message = xmlElement(body, "Message")
+	return e
+}
+
+// xmlElement returns the text of the first element called name in an error
+// document, which is all that's needed of them.
+func xmlElement(doc []byte, name string) string {
+	start := bytes.Index(doc, []byte("<"+name+">"))
+	if start < 0 {
+		return ""
+	}
+	rest := doc[start+len(name)+2:]
+	end := bytes.Index(rest, []byte("</"+name+">"))
+	if end < 0 {
+		return ""
+	}
+	return string(rest[:end])
+}
+
+var errNoCredentials = errors.New("no S3 access key or secret key")
+
+// Check returns an error if the bucket can't be uploaded to as configured.
+func (b *Bucket) Check() error {
+	if b.Bucket == "" {
+		return errors.New("no S3 bucket")
+	}
+	if b.AccessKey == "" || b.SecretKey == "" {
+		return errNoCredentials
+	}
+	_, err := b.EndpointURL()
+	return err
+}
+
+// sign adds a Signature Version 4 Authorization header to req, whose body
+// has the given SHA-256 (or is unsignedPayload), signing the host, the
+// Content-Type and the x-amz-* headers.
+func (b *Bucket) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "content-type" || lk == "range" || strings.HasPrefix(lk, "x-amz-") {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		encodePath(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + b.region() + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+b.SecretKey), day)
+	key = hmacSHA256(key, b.region())
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", b.AccessKey, scope, signedHeaders, sig))
+}
+
+// encodePath escapes every byte of p but unreserved characters and slashes,
+// as signing requires.
+func encodePath(p string) string {
+	var sb strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c == '/' || isUnreserved(c) {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+func encodeQuery(s string) string {
+	return strings.Replace(encodePath(s), "/", "%2F", -1)
+}
+
+func canonicalQuery(q url.Values) string {
+	var keys []string
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), q[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, encodeQuery(k)+"="+encodeQuery(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func isUnreserved(c byte) bool {
+	return 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
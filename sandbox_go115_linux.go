@@ -0,0 +1,10 @@
+//go:build !go1.16
+// +build !go1.16
+
+package main
+
+import "errors"
+
+func enableSandbox(rwPaths []string, ports []uint64) error {
+	return errors.New("--sandbox needs a binary built with Go 1.16 or later")
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+func enableSandbox(rwPaths []string, ports []uint64) error {
+	return errors.New("--sandbox is only supported on Linux")
+}
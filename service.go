@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const serviceUsage = `usage: service install|uninstall|start|stop [-name NAME]`
+
+// service implements the "service" command family, which has the system's
+// service manager run the daemon: the Windows service control manager, or
+// launchd on macOS. "service install" records the flags given before it
+// for the daemon, so
+//
+//	gdrive_sync --input_dir=/scans --output_dir=Scans service install
+//
+// installs a service running "gdrive_sync --input_dir=/scans
+// --output_dir=Scans run". On Windows it runs in the system directory, so
+// give paths in the flags as absolute paths.
+func service(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New(serviceUsage)
+	}
+	fs := flag.NewFlagSet("service "+args[0], flag.ExitOnError)
+	name := fs.String("name", "gdrive_sync", "Name of the service, to install more than one")
+	fs.Parse(args[1:])
+	if fs.NArg() > 0 || *name == "" {
+		return errors.New(serviceUsage)
+	}
+	switch args[0] {
+	case "install":
+		exe, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("unable to find the executable: %w", err)
+		}
+		if exe, err = filepath.Abs(exe); err != nil {
+			return err
+		}
+		return installService(*name, exe, daemonArgs())
+	case "uninstall":
+		return uninstallService(*name)
+	case "start":
+		return startService(*name)
+	case "stop":
+		return stopService(*name)
+	default:
+		return errors.New(serviceUsage)
+	}
+}
+
+// daemonArgs returns the arguments an installed service runs the daemon
+// with: the flags given before the command, then "run".
+func daemonArgs() []string {
+	given := os.Args[1 : len(os.Args)-len(flag.Args())]
+	return append(append([]string(nil), given...), "run")
+}
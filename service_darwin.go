@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// launchdLabelPrefix is prepended to the service's name for its launchd
+// label.
+const launchdLabelPrefix = "com.github.dknowles2."
+
+// The daemon is kept alive unless it exits cleanly, as it does when
+// "launchctl stop" sends it SIGTERM.
+var launchdPlist = template.Must(template.New("plist").Funcs(template.FuncMap{"xml": xmlEscape}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{xml .Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+{{- range .Args}}
+		<string>{{xml .}}</string>
+{{- end}}
+	</array>
+	<key>WorkingDirectory</key>
+	<string>{{xml .Dir}}</string>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>StandardOutPath</key>
+	<string>{{xml .Log}}</string>
+	<key>StandardErrorPath</key>
+	<string>{{xml .Log}}</string>
+</dict>
+</plist>
+`))
+
+func xmlEscape(s string) (string, error) {
+	var b strings.Builder
+	err := xml.EscapeText(&b, []byte(s))
+	return b.String(), err
+}
+
+// launchdPaths returns where the plist and log of the service named name
+// go: for root, a daemon that runs at boot, otherwise an agent that runs
+// when the user logs in.
+func launchdPaths(name string) (plist, logFile string, err error) {
+	label := launchdLabelPrefix + name
+	if os.Geteuid() == 0 {
+		return filepath.Join("/Library/LaunchDaemons", label+".plist"), filepath.Join("/Library/Logs", name+".log"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", err
+	}
+	return filepath.Join(home, "Library/LaunchAgents", label+".plist"), filepath.Join(home, "Library/Logs", name+".log"), nil
+}
+
+func installService(name, exe string, args []string) error {
+	plist, logFile, err := launchdPaths(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(plist); err == nil {
+		return fmt.Errorf("service %s is already installed at %s", name, plist)
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	err = launchdPlist.Execute(&b, struct {
+		Label, Dir, Log string
+		Args            []string
+	}{launchdLabelPrefix + name, dir, logFile, append([]string{exe}, args...)})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(plist), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := ioutil.WriteFile(plist, b.Bytes(), 0644); err != nil {
+		return fmt.Errorf("unable to write %s: %w", plist, err)
+	}
+	if err := launchctl("load", "-w", plist); err != nil {
+		return err
+	}
+	log.Printf("Installed and started service %s from %s, logging to %s", name, plist, logFile)
+	return nil
+}
+
+func uninstallService(name string) error {
+	plist, _, err := launchdPaths(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(plist); err != nil {
+		return fmt.Errorf("service %s is not installed: %w", name, err)
+	}
+	if err := launchctl("unload", "-w", plist); err != nil {
+		return err
+	}
+	if err := os.Remove(plist); err != nil {
+		return err
+	}
+	log.Printf("Uninstalled service %s", name)
+	return nil
+}
+
+func startService(name string) error {
+	return launchctl("start", launchdLabelPrefix+name)
+}
+
+func stopService(name string) error {
+	return launchctl("stop", launchdLabelPrefix+name)
+}
+
+func launchctl(args ...string) error {
+	out, err := exec.Command("launchctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("launchctl %s failed: %w: %s", args[0], err, bytes.TrimSpace(out))
+	}
+	return nil
+}
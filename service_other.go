@@ -0,0 +1,24 @@
+//go:build !windows && !darwin
+// +build !windows,!darwin
+
+package main
+
+import "errors"
+
+var errNoService = errors.New("the service command is for Windows and macOS; elsewhere, run the daemon under systemd or in a container (see deploy/)")
+
+func installService(name, exe string, args []string) error {
+	return errNoService
+}
+
+func uninstallService(name string) error {
+	return errNoService
+}
+
+func startService(name string) error {
+	return errNoService
+}
+
+func stopService(name string) error {
+	return errNoService
+}
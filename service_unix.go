@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// serviceControl is for the Windows service manager; other systems' service
+// managers stop the daemon with SIGTERM.
+func serviceControl(stop func()) (func(err error), error) {
+	return func(error) {}, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceStopTimeout is how long "service stop" waits for the daemon to
+// finish its uploads and exit.
+const serviceStopTimeout = time.Minute
+
+func installService(name, exe string, args []string) error {
+	// A service has no stderr, so it logs to a file unless told otherwise.
+	logFile := ""
+	if !cmdlineFlags["log_file"] {
+		dir := filepath.Join(os.Getenv("ProgramData"), "gdrive_sync")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		logFile = filepath.Join(dir, name+".log")
+		// The flag must come before the command, which is last.
+		n := len(args) - 1
+		args = append(append(append([]string(nil), args[:n]...), "--log_file="+logFile), args[n:]...)
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("unable to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s is already installed", name)
+	}
+	s, err := m.CreateService(name, exe, mgr.Config{
+		DisplayName: name,
+		Description: "Uploads new files to Google Drive",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return fmt.Errorf("unable to create service %s: %w", name, err)
+	}
+	defer s.Close()
+	// Restart the daemon a minute after it fails.
+	restart := []mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: time.Minute}}
+	if err := s.SetRecoveryActions(restart, uint32((24 * time.Hour).Seconds())); err != nil {
+		log.Printf("Unable to have service %s restarted on failure: %s", name, err)
+	}
+	if logFile != "" {
+		log.Printf("Installed service %s, logging to %s; start it with \"service start\"", name, logFile)
+	} else {
+		log.Printf("Installed service %s; start it with \"service start\"", name)
+	}
+	return nil
+}
+
+func uninstallService(name string) error {
+	return withService(name, func(s *mgr.Service) error {
+		if st, err := s.Query(); err == nil && st.State != svc.Stopped {
+			if err := stopAndWait(s); err != nil {
+				log.Printf("Unable to stop service %s: %s", name, err)
+			}
+		}
+		if err := s.Delete(); err != nil {
+			return fmt.Errorf("unable to delete service %s: %w", name, err)
+		}
+		log.Printf("Uninstalled service %s", name)
+		return nil
+	})
+}
+
+func startService(name string) error {
+	return withService(name, func(s *mgr.Service) error {
+		if err := s.Start(); err != nil {
+			return fmt.Errorf("unable to start service %s: %w", name, err)
+		}
+		return nil
+	})
+}
+
+func stopService(name string) error {
+	return withService(name, stopAndWait)
+}
+
+// withService calls fn with the installed service named name.
+func withService(name string, fn func(s *mgr.Service) error) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("unable to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("unable to open service %s: %w", name, err)
+	}
+	defer s.Close()
+	return fn(s)
+}
+
+// stopAndWait stops s, waiting up to serviceStopTimeout for it to exit.
+func stopAndWait(s *mgr.Service) error {
+	st, err := s.Control(svc.Stop)
+	if err != nil {
+		return fmt.Errorf("unable to stop service %s: %w", s.Name, err)
+	}
+	deadline := time.Now().Add(serviceStopTimeout)
+	for st.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service %s didn't stop within %s", s.Name, serviceStopTimeout)
+		}
+		time.Sleep(300 * time.Millisecond)
+		if st, err = s.Query(); err != nil {
+			return fmt.Errorf("unable to query service %s: %w", s.Name, err)
+		}
+	}
+	return nil
+}
+
+// serviceControl has the daemon, when the service manager runs it, report
+// to it and call stop when it asks the service to stop. The daemon must
+// call the function returned once it has stopped, with the error it
+// stopped with.
+func serviceControl(stop func()) (func(err error), error) {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return nil, fmt.Errorf("unable to tell whether running as a service: %w", err)
+	}
+	if !isService {
+		return func(error) {}, nil
+	}
+	h := &serviceHandler{stop: stop, done: make(chan error, 1)}
+	ran := make(chan error, 1)
+	// The name is unused for a service that has its own process.
+	go func() { ran <- svc.Run("", h) }()
+	return func(err error) {
+		h.done <- err
+		if err := <-ran; err != nil {
+			log.Printf("Service manager failed: %s", err)
+		}
+	}, nil
+}
+
+// serviceHandler reports the daemon's state to the service manager.
+type serviceHandler struct {
+	stop func()
+	done chan error
+}
+
+func (h *serviceHandler) Execute(args []string, r <-chan svc.ChangeRequest, s chan<- svc.Status) (bool, uint32) {
+	s <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				s <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				log.Printf("Service manager asked to stop; shutting down")
+				s <- svc.Status{State: svc.StopPending}
+				h.stop()
+			}
+		case err := <-h.done:
+			if err != nil {
+				// A service-specific exit code, so that the service
+				// manager restarts it.
+				return true, 1
+			}
+			return false, 0
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/uploader"
+)
+
+var (
+	stabilityStrategy = flag.String("stability_strategy", "size", "Comma-separated checks a file must pass, in order, before it is uploaded: size (stops growing), open (no process has it open), flock (not locked), mtime (unmodified for --stability_mtime_age) or marker (--stability_marker exists)")
+	stabilityMtimeAge = flag.Duration("stability_mtime_age", 30*time.Second, "With --stability_strategy=mtime, how long a file must go unmodified")
+	stabilityInterval = flag.Duration("stability_interval", time.Second, "How often the size, open, flock and marker checks look at a file")
+	stabilityChecks   = flag.Int("stability_checks", 10, "With --stability_strategy=size, how many looks in a row a file's size must be unchanged")
+	stabilityTimeout  = flag.Duration("stability_timeout", 0, "Give up waiting for a file to be complete after this long, retrying it later as a failed upload (0 waits forever)")
+	stabilityMarker   = flag.String("stability_marker", "{}.done", "With --stability_strategy=marker, the file a scanner writes once it is done, with {} standing for the file's path")
+)
+
+// stabilityFromFlags returns the Stability --stability_strategy describes.
+func stabilityFromFlags() (uploader.Stability, error) {
+	var all uploader.AllStable
+	for _, name := range strings.Split(*stabilityStrategy, ",") {
+		switch strings.TrimSpace(name) {
+		case "size":
+			all = append(all, uploader.SizeStable{Interval: *stabilityInterval, Checks: *stabilityChecks})
+		case "open":
+			all = append(all, uploader.NoOpenHandles{Interval: *stabilityInterval})
+		case "flock":
+			all = append(all, uploader.Unlocked{Interval: *stabilityInterval})
+		case "mtime":
+			all = append(all, uploader.MtimeAge{Age: *stabilityMtimeAge})
+		case "marker":
+			if !strings.Contains(*stabilityMarker, "{}") {
+				return nil, fmt.Errorf("--stability_marker %q has no {}", *stabilityMarker)
+			}
+			all = append(all, uploader.Marker{Pattern: *stabilityMarker, Interval: *stabilityInterval})
+		default:
+			return nil, fmt.Errorf("unknown --stability_strategy %q", name)
+		}
+	}
+	if len(all) == 1 {
+		return all[0], nil
+	}
+	return all, nil
+}
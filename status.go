@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/uploader"
+	"github.com/dustin/go-humanize"
+)
+
+// status implements the "status" command, which prints what a running
+// daemon is doing, as served on its /status page.
+func status(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	addr := fs.String("addr", "", "Address of the daemon's --http_addr or --probe_addr (default from those flags)")
+	raw := fs.Bool("json", false, "Print the status JSON as served")
+	fs.Parse(args)
+	if *addr == "" {
+		if *addr = *httpAddr; *addr == "" {
+			*addr = *probeAddr
+		}
+	}
+	if *addr == "" {
+		return errors.New("--addr (or --http_addr) is required")
+	}
+	host, port, err := net.SplitHostPort(*addr)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %w", *addr, err)
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	req, err := http.NewRequest("GET", "http://"+net.JoinHostPort(host, port)+"/status", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("unable to reach the daemon: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to get status: %s", resp.Status)
+	}
+	if *raw {
+		_, err := io.Copy(os.Stdout, resp.Body)
+		return err
+	}
+	var st struct {
+		Standby   bool              `json:"standby"`
+		Stopping  bool              `json:"stopping"`
+		Uploaders []uploader.Status `json:"uploaders"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		return fmt.Errorf("unable to parse status: %w", err)
+	}
+	switch {
+	case st.Stopping:
+		fmt.Println("Stopping")
+	case st.Standby:
+		fmt.Println("Standby (another replica is uploading)")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, u := range st.Uploaders {
+		state := "starting"
+		if u.Ready {
+			state = "watching"
+		}
+		last := "never"
+		if u.LastUpload != nil {
+			last = humanize.Time(*u.LastUpload)
+		}
+		if u.HeldUntil != nil {
+			state += ", held by the schedule until " + u.HeldUntil.Local().Format("Mon 15:04")
+		}
+		fmt.Fprintf(w, "%s -> %s\t%s\tlast upload %s\n", u.InputDir, u.OutputDir, state, last)
+		for _, t := range u.Uploading {
+			fmt.Fprintf(w, "  uploading %s\t%s/%s (%.0f%%)\tfor %s\n", t.File, humanize.Bytes(uint64(t.Bytes)), humanize.Bytes(uint64(t.Size)), t.Percent, time.Since(t.Started).Round(time.Second))
+		}
+		for _, f := range u.Queued {
+			fmt.Fprintf(w, "  queued %s\n", f)
+		}
+		for _, f := range u.Parked {
+			fmt.Fprintf(w, "  parked %s\n", f)
+		}
+		for _, f := range u.Offline {
+			fmt.Fprintf(w, "  offline %s\n", f)
+		}
+		for _, f := range u.Undeletable {
+			fmt.Fprintf(w, "  undeletable %s\n", f)
+		}
+		for _, a := range u.Accounts {
+			switch {
+			case a.TokenError != "":
+				fmt.Fprintf(w, "  account %s\ttoken error: %s\n", a.Name, a.TokenError)
+			case a.TokenExpiry != nil:
+				fmt.Fprintf(w, "  account %s\ttoken expires %s\n", a.Name, humanize.Time(*a.TokenExpiry))
+			}
+		}
+	}
+	return w.Flush()
+}
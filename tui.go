@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+
+	"github.com/dknowles2/gdrive_sync/tui"
+	"github.com/dknowles2/gdrive_sync/uploader"
+)
+
+var tuiMode = flag.Bool("tui", false, "When stdout is a terminal, show a live view of queued and running uploads with progress bars, speeds and recent results, like rclone's --progress. Applies to run, upload and reupload")
+
+// view is the live view started by --tui, or nil.
+var view *tui.View
+
+// viewCommands are the commands that upload files, for which --tui draws
+// a view.
+var viewCommands = map[string]bool{"run": true, "upload": true, "reupload": true}
+
+// startView starts the --tui view for the command name, if it applies,
+// returning where logs should go: above the view, when they share its
+// terminal.
+func startView(name string) (io.Writer, error) {
+	if !*tuiMode || !viewCommands[name] || !tui.IsTerminal(os.Stdout) {
+		return os.Stderr, nil
+	}
+	if *eventsFormat != "" || *eventsFile == "-" {
+		return nil, errors.New("--tui can't be combined with events on stdout")
+	}
+	view = tui.New(os.Stdout)
+	if tui.IsTerminal(os.Stderr) {
+		return view.Logs(os.Stderr), nil
+	}
+	return os.Stderr, nil
+}
+
+// watchUploaders shows the status of us in the view, if there is one.
+func watchUploaders(us []*uploader.Uploader) {
+	if view == nil {
+		return
+	}
+	if us == nil {
+		view.Watch(nil)
+		return
+	}
+	view.Watch(func() []uploader.Status {
+		sts := make([]uploader.Status, len(us))
+		for i, u := range us {
+			sts[i] = u.Status()
+		}
+		return sts
+	})
+}
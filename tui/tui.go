@@ -0,0 +1,311 @@
+// Package tui draws a live view of what the uploader is doing on a terminal:
+// the uploads in progress with progress bars and speeds, what is queued, and
+// the most recent results, with the log scrolling above it.
+package tui
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/events"
+	"github.com/dknowles2/gdrive_sync/uploader"
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	// refreshInterval is how often the view is redrawn.
+	refreshInterval = 500 * time.Millisecond
+	// rateWindow is how far back upload speeds are measured.
+	rateWindow = 10 * time.Second
+
+	// How much of each list is shown.
+	maxUploading = 10
+	maxQueued    = 5
+	maxRecent    = 5
+
+	barWidth = 20
+)
+
+type sample struct {
+	at    time.Time
+	bytes int64
+}
+
+// View is a live status display on a terminal. It is an events.Sink, from
+// which it learns of completed uploads; the rest comes from polling the
+// uploaders' status.
+type View struct {
+	out *os.File
+
+	mu      sync.Mutex
+	status  func() []uploader.Status
+	frame   []string // last drawn
+	lines   int      // lines of the frame on screen
+	recent  []events.Event
+	samples map[string][]sample
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// New starts drawing a view on out, which should be a terminal (see
+// IsTerminal).
+func New(out *os.File) *View {
+	v := &View{
+		out:     out,
+		samples: make(map[string][]sample),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go v.run()
+	return v
+}
+
+// Watch makes the view show what status reports, replacing what it showed
+// before. A nil status shows nothing.
+func (v *View) Watch(status func() []uploader.Status) {
+	v.mu.Lock()
+	v.status = status
+	v.mu.Unlock()
+}
+
+func (v *View) Emit(e events.Event) {
+	if e.Type != events.Uploaded && e.Type != events.Failed {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.recent = append(v.recent, e)
+	if len(v.recent) > maxRecent {
+		v.recent = v.recent[len(v.recent)-maxRecent:]
+	}
+}
+
+// Close stops redrawing the view, leaving its last frame on the screen.
+func (v *View) Close() {
+	close(v.done)
+	<-v.stopped
+	v.mu.Lock()
+	v.lines = 0
+	v.mu.Unlock()
+}
+
+// Logs returns a writer for log output to w, the same terminal as the view,
+// that scrolls the log above the view rather than over it.
+func (v *View) Logs(w io.Writer) io.Writer {
+	return &logWriter{v: v, w: w}
+}
+
+type logWriter struct {
+	v *View
+	w io.Writer
+}
+
+func (l *logWriter) Write(p []byte) (int, error) {
+	l.v.mu.Lock()
+	defer l.v.mu.Unlock()
+	l.v.eraseLocked()
+	n, err := l.w.Write(p)
+	if len(p) > 0 && p[len(p)-1] != '\n' {
+		io.WriteString(l.w, "\n")
+	}
+	l.v.drawLocked()
+	return n, err
+}
+
+func (v *View) run() {
+	defer close(v.stopped)
+	t := time.NewTicker(refreshInterval)
+	defer t.Stop()
+	for {
+		v.refresh()
+		select {
+		case <-t.C:
+		case <-v.done:
+			v.refresh()
+			return
+		}
+	}
+}
+
+// refresh polls the status and redraws the view.
+func (v *View) refresh() {
+	v.mu.Lock()
+	status := v.status
+	v.mu.Unlock()
+	// Polled without v.mu held, since the uploaders may log meanwhile.
+	var sts []uploader.Status
+	if status != nil {
+		sts = status()
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	frame := v.render(sts, time.Now())
+	for i, line := range frame {
+		frame[i] = truncate(strings.Replace(line, "\n", " ", -1), width(v.out)-1)
+	}
+	v.eraseLocked()
+	v.frame = frame
+	v.drawLocked()
+}
+
+// eraseLocked clears the frame from the screen. v.mu must be held.
+func (v *View) eraseLocked() {
+	if v.lines > 0 {
+		fmt.Fprintf(v.out, "\r\x1b[%dA\x1b[J", v.lines)
+		v.lines = 0
+	}
+}
+
+// drawLocked prints the last frame. v.mu must be held.
+func (v *View) drawLocked() {
+	if v.lines > 0 || len(v.frame) == 0 {
+		return
+	}
+	io.WriteString(v.out, strings.Join(v.frame, "\n")+"\n")
+	v.lines = len(v.frame)
+}
+
+// render returns the lines of the view. v.mu must be held, since it
+// updates the speed samples.
+func (v *View) render(sts []uploader.Status, now time.Time) []string {
+	var uploading []uploader.Transfer
+	var queued []string
+	var parked, offline int
+	var held time.Time
+	for _, st := range sts {
+		if st.HeldUntil != nil {
+			held = *st.HeldUntil
+		}
+		uploading = append(uploading, st.Uploading...)
+		queued = append(queued, st.Queued...)
+		parked += len(st.Parked)
+		offline += len(st.Offline)
+	}
+
+	rates := make(map[string]float64)
+	var total float64
+	seen := make(map[string]bool)
+	for _, t := range uploading {
+		seen[t.File] = true
+		s := append(v.samples[t.File], sample{now, t.Bytes})
+		for len(s) > 2 && now.Sub(s[1].at) >= rateWindow {
+			s = s[1:]
+		}
+		v.samples[t.File] = s
+		if d := s[len(s)-1].at.Sub(s[0].at).Seconds(); d > 0 {
+			rates[t.File] = float64(s[len(s)-1].bytes-s[0].bytes) / d
+			total += rates[t.File]
+		}
+	}
+	for f := range v.samples {
+		if !seen[f] {
+			delete(v.samples, f)
+		}
+	}
+
+	var lines []string
+	if len(uploading) == 0 && len(queued) == 0 {
+		lines = append(lines, fmt.Sprintf("Idle: watching %s", plural(len(sts), "directory", "directories")))
+	} else {
+		lines = append(lines, fmt.Sprintf("Uploading %s at %s/s, %d queued", plural(len(uploading), "file", "files"), humanize.Bytes(uint64(total)), len(queued)))
+	}
+	if parked > 0 {
+		lines[0] += fmt.Sprintf(", %d waiting to retry", parked)
+	}
+	if offline > 0 {
+		lines[0] += fmt.Sprintf(", %d waiting for the network", offline)
+	}
+	if !held.IsZero() {
+		lines[0] += ", held by the schedule until " + held.Local().Format("Mon 15:04")
+	}
+	for i, t := range uploading {
+		if i == maxUploading {
+			lines = append(lines, fmt.Sprintf("  ...and %d more", len(uploading)-i))
+			break
+		}
+		lines = append(lines, "  "+transferLine(t, rates[t.File]))
+	}
+	if len(queued) > 0 {
+		names := make([]string, 0, maxQueued)
+		for i, f := range queued {
+			if i == maxQueued {
+				break
+			}
+			names = append(names, filepath.Base(f))
+		}
+		line := "Queued: " + strings.Join(names, ", ")
+		if len(queued) > maxQueued {
+			line += fmt.Sprintf(" and %d more", len(queued)-maxQueued)
+		}
+		lines = append(lines, line)
+	}
+	if len(v.recent) > 0 {
+		lines = append(lines, "Recent:")
+		for i := len(v.recent) - 1; i >= 0; i-- {
+			lines = append(lines, "  "+resultLine(v.recent[i]))
+		}
+	}
+	return lines
+}
+
+// transferLine describes an upload in progress, sending rate bytes a
+// second.
+func transferLine(t uploader.Transfer, rate float64) string {
+	done := 0
+	var pct float64
+	if t.Size > 0 {
+		pct = float64(t.Bytes) * 100 / float64(t.Size)
+		done = int(float64(barWidth) * float64(t.Bytes) / float64(t.Size))
+		if done > barWidth {
+			done = barWidth
+		}
+	}
+	bar := strings.Repeat("#", done) + strings.Repeat(".", barWidth-done)
+	line := fmt.Sprintf("[%s] %3.0f%%  %s/%s", bar, pct, humanize.Bytes(uint64(t.Bytes)), humanize.Bytes(uint64(t.Size)))
+	if rate > 0 {
+		line += fmt.Sprintf("  %s/s", humanize.Bytes(uint64(rate)))
+		if left := t.Size - t.Bytes; left > 0 {
+			eta := time.Duration(float64(left) / rate * float64(time.Second))
+			line += fmt.Sprintf("  %s left", eta.Round(time.Second))
+		}
+	}
+	return line + "  " + filepath.Base(t.File)
+}
+
+// resultLine describes a finished upload.
+func resultLine(e events.Event) string {
+	at := e.Time.Local().Format("15:04:05")
+	if e.Type == events.Failed {
+		return fmt.Sprintf("failed    %s  %s: %s", at, filepath.Base(e.File), e.Error)
+	}
+	line := fmt.Sprintf("uploaded  %s  %s", at, filepath.Base(e.File))
+	if e.Size > 0 {
+		line += "  " + humanize.Bytes(uint64(e.Size))
+	}
+	return line
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return "1 " + one
+	}
+	return fmt.Sprintf("%d %s", n, many)
+}
+
+// truncate cuts s to n runes, so that no line wraps.
+func truncate(s string, n int) string {
+	if n <= 0 {
+		return s
+	}
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
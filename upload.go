@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+)
+
+// upload implements the "upload" command, a one-shot run of the daemon: it
+// uploads the files already in --input_dir, or those given, with the same
+// settings, and exits once they are done. It is for cron jobs and manual
+// pushes.
+func upload(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("upload", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: upload [<file-or-dir>...]")
+		fmt.Fprintln(fs.Output(), "With no arguments, uploads what is in --input_dir (every --config pair). Files are removed once uploaded unless --delete=false.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	var files []string
+	if fs.NArg() > 0 {
+		var err error
+		if files, err = expandPaths(fs.Args()); err != nil {
+			return err
+		}
+	}
+
+	us, cleanup, err := newUploaders(ctx)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if len(files) > 0 {
+		// With --config, files go to the first pair's folder.
+		us = us[:1]
+	}
+	var first error
+	for _, u := range us {
+		if err := u.UploadExisting(ctx, files...); err != nil && first == nil {
+			first = err
+		}
+	}
+	if first == nil {
+		log.Printf("Done")
+	}
+	return first
+}
@@ -0,0 +1,150 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/gdrive"
+	"github.com/dustin/go-humanize"
+)
+
+// Account is an additional Google account uploads may be spread across.
+// Each account needs its own copy of the output folder.
+type Account struct {
+	Name  string
+	Drive *gdrive.Drive
+}
+
+// Account selection policies.
+const (
+	// RoundRobin sends each upload to the next account in turn.
+	RoundRobin = "round_robin"
+	// Fill uses each account until its storage use passes the fill
+	// threshold, then moves on to the next.
+	Fill = "fill"
+)
+
+// DefaultAccount names the account the Uploader was created with.
+const DefaultAccount = "default"
+
+// quotaRefresh is how long storage usage reported by Drive is trusted before
+// it is fetched again. Uploads in between are added to it locally.
+const quotaRefresh = 10 * time.Minute
+
+type account struct {
+	name     string
+	drive    *gdrive.Drive
+	folderId string
+
+	// foldersMu guards folders, the IDs of subfolders of folderId and the
+	// route folders by parent ID and slash-separated path.
+	foldersMu sync.Mutex
+	folders   map[string]string
+
+	// routeFolders are the IDs of the Route folders, by Folder.
+	routeFolders map[string]string
+
+	// Guarded by accountPool.mu.
+	usage, limit int64
+	checked      time.Time
+}
+
+// accountPool picks which account each upload goes to.
+type accountPool struct {
+	policy    string
+	threshold float64
+
+	mu       sync.Mutex
+	accounts []*account
+	next     int
+}
+
+func newAccount(name string, d *gdrive.Drive, folderId string) *account {
+	return &account{name: name, drive: d, folderId: folderId, folders: make(map[string]string)}
+}
+
+func newAccountPool(primary *account, extra []Account, out string, routes []string, create bool, policy string, threshold float64) (*accountPool, error) {
+	p := &accountPool{policy: policy, threshold: threshold, accounts: []*account{primary}}
+	for _, a := range extra {
+		folderId, err := outputFolderId(a.Drive, out, create)
+		if err != nil {
+			return nil, fmt.Errorf("account %s: %w", a.Name, err)
+		}
+		p.accounts = append(p.accounts, newAccount(a.Name, a.Drive, folderId))
+	}
+	for _, a := range p.accounts {
+		var err error
+		if a.routeFolders, err = gdrive.FolderIds(context.Background(), a.drive, routes, create); err != nil {
+			return nil, fmt.Errorf("account %s: route folder: %w", a.name, err)
+		}
+	}
+	switch policy {
+	case "", RoundRobin, Fill:
+	default:
+		return nil, fmt.Errorf("unknown account policy %q", policy)
+	}
+	return p, nil
+}
+
+// outputFolderId returns the ID of the Drive folder out, creating it first
+// if create is set.
+func outputFolderId(d *gdrive.Drive, out string, create bool) (string, error) {
+	if create {
+		return gdrive.EnsureFolderId(context.Background(), d, out)
+	}
+	return gdrive.GetFolderId(d, out)
+}
+
+// drive reports whether files are uploaded to Drive at all.
+func (p *accountPool) drive() bool {
+	return len(p.accounts) > 0
+}
+
+// multi reports whether uploads are being spread across several accounts.
+func (p *accountPool) multi() bool {
+	return len(p.accounts) > 1
+}
+
+// pick returns the account to upload a file of the given size to.
+func (p *accountPool) pick(ctx context.Context, size int64) *account {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.multi() {
+		return p.accounts[0]
+	}
+	if p.policy != Fill {
+		a := p.accounts[p.next%len(p.accounts)]
+		p.next++
+		return a
+	}
+	for _, a := range p.accounts {
+		p.refreshLocked(ctx, a)
+		if a.limit <= 0 || float64(a.usage+size) <= p.threshold*float64(a.limit) {
+			a.usage += size
+			return a
+		}
+	}
+	last := p.accounts[len(p.accounts)-1]
+	warnf(ctx, "every account is over %.0f%% full; uploading to %s", p.threshold*100, last.name)
+	last.usage += size
+	return last
+}
+
+// refreshLocked fetches the storage quota for a if it is stale. Errors keep
+// the previous figures.
+func (p *accountPool) refreshLocked(ctx context.Context, a *account) {
+	if time.Since(a.checked) < quotaRefresh {
+		return
+	}
+	about, err := a.drive.About.Get().Fields("storageQuota").Context(ctx).Do()
+	if err != nil {
+		errorf(ctx, "failed to get storage quota for account %s: %s", a.name, err)
+		return
+	}
+	a.usage, a.limit, a.checked = about.StorageQuota.Usage, about.StorageQuota.Limit, time.Now()
+	if a.limit > 0 {
+		infof(ctx, "Account %s is using %s of %s", a.name, humanize.IBytes(uint64(a.usage)), humanize.IBytes(uint64(a.limit)))
+	}
+}
@@ -0,0 +1,180 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/events"
+	"github.com/dknowles2/gdrive_sync/gdrive"
+)
+
+// archiveMaxSuffix bounds the search for a free name in the archive or the
+// failed directory.
+const archiveMaxSuffix = 10000
+
+// dispose gets an uploaded file out of the input directory, by moving it to
+// the archive if one is configured and deleting it otherwise.
+func (u *Uploader) dispose(ctx context.Context, f string) error {
+	if u.opts.ArchiveDir == "" {
+		return os.Remove(f)
+	}
+	dst, err := u.archive(f)
+	if err != nil {
+		return err
+	}
+	infof(ctx, "Archived %s to %s", f, dst)
+	u.emit(ctx, events.Event{Type: events.Archived, File: f})
+	return nil
+}
+
+// archiveDirFor returns the directory f is moved to: its subdirectory
+// within the input tree under ArchiveDir, below a folder named for the
+// current date if ArchiveLayout is set.
+func (u *Uploader) archiveDirFor(f string) string {
+	return u.archiveDirAt(u.opts.ArchiveDir, f, time.Now())
+}
+
+// archiveDirAt returns the directory under root that f was archived into
+// at t.
+func (u *Uploader) archiveDirAt(root, f string, t time.Time) string {
+	dir := root
+	if u.opts.ArchiveLayout != "" {
+		dir = filepath.Join(dir, filepath.FromSlash(t.Format(u.opts.ArchiveLayout)))
+	}
+	if rel := u.relDir(f); rel != "" && !u.opts.Flatten {
+		dir = filepath.Join(dir, filepath.FromSlash(rel))
+	}
+	return dir
+}
+
+// archive moves f into the archive, returning where it ended up.
+func (u *Uploader) archive(f string) (string, error) {
+	return moveInto(u.archiveDirFor(f), f)
+}
+
+// FindArchived returns the archived copy of f, uploaded at t with MD5 sum,
+// in the archive at root (ArchiveDir if it's empty). It looks where the
+// upload put it, under its own name or with the suffix added for a clash.
+func (u *Uploader) FindArchived(root, f string, t time.Time, sum string) (string, error) {
+	if root == "" {
+		root = u.opts.ArchiveDir
+	}
+	dir := u.archiveDirAt(root, f, t)
+	base := filepath.Base(f)
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	for i := 0; i < archiveMaxSuffix; i++ {
+		name := base
+		if i > 0 {
+			name = fmt.Sprintf("%s (%d)%s", stem, i, ext)
+		}
+		p := filepath.Join(dir, name)
+		got, err := gdrive.FileMD5(p)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if sum == "" || got == sum {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("no archived copy of %s with the recorded checksum in %s", base, dir)
+}
+
+// moveInto moves f into dir, creating it if needed, without overwriting
+// anything already there: " (N)" is added before the extension until the
+// name is free. It returns where the file ended up.
+func moveInto(dir, f string) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+	base := filepath.Base(f)
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	for i := 0; i < archiveMaxSuffix; i++ {
+		name := base
+		if i > 0 {
+			name = fmt.Sprintf("%s (%d)%s", stem, i, ext)
+		}
+		dst := filepath.Join(dir, name)
+		if _, err := os.Lstat(dst); !os.IsNotExist(err) {
+			continue
+		}
+		err := moveFile(f, dst)
+		if os.IsExist(err) {
+			continue
+		}
+		return dst, err
+	}
+	return "", fmt.Errorf("no free name for %s in %s", base, dir)
+}
+
+// moveFile renames src to dst, falling back to copying and deleting when
+// they are on different file systems. The copy never replaces an existing
+// dst; the rename can only race with another writer in the archive.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	} else if _, serr := os.Lstat(src); serr != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	os.Chtimes(dst, fi.ModTime(), fi.ModTime())
+	in.Close()
+	if err := os.Remove(src); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+// Owns reports whether f is in the Uploader's input directory, a mount it
+// watches, or its archive.
+func (u *Uploader) Owns(f string) bool {
+	if within(u.rootOf(filepath.Dir(f)), f) {
+		return true
+	}
+	return u.opts.ArchiveDir != "" && within(u.opts.ArchiveDir, f)
+}
+
+// within reports whether path is root or somewhere below it.
+func within(root, path string) bool {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return false
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,46 @@
+package uploader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/gdrive"
+)
+
+func TestFindArchived(t *testing.T) {
+	archive, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(archive)
+	u, _, in, _ := newTestUploader(t, Options{ArchiveDir: archive, ArchiveLayout: "2006/01"})
+	f := filepath.Join(in, "sub", "scan.pdf")
+	at := time.Date(2024, 5, 17, 12, 0, 0, 0, time.Local)
+
+	// Another scan.pdf got there first, so this one was archived with a
+	// suffix.
+	dir := filepath.Join(archive, "2024", "05", "sub")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, dir, "scan.pdf", []byte("the other scan"))
+	want := writeTestFile(t, dir, "scan (1).pdf", []byte("this scan"))
+	sum, err := gdrive.FileMD5(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := u.FindArchived("", f, at, sum)
+	if err != nil {
+		t.Fatalf("FindArchived: %s", err)
+	}
+	if got != want {
+		t.Errorf("FindArchived = %s, want %s", got, want)
+	}
+	if _, err := u.FindArchived("", f, at.AddDate(0, 1, 0), sum); err == nil {
+		t.Error("FindArchived found a copy in the next month's folder")
+	}
+}
@@ -0,0 +1,72 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// Backend is storage other than Drive, such as an S3 bucket, that every
+// file sent to Drive is also copied to. Like destinations, a file is only
+// finished once every backend has it.
+type Backend interface {
+	// Name identifies the backend in logs and the journal.
+	Name() string
+	// Put stores size bytes read from r at the slash-separated key and
+	// returns where they were stored. Errors with a Temporary method
+	// returning true are retried like transient Drive errors.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (string, error)
+}
+
+// putBackend copies the file name to b, at the path it has beneath the
+// output folder, returning where it was stored.
+func (u *Uploader) putBackend(ctx context.Context, b Backend, name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	dir, base, err := u.remotePath(name, fi)
+	if err != nil {
+		return "", err
+	}
+	contentType, err := detectMimeType(f, name)
+	if err != nil {
+		return "", err
+	}
+	infof(ctx, "Uploading file: %s to %s", name, b.Name())
+	t := u.startTransfer(name, fi.Size())
+	defer u.endTransfer(name)
+	progressed(ctx)
+	loc, err := b.Put(ctx, path.Join(dir, base), &transferReader{ctx: ctx, r: f, u: u, t: t}, fi.Size(), contentType)
+	if err != nil {
+		return "", fmt.Errorf("uploading to %s: %w", b.Name(), err)
+	}
+	infof(ctx, "Uploaded %s to %s", name, loc)
+	return loc, nil
+}
+
+// transferReader records how much of a transfer has been read.
+type transferReader struct {
+	ctx context.Context
+	r   io.Reader
+	u   *Uploader
+	t   *Transfer
+	n   int64
+}
+
+func (tr *transferReader) Read(p []byte) (int, error) {
+	n, err := tr.r.Read(p)
+	tr.n += int64(n)
+	tr.u.updateTransfer(tr.t, tr.n)
+	if n > 0 {
+		progressed(tr.ctx)
+	}
+	return n, err
+}
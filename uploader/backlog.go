@@ -0,0 +1,62 @@
+package uploader
+
+import "context"
+
+// backlogged is a file found while MaxQueuedFiles others were already in the
+// pipeline, waiting for room.
+type backlogged struct {
+	ctx  context.Context
+	name string
+}
+
+// start takes name through the pipeline in the background, or backlogs it
+// if MaxQueuedFiles files are already in it. Only the name of a backlogged
+// file is kept until there is room, not the state of waiting for it to
+// stabilize and uploading it.
+func (u *Uploader) start(ctx context.Context, name string) {
+	u.mu.Lock()
+	if max := u.opts.MaxQueuedFiles; max > 0 {
+		if u.queued >= max {
+			if key := pathKey(name); !u.backlogged[key] {
+				u.backlogged[key] = true
+				u.backlog = append(u.backlog, backlogged{ctx, name})
+			}
+			u.mu.Unlock()
+			return
+		}
+		u.queued++
+	}
+	u.uploads.Add(1)
+	u.mu.Unlock()
+	go func() {
+		defer u.uploads.Done()
+		u.upload(u.uploadContext(ctx), name)
+		u.next()
+	}()
+}
+
+// next gives up a finished file's room in the pipeline to the oldest
+// backlogged file.
+func (u *Uploader) next() {
+	if u.opts.MaxQueuedFiles <= 0 {
+		return
+	}
+	u.mu.Lock()
+	u.queued--
+	var b backlogged
+	found := false
+	for len(u.backlog) > 0 && !found {
+		b = u.backlog[0]
+		u.backlog = u.backlog[1:]
+		delete(u.backlogged, pathKey(b.name))
+		// Files found before shutting down are left for the next run.
+		found = b.ctx.Err() == nil
+	}
+	if len(u.backlog) == 0 {
+		u.backlog = nil
+	}
+	u.mu.Unlock()
+	if found {
+		u.start(b.ctx, b.name)
+	}
+}
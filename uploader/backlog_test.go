@@ -0,0 +1,28 @@
+package uploader
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBacklog(t *testing.T) {
+	u, _, in, _ := newTestUploader(t, Options{MaxQueuedFiles: 1})
+	f := writeTestFile(t, in, "scan.pdf", []byte("scan"))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// With the pipeline full, files wait by name, each once.
+	u.queued = 1
+	u.start(ctx, f)
+	u.start(ctx, f)
+	if len(u.backlog) != 1 {
+		t.Fatalf("backlogged %d files, want 1", len(u.backlog))
+	}
+
+	// Room made while shutting down isn't given to the backlog.
+	cancel()
+	u.next()
+	u.uploads.Wait()
+	if u.queued != 0 || len(u.backlog) != 0 || len(u.backlogged) != 0 {
+		t.Errorf("after shutting down, %d files are queued and %d backlogged, want none", u.queued, len(u.backlog))
+	}
+}
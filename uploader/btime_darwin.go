@@ -0,0 +1,16 @@
+package uploader
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// birthTime returns when the file described by fi was created.
+func birthTime(_ string, fi os.FileInfo) (time.Time, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(st.Birthtimespec.Unix()), true
+}
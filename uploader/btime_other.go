@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package uploader
+
+import (
+	"os"
+	"time"
+)
+
+// birthTime reports that creation times aren't known on this platform.
+func birthTime(string, os.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
@@ -0,0 +1,16 @@
+package uploader
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// birthTime returns when the file described by fi was created.
+func birthTime(_ string, fi os.FileInfo) (time.Time, bool) {
+	d, ok := fi.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, d.CreationTime.Nanoseconds()), true
+}
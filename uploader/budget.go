@@ -0,0 +1,123 @@
+package uploader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/events"
+	"github.com/dknowles2/gdrive_sync/statefile"
+	"github.com/dustin/go-humanize"
+)
+
+// Budget limits how many bytes are uploaded per calendar month. Usage is
+// saved to a state file so restarts don't reset it. One Budget may be
+// shared by several Uploaders.
+type Budget struct {
+	limit int64
+	path  string
+
+	mu     sync.Mutex
+	state  budgetState
+	paused bool
+}
+
+type budgetState struct {
+	Month string `json:"month"`
+	Bytes int64  `json:"bytes"`
+}
+
+func monthOf(t time.Time) string {
+	return t.Format("2006-01")
+}
+
+// nextMonth returns the start of the month after t, in t's location.
+func nextMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+}
+
+// NewBudget returns a Budget of limit bytes per month, keeping its usage in
+// the file at path unless path is empty.
+func NewBudget(limit int64, path string) (*Budget, error) {
+	b := &Budget{limit: limit, path: path}
+	if path == "" {
+		return b, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read transfer state: %w", err)
+	}
+	if err := json.Unmarshal(data, &b.state); err != nil {
+		return nil, fmt.Errorf("unable to parse transfer state %s: %w", path, err)
+	}
+	return b, nil
+}
+
+// usedLocked returns the bytes uploaded so far this month.
+func (b *Budget) usedLocked(now time.Time) int64 {
+	if b.state.Month != monthOf(now) {
+		b.state = budgetState{Month: monthOf(now)}
+	}
+	return b.state.Bytes
+}
+
+// waitForBudget blocks while uploading size more bytes would exceed this month's cap.
+// A file larger than the whole cap is let through at the start of a month,
+// since it would otherwise never upload.
+func (u *Uploader) waitForBudget(ctx context.Context, f string, size int64) error {
+	b := u.opts.Budget
+	for {
+		now := time.Now()
+		b.mu.Lock()
+		used := b.usedLocked(now)
+		if used == 0 || used+size <= b.limit {
+			if b.paused {
+				infof(ctx, "Monthly transfer cap reset; resuming uploads")
+				b.paused = false
+			}
+			b.mu.Unlock()
+			return nil
+		}
+		resume := nextMonth(now)
+		if !b.paused {
+			b.paused = true
+			errorf(ctx, "ALERT: monthly transfer cap of %s reached (%s used); pausing uploads until %s",
+				humanize.IBytes(uint64(b.limit)), humanize.IBytes(uint64(used)), resume.Format(time.RFC3339))
+			u.emit(ctx, events.Event{Type: events.Paused, File: f, Bytes: used, Size: b.limit})
+		}
+		b.mu.Unlock()
+		// Check at least hourly in case the clock jumps.
+		d := time.Until(resume)
+		if d > time.Hour {
+			d = time.Hour
+		}
+		if err := sleep(ctx, d); err != nil {
+			return err
+		}
+	}
+}
+
+// spend records that n bytes were uploaded.
+func (b *Budget) spend(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.usedLocked(time.Now())
+	b.state.Bytes += n
+	if b.path == "" {
+		return
+	}
+	data, err := json.Marshal(b.state)
+	if err == nil {
+		err = statefile.Write(b.path, data, 0644)
+	}
+	if err != nil {
+		errorf(context.Background(), "failed to save transfer state: %s", err)
+	}
+}
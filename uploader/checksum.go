@@ -0,0 +1,52 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+)
+
+// checksumAttempts is how many times a file is sent before giving up when
+// the copy Drive stored doesn't match it.
+const checksumAttempts = 3
+
+// checksumError means Drive stored different bytes than were read locally.
+type checksumError struct {
+	local, remote string
+}
+
+func (e *checksumError) Error() string {
+	return fmt.Sprintf("Drive checksum %s does not match local checksum %s", e.remote, e.local)
+}
+
+// verifiedUpload uploads f, to dest if it is set, and checks the MD5 Drive computed against the one
+// computed while reading it, replacing a mismatched copy until they agree.
+// The local file is only removed after an upload that passes.
+func (u *Uploader) verifiedUpload(ctx context.Context, f string, dest *account) (*uploaded, error) {
+	for i := 1; ; i++ {
+		r, err := u.doUpload(ctx, f, dest)
+		if err != nil {
+			return nil, err
+		}
+		if r.file.Md5Checksum == "" {
+			if r.md5 != "" {
+				warnf(ctx, "Drive returned no checksum for %s; not verified", f)
+			}
+			return r, nil
+		}
+		if r.file.Md5Checksum == r.md5 {
+			return r, nil
+		}
+		err = &checksumError{local: r.md5, remote: r.file.Md5Checksum}
+		errorf(ctx, "upload of %s is corrupt: %s", f, err)
+		// A replaced file gets its contents replaced again on the next try.
+		if !r.replaced {
+			if derr := r.account.drive.Files.Delete(r.file.Id).SupportsAllDrives(true).Context(ctx).Do(); derr != nil {
+				errorf(ctx, "failed to delete corrupt copy %s of %s: %s", r.file.Id, f, derr)
+			}
+		}
+		if i == checksumAttempts {
+			return nil, err
+		}
+		infof(ctx, "Uploading %s again (attempt %d of %d)", f, i+1, checksumAttempts)
+	}
+}
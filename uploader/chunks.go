@@ -0,0 +1,113 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Bounds and targets for adaptive chunk sizing. Chunk sizes must be
+// multiples of 256KiB.
+const (
+	minChunkSize     = 256 << 10
+	initialChunkSize = 8 << 20
+	maxChunkSize     = 128 << 20
+
+	// A chunk finishing faster than this means the connection could carry
+	// more per request; slower than slowChunk means a stall would cost a
+	// lot of re-sent data.
+	fastChunk = 3 * time.Second
+	slowChunk = 20 * time.Second
+
+	// chunkSamples is how many chunks are measured at a size before it is
+	// changed.
+	chunkSamples = 3
+)
+
+// chunkTuner picks the resumable upload chunk size from the throughput
+// measured on previous chunks. It is shared by all uploads, since they share
+// the same connection.
+type chunkTuner struct {
+	mu      sync.Mutex
+	size    int
+	samples []time.Duration
+}
+
+func newChunkTuner() *chunkTuner {
+	return &chunkTuner{size: initialChunkSize}
+}
+
+func (t *chunkTuner) chunkSize() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.size
+}
+
+// observe records that a chunk of the given size took d to upload, growing
+// the chunk size when chunks are consistently fast and shrinking it when
+// they are slow.
+func (t *chunkTuner) observe(size int, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if size != t.size {
+		// Measured under an earlier setting; ignore.
+		return
+	}
+	t.samples = append(t.samples, d)
+	if len(t.samples) < chunkSamples {
+		return
+	}
+	mean, cv := meanAndCV(t.samples)
+	t.samples = nil
+	switch {
+	case mean < fastChunk && cv < 0.5 && t.size < maxChunkSize:
+		t.setLocked(t.size * 2)
+	case mean > slowChunk && t.size > minChunkSize:
+		t.setLocked(t.size / 2)
+	}
+}
+
+// timedOut shrinks the chunk size after an upload failed with a timeout.
+func (t *chunkTuner) timedOut() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.samples = nil
+	if t.size > minChunkSize {
+		t.setLocked(t.size / 2)
+	}
+}
+
+func (t *chunkTuner) setLocked(size int) {
+	infof(context.Background(), "Adjusting upload chunk size from %s to %s", humanize.IBytes(uint64(t.size)), humanize.IBytes(uint64(size)))
+	t.size = size
+}
+
+func meanAndCV(ds []time.Duration) (time.Duration, float64) {
+	var sum float64
+	for _, d := range ds {
+		sum += float64(d)
+	}
+	mean := sum / float64(len(ds))
+	var sq float64
+	for _, d := range ds {
+		sq += (float64(d) - mean) * (float64(d) - mean)
+	}
+	if mean == 0 {
+		return 0, 0
+	}
+	return time.Duration(mean), math.Sqrt(sq/float64(len(ds))) / mean
+}
+
+// isTimeout reports whether err is a network or deadline timeout.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
@@ -0,0 +1,151 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/journal"
+)
+
+// What to do with a file whose PreUploadCommand fails.
+const (
+	// PreUploadFail counts it as a failed upload, so it is retried and
+	// eventually given up on like one. This is the default.
+	PreUploadFail = "fail"
+	// PreUploadSkip leaves it alone until it next changes.
+	PreUploadSkip = "skip"
+	// PreUploadIgnore uploads it anyway.
+	PreUploadIgnore = "ignore"
+)
+
+// DefaultHookTimeout is how long a hook command may run, unless
+// Options.HookTimeout says otherwise.
+const DefaultHookTimeout = 5 * time.Minute
+
+// maxHookOutput is how much of a failed hook command's output is logged.
+const maxHookOutput = 1024
+
+func validPreUploadPolicy(p string) bool {
+	switch p {
+	case "", PreUploadFail, PreUploadSkip, PreUploadIgnore:
+		return true
+	}
+	return false
+}
+
+// preUpload runs PreUploadCommand on f, reporting whether f should be
+// uploaded. The error is that of a command failing under PreUploadFail,
+// which has been handled as a failed upload.
+func (u *Uploader) preUpload(ctx context.Context, f string) (bool, error) {
+	if u.opts.PreUploadCommand == "" {
+		return true, nil
+	}
+	if u.opts.DryRun {
+		infof(ctx, "DRY RUN: would run the pre-upload command on %s", f)
+		return true, nil
+	}
+	err := u.runHook(ctx, "pre_upload", u.opts.PreUploadCommand, f)
+	if err == nil {
+		return true, nil
+	}
+	switch u.opts.PreUploadPolicy {
+	case PreUploadIgnore:
+		warnf(ctx, "pre-upload command failed on %s, uploading it anyway: %s", f, err)
+		return true, nil
+	case PreUploadSkip:
+		errorf(ctx, "pre-upload command failed on %s, skipping it: %s", f, err)
+		return false, nil
+	}
+	err = fmt.Errorf("pre-upload command failed: %w", err)
+	errorf(ctx, "failed to upload file %s: %s", f, err)
+	u.emitFailure(ctx, f, err)
+	if ctx.Err() == nil {
+		u.failed(ctx, f, err)
+		u.postUpload(ctx, f, u.journalEntry(f), err)
+	}
+	return false, err
+}
+
+// postUpload runs SuccessCommand or FailureCommand once an upload of f,
+// described by j, has ended with err. Their failures are only logged.
+func (u *Uploader) postUpload(ctx context.Context, f string, j journal.Entry, err error) {
+	hook, command, env := "success", u.opts.SuccessCommand, []string{
+		"GDRIVE_SYNC_RESULT=uploaded",
+		"GDRIVE_SYNC_DRIVE_FILE_ID=" + j.DriveFileId,
+		"GDRIVE_SYNC_PHOTOS_ITEM_ID=" + j.PhotosItemId,
+		"GDRIVE_SYNC_LINK=" + j.Link,
+	}
+	if err != nil {
+		hook, command, env = "failure", u.opts.FailureCommand, []string{
+			"GDRIVE_SYNC_RESULT=failed",
+			"GDRIVE_SYNC_ERROR=" + err.Error(),
+		}
+	}
+	if command == "" {
+		return
+	}
+	if herr := u.runHook(ctx, hook, command, f, env...); herr != nil {
+		errorf(ctx, "%s command failed on %s: %s", hook, f, herr)
+	}
+}
+
+// runHook runs a shell command for f, which is passed in GDRIVE_SYNC_*
+// environment variables along with env, killing it after HookTimeout.
+func (u *Uploader) runHook(ctx context.Context, hook, command, f string, env ...string) error {
+	timeout := u.opts.HookTimeout
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	}
+	var size int64
+	if fi, err := os.Stat(f); err == nil {
+		size = fi.Size()
+	}
+	cmd.Env = append(os.Environ(),
+		"GDRIVE_SYNC_HOOK="+hook,
+		"GDRIVE_SYNC_ID="+correlationId(ctx),
+		"GDRIVE_SYNC_FILE="+f,
+		"GDRIVE_SYNC_NAME="+filepath.Base(f),
+		"GDRIVE_SYNC_SIZE="+strconv.FormatInt(size, 10),
+	)
+	cmd.Env = append(cmd.Env, env...)
+	// Output goes to a file rather than a pipe so that a timed-out command
+	// is done with once the shell is killed, even if its children still
+	// hold the output open.
+	out, err := ioutil.TempFile("", "gdrive_sync-hook-*")
+	if err != nil {
+		return err
+	}
+	defer removeTemp(out)
+	cmd.Stdout, cmd.Stderr = out, out
+	err = cmd.Run()
+	if err == nil {
+		return nil
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	out.Seek(0, io.SeekStart)
+	msg, _ := ioutil.ReadAll(io.LimitReader(out, maxHookOutput))
+	if s := strings.TrimSpace(string(msg)); s != "" {
+		return fmt.Errorf("%w: %s", err, s)
+	}
+	return err
+}
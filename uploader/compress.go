@@ -0,0 +1,119 @@
+package uploader
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Compression formats.
+const (
+	CompressGzip = "gzip"
+	CompressZip  = "zip"
+)
+
+// compressedTypes are the extension and MIME type of each format.
+var compressedTypes = map[string]struct{ ext, mime string }{
+	CompressGzip: {".gz", "application/gzip"},
+	CompressZip:  {".zip", "application/zip"},
+}
+
+// Compression compresses files matching Pattern (as for Route) in Format
+// before they are uploaded, adding the format's extension to their names.
+type Compression struct {
+	Pattern string
+	Format  string
+}
+
+type compression struct {
+	pattern pattern
+	format  string
+}
+
+func compileCompressions(cs []Compression) ([]compression, error) {
+	var compiled []compression
+	for _, c := range cs {
+		if _, ok := compressedTypes[c.Format]; !ok {
+			return nil, fmt.Errorf("unknown compression format %q, want %s or %s", c.Format, CompressGzip, CompressZip)
+		}
+		p, err := compilePattern(c.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("compression %s: %w", c.Format, err)
+		}
+		compiled = append(compiled, compression{pattern: p, format: c.Format})
+	}
+	return compiled, nil
+}
+
+// compressionFor returns the format f is compressed in before upload, the
+// first matching rule winning, or "".
+func (u *Uploader) compressionFor(f string) string {
+	rel := u.relPath(f)
+	for _, c := range u.compressions {
+		if c.pattern.match(rel) {
+			return c.format
+		}
+	}
+	return ""
+}
+
+// compressFile writes f, whose base name is base, compressed in format to a
+// temporary file and returns it open at the start, along with the MD5 of
+// the original. The copy gets f's modification time, and the output only
+// depends on f's contents, name and modification time, so compressing an
+// unchanged file again gives the same bytes: duplicates are still detected
+// and interrupted resumable uploads can continue.
+func compressFile(f *os.File, fi os.FileInfo, base, format string) (*os.File, string, error) {
+	tmp, err := ioutil.TempFile("", "gdrive_sync-*"+compressedTypes[format].ext)
+	if err != nil {
+		return nil, "", err
+	}
+	fail := func(err error) (*os.File, string, error) {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, "", err
+	}
+	sum := md5.New()
+	src := io.TeeReader(f, sum)
+	switch format {
+	case CompressGzip:
+		zw := gzip.NewWriter(tmp)
+		zw.Name, zw.ModTime = base, fi.ModTime()
+		if _, err := io.Copy(zw, src); err != nil {
+			return fail(err)
+		}
+		if err := zw.Close(); err != nil {
+			return fail(err)
+		}
+	case CompressZip:
+		zw := zip.NewWriter(tmp)
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: base, Method: zip.Deflate, Modified: fi.ModTime()})
+		if err != nil {
+			return fail(err)
+		}
+		if _, err := io.Copy(w, src); err != nil {
+			return fail(err)
+		}
+		if err := zw.Close(); err != nil {
+			return fail(err)
+		}
+	}
+	if err := os.Chtimes(tmp.Name(), fi.ModTime(), fi.ModTime()); err != nil {
+		return fail(err)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return fail(err)
+	}
+	return tmp, hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// removeTemp closes and deletes a temporary file.
+func removeTemp(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
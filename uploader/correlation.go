@@ -0,0 +1,28 @@
+package uploader
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+type correlationKey struct{}
+
+// newCorrelationId returns a short random ID used to tie together every log
+// line and event for one file.
+func newCorrelationId() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "????????"
+	}
+	return hex.EncodeToString(b)
+}
+
+func withCorrelationId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+func correlationId(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
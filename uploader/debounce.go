@@ -0,0 +1,61 @@
+package uploader
+
+import (
+	"sync"
+	"time"
+)
+
+// debouncer coalesces the events for each path, calling fire for it once
+// none has arrived for the quiet period. Scanners write each file many
+// times, and without it every write would race to start an upload.
+type debouncer struct {
+	quiet time.Duration
+	fire  func(string)
+
+	mu      sync.Mutex
+	pending map[string]*time.Timer // by pathKey
+	stopped bool
+}
+
+func newDebouncer(quiet time.Duration, fire func(string)) *debouncer {
+	return &debouncer{quiet: quiet, fire: fire, pending: make(map[string]*time.Timer)}
+}
+
+// add records an event for name, restarting its quiet period.
+func (d *debouncer) add(name string) {
+	key := pathKey(name)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped {
+		return
+	}
+	if t, ok := d.pending[key]; ok {
+		t.Reset(d.quiet)
+		return
+	}
+	var t *time.Timer
+	t = time.AfterFunc(d.quiet, func() {
+		d.mu.Lock()
+		// A Reset racing with expiry runs this a second time, once the
+		// path has been fired or has a newer timer.
+		if d.stopped || d.pending[key] != t {
+			d.mu.Unlock()
+			return
+		}
+		delete(d.pending, key)
+		d.mu.Unlock()
+		d.fire(name)
+	})
+	d.pending[key] = t
+}
+
+// stop drops every pending event.
+func (d *debouncer) stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stopped = true
+	for key, t := range d.pending {
+		t.Stop()
+		delete(d.pending, key)
+	}
+}
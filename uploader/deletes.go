@@ -0,0 +1,178 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/events"
+	"github.com/dknowles2/gdrive_sync/journal"
+)
+
+// deleteRetryDelays is the schedule for retrying deletions of uploaded files
+// that failed, typically with EBUSY on SMB and NFS mounts just after the
+// upload closed the file. Once it is exhausted the file is reported as
+// undeletable.
+var deleteRetryDelays = []time.Duration{
+	1 * time.Second,
+	5 * time.Second,
+	15 * time.Second,
+	30 * time.Second,
+	1 * time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	1 * time.Hour,
+}
+
+// undeletableReportInterval is how often the files that could not be deleted
+// are listed again.
+const undeletableReportInterval = 6 * time.Hour
+
+type pendingDelete struct {
+	ctx      context.Context
+	f        string
+	attempts int
+	next     time.Time
+}
+
+// remove deletes or archives an uploaded file and its trigger, handing it to
+// the deferred-delete queue if that fails. It reports whether the file
+// was queued, in which case it must stay marked in progress so it isn't
+// uploaded again.
+func (u *Uploader) remove(ctx context.Context, f string) bool {
+	infof(ctx, "Removing %s", f)
+	err := u.dispose(ctx, f)
+	if err == nil || os.IsNotExist(err) {
+		u.removed(ctx, f)
+		return false
+	}
+	warnf(ctx, "failed to delete file %s: %s; will retry", f, err)
+	u.mu.Lock()
+	u.deletes = append(u.deletes, &pendingDelete{ctx: ctx, f: f, next: time.Now().Add(deleteRetryDelays[0])})
+	u.mu.Unlock()
+	return true
+}
+
+func (u *Uploader) removed(ctx context.Context, f string) {
+	u.emit(ctx, events.Event{Type: events.Deleted, File: f})
+	u.journal(ctx, journal.Entry{Path: f}, journal.Removed)
+	u.consumeTrigger(ctx, f)
+}
+
+// processDeletes retries queued deletions on their schedule and periodically
+// reports the files that could not be deleted.
+func (u *Uploader) processDeletes(ctx context.Context) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	lastReport := time.Now()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		now := time.Now()
+		u.mu.Lock()
+		var due, waiting []*pendingDelete
+		for _, d := range u.deletes {
+			if now.Before(d.next) {
+				waiting = append(waiting, d)
+			} else {
+				due = append(due, d)
+			}
+		}
+		u.deletes = waiting
+		u.mu.Unlock()
+
+		for _, d := range due {
+			u.retryDelete(d)
+		}
+		if now.Sub(lastReport) >= undeletableReportInterval {
+			u.reportUndeletable()
+			lastReport = now
+		}
+	}
+}
+
+// drainDeletes retries every queued deletion on its schedule until each has
+// worked or been given up on, or ctx is done.
+func (u *Uploader) drainDeletes(ctx context.Context) {
+	for {
+		u.mu.Lock()
+		if len(u.deletes) == 0 {
+			u.mu.Unlock()
+			return
+		}
+		d := u.deletes[0]
+		for _, o := range u.deletes[1:] {
+			if o.next.Before(d.next) {
+				d = o
+			}
+		}
+		var rest []*pendingDelete
+		for _, o := range u.deletes {
+			if o != d {
+				rest = append(rest, o)
+			}
+		}
+		u.deletes = rest
+		u.mu.Unlock()
+		if wait := time.Until(d.next); wait > 0 && sleep(ctx, wait) != nil {
+			u.mu.Lock()
+			u.deletes = append(u.deletes, d)
+			u.mu.Unlock()
+			return
+		}
+		u.retryDelete(d)
+	}
+}
+
+func (u *Uploader) retryDelete(d *pendingDelete) {
+	d.attempts++
+	err := u.dispose(d.ctx, d.f)
+	if err == nil || os.IsNotExist(err) {
+		infof(d.ctx, "Removed %s after %d retries", d.f, d.attempts)
+		u.mu.Lock()
+		delete(u.inProgress, pathKey(d.f))
+		u.mu.Unlock()
+		u.removed(d.ctx, d.f)
+		return
+	}
+	u.mu.Lock()
+	if d.attempts < len(deleteRetryDelays) {
+		d.next = time.Now().Add(deleteRetryDelays[d.attempts])
+		u.deletes = append(u.deletes, d)
+		u.mu.Unlock()
+		return
+	}
+	u.undeletable[d.f] = err.Error()
+	u.mu.Unlock()
+	errorf(d.ctx, "ALERT: giving up deleting uploaded file %s: %s", d.f, err)
+	u.emitFailure(d.ctx, d.f, fmt.Errorf("unable to delete uploaded file: %w", err))
+}
+
+// reportUndeletable logs every uploaded file that is still on disk because
+// it could not be deleted, dropping any that have since gone away.
+func (u *Uploader) reportUndeletable() {
+	u.mu.Lock()
+	var files []string
+	for f := range u.undeletable {
+		if _, err := os.Stat(f); os.IsNotExist(err) {
+			delete(u.undeletable, f)
+			delete(u.inProgress, pathKey(f))
+			continue
+		}
+		files = append(files, f)
+	}
+	u.mu.Unlock()
+	if len(files) == 0 {
+		return
+	}
+	sort.Strings(files)
+	errorf(context.Background(), "ALERT: %d uploaded files could not be deleted and need manual cleanup:", len(files))
+	for _, f := range files {
+		errorf(context.Background(), "  %s", f)
+	}
+}
@@ -0,0 +1,55 @@
+package uploader
+
+import (
+	"context"
+	"time"
+)
+
+// drainContext carries the values of one context but is cancelled with
+// another, so uploads keep their correlation IDs while outliving Run's
+// context.
+type drainContext struct {
+	context.Context
+	done context.Context
+}
+
+func (c drainContext) Deadline() (time.Time, bool) { return c.done.Deadline() }
+func (c drainContext) Done() <-chan struct{}       { return c.done.Done() }
+func (c drainContext) Err() error                  { return c.done.Err() }
+
+// uploadContext returns the context an upload discovered under ctx runs
+// with. When draining is enabled it is only cancelled once the drain
+// timeout passes after Run's context is done.
+func (u *Uploader) uploadContext(ctx context.Context) context.Context {
+	if u.drain == nil {
+		return ctx
+	}
+	return drainContext{Context: ctx, done: u.drain}
+}
+
+// drainUploads waits up to DrainTimeout for running uploads to finish and
+// then cancels any that haven't. Files left behind are picked up again by
+// the next start's initial upload.
+func (u *Uploader) drainUploads() {
+	done := make(chan struct{})
+	go func() {
+		u.uploads.Wait()
+		close(done)
+	}()
+	infof(context.Background(), "Draining uploads for up to %s...", u.opts.DrainTimeout)
+	select {
+	case <-done:
+		infof(context.Background(), "All uploads finished")
+	case <-time.After(u.opts.DrainTimeout):
+		warnf(context.Background(), "Drain timeout reached; cancelling remaining uploads")
+	}
+	u.stopDrain()
+	<-done
+}
+
+// Ready reports whether the Uploader has started watching for new files.
+func (u *Uploader) Ready() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.ready
+}
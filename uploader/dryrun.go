@@ -0,0 +1,103 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/dknowles2/gdrive_sync/gdrive"
+)
+
+// dryRun logs what transfer would do with f: where it would go and what
+// would happen given the duplicates already there. It only reads from
+// Drive.
+func (u *Uploader) dryRun(ctx context.Context, f string) error {
+	if u.isPhoto(f) {
+		infof(ctx, "DRY RUN: would upload %s to Photos", f)
+		return nil
+	}
+	file, err := os.Open(f)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	dir, base, err := u.remotePath(f, fi)
+	if err != nil {
+		return err
+	}
+	for _, b := range u.opts.Backends {
+		infof(ctx, "DRY RUN: would copy %s to %s as %s", f, b.Name(), path.Join(dir, base))
+	}
+	if !u.accounts.drive() {
+		return nil
+	}
+	// Compressing only writes a temporary file, and tells what duplicate
+	// detection would find.
+	if format := u.compressionFor(f); format != "" && !u.converted(f) {
+		cf, _, err := compressFile(file, fi, filepath.Base(f), format)
+		if err != nil {
+			return err
+		}
+		defer removeTemp(cf)
+		if fi, err = cf.Stat(); err != nil {
+			return err
+		}
+		infof(ctx, "DRY RUN: would compress %s with %s", f, format)
+		file = cf
+		base += compressedTypes[format].ext
+	}
+	a := u.accounts.pick(ctx, fi.Size())
+	dest := u.outputDir
+	if r := u.routeFor(f); r != nil && r.folder != "" {
+		dest = r.folder
+	}
+	if convert, lang := u.conversionFor(f); convert {
+		infof(ctx, "DRY RUN: would convert %s to a Google Doc%s", f, ocrLanguageNote(lang))
+	}
+	dest = path.Join(dest, dir)
+	parent, err := gdrive.ResolvePath(ctx, a.drive, u.rootFolder(a, f), dir)
+	if errors.Is(err, gdrive.ErrFolderNotFound) {
+		infof(ctx, "DRY RUN: would create Drive folder %s and upload %s to it as %s", dest, f, base)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	dup, err := u.checkDuplicate(ctx, a, parent, base, file)
+	if err != nil {
+		return err
+	}
+	switch {
+	case dup.same != nil:
+		infof(ctx, "DRY RUN: %s is already in Drive as %s; would not upload it again", f, dup.same.Id)
+	case dup.replace != nil:
+		infof(ctx, "DRY RUN: would replace the contents of %s in %s with %s", dup.replace.Name, dest, f)
+	default:
+		infof(ctx, "DRY RUN: would upload %s to %s as %s", f, dest, dup.name)
+	}
+	return nil
+}
+
+func mkdirUnlessDryRun(opts Options, dir string) error {
+	if opts.DryRun {
+		return nil
+	}
+	return os.MkdirAll(dir, 0755)
+}
+
+// dryRunFinish logs what finish would do with f.
+func (u *Uploader) dryRunFinish(ctx context.Context, f string) {
+	switch {
+	case u.opts.KeepFiles:
+		infof(ctx, "DRY RUN: would keep %s", f)
+	case u.opts.ArchiveDir != "":
+		infof(ctx, "DRY RUN: would move %s to %s", f, u.archiveDirFor(f))
+	default:
+		infof(ctx, "DRY RUN: would delete %s", f)
+	}
+}
@@ -0,0 +1,140 @@
+package uploader
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dknowles2/gdrive_sync/gdrive"
+	"google.golang.org/api/drive/v3"
+)
+
+// What to do when the destination folder already has a file of the same
+// name but different contents. A file whose identical copy is already there
+// is skipped whatever the policy.
+const (
+	// DuplicateSkip only skips identical copies, uploading the file
+	// alongside one that differs.
+	DuplicateSkip = "skip"
+	// DuplicateRename uploads the file under a free name, "name (N).ext".
+	DuplicateRename = "rename"
+	// DuplicateOverwrite replaces the existing file's contents.
+	DuplicateOverwrite = "overwrite"
+	// DuplicateVersion replaces the existing file's contents, keeping the
+	// previous contents forever as a revision.
+	DuplicateVersion = "version"
+)
+
+func validDuplicatePolicy(p string) bool {
+	switch p {
+	case "", DuplicateSkip, DuplicateRename, DuplicateOverwrite, DuplicateVersion:
+		return true
+	}
+	return false
+}
+
+// duplicate is the outcome of checking the destination folder for a file
+// being uploaded.
+type duplicate struct {
+	// same is an existing copy with identical contents; nothing needs
+	// uploading.
+	same *drive.File
+	// replace is an existing file whose contents are to be replaced.
+	replace *drive.File
+	// name is what to call the new file.
+	name string
+	// md5 is the hex MD5 of the file, if it had to be hashed to compare it
+	// with a file of the same name.
+	md5 string
+}
+
+// checkDuplicate looks for files named base in folder and decides, by
+// OnDuplicate, how f should be uploaded. f is read to hash it only when a
+// file of that name is there.
+func (u *Uploader) checkDuplicate(ctx context.Context, a *account, folder, base string, f *os.File) (*duplicate, error) {
+	dup := &duplicate{name: base}
+	existing, err := gdrive.FilesNamed(ctx, a.drive, folder, base)
+	if err != nil || len(existing) == 0 {
+		return dup, err
+	}
+	sum := md5.New()
+	_, err = io.Copy(sum, f)
+	if _, serr := f.Seek(0, io.SeekStart); err == nil {
+		err = serr
+	}
+	if err != nil {
+		return nil, err
+	}
+	local := hex.EncodeToString(sum.Sum(nil))
+	dup.md5 = local
+	for _, e := range existing {
+		// Google Docs converted from uploads have no checksum of their
+		// own, only the one recorded in their provenance.
+		if e.Md5Checksum == local || (e.Md5Checksum == "" && e.AppProperties[gdrive.AppPropertyMD5] == local) {
+			dup.same = e
+			return dup, nil
+		}
+	}
+	switch u.opts.OnDuplicate {
+	case DuplicateRename:
+		if dup.name, err = u.freeName(ctx, a, folder, base); err != nil {
+			return nil, err
+		}
+	case DuplicateOverwrite, DuplicateVersion:
+		dup.replace = existing[0]
+	}
+	return dup, nil
+}
+
+// freeName returns the first of "stem (1).ext", "stem (2).ext", ... not
+// taken in folder.
+func (u *Uploader) freeName(ctx context.Context, a *account, folder, base string) (string, error) {
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	for i := 1; i < archiveMaxSuffix; i++ {
+		name := fmt.Sprintf("%s (%d)%s", stem, i, ext)
+		taken, err := gdrive.FilesNamed(ctx, a.drive, folder, name)
+		if err != nil {
+			return "", err
+		}
+		if len(taken) == 0 {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no free name for %s in Drive folder %s", base, folder)
+}
+
+// keepRevision pins the current contents of f so replacing them doesn't
+// let Drive prune them later.
+func keepRevision(ctx context.Context, a *account, f *drive.File) error {
+	if f.HeadRevisionId == "" {
+		return nil
+	}
+	_, err := a.drive.Revisions.Update(f.Id, f.HeadRevisionId, &drive.Revision{KeepForever: true}).Fields("id").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("unable to keep revision %s of %s: %w", f.HeadRevisionId, f.Name, err)
+	}
+	return nil
+}
+
+// alreadyUploaded returns the result for a file whose identical copy same
+// is already in Drive, hashing f as an upload would.
+func alreadyUploaded(a *account, folder string, same *drive.File, f *os.File) (*uploaded, error) {
+	md5sum, sha := md5.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(md5sum, sha), f); err != nil {
+		return nil, err
+	}
+	return &uploaded{
+		file:     same,
+		account:  a,
+		folderId: folder,
+		md5:      hex.EncodeToString(md5sum.Sum(nil)),
+		sha256:   hex.EncodeToString(sha.Sum(nil)),
+	}, nil
+}
@@ -0,0 +1,154 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/journal"
+)
+
+// DefaultMaxFailures is how many failed uploads of a file are allowed
+// before it is given up on, unless Options.MaxFailures says otherwise.
+const DefaultMaxFailures = 5
+
+// failed handles an upload of f that failed with err: it is parked to be
+// tried again after a backoff that doubles with each failure in a row, and
+// once it has failed MaxFailures times it is quarantined: moved to
+// FailedDir, or marked abandoned in the journal, so it stops being retried.
+// With a journal, the count and backoff survive restarts.
+func (u *Uploader) failed(ctx context.Context, f string, err error) {
+	max := u.opts.MaxFailures
+	if max <= 0 {
+		max = DefaultMaxFailures
+	}
+	key := pathKey(f)
+	u.mu.Lock()
+	if _, ok := u.failures[key]; !ok {
+		if e, ok := u.retriesBefore(f); ok {
+			u.failures[key] = e.Failures
+		}
+	}
+	u.failures[key]++
+	n := u.failures[key]
+	u.mu.Unlock()
+	if n < max || (u.opts.FailedDir == "" && u.opts.Journal == nil) {
+		j := u.journalEntry(f)
+		j.Error = err.Error()
+		j.Failures = n
+		u.journal(ctx, j, journal.Retrying)
+		u.park(ctx, f, failureDelay(n))
+		return
+	}
+	u.mu.Lock()
+	delete(u.failures, key)
+	u.mu.Unlock()
+	u.abandon(ctx, f, n, err)
+}
+
+// failureDelay returns how long a file waits to be tried again after its
+// nth failure in a row.
+func failureDelay(n int) time.Duration {
+	if n > 1 && n < 32 {
+		if d := parkedRetryDelay << uint(n-1); d > 0 && d < maxParkedRetryDelay {
+			return d
+		}
+		return maxParkedRetryDelay
+	}
+	return parkedRetryDelay
+}
+
+// retriesBefore returns the journal's record of the failures of f in a row,
+// if f hasn't changed since.
+func (u *Uploader) retriesBefore(f string) (journal.Entry, bool) {
+	if u.opts.Journal == nil {
+		return journal.Entry{}, false
+	}
+	e, ok := u.opts.Journal.Retries(f)
+	if !ok {
+		return e, false
+	}
+	fi, err := os.Stat(f)
+	if err != nil || fi.Size() != e.Size || !fi.ModTime().Equal(e.ModTime) {
+		return e, false
+	}
+	return e, true
+}
+
+// backingOff reports whether f is waiting out its backoff after failing. A
+// file the journal says is waiting, typically after a restart, is parked
+// until its backoff ends.
+func (u *Uploader) backingOff(ctx context.Context, f string) bool {
+	if next, ok := u.parkedUntil(f); ok {
+		infof(ctx, "Skipping %s: parked until %s", f, next.Format("2006-01-02 15:04:05"))
+		return true
+	}
+	e, ok := u.retriesBefore(f)
+	if !ok {
+		return false
+	}
+	wait := time.Until(e.Time.Add(failureDelay(e.Failures)))
+	if wait <= 0 {
+		return false
+	}
+	infof(ctx, "Skipping %s: backing off after %d failed uploads, the last at %s", f, e.Failures, e.Time.Format("2006-01-02 15:04:05"))
+	u.park(ctx, f, wait)
+	return true
+}
+
+// abandon quarantines f after n failures.
+func (u *Uploader) abandon(ctx context.Context, f string, n int, err error) {
+	j := u.journalEntry(f)
+	j.Error = err.Error()
+	j.Failures = n
+	u.forgetFanout(f)
+	reason := fmt.Errorf("gave up after %d failed uploads: %w", n, err)
+	if u.opts.FailedDir == "" {
+		errorf(ctx, "ALERT: giving up on %s after %d failed uploads: %s", f, n, err)
+	} else if dst, merr := moveInto(u.failedDirFor(f), f); merr != nil {
+		errorf(ctx, "ALERT: giving up on %s after %d failed uploads, but could not move it to %s: %s", f, n, u.opts.FailedDir, merr)
+	} else {
+		errorf(ctx, "ALERT: moved %s to %s after %d failed uploads: %s", f, dst, n, err)
+		reason = fmt.Errorf("moved to %s after %d failed uploads: %w", dst, n, err)
+		j.Quarantine = dst
+		u.consumeTrigger(ctx, f)
+	}
+	u.journal(ctx, j, journal.Abandoned)
+	u.emitFailure(ctx, f, reason)
+}
+
+// failedDirFor returns where f is moved in FailedDir, keeping its
+// subdirectory within the input tree.
+func (u *Uploader) failedDirFor(f string) string {
+	if rel := u.relDir(f); rel != "" {
+		return filepath.Join(u.opts.FailedDir, filepath.FromSlash(rel))
+	}
+	return u.opts.FailedDir
+}
+
+// abandonedBefore reports whether the journal says f, unchanged since, was
+// quarantined.
+func (u *Uploader) abandonedBefore(ctx context.Context, f string) bool {
+	if u.opts.Journal == nil {
+		return false
+	}
+	e, ok := u.opts.Journal.Last(f)
+	if !ok || e.Status != journal.Abandoned {
+		return false
+	}
+	fi, err := os.Stat(f)
+	if err != nil || fi.Size() != e.Size || !fi.ModTime().Equal(e.ModTime) {
+		return false
+	}
+	infof(ctx, "Skipping %s: given up on at %s", f, e.Time.Format("2006-01-02 15:04:05"))
+	return true
+}
+
+// succeeded forgets the failures of f.
+func (u *Uploader) succeeded(f string) {
+	u.mu.Lock()
+	delete(u.failures, pathKey(f))
+	u.mu.Unlock()
+}
@@ -0,0 +1,131 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/gdrive"
+)
+
+// Destination is another Drive folder every file sent to Drive is also
+// uploaded to, such as a shared family folder. Routes apply to it as to the
+// output folder. A file is only finished, and removed locally, once it has
+// reached the output folder and every destination.
+type Destination struct {
+	// Name identifies the destination in logs and the journal.
+	Name string
+	// Drive is the destination's account; nil for the Uploader's own.
+	Drive *gdrive.Drive
+	// Folder is given like the output folder.
+	Folder string
+}
+
+// fanout is how far the upload of one file to every destination got.
+type fanout struct {
+	// size and modTime are the file's when the uploads started; if it
+	// changes they start over.
+	size    int64
+	modTime time.Time
+	// primary is the upload to the output folder, once it has succeeded.
+	primary *uploaded
+	// done are the IDs of the copies in the destinations, and the locations
+	// of those in the backends, that have succeeded, by name.
+	done map[string]string
+}
+
+func newDestinations(ds []Destination, d *gdrive.Drive, opts Options, routes []string) ([]*account, error) {
+	seen := map[string]bool{DefaultAccount: true}
+	for _, a := range opts.Accounts {
+		seen[a.Name] = true
+	}
+	for _, b := range opts.Backends {
+		if seen[b.Name()] {
+			return nil, fmt.Errorf("backend name %q is already used", b.Name())
+		}
+		seen[b.Name()] = true
+	}
+	var dests []*account
+	for _, dst := range ds {
+		if dst.Name == "" || seen[dst.Name] {
+			return nil, fmt.Errorf("destination name %q is empty or already used", dst.Name)
+		}
+		seen[dst.Name] = true
+		if dst.Drive == nil {
+			dst.Drive = d
+		}
+		folderId, err := outputFolderId(dst.Drive, dst.Folder, opts.CreateOutputDir)
+		if err != nil {
+			return nil, fmt.Errorf("destination %s: %w", dst.Name, err)
+		}
+		a := newAccount(dst.Name, dst.Drive, folderId)
+		if a.routeFolders, err = gdrive.FolderIds(context.Background(), a.drive, routes, opts.CreateOutputDir); err != nil {
+			return nil, fmt.Errorf("destination %s: route folder: %w", dst.Name, err)
+		}
+		dests = append(dests, a)
+	}
+	return dests, nil
+}
+
+// fanoutOf returns the progress of uploading f, as it is now, everywhere.
+func (u *Uploader) fanoutOf(f string) (*fanout, error) {
+	fi, err := os.Stat(f)
+	if err != nil {
+		return nil, err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	fo, ok := u.fanouts[pathKey(f)]
+	if !ok || fo.size != fi.Size() || !fo.modTime.Equal(fi.ModTime()) {
+		fo = &fanout{size: fi.Size(), modTime: fi.ModTime(), done: make(map[string]string)}
+		u.fanouts[pathKey(f)] = fo
+	}
+	return fo, nil
+}
+
+// forgetFanout drops the progress of uploading f, once it is finished.
+func (u *Uploader) forgetFanout(f string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.fanouts, pathKey(f))
+}
+
+// uploadEverywhere uploads f to the output folder and every destination and
+// backend it hasn't already reached, returning the upload to the output
+// folder, which is nil without Drive.
+func (u *Uploader) uploadEverywhere(ctx context.Context, f string) (*uploaded, map[string]string, error) {
+	fo, err := u.fanoutOf(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fo.primary == nil && u.accounts.drive() {
+		r, err := u.verifiedUpload(ctx, f, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		fo.primary = r
+	}
+	for _, dst := range u.destinations {
+		if _, ok := fo.done[dst.name]; ok {
+			continue
+		}
+		r, err := u.verifiedUpload(ctx, f, dst)
+		if err != nil {
+			return nil, nil, fmt.Errorf("uploading to %s: %w", dst.name, err)
+		}
+		fo.done[dst.name] = r.file.Id
+	}
+	for _, b := range u.opts.Backends {
+		if _, ok := fo.done[b.Name()]; ok {
+			continue
+		}
+		loc, err := u.putBackend(ctx, b, f)
+		if err != nil {
+			return nil, nil, err
+		}
+		fo.done[b.Name()] = loc
+	}
+	u.forgetFanout(f)
+	return fo.primary, fo.done, nil
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package uploader
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// fileIsLocked reports whether another process holds a flock on f.
+func fileIsLocked(f string) (bool, error) {
+	file, err := os.Open(f)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return false, syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
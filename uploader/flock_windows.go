@@ -0,0 +1,9 @@
+package uploader
+
+import "context"
+
+// fileIsLocked reports whether another process has f open without sharing
+// it. Windows has no advisory locks, so this is the open-handle check.
+func fileIsLocked(f string) (bool, error) {
+	return fileIsOpen(context.Background(), f)
+}
@@ -0,0 +1,43 @@
+package uploader
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/dknowles2/gdrive_sync/gdrive"
+)
+
+// relDir returns the slash-separated directory of f relative to the tree it
+// was found in, or "" if it is at the top or outside it.
+func (u *Uploader) relDir(f string) string {
+	dir := filepath.Dir(f)
+	rel, err := filepath.Rel(u.rootOf(dir), dir)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// folderFor returns the ID of the folder at the slash-separated path rel
+// beneath the folder root of account a, as given by remotePath. Folders are
+// created as needed and their IDs cached.
+func (u *Uploader) folderFor(ctx context.Context, a *account, root, rel string) (string, error) {
+	if rel == "" {
+		return root, nil
+	}
+	// Holding the lock while creating folders stops concurrent uploads from
+	// the same new directory each creating its own copy.
+	a.foldersMu.Lock()
+	defer a.foldersMu.Unlock()
+	k := root + "/" + rel
+	if id, ok := a.folders[k]; ok {
+		return id, nil
+	}
+	id, err := gdrive.EnsurePath(ctx, a.drive, root, rel)
+	if err != nil {
+		return "", err
+	}
+	a.folders[k] = id
+	return id, nil
+}
@@ -0,0 +1,43 @@
+// +build cgo
+
+#include <CoreServices/CoreServices.h>
+#include "_cgo_export.h"
+
+static void gdsCallback(ConstFSEventStreamRef stream, void *info, size_t n, void *paths,
+                        const FSEventStreamEventFlags flags[], const FSEventStreamEventId ids[]) {
+	goFSEventsCallback((uintptr_t)info, n, (char **)paths, (FSEventStreamEventFlags *)flags);
+}
+
+FSEventStreamRef gdsStartStream(uintptr_t handle, char **paths, int n, double latency, dispatch_queue_t queue) {
+	CFMutableArrayRef arr = CFArrayCreateMutable(NULL, n, &kCFTypeArrayCallBacks);
+	for (int i = 0; i < n; i++) {
+		CFStringRef s = CFStringCreateWithCString(NULL, paths[i], kCFStringEncodingUTF8);
+		CFArrayAppendValue(arr, s);
+		CFRelease(s);
+	}
+	FSEventStreamContext ctx = {0, (void *)handle, NULL, NULL, NULL};
+	FSEventStreamRef stream = FSEventStreamCreate(NULL, gdsCallback, &ctx, arr, kFSEventStreamEventIdSinceNow, latency,
+	                                              kFSEventStreamCreateFlagFileEvents | kFSEventStreamCreateFlagNoDefer);
+	CFRelease(arr);
+	FSEventStreamSetDispatchQueue(stream, queue);
+	FSEventStreamStart(stream);
+	return stream;
+}
+
+static void gdsNoop(void *ctx) {}
+
+void gdsStopStream(FSEventStreamRef stream, dispatch_queue_t queue) {
+	FSEventStreamStop(stream);
+	FSEventStreamInvalidate(stream);
+	FSEventStreamRelease(stream);
+	// Wait for any callback already queued to finish.
+	dispatch_sync_f(queue, NULL, gdsNoop);
+}
+
+dispatch_queue_t gdsNewQueue(void) {
+	return dispatch_queue_create("gdrive_sync.fsevents", DISPATCH_QUEUE_SERIAL);
+}
+
+void gdsReleaseQueue(dispatch_queue_t queue) {
+	dispatch_release(queue);
+}
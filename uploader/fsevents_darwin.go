@@ -0,0 +1,259 @@
+//go:build cgo
+// +build cgo
+
+package uploader
+
+/*
+#cgo LDFLAGS: -framework CoreServices
+#include <stdlib.h>
+#include <CoreServices/CoreServices.h>
+
+FSEventStreamRef gdsStartStream(uintptr_t handle, char **paths, int n, double latency, dispatch_queue_t queue);
+void gdsStopStream(FSEventStreamRef stream, dispatch_queue_t queue);
+dispatch_queue_t gdsNewQueue(void);
+void gdsReleaseQueue(dispatch_queue_t queue);
+*/
+import "C"
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// FSEvents flags, see FSEvents.h.
+const (
+	fsevMustScanSubDirs = 0x00000001
+	fsevItemCreated     = 0x00000100
+	fsevItemRemoved     = 0x00000200
+	fsevItemRenamed     = 0x00000800
+	fsevItemModified    = 0x00001000
+)
+
+// fseventsLatency is how long FSEvents may coalesce events, in seconds.
+const fseventsLatency = 0.1
+
+// fseventsWatcher watches directories with a single FSEvents stream, which
+// scales far better on macOS than kqueue's one descriptor per file. FSEvents
+// is always recursive, so events outside the added directories are dropped.
+type fseventsWatcher struct {
+	handle uintptr
+	queue  C.dispatch_queue_t
+	events chan fsnotify.Event
+	errors chan error
+	done   chan struct{}
+	wake   chan struct{}
+	wg     sync.WaitGroup
+
+	// mu guards the stream. It may be held while waiting for callbacks to
+	// drain, so callbacks must never take it.
+	mu     sync.Mutex
+	roots  []string // resolved stream roots
+	stream C.FSEventStreamRef
+	closed bool
+
+	// pmu guards state shared with callbacks. Callbacks only queue events
+	// here so they never block the dispatch queue.
+	pmu     sync.Mutex
+	dirs    map[string]string // resolved path -> path as added
+	pending []fsnotify.Event
+}
+
+var (
+	fseventsMu       sync.Mutex
+	fseventsWatchers = make(map[uintptr]*fseventsWatcher)
+	fseventsNext     uintptr
+)
+
+func newFileWatcher() (fileWatcher, error) {
+	w := &fseventsWatcher{
+		queue:  C.gdsNewQueue(),
+		events: make(chan fsnotify.Event),
+		errors: make(chan error),
+		done:   make(chan struct{}),
+		wake:   make(chan struct{}, 1),
+		dirs:   make(map[string]string),
+	}
+	w.wg.Add(1)
+	go w.forward()
+	// The C side only ever sees this integer handle, never a Go pointer.
+	fseventsMu.Lock()
+	fseventsNext++
+	w.handle = fseventsNext
+	fseventsWatchers[w.handle] = w
+	fseventsMu.Unlock()
+	return w, nil
+}
+
+func (w *fseventsWatcher) Events() <-chan fsnotify.Event { return w.events }
+func (w *fseventsWatcher) Errors() <-chan error          { return w.errors }
+
+func (w *fseventsWatcher) Add(name string) error {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return err
+	}
+	// FSEvents reports resolved paths (e.g. /private/var for /var).
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return errors.New("watcher closed")
+	}
+	w.pmu.Lock()
+	w.dirs[real] = name
+	w.pmu.Unlock()
+	for _, r := range w.roots {
+		if real == r || strings.HasPrefix(real, r+"/") {
+			// Already covered by an existing stream.
+			return nil
+		}
+	}
+	roots := []string{real}
+	for _, r := range w.roots {
+		if !strings.HasPrefix(r, real+"/") {
+			roots = append(roots, r)
+		}
+	}
+	w.roots = roots
+	w.restartLocked()
+	return nil
+}
+
+// restartLocked replaces the running stream with one covering w.roots.
+func (w *fseventsWatcher) restartLocked() {
+	if w.stream != nil {
+		C.gdsStopStream(w.stream, w.queue)
+		w.stream = nil
+	}
+	n := len(w.roots)
+	paths := C.malloc(C.size_t(n) * C.size_t(unsafe.Sizeof(uintptr(0))))
+	defer C.free(paths)
+	cpaths := (*[1 << 20]*C.char)(paths)[:n:n]
+	for i, r := range w.roots {
+		cpaths[i] = C.CString(r)
+	}
+	w.stream = C.gdsStartStream(C.uintptr_t(w.handle), (**C.char)(paths), C.int(n), fseventsLatency, w.queue)
+	for _, p := range cpaths {
+		C.free(unsafe.Pointer(p))
+	}
+}
+
+func (w *fseventsWatcher) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.done)
+	if w.stream != nil {
+		C.gdsStopStream(w.stream, w.queue)
+		w.stream = nil
+	}
+	C.gdsReleaseQueue(w.queue)
+	w.mu.Unlock()
+
+	fseventsMu.Lock()
+	delete(fseventsWatchers, w.handle)
+	fseventsMu.Unlock()
+	w.wg.Wait()
+	close(w.events)
+	close(w.errors)
+	return nil
+}
+
+// forward delivers queued events to the Events channel.
+func (w *fseventsWatcher) forward() {
+	defer w.wg.Done()
+	for {
+		select {
+		case <-w.wake:
+		case <-w.done:
+			return
+		}
+		w.pmu.Lock()
+		pending := w.pending
+		w.pending = nil
+		w.pmu.Unlock()
+		for _, e := range pending {
+			select {
+			case w.events <- e:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// translate maps a resolved event path back to the watched directory it was
+// added as, returning false if the path isn't a direct child of one.
+func (w *fseventsWatcher) translate(p string) (string, bool) {
+	w.pmu.Lock()
+	defer w.pmu.Unlock()
+	dir := filepath.Dir(p)
+	if added, ok := w.dirs[dir]; ok {
+		return filepath.Join(added, filepath.Base(p)), true
+	}
+	return "", false
+}
+
+// enqueue hands an event to the forwarding goroutine without blocking.
+func (w *fseventsWatcher) enqueue(e fsnotify.Event) {
+	w.pmu.Lock()
+	w.pending = append(w.pending, e)
+	w.pmu.Unlock()
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+//export goFSEventsCallback
+func goFSEventsCallback(handle C.uintptr_t, n C.size_t, paths **C.char, flags *C.FSEventStreamEventFlags) {
+	fseventsMu.Lock()
+	w := fseventsWatchers[uintptr(handle)]
+	fseventsMu.Unlock()
+	if w == nil {
+		return
+	}
+	count := int(n)
+	ps := (*[1 << 20]*C.char)(unsafe.Pointer(paths))[:count:count]
+	fs := (*[1 << 20]C.FSEventStreamEventFlags)(unsafe.Pointer(flags))[:count:count]
+	for i := 0; i < count; i++ {
+		f := uint32(fs[i])
+		if f&fsevMustScanSubDirs != 0 {
+			select {
+			case w.errors <- errors.New("fsevents: events were dropped; a rescan is needed"):
+			default:
+			}
+		}
+		name, ok := w.translate(C.GoString(ps[i]))
+		if !ok {
+			continue
+		}
+		var op fsnotify.Op
+		if f&fsevItemCreated != 0 {
+			op |= fsnotify.Create
+		}
+		if f&fsevItemModified != 0 {
+			op |= fsnotify.Write
+		}
+		if f&fsevItemRenamed != 0 {
+			op |= fsnotify.Rename
+		}
+		if f&fsevItemRemoved != 0 {
+			op |= fsnotify.Remove
+		}
+		if op != 0 {
+			w.enqueue(fsnotify.Event{Name: name, Op: op})
+		}
+	}
+}
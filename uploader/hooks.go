@@ -0,0 +1,58 @@
+package uploader
+
+import (
+	"errors"
+
+	"github.com/dknowles2/gdrive_sync/events"
+)
+
+// Hooks are functions called as uploads go through the pipeline, for
+// programs embedding it. Any may be nil. They are called synchronously, so
+// they should return quickly.
+type Hooks struct {
+	// OnUploadStarted is called as each attempt to upload a file begins,
+	// with its Size.
+	OnUploadStarted func(events.Event)
+	// OnProgress is called as a file is sent, with the Bytes sent so far
+	// of its Size.
+	OnProgress func(events.Event)
+	// OnUploadComplete is called once a file is uploaded, with its
+	// DriveFileId (or PhotosItemId) and Link if it was shared.
+	OnUploadComplete func(events.Event)
+	// OnUploadFailed is called when a file is given up on, or couldn't be
+	// removed once uploaded, with the error.
+	OnUploadFailed func(events.Event, error)
+}
+
+func (h *Hooks) call(e events.Event, err error) {
+	var fn func(events.Event)
+	switch e.Type {
+	case events.Uploading:
+		fn = h.OnUploadStarted
+	case events.Progress:
+		fn = h.OnProgress
+	case events.Uploaded:
+		fn = h.OnUploadComplete
+	case events.Failed:
+		if h.OnUploadFailed != nil {
+			if err == nil {
+				err = errors.New(e.Error)
+			}
+			h.OnUploadFailed(e, err)
+		}
+	}
+	if fn != nil {
+		fn(e)
+	}
+}
+
+// then returns a function calling fn and then next.
+func then(fn, next func(events.Event)) func(events.Event) {
+	if fn == nil {
+		return next
+	}
+	return func(e events.Event) {
+		fn(e)
+		next(e)
+	}
+}
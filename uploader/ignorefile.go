@@ -0,0 +1,173 @@
+package uploader
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ignoreFileName is the file in the input directory listing, in gitignore
+// syntax, files not to upload. It is reread whenever it changes.
+const ignoreFileName = ".gdriveignore"
+
+// ignoreFile holds the rules of an ignore file.
+type ignoreFile struct {
+	path string
+
+	mu    sync.Mutex
+	rules []ignoreRule
+}
+
+// ignoreRule is one pattern line of an ignore file.
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+func newIgnoreFile(dir string) *ignoreFile {
+	f := &ignoreFile{path: filepath.Join(dir, ignoreFileName)}
+	f.load()
+	return f
+}
+
+// load rereads the file, keeping the current rules if it can't be read. A
+// missing file has no rules.
+func (f *ignoreFile) load() {
+	b, err := ioutil.ReadFile(f.path)
+	if err != nil && !os.IsNotExist(err) {
+		errorf(context.Background(), "failed to read %s: %s", f.path, err)
+		return
+	}
+	rules := parseIgnoreRules(f.path, string(b))
+	f.mu.Lock()
+	changed := len(rules) > 0 || len(f.rules) > 0
+	f.rules = rules
+	f.mu.Unlock()
+	if changed {
+		infof(context.Background(), "Loaded %d ignore rules from %s", len(rules), f.path)
+	}
+}
+
+// is reports whether name is the ignore file.
+func (f *ignoreFile) is(name string) bool {
+	return filepath.Clean(name) == f.path
+}
+
+// ignored reports whether the file, or if dir is set the directory, at the
+// slash-separated relative path rel is ignored. Everything beneath an
+// ignored directory is ignored too.
+func (f *ignoreFile) ignored(rel string, dir bool) bool {
+	f.mu.Lock()
+	rules := f.rules
+	f.mu.Unlock()
+	if len(rules) == 0 {
+		return false
+	}
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if matchIgnoreRules(rules, strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return matchIgnoreRules(rules, rel, dir)
+}
+
+// matchIgnoreRules applies rules to p in order, the last match deciding.
+func matchIgnoreRules(rules []ignoreRule, p string, dir bool) bool {
+	ignored := false
+	for _, r := range rules {
+		if r.dirOnly && !dir {
+			continue
+		}
+		if r.re.MatchString(p) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// parseIgnoreRules parses gitignore syntax, logging and skipping lines it
+// can't make sense of.
+func parseIgnoreRules(name, data string) []ignoreRule {
+	var rules []ignoreRule
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if !strings.HasSuffix(line, `\ `) {
+			line = strings.TrimRight(line, " \t")
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		var r ignoreRule
+		if line[0] == '!' {
+			r.negate = true
+			line = line[1:]
+		} else if line[0] == '\\' && len(line) > 1 && (line[1] == '#' || line[1] == '!') {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		// A pattern with a slash other than at the end is relative to the
+		// input directory; others match at any depth.
+		expr := "^(?:.*/)?"
+		if strings.Contains(line, "/") {
+			expr = "^"
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		re, err := regexp.Compile(expr + globRegexp(line) + "$")
+		if err != nil {
+			warnf(context.Background(), "%s:%d: ignoring invalid pattern: %s", name, i+1, err)
+			continue
+		}
+		r.re = re
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// globRegexp translates a gitignore glob into a regular expression.
+func globRegexp(g string) string {
+	var b strings.Builder
+	for i := 0; i < len(g); i++ {
+		switch c := g[i]; {
+		case strings.HasPrefix(g[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(g[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '\\' && i+1 < len(g):
+			i++
+			b.WriteString(regexp.QuoteMeta(g[i : i+1]))
+		case c == '[':
+			j := strings.IndexByte(g[i+1:], ']')
+			if j <= 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := g[i+1 : i+1+j]
+			if class[0] == '!' {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += j + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(g[i : i+1]))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,67 @@
+package uploader
+
+import (
+	"context"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/events"
+)
+
+// A pair normally sees daily traffic, and so is alerted on, if files were
+// uploaded on baselineActiveDays of the baselineDays days up to its last
+// upload.
+const (
+	baselineDays       = 7
+	baselineActiveDays = 5
+)
+
+// monitorInactivity periodically checks when a file was last uploaded and
+// logs an alert once per quiet period. This catches the case where the
+// daemon is healthy but whatever feeds the input directory has stopped.
+// Pairs that see uploads only now and then, or whose traffic hasn't been
+// seen for long enough since starting, aren't alerted on.
+func (u *Uploader) monitorInactivity(ctx context.Context) {
+	period := u.opts.InactivityAlert
+	interval := period / 10
+	if interval > time.Minute {
+		interval = time.Minute
+	} else if interval <= 0 {
+		interval = period
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	var lastAlert, seen time.Time
+	days := make(map[string]bool)
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		u.mu.Lock()
+		last, lastSuccess := u.lastUpload, u.lastSuccess
+		u.mu.Unlock()
+		if lastSuccess.After(seen) {
+			seen = lastSuccess
+			days[day(seen)] = true
+			for d := range days {
+				if d <= day(seen.AddDate(0, 0, -baselineDays)) {
+					delete(days, d)
+				}
+			}
+		}
+		if time.Since(last) < period || time.Since(lastAlert) < period || len(days) < baselineActiveDays {
+			continue
+		}
+		errorf(ctx, "ALERT: no files uploaded from %s to %q in %s (last upload at %s)",
+			u.inputDir, u.outputDir, time.Since(last).Round(time.Second), last.Format(time.RFC3339))
+		u.emit(ctx, events.Event{Type: events.Inactive, File: u.inputDir})
+		lastAlert = time.Now()
+	}
+}
+
+// day returns the local date of t, in a form that sorts by date.
+func day(t time.Time) string {
+	return t.Format("2006-01-02")
+}
@@ -0,0 +1,50 @@
+package uploader
+
+import (
+	"context"
+	"os"
+
+	"github.com/dknowles2/gdrive_sync/journal"
+)
+
+// journalEntry returns an entry for f stamped with its current size and
+// modification time, so later steps of the attempt describe the same
+// version of the file.
+func (u *Uploader) journalEntry(f string) journal.Entry {
+	e := journal.Entry{Path: f}
+	if fi, err := os.Stat(f); err == nil {
+		e.Size = fi.Size()
+		e.ModTime = fi.ModTime()
+	}
+	return e
+}
+
+// journal appends e with status s to the journal, if one is configured.
+func (u *Uploader) journal(ctx context.Context, e journal.Entry, s journal.Status) {
+	if u.opts.Journal == nil {
+		return
+	}
+	e.Status = s
+	if err := u.opts.Journal.Append(e); err != nil {
+		errorf(ctx, "failed to record %s in journal: %s", e.Path, err)
+	}
+}
+
+// uploadedBefore reports whether the journal says f, unchanged since, was
+// already uploaded. That happens when the daemon stopped between uploading
+// and removing it, or when files are kept.
+func (u *Uploader) uploadedBefore(ctx context.Context, f string) bool {
+	if u.opts.Journal == nil {
+		return false
+	}
+	e, ok := u.opts.Journal.Last(f)
+	if !ok || e.Status != journal.Uploaded {
+		return false
+	}
+	fi, err := os.Stat(f)
+	if err != nil || fi.Size() != e.Size || !fi.ModTime().Equal(e.ModTime) {
+		return false
+	}
+	infof(ctx, "Skipping %s: already uploaded at %s", f, e.Time.Format("2006-01-02 15:04:05"))
+	return true
+}
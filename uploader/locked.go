@@ -0,0 +1,35 @@
+package uploader
+
+import (
+	"context"
+	"time"
+)
+
+// lockedRetryDelays is the schedule for retrying files that another process
+// (an antivirus scanner, Spotlight, a NAS indexer) briefly holds locked.
+// These locks are transient and short, so the schedule is short too and
+// does not count as an upload failure until it is exhausted.
+var lockedRetryDelays = []time.Duration{
+	500 * time.Millisecond,
+	1 * time.Second,
+	2 * time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+}
+
+// retryLocked calls fn, retrying on the locked-file schedule for as long as
+// it fails because f is locked.
+func (u *Uploader) retryLocked(ctx context.Context, f string, fn func() error) error {
+	for i := 0; ; i++ {
+		err := fn()
+		if err == nil || !isLocked(err) || i >= len(lockedRetryDelays) {
+			return err
+		}
+		d := lockedRetryDelays[i]
+		infof(ctx, "%s is locked by another process; retrying in %s", f, d)
+		if err := sleep(ctx, d); err != nil {
+			return err
+		}
+	}
+}
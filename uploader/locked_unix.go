@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package uploader
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isLocked reports whether err means the file is temporarily in use by
+// another process.
+func isLocked(err error) bool {
+	return errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.ETXTBSY) || errors.Is(err, syscall.EAGAIN)
+}
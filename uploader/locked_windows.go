@@ -0,0 +1,18 @@
+package uploader
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Windows error codes for files held open by another process.
+const (
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+// isLocked reports whether err means the file is temporarily in use by
+// another process.
+func isLocked(err error) bool {
+	return errors.Is(err, errorSharingViolation) || errors.Is(err, errorLockViolation)
+}
@@ -0,0 +1,64 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/dknowles2/gdrive_sync/logging"
+)
+
+// Logger receives what the uploader logs. Each message comes with its level
+// and, if it is about one file, an "id" field holding the file's
+// correlation ID. *logging.Logger is a Logger.
+type Logger interface {
+	Log(level logging.Level, msg string, fields ...logging.Field)
+}
+
+// logger is where the uploader logs to. By default it is the standard
+// logger, which drops the level.
+var logger Logger = stdLogger{}
+
+// SetLogger sends what the uploader logs to l. It must be called before any
+// Uploader is created.
+func SetLogger(l Logger) {
+	logger = l
+}
+
+// stdLogger logs to the standard logger, prefixing messages with their
+// correlation ID.
+type stdLogger struct{}
+
+func (stdLogger) Log(level logging.Level, msg string, fields ...logging.Field) {
+	for _, f := range fields {
+		if f.Key == "id" {
+			msg = fmt.Sprintf("[%s] %s", f.Value, msg)
+		}
+	}
+	log.Print(msg)
+}
+
+// logAt logs at level, with the correlation ID from ctx if there is one.
+func logAt(ctx context.Context, level logging.Level, format string, v ...interface{}) {
+	var fields []logging.Field
+	if id := correlationId(ctx); id != "" {
+		fields = append(fields, logging.Field{Key: "id", Value: id})
+	}
+	logger.Log(level, fmt.Sprintf(format, v...), fields...)
+}
+
+func debugf(ctx context.Context, format string, v ...interface{}) {
+	logAt(ctx, logging.Debug, format, v...)
+}
+
+func infof(ctx context.Context, format string, v ...interface{}) {
+	logAt(ctx, logging.Info, format, v...)
+}
+
+func warnf(ctx context.Context, format string, v ...interface{}) {
+	logAt(ctx, logging.Warn, format, v...)
+}
+
+func errorf(ctx context.Context, format string, v ...interface{}) {
+	logAt(ctx, logging.Error, format, v...)
+}
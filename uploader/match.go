@@ -0,0 +1,66 @@
+package uploader
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// regexpPrefix marks a pattern as a regular expression rather than a glob.
+const regexpPrefix = "re:"
+
+// pattern matches files by a glob or, when written with regexpPrefix, a
+// regular expression. Globs without a slash match the base name; other
+// globs and regular expressions match the slash-separated path relative to
+// the watched tree.
+type pattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+func compilePattern(s string) (pattern, error) {
+	if strings.HasPrefix(s, regexpPrefix) {
+		re, err := regexp.Compile(strings.TrimPrefix(s, regexpPrefix))
+		if err != nil {
+			return pattern{}, fmt.Errorf("invalid pattern %q: %w", s, err)
+		}
+		return pattern{re: re}, nil
+	}
+	if _, err := path.Match(s, ""); err != nil {
+		return pattern{}, fmt.Errorf("invalid pattern %q: %w", s, err)
+	}
+	return pattern{glob: s}, nil
+}
+
+func compilePatterns(ss []string) ([]pattern, error) {
+	var ps []pattern
+	for _, s := range ss {
+		p, err := compilePattern(s)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// match reports whether the file at the slash-separated relative path rel
+// matches p.
+func (p pattern) match(rel string) bool {
+	if p.re != nil {
+		return p.re.MatchString(rel)
+	}
+	if !strings.Contains(p.glob, "/") {
+		rel = path.Base(rel)
+	}
+	ok, _ := path.Match(p.glob, rel)
+	return ok
+}
+
+// relPath returns the slash-separated path of f relative to the tree it was
+// found in.
+func (u *Uploader) relPath(f string) string {
+	return path.Join(u.relDir(f), filepath.Base(f))
+}
@@ -0,0 +1,58 @@
+package uploader
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sniffLen is how much of a file content sniffing looks at.
+const sniffLen = 512
+
+// signatures are formats common from scanners and cameras that
+// http.DetectContentType doesn't know, by their leading bytes. HEIF files
+// start with a box length, so their brand is matched at offset 4.
+var signatures = []struct {
+	offset int
+	magic  string
+	mime   string
+}{
+	{0, "II*\x00", "image/tiff"},
+	{0, "MM\x00*", "image/tiff"},
+	{4, "ftypheic", "image/heic"},
+	{4, "ftypheix", "image/heic"},
+	{4, "ftypmif1", "image/heif"},
+	{4, "ftypavif", "image/avif"},
+}
+
+// detectMimeType returns the MIME type of f, named name, from its contents,
+// falling back to its extension when they only show it is text or binary.
+// It leaves f at the start.
+func detectMimeType(f *os.File, name string) (string, error) {
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	buf = buf[:n]
+	for _, s := range signatures {
+		if len(buf) >= s.offset+len(s.magic) && bytes.Equal(buf[s.offset:s.offset+len(s.magic)], []byte(s.magic)) {
+			return s.mime, nil
+		}
+	}
+	sniffed := http.DetectContentType(buf)
+	if sniffed != "application/octet-stream" && !strings.HasPrefix(sniffed, "text/plain") {
+		return sniffed, nil
+	}
+	if byExt := mime.TypeByExtension(filepath.Ext(name)); byExt != "" {
+		return byExt, nil
+	}
+	return sniffed, nil
+}
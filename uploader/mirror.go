@@ -0,0 +1,36 @@
+package uploader
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+)
+
+// mirrorDirs creates the Drive folders matching the allowed directories
+// beneath root in every account, so with Mirror even empty directories
+// appear in Drive. Folders for files are otherwise only created as they are
+// uploaded.
+func (u *Uploader) mirrorDirs(ctx context.Context, root string) {
+	if !u.opts.Mirror || u.opts.DryRun {
+		return
+	}
+	filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.IsDir() {
+			return nil
+		}
+		if !u.dirAllowed(p) {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(u.rootOf(p), p)
+		if err != nil || rel == "." {
+			return nil
+		}
+		for _, a := range u.accounts.accounts {
+			if _, err := u.folderFor(ctx, a, a.folderId, filepath.ToSlash(rel)); err != nil {
+				errorf(ctx, "failed to create Drive folder for %s: %s", p, err)
+				return filepath.SkipDir
+			}
+		}
+		return ctx.Err()
+	})
+}
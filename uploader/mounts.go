@@ -0,0 +1,93 @@
+package uploader
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// rootOf returns the watched tree that dir belongs to: a mount beneath
+// MountRoot, or the input directory.
+func (u *Uploader) rootOf(dir string) string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for m := range u.mounts {
+		if dir == m || strings.HasPrefix(dir, m+string(filepath.Separator)) {
+			return m
+		}
+	}
+	return u.inputDir
+}
+
+// watchMounts adds every filesystem mounted beneath MountRoot as another
+// input directory, and keeps doing so as media is inserted and removed.
+func (u *Uploader) watchMounts(ctx context.Context) {
+	changed, err := mountChanges(ctx)
+	if err != nil {
+		warnf(ctx, "Not watching for mounts under %s: %s", u.opts.MountRoot, err)
+		return
+	}
+	for {
+		mounts, err := listMounts(u.opts.MountRoot)
+		if err != nil {
+			errorf(ctx, "failed to list mounts: %s", err)
+		}
+		u.mu.Lock()
+		var added, removed []string
+		for m := range mounts {
+			if !u.mounts[m] {
+				added = append(added, m)
+			}
+		}
+		for m := range u.mounts {
+			if !mounts[m] {
+				removed = append(removed, m)
+				delete(u.mounts, m)
+			}
+		}
+		u.mu.Unlock()
+		for _, m := range removed {
+			// The kernel drops the watches on an unmounted filesystem, so
+			// there's nothing left to remove.
+			infof(ctx, "Stopped watching unmounted %s", m)
+		}
+		for _, m := range added {
+			u.addMount(ctx, m)
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// addMount watches a newly mounted filesystem and uploads what's on it.
+func (u *Uploader) addMount(ctx context.Context, m string) {
+	// Register the mount first so dirAllowed measures depth from it.
+	u.mu.Lock()
+	u.mounts[m] = true
+	u.mu.Unlock()
+	var err error
+	if u.opts.Recursive {
+		err = u.addWatchTree(m)
+	} else {
+		err = u.watcher.Add(m)
+	}
+	if err != nil {
+		errorf(ctx, "failed to add watcher for mount %s: %s", m, err)
+		u.mu.Lock()
+		delete(u.mounts, m)
+		u.mu.Unlock()
+		return
+	}
+	infof(ctx, "Watching mounted %s", m)
+	err = u.walkFiles(m, func(name string, fi os.FileInfo) error {
+		u.discover(ctx, name, fi.Size(), "Found file on mounted media: %s")
+		return nil
+	})
+	if err != nil {
+		errorf(ctx, "failed to list %s: %s", m, err)
+	}
+}
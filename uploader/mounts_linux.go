@@ -0,0 +1,83 @@
+package uploader
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const procMounts = "/proc/self/mounts"
+
+// listMounts returns the mount points strictly beneath root.
+func listMounts(root string) (map[string]bool, error) {
+	f, err := os.Open(procMounts)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	root = filepath.Clean(root)
+	mounts := make(map[string]bool)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		if m := unescapeMount(fields[1]); strings.HasPrefix(m, root+"/") {
+			mounts[m] = true
+		}
+	}
+	return mounts, s.Err()
+}
+
+// unescapeMount decodes the octal escapes (e.g. \040 for space) the kernel
+// uses in mount paths.
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// mountChanges signals whenever the mount table changes. The kernel flags
+// /proc/self/mounts with POLLPRI on every mount and unmount, which catches
+// udisks, autofs and manual mounts alike.
+func mountChanges(ctx context.Context) (<-chan struct{}, error) {
+	f, err := os.Open(procMounts)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer f.Close()
+		fds := []unix.PollFd{{Fd: int32(f.Fd()), Events: unix.POLLPRI}}
+		for ctx.Err() == nil {
+			// Wake up every second to notice cancellation.
+			n, err := unix.Poll(fds, 1000)
+			if err != nil || n == 0 || fds[0].Revents&(unix.POLLPRI|unix.POLLERR) == 0 {
+				continue
+			}
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package uploader
+
+import (
+	"context"
+	"errors"
+)
+
+var errMountsUnsupported = errors.New("mount watching is only supported on Linux")
+
+func listMounts(root string) (map[string]bool, error) {
+	return nil, errMountsUnsupported
+}
+
+func mountChanges(ctx context.Context) (<-chan struct{}, error) {
+	return nil, errMountsUnsupported
+}
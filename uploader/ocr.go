@@ -0,0 +1,53 @@
+package uploader
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxIndexableText is the largest contentHints.indexableText Drive accepts.
+const maxIndexableText = 128 << 10
+
+// extractText runs the configured OCR command against f and returns its
+// output, suitable for use as indexable text.
+func (u *Uploader) extractText(ctx context.Context, f string) string {
+	if len(u.opts.OCRCommand) == 0 {
+		return ""
+	}
+	args := expandCommand(u.opts.OCRCommand, f)
+	out, err := exec.CommandContext(ctx, args[0], args[1:]...).Output()
+	if err != nil {
+		warnf(ctx, "OCR of %s failed, uploading without indexable text: %s", f, err)
+		return ""
+	}
+	text := strings.TrimSpace(string(out))
+	if len(text) > maxIndexableText {
+		// Don't split a multi-byte rune.
+		cut := maxIndexableText
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		text = text[:cut]
+	}
+	return text
+}
+
+// expandCommand replaces "{}" in the arguments of cmd with the path f, or
+// appends f if there is no "{}".
+func expandCommand(cmd []string, f string) []string {
+	args := make([]string, 0, len(cmd)+1)
+	replaced := false
+	for _, a := range cmd {
+		if strings.Contains(a, "{}") {
+			a = strings.ReplaceAll(a, "{}", f)
+			replaced = true
+		}
+		args = append(args, a)
+	}
+	if !replaced {
+		args = append(args, f)
+	}
+	return args
+}
@@ -0,0 +1,263 @@
+package uploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/events"
+	"github.com/dknowles2/gdrive_sync/statefile"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// offlineProbeAddr is dialled to tell whether Drive can be reached.
+	offlineProbeAddr    = "www.googleapis.com:443"
+	offlineProbeTimeout = 10 * time.Second
+	// Probes back off from offlineMinDelay to offlineMaxDelay while the
+	// network stays down.
+	offlineMinDelay = 5 * time.Second
+	offlineMaxDelay = 5 * time.Minute
+)
+
+// OfflineQueue remembers, in a state file, the files whose uploads failed
+// because Drive couldn't be reached, so they are retried once it can be,
+// even after a restart. One OfflineQueue may be shared by several
+// Uploaders.
+type OfflineQueue struct {
+	path string
+
+	mu    sync.Mutex
+	files map[string]string // paths by pathKey
+}
+
+// NewOfflineQueue returns an OfflineQueue keeping its state in the file at
+// path. An empty path keeps it in memory only.
+func NewOfflineQueue(path string) (*OfflineQueue, error) {
+	q := &OfflineQueue{path: path, files: make(map[string]string)}
+	if path == "" {
+		return q, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read offline queue: %w", err)
+	}
+	var files []string
+	if err := json.Unmarshal(data, &files); err != nil {
+		// The files are still there to be found by the initial scan.
+		warnf(context.Background(), "Ignoring unreadable offline queue %s: %s", path, err)
+		return q, nil
+	}
+	for _, f := range files {
+		q.files[pathKey(f)] = f
+	}
+	return q, nil
+}
+
+// add queues f, reporting whether it wasn't already.
+func (q *OfflineQueue) add(f string) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.files[pathKey(f)]; ok {
+		return false, nil
+	}
+	q.files[pathKey(f)] = f
+	return true, q.saveLocked()
+}
+
+func (q *OfflineQueue) remove(f string) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.files[pathKey(f)]; !ok {
+		return false, nil
+	}
+	delete(q.files, pathKey(f))
+	return true, q.saveLocked()
+}
+
+// list returns the queued files for which keep is true, sorted.
+func (q *OfflineQueue) list(keep func(string) bool) []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var files []string
+	for _, f := range q.files {
+		if keep(f) {
+			files = append(files, f)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+func (q *OfflineQueue) saveLocked() error {
+	if q.path == "" {
+		return nil
+	}
+	files := make([]string, 0, len(q.files))
+	for _, f := range q.files {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	data, err := json.Marshal(files)
+	if err != nil {
+		return err
+	}
+	if err := statefile.Write(q.path, data, 0600); err != nil {
+		return fmt.Errorf("unable to write offline queue: %w", err)
+	}
+	return nil
+}
+
+// isOffline reports whether err means Drive couldn't be reached at all, as
+// opposed to it failing the request.
+func isOffline(err error) bool {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	for _, errno := range []syscall.Errno{syscall.ECONNREFUSED, syscall.ENETUNREACH, syscall.EHOSTUNREACH, syscall.ENETDOWN} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	// A dial that timed out never got as far as Drive.
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// owns reports whether f is one of u's files.
+func (u *Uploader) owns(f string) bool {
+	return within(u.inputDir, f) || (u.opts.MountRoot != "" && within(u.opts.MountRoot, f))
+}
+
+// queueOffline holds f, whose upload failed because Drive is unreachable,
+// until processOffline finds the network is back.
+func (u *Uploader) queueOffline(ctx context.Context, f string, err error) {
+	added, qerr := u.opts.OfflineQueue.add(f)
+	if qerr != nil {
+		errorf(ctx, "failed to save offline queue: %s", qerr)
+	}
+	u.mu.Lock()
+	first := !u.offline
+	u.offline = true
+	if added {
+		u.offlineQueued++
+	}
+	u.mu.Unlock()
+	if first {
+		warnf(ctx, "Drive is unreachable (%s); queueing uploads from %s until it is back", err, u.inputDir)
+		u.emit(ctx, events.Event{Type: events.Offline, File: u.inputDir, Error: err.Error()})
+	}
+	infof(ctx, "Queued %s until Drive is reachable", f)
+}
+
+// dequeueOffline drops f from the offline queue, if it is there, once it
+// has been dealt with some other way than waiting for the network, and
+// reports the backlog cleared once nothing is left in it.
+func (u *Uploader) dequeueOffline(ctx context.Context, f string) {
+	removed, err := u.opts.OfflineQueue.remove(f)
+	if err != nil {
+		errorf(ctx, "failed to save offline queue: %s", err)
+	}
+	if !removed || len(u.opts.OfflineQueue.list(u.owns)) > 0 {
+		return
+	}
+	u.mu.Lock()
+	cleared := u.offline
+	n := u.offlineQueued
+	u.offline = false
+	u.offlineQueued = 0
+	u.mu.Unlock()
+	if cleared {
+		infof(ctx, "Offline backlog of %d uploads from %s cleared", n, u.inputDir)
+		u.emit(ctx, events.Event{Type: events.BacklogCleared, File: u.inputDir, Size: int64(n)})
+	}
+}
+
+// processOffline watches for the network to come back while uploads are
+// queued in the offline queue, probing with backoff, and then starts them
+// again.
+func (u *Uploader) processOffline(ctx context.Context) {
+	// Whatever was queued before a restart is tried at once.
+	next := time.Now()
+	delay := offlineMinDelay
+	t := time.NewTicker(offlineMinDelay)
+	defer t.Stop()
+	for {
+		u.mu.Lock()
+		var due []string
+		for _, f := range u.opts.OfflineQueue.list(u.owns) {
+			if !u.inProgress[pathKey(f)] {
+				due = append(due, f)
+			}
+		}
+		if len(due) > 0 && !u.offline {
+			// Queued before a restart.
+			u.offline = true
+			u.offlineQueued = len(due)
+		}
+		u.mu.Unlock()
+
+		if len(due) == 0 {
+			delay = offlineMinDelay
+		} else if !time.Now().Before(next) {
+			if err := probeDrive(ctx); err != nil {
+				next = time.Now().Add(delay)
+				warnf(ctx, "Drive is still unreachable (%s); checking again in %s", err, delay)
+				if delay *= 2; delay > offlineMaxDelay {
+					delay = offlineMaxDelay
+				}
+			} else {
+				delay = offlineMinDelay
+				infof(ctx, "Drive is reachable again; retrying %d queued uploads from %s", len(due), u.inputDir)
+				for _, f := range due {
+					u.retryOffline(ctx, f)
+				}
+			}
+		}
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (u *Uploader) retryOffline(ctx context.Context, f string) {
+	if _, err := os.Stat(f); err != nil {
+		// Gone while we waited; nothing left to upload.
+		u.dequeueOffline(ctx, f)
+		return
+	}
+	u.uploads.Add(1)
+	go func() {
+		defer u.uploads.Done()
+		u.upload(u.uploadContext(withCorrelationId(ctx, newCorrelationId())), f)
+	}()
+}
+
+// probeDrive returns an error if Drive's API host can't be connected to.
+func probeDrive(ctx context.Context) error {
+	d := net.Dialer{Timeout: offlineProbeTimeout}
+	c, err := d.DialContext(ctx, "tcp", offlineProbeAddr)
+	if err != nil {
+		return err
+	}
+	return c.Close()
+}
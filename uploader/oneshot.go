@@ -0,0 +1,60 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/dknowles2/gdrive_sync/events"
+)
+
+// UploadExisting takes the files already in the input directory, or just
+// files if any are given, through the same pipeline as Run and returns once
+// each has been uploaded or has failed. Unlike Run it doesn't watch for new
+// files, so it suits one-shot uploads from cron.
+func (u *Uploader) UploadExisting(ctx context.Context, files ...string) error {
+	if len(files) == 0 {
+		err := u.walkFiles(u.inputDir, func(name string, _ os.FileInfo) error {
+			if target, ok := u.triggered(name); ok {
+				files = append(files, target)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list directory contents: %w", err)
+		}
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := 0
+	for _, f := range files {
+		wg.Add(1)
+		go func(f string) {
+			defer wg.Done()
+			ctx := withCorrelationId(ctx, newCorrelationId())
+			infof(ctx, "Found file: %s", f)
+			u.emit(ctx, events.Event{Type: events.Discovered, File: f})
+			if err := u.process(ctx, f); err != nil {
+				mu.Lock()
+				failed++
+				mu.Unlock()
+			}
+		}(f)
+	}
+	wg.Wait()
+	// There's no later for deletions to be retried in, nor for parked
+	// uploads, which the next run will pick up.
+	u.drainDeletes(ctx)
+	u.reportUndeletable()
+	u.mu.Lock()
+	undeletable := len(u.undeletable)
+	u.mu.Unlock()
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed to upload", failed, len(files))
+	}
+	if undeletable > 0 {
+		return fmt.Errorf("%d uploaded files could not be deleted", undeletable)
+	}
+	return ctx.Err()
+}
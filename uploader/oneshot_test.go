@@ -0,0 +1,39 @@
+package uploader
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadExistingDrainsDeletes(t *testing.T) {
+	defer func(d []time.Duration) { deleteRetryDelays = d }(deleteRetryDelays)
+	deleteRetryDelays = []time.Duration{time.Millisecond, time.Millisecond}
+
+	// A file where this year's archive folder should be keeps the upload
+	// from ever being moved out of the way.
+	archive, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(archive)
+	writeTestFile(t, archive, time.Now().Format("2006"), nil)
+	u, fd, in, out := newTestUploader(t, Options{ArchiveDir: archive, ArchiveLayout: "2006", Stability: SizeStable{Interval: time.Millisecond, Checks: 1}})
+	f := writeTestFile(t, in, "scan.pdf", []byte("scan"))
+
+	err = u.UploadExisting(context.Background(), f)
+	if err == nil || !strings.Contains(err.Error(), "could not be deleted") {
+		t.Fatalf("UploadExisting = %v, want an error about the undeletable file", err)
+	}
+	checkUploaded(t, fd, out, "scan.pdf", []byte("scan"))
+	if len(u.deletes) != 0 {
+		t.Errorf("%d deletions are still queued", len(u.deletes))
+	}
+	if _, ok := u.undeletable[filepath.Clean(f)]; !ok {
+		t.Errorf("%s isn't reported as undeletable", f)
+	}
+}
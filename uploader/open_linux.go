@@ -0,0 +1,81 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// fileIsOpen reports whether any other process has f open, by looking for
+// it among every process's /proc/PID/fd links. Processes whose descriptors
+// can't be read (those of other users, without root) are covered by trying
+// to take a write lease on f, which fails while anyone has it open.
+func fileIsOpen(ctx context.Context, f string) (bool, error) {
+	fi, err := os.Stat(f)
+	if err != nil {
+		return false, err
+	}
+	proc, err := os.Open("/proc")
+	if err != nil {
+		return leaseHeld(f)
+	}
+	names, err := proc.Readdirnames(-1)
+	proc.Close()
+	if err != nil {
+		return leaseHeld(f)
+	}
+	self := strconv.Itoa(os.Getpid())
+	hidden := false
+	for _, pid := range names {
+		if _, err := strconv.Atoi(pid); err != nil || pid == self {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		dir := filepath.Join("/proc", pid, "fd")
+		d, err := os.Open(dir)
+		if err != nil {
+			// Processes that exit meanwhile are no loss.
+			hidden = hidden || os.IsPermission(err)
+			continue
+		}
+		fds, _ := d.Readdirnames(-1)
+		d.Close()
+		for _, fd := range fds {
+			if st, err := os.Stat(filepath.Join(dir, fd)); err == nil && os.SameFile(fi, st) {
+				return true, nil
+			}
+		}
+	}
+	if hidden {
+		return leaseHeld(f)
+	}
+	return false, nil
+}
+
+// leaseHeld reports whether f is open elsewhere by trying to take a write
+// lease on it. The lease needs CAP_LEASE or ownership of f; without either
+// the file is assumed closed.
+func leaseHeld(f string) (bool, error) {
+	file, err := os.Open(f)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	fd := int(file.Fd())
+	_, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_SETLEASE, syscall.F_WRLCK)
+	switch {
+	case errno == 0:
+		syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_SETLEASE, syscall.F_UNLCK)
+		return false, nil
+	case errors.Is(errno, syscall.EAGAIN), errors.Is(errno, syscall.EBUSY):
+		return true, nil
+	case errors.Is(errno, syscall.EACCES), errors.Is(errno, syscall.EPERM):
+		return false, nil
+	}
+	return false, errno
+}
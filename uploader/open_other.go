@@ -0,0 +1,31 @@
+//go:build !windows && !linux
+// +build !windows,!linux
+
+package uploader
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+)
+
+// fileIsOpen reports whether any process has f open, using lsof.
+func fileIsOpen(ctx context.Context, f string) (bool, error) {
+	lsof, err := exec.LookPath("lsof")
+	if err != nil {
+		return false, err
+	}
+	_, err = exec.CommandContext(ctx, lsof, "-w", "-F", "p", f).Output()
+	if err == nil {
+		return true, nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// lsof will return an error return code and an empty stderr if the file is not open.
+		if string(exitErr.Stderr) == "" {
+			return false, nil
+		}
+		return false, err
+	}
+	return false, err
+}
@@ -0,0 +1,26 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"syscall"
+)
+
+// fileIsOpen reports whether any process has f open, by trying to open it
+// without sharing: Windows refuses that with a sharing violation while
+// another handle is open.
+func fileIsOpen(ctx context.Context, f string) (bool, error) {
+	name, err := syscall.UTF16PtrFromString(longPath(f))
+	if err != nil {
+		return false, err
+	}
+	h, err := syscall.CreateFile(name, syscall.GENERIC_READ, 0, nil, syscall.OPEN_EXISTING, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		if errors.Is(err, errorSharingViolation) || errors.Is(err, errorLockViolation) {
+			return true, nil
+		}
+		return false, err
+	}
+	syscall.CloseHandle(h)
+	return false, nil
+}
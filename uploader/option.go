@@ -0,0 +1,143 @@
+package uploader
+
+import (
+	"reflect"
+
+	"github.com/dknowles2/gdrive_sync/events"
+	"github.com/dknowles2/gdrive_sync/gdrive"
+	"github.com/dknowles2/gdrive_sync/journal"
+	"github.com/dknowles2/gdrive_sync/manifest"
+)
+
+// Option sets one of the Options of an Uploader made by Build, for
+// programs embedding the upload pipeline.
+type Option func(*Options)
+
+// Build returns an Uploader of the files in the directory in to the Drive
+// folder out, configured by options applied in order. It is New with the
+// Options built up one at a time.
+func Build(in, out string, d *gdrive.Drive, options ...Option) (*Uploader, error) {
+	var opts Options
+	for _, o := range options {
+		o(&opts)
+	}
+	return New(in, out, d, opts)
+}
+
+// WithOptions sets the fields of opts that aren't zero, for setting those
+// without an Option of their own. The rest keep what earlier options set.
+func WithOptions(opts Options) Option {
+	return func(o *Options) {
+		src, dst := reflect.ValueOf(opts), reflect.ValueOf(o).Elem()
+		for i := 0; i < src.NumField(); i++ {
+			if f := src.Field(i); !f.IsZero() {
+				dst.Field(i).Set(f)
+			}
+		}
+	}
+}
+
+// WithEvents sends every pipeline event to s, as well as any sinks already
+// given.
+func WithEvents(s events.Sink) Option {
+	return func(o *Options) {
+		if o.Events == nil {
+			o.Events = s
+		} else {
+			o.Events = events.Multi(o.Events, s)
+		}
+	}
+}
+
+// OnEvent calls fn with every pipeline event of the given types, or of every
+// type if none are given. fn is called synchronously by the pipeline, so it
+// should return quickly.
+func OnEvent(fn func(events.Event), types ...events.Type) Option {
+	return WithEvents(events.Func(func(e events.Event) {
+		if len(types) == 0 {
+			fn(e)
+			return
+		}
+		for _, t := range types {
+			if e.Type == t {
+				fn(e)
+				return
+			}
+		}
+	}))
+}
+
+// OnUploadStarted calls fn as each attempt to upload a file begins, after
+// any hooks already given.
+func OnUploadStarted(fn func(events.Event)) Option {
+	return func(o *Options) { o.Hooks.OnUploadStarted = then(o.Hooks.OnUploadStarted, fn) }
+}
+
+// OnProgress calls fn as files are sent.
+func OnProgress(fn func(events.Event)) Option {
+	return func(o *Options) { o.Hooks.OnProgress = then(o.Hooks.OnProgress, fn) }
+}
+
+// OnUploadComplete calls fn once each file is uploaded.
+func OnUploadComplete(fn func(events.Event)) Option {
+	return func(o *Options) { o.Hooks.OnUploadComplete = then(o.Hooks.OnUploadComplete, fn) }
+}
+
+// OnUploadFailed calls fn with each file given up on and why.
+func OnUploadFailed(fn func(events.Event, error)) Option {
+	return func(o *Options) {
+		prev := o.Hooks.OnUploadFailed
+		o.Hooks.OnUploadFailed = func(e events.Event, err error) {
+			if prev != nil {
+				prev(e, err)
+			}
+			fn(e, err)
+		}
+	}
+}
+
+// WithRecursive watches subdirectories too, down to maxDepth levels (0 for
+// unlimited).
+func WithRecursive(maxDepth int) Option {
+	return func(o *Options) { o.Recursive, o.MaxDepth = true, maxDepth }
+}
+
+// WithKeepFiles leaves files in place once they are uploaded.
+func WithKeepFiles() Option {
+	return func(o *Options) { o.KeepFiles = true }
+}
+
+// WithArchiveDir moves uploaded files into dir instead of deleting them.
+func WithArchiveDir(dir string) Option {
+	return func(o *Options) { o.ArchiveDir = dir }
+}
+
+// WithRoutes sends files matching the routes to other folders.
+func WithRoutes(routes ...Route) Option {
+	return func(o *Options) { o.Routes = append(o.Routes, routes...) }
+}
+
+// WithBackends also copies every file to the backends.
+func WithBackends(backends ...Backend) Option {
+	return func(o *Options) { o.Backends = append(o.Backends, backends...) }
+}
+
+// WithJournal records every upload attempt in j.
+func WithJournal(j *journal.Journal) Option {
+	return func(o *Options) { o.Journal = j }
+}
+
+// WithManifest records every upload in m.
+func WithManifest(m *manifest.Manifest) Option {
+	return func(o *Options) { o.Manifest = m }
+}
+
+// WithCreateOutputDir creates the output folder if it doesn't exist.
+func WithCreateOutputDir() Option {
+	return func(o *Options) { o.CreateOutputDir = true }
+}
+
+// WithDryRun logs what would be uploaded without changing anything.
+func WithDryRun() Option {
+	return func(o *Options) { o.DryRun = true }
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package uploader
+
+import "path/filepath"
+
+func longPath(p string) string  { return p }
+func shortPath(p string) string { return p }
+
+// pathKey identifies a file for duplicate detection.
+func pathKey(p string) string { return filepath.Clean(p) }
@@ -0,0 +1,45 @@
+package uploader
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+const (
+	longPrefix    = `\\?\`
+	longUNCPrefix = `\\?\UNC\`
+)
+
+// longPath returns p in the \\?\ form that lifts the 260 character MAX_PATH
+// limit, for APIs such as ReadDirectoryChangesW that the os package doesn't
+// convert itself. UNC paths (\\server\share) become \\?\UNC\server\share.
+func longPath(p string) string {
+	if strings.HasPrefix(p, longPrefix) {
+		return p
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return longUNCPrefix + abs[2:]
+	}
+	return longPrefix + abs
+}
+
+// shortPath undoes longPath, so paths compare equal however they were given.
+func shortPath(p string) string {
+	switch {
+	case strings.HasPrefix(p, longUNCPrefix):
+		return `\\` + p[len(longUNCPrefix):]
+	case strings.HasPrefix(p, longPrefix):
+		return p[len(longPrefix):]
+	}
+	return p
+}
+
+// pathKey identifies a file for duplicate detection. Windows file systems are
+// case-insensitive, so the same file may be reported as Scan.PDF and scan.pdf.
+func pathKey(p string) string {
+	return strings.ToLower(filepath.Clean(shortPath(p)))
+}
@@ -0,0 +1,40 @@
+package uploader
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dknowles2/gdrive_sync/photos"
+)
+
+// isPhoto reports whether f goes to Photos: if the route it matches says
+// so or, when it matches no route, if its name matches PhotosPatterns.
+func (u *Uploader) isPhoto(f string) bool {
+	if u.opts.Photos == nil {
+		return false
+	}
+	if r := u.routeFor(f); r != nil {
+		return r.photos
+	}
+	// Scanners and cameras disagree on extension case, so match lowercase.
+	base := strings.ToLower(filepath.Base(f))
+	for _, p := range u.opts.PhotosPatterns {
+		if ok, _ := filepath.Match(p, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *Uploader) uploadPhoto(ctx context.Context, name string) (*photos.MediaItem, error) {
+	infof(ctx, "Uploading file to Photos: %s", name)
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	progressed(ctx)
+	return u.opts.Photos.Upload(ctx, name, &progressReader{ctx, f})
+}
@@ -0,0 +1,170 @@
+package uploader
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// pollWatcher is a fileWatcher that rescans its directories on an interval,
+// for network file systems such as SMB and NFS where change notifications
+// never arrive for changes made by other machines.
+type pollWatcher struct {
+	interval time.Duration
+	events   chan fsnotify.Event
+	errors   chan error
+	done     chan struct{}
+	once     sync.Once
+
+	mu   sync.Mutex
+	dirs map[string]map[string]fileStamp // dir -> entry name -> last seen
+}
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func newPollWatcher(interval time.Duration) *pollWatcher {
+	w := &pollWatcher{
+		interval: interval,
+		events:   make(chan fsnotify.Event),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+		dirs:     make(map[string]map[string]fileStamp),
+	}
+	go w.run()
+	return w
+}
+
+func (w *pollWatcher) Events() <-chan fsnotify.Event { return w.events }
+func (w *pollWatcher) Errors() <-chan error          { return w.errors }
+
+// Add starts polling name. What is in it now is the baseline, so only later
+// changes are reported.
+func (w *pollWatcher) Add(name string) error {
+	entries, err := scanDir(name)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	select {
+	case <-w.done:
+		return errors.New("watcher closed")
+	default:
+	}
+	w.dirs[name] = entries
+	return nil
+}
+
+func (w *pollWatcher) Close() error {
+	w.once.Do(func() { close(w.done) })
+	return nil
+}
+
+func scanDir(dir string) (map[string]fileStamp, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]fileStamp, len(files))
+	for _, fi := range files {
+		entries[fi.Name()] = fileStamp{size: fi.Size(), modTime: fi.ModTime(), dir: fi.IsDir()}
+	}
+	return entries, nil
+}
+
+func (w *pollWatcher) run() {
+	defer close(w.events)
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-w.done:
+			return
+		}
+		w.mu.Lock()
+		dirs := make([]string, 0, len(w.dirs))
+		for d := range w.dirs {
+			dirs = append(dirs, d)
+		}
+		w.mu.Unlock()
+		for _, d := range dirs {
+			if !w.poll(d) {
+				return
+			}
+		}
+	}
+}
+
+// poll rescans dir and sends events for what changed since the last scan.
+// It returns false once the watcher is closed.
+func (w *pollWatcher) poll(dir string) bool {
+	entries, err := scanDir(dir)
+	if os.IsNotExist(err) {
+		w.mu.Lock()
+		delete(w.dirs, dir)
+		w.mu.Unlock()
+		return w.send(fsnotify.Event{Name: dir, Op: fsnotify.Remove})
+	}
+	if err != nil {
+		select {
+		case w.errors <- err:
+			return true
+		case <-w.done:
+			return false
+		}
+	}
+	w.mu.Lock()
+	prev, ok := w.dirs[dir]
+	if ok {
+		w.dirs[dir] = entries
+	}
+	w.mu.Unlock()
+	if !ok {
+		return true
+	}
+	for name, st := range entries {
+		p := filepath.Join(dir, name)
+		old, seen := prev[name]
+		switch {
+		case !seen && st.dir:
+			if !w.send(fsnotify.Event{Name: p, Op: fsnotify.Create}) {
+				return false
+			}
+		case !seen:
+			if !w.send(fsnotify.Event{Name: p, Op: fsnotify.Create | fsnotify.Write}) {
+				return false
+			}
+		case !st.dir && (st.size != old.size || !st.modTime.Equal(old.modTime)):
+			if !w.send(fsnotify.Event{Name: p, Op: fsnotify.Write}) {
+				return false
+			}
+		}
+	}
+	for name := range prev {
+		if _, ok := entries[name]; !ok {
+			if !w.send(fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Remove}) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (w *pollWatcher) send(e fsnotify.Event) bool {
+	select {
+	case w.events <- e:
+		return true
+	case <-w.done:
+		return false
+	}
+}
@@ -0,0 +1,87 @@
+package uploader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// pollEvents rescans dir once, returning the events sent for it by name
+// relative to dir.
+func pollEvents(t *testing.T, w *pollWatcher, dir string) map[string]fsnotify.Op {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		w.poll(dir)
+		close(done)
+	}()
+	got := make(map[string]fsnotify.Op)
+	for {
+		select {
+		case e := <-w.Events():
+			rel, _ := filepath.Rel(dir, e.Name)
+			got[rel] |= e.Op
+		case <-done:
+			return got
+		}
+	}
+}
+
+func checkEvents(t *testing.T, what string, got, want map[string]fsnotify.Op) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%s: got events %v, want %v", what, got, want)
+	}
+}
+
+func TestPollWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "poll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	old := writeTestFile(t, dir, "a.pdf", []byte("a"))
+
+	// Polls are driven by hand rather than by the ticker.
+	w := newPollWatcher(time.Hour)
+	defer w.Close()
+	if err := w.Add(dir); err != nil {
+		t.Fatalf("Add: %s", err)
+	}
+	checkEvents(t, "nothing changed", pollEvents(t, w, dir), map[string]fsnotify.Op{})
+
+	writeTestFile(t, dir, "b.pdf", []byte("b"))
+	if err := ioutil.WriteFile(old, []byte("a, rescanned"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	checkEvents(t, "after changes", pollEvents(t, w, dir), map[string]fsnotify.Op{
+		"a.pdf": fsnotify.Write,
+		"b.pdf": fsnotify.Create | fsnotify.Write,
+		"sub":   fsnotify.Create,
+	})
+
+	if err := os.Remove(old); err != nil {
+		t.Fatal(err)
+	}
+	checkEvents(t, "after a removal", pollEvents(t, w, dir), map[string]fsnotify.Op{"a.pdf": fsnotify.Remove})
+
+	// A directory that has gone is reported once and no longer polled.
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	checkEvents(t, "after removing the directory", pollEvents(t, w, dir), map[string]fsnotify.Op{".": fsnotify.Remove})
+	w.mu.Lock()
+	_, polled := w.dirs[dir]
+	w.mu.Unlock()
+	if polled {
+		t.Error("the removed directory is still polled")
+	}
+}
@@ -0,0 +1,98 @@
+package uploader
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// DefaultProgressInterval and DefaultProgressPercent are how often an
+// upload's progress is logged, unless Options.ProgressInterval and
+// Options.ProgressPercent say otherwise.
+const (
+	DefaultProgressInterval = 10 * time.Second
+	DefaultProgressPercent  = 10
+)
+
+// rateWindow is how far back the transfer rate is measured.
+const rateWindow = 30 * time.Second
+
+type progressSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// progressMeter decides when an upload's progress is worth logging, and
+// measures its rate over the last rateWindow.
+type progressMeter struct {
+	size     int64
+	interval time.Duration
+	percent  float64
+
+	samples     []progressSample
+	logged      time.Time
+	loggedBytes int64
+}
+
+func (u *Uploader) newProgressMeter(size int64) *progressMeter {
+	m := &progressMeter{size: size, interval: u.opts.ProgressInterval, percent: u.opts.ProgressPercent}
+	if m.interval <= 0 {
+		m.interval = DefaultProgressInterval
+	}
+	if m.percent <= 0 {
+		m.percent = DefaultProgressPercent
+	}
+	now := time.Now()
+	m.samples = []progressSample{{now, 0}}
+	m.logged = now
+	return m
+}
+
+// update records that bytes have been sent, reporting whether to log it:
+// once interval has passed or percent more of the file has been sent since
+// it was last logged, and when the upload is done.
+func (m *progressMeter) update(bytes int64) bool {
+	now := time.Now()
+	m.samples = append(m.samples, progressSample{now, bytes})
+	// Keep one sample older than the window, so it is always covered.
+	i := 0
+	for i < len(m.samples)-2 && now.Sub(m.samples[i+1].at) >= rateWindow {
+		i++
+	}
+	m.samples = m.samples[i:]
+
+	done := m.size > 0 && bytes >= m.size
+	step := m.size > 0 && float64(bytes-m.loggedBytes)*100 >= m.percent*float64(m.size)
+	if !done && !step && now.Sub(m.logged) < m.interval {
+		return false
+	}
+	m.logged, m.loggedBytes = now, bytes
+	return true
+}
+
+// rate returns the bytes sent per second over the last rateWindow.
+func (m *progressMeter) rate() float64 {
+	first, last := m.samples[0], m.samples[len(m.samples)-1]
+	d := last.at.Sub(first.at).Seconds()
+	if d <= 0 {
+		return 0
+	}
+	return float64(last.bytes-first.bytes) / d
+}
+
+// String describes the progress last recorded, along the lines of
+// "12 MB/100 MB (12%) at 1.2 MB/s, 1m13s left".
+func (m *progressMeter) String() string {
+	bytes := m.samples[len(m.samples)-1].bytes
+	if m.size <= 0 {
+		return humanize.Bytes(uint64(bytes))
+	}
+	s := fmt.Sprintf("%s/%s (%.0f%%)", humanize.Bytes(uint64(bytes)), humanize.Bytes(uint64(m.size)), float64(bytes)*100/float64(m.size))
+	r := m.rate()
+	if r <= 0 || bytes >= m.size {
+		return s
+	}
+	eta := time.Duration(float64(m.size-bytes) / r * float64(time.Second))
+	return fmt.Sprintf("%s at %s/s, %s left", s, humanize.Bytes(uint64(r)), eta.Round(time.Second))
+}
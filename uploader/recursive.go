@@ -0,0 +1,111 @@
+package uploader
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dirAllowed reports whether dir, which must be the input directory, a
+// mount beneath MountRoot, or beneath either, should be watched.
+func (u *Uploader) dirAllowed(dir string) bool {
+	rel, err := filepath.Rel(u.rootOf(dir), dir)
+	if err != nil || rel == "." {
+		return err == nil
+	}
+	if shouldIgnore(dir) {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	if u.ignoreFile.ignored(rel, true) {
+		return false
+	}
+	if u.opts.MaxDepth > 0 && strings.Count(rel, "/")+1 > u.opts.MaxDepth {
+		return false
+	}
+	base := filepath.Base(dir)
+	for _, p := range u.opts.ExcludeDirs {
+		p = strings.TrimSuffix(p, "/")
+		if ok, _ := filepath.Match(p, base); ok {
+			return false
+		}
+		if ok, _ := filepath.Match(p, rel); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// addWatchTree watches root and every allowed directory beneath it.
+func (u *Uploader) addWatchTree(root string) error {
+	return filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		if !u.dirAllowed(p) {
+			return filepath.SkipDir
+		}
+		return u.watcher.Add(p)
+	})
+}
+
+// addDir starts watching a directory created while running and uploads any
+// files that landed in it before the watch was in place.
+func (u *Uploader) addDir(ctx context.Context, dir string) {
+	if !u.dirAllowed(dir) {
+		return
+	}
+	infof(ctx, "Watching new directory %s", dir)
+	if err := u.addWatchTree(dir); err != nil {
+		errorf(ctx, "failed to add watcher for %s: %s", dir, err)
+		return
+	}
+	u.mirrorDirs(ctx, dir)
+	err := u.walkFiles(dir, func(name string, fi os.FileInfo) error {
+		u.discover(ctx, name, fi.Size(), "Found new file: %s")
+		return nil
+	})
+	if err != nil {
+		errorf(ctx, "failed to list %s: %s", dir, err)
+	}
+}
+
+// walkFiles calls fn for every file that should be uploaded in root,
+// descending into allowed subdirectories when watching recursively.
+func (u *Uploader) walkFiles(root string, fn func(string, os.FileInfo) error) error {
+	if !u.opts.Recursive {
+		files, err := ioutil.ReadDir(root)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if f.IsDir() || u.ignored(filepath.Join(root, f.Name())) {
+				continue
+			}
+			if err := fn(filepath.Join(root, f.Name()), f); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if !u.dirAllowed(p) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if u.ignored(p) {
+			return nil
+		}
+		return fn(p, fi)
+	})
+}
@@ -0,0 +1,76 @@
+package uploader
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// remotePathData is what a RemotePathTemplate is executed with.
+type remotePathData struct {
+	// Name is the file's name, Stem that without its extension, and Ext
+	// the extension including the dot.
+	Name, Stem, Ext string
+	// Dir is the slash-separated directory of the file relative to the
+	// watched tree, or "" at the top.
+	Dir string
+	// Year, Month and Day are from the file's modification time, zero
+	// padded ("2024", "03", "09").
+	Year, Month, Day string
+	// Time is the file's modification time.
+	Time time.Time
+}
+
+// parseRemotePath parses a RemotePathTemplate.
+func parseRemotePath(s string) (*template.Template, error) {
+	t, err := template.New("remote_path").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote path template: %w", err)
+	}
+	// Catch misspelt fields now rather than on every upload.
+	if err := t.Execute(ioutil.Discard, remotePathData{}); err != nil {
+		return nil, fmt.Errorf("invalid remote path template: %w", err)
+	}
+	return t, nil
+}
+
+// remotePath returns the slash-separated folder, relative to the output
+// folder, that f is uploaded to and the name it is given there.
+func (u *Uploader) remotePath(f string, fi os.FileInfo) (dir, name string, err error) {
+	base := filepath.Base(f)
+	rel := u.relDir(f)
+	if u.opts.Flatten {
+		rel = ""
+	}
+	if u.remotePathTmpl == nil {
+		return rel, base, nil
+	}
+	ext := filepath.Ext(base)
+	mtime := fi.ModTime()
+	data := remotePathData{
+		Name:  base,
+		Stem:  strings.TrimSuffix(base, ext),
+		Ext:   ext,
+		Dir:   rel,
+		Year:  mtime.Format("2006"),
+		Month: mtime.Format("01"),
+		Day:   mtime.Format("02"),
+		Time:  mtime,
+	}
+	var b bytes.Buffer
+	if err := u.remotePathTmpl.Execute(&b, data); err != nil {
+		return "", "", fmt.Errorf("unable to expand remote path for %s: %w", f, err)
+	}
+	s := strings.TrimSpace(b.String())
+	dir, name = path.Split(path.Clean("/" + s))
+	if name == "" || strings.HasSuffix(s, "/") {
+		return "", "", fmt.Errorf("remote path for %s has no file name: %q", f, b.String())
+	}
+	return strings.Trim(dir, "/"), name, nil
+}
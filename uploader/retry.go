@@ -0,0 +1,220 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// DefaultMaxAttempts is how many times an upload failing with transient
+// errors is tried before it is parked, unless Options.MaxAttempts says
+// otherwise.
+const DefaultMaxAttempts = 8
+
+// Backoff between attempts doubles from retryBaseDelay up to retryMaxDelay,
+// with jitter so that uploads failing together don't retry together.
+var (
+	retryBaseDelay = 1 * time.Second
+	retryMaxDelay  = 5 * time.Minute
+)
+
+// parkedRetryDelay is how long a parked upload waits before it is tried
+// again from scratch after its first failure, doubling with each failure in
+// a row up to maxParkedRetryDelay.
+const (
+	parkedRetryDelay    = 30 * time.Minute
+	maxParkedRetryDelay = 24 * time.Hour
+)
+
+var (
+	jitterMu sync.Mutex
+	jitter   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// exhaustedError means an upload kept failing with transient errors until
+// it ran out of attempts.
+type exhaustedError struct {
+	attempts int
+	err      error
+}
+
+func (e *exhaustedError) Error() string {
+	return fmt.Sprintf("giving up after %d attempts: %s", e.attempts, e.err)
+}
+
+func (e *exhaustedError) Unwrap() error { return e.err }
+
+// isTransient reports whether err is worth retrying: rate limiting, a Drive
+// server error, or a dropped connection.
+func isTransient(err error) bool {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		if gerr.Code == http.StatusTooManyRequests || gerr.Code >= 500 {
+			return true
+		}
+		if gerr.Code == http.StatusForbidden {
+			for _, e := range gerr.Errors {
+				switch e.Reason {
+				case "rateLimitExceeded", "userRateLimitExceeded":
+					return true
+				}
+			}
+		}
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	// Backends' errors say for themselves.
+	var te interface{ Temporary() bool }
+	if errors.As(err, &te) && te.Temporary() {
+		return true
+	}
+	if isTimeout(err) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// retryAfter returns how long a Retry-After header on err asks for, or 0.
+func retryAfter(err error) time.Duration {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) || gerr.Header == nil {
+		return 0
+	}
+	h := gerr.Header.Get("Retry-After")
+	if h == "" {
+		return 0
+	}
+	if s, err := strconv.Atoi(h); err == nil {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// backoff returns the delay before the attempt after attempt, picked at
+// random from the upper half of its exponential step.
+func backoff(attempt int) time.Duration {
+	d := retryMaxDelay
+	if attempt < 32 {
+		if s := retryBaseDelay << uint(attempt-1); s < d {
+			d = s
+		}
+	}
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return d/2 + time.Duration(jitter.Int63n(int64(d/2)+1))
+}
+
+// retryTransient calls fn until it succeeds, fails with an error that isn't
+// transient, or has been tried MaxAttempts times.
+func (u *Uploader) retryTransient(ctx context.Context, f string, fn func() error) error {
+	max := u.opts.MaxAttempts
+	if max <= 0 {
+		max = DefaultMaxAttempts
+	}
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !isTransient(err) || ctx.Err() != nil {
+			return err
+		}
+		if attempt >= max {
+			return &exhaustedError{attempts: attempt, err: err}
+		}
+		d := backoff(attempt)
+		if ra := retryAfter(err); ra > d {
+			d = ra
+		}
+		warnf(ctx, "attempt %d of %d to upload %s failed: %s; retrying in %s", attempt, max, f, err, d.Round(time.Millisecond))
+		if err := sleep(ctx, d); err != nil {
+			return err
+		}
+	}
+}
+
+type parkedUpload struct {
+	ctx  context.Context
+	f    string
+	next time.Time
+}
+
+// park queues f, which failed, to be tried again after d. A file that is
+// already parked just has its retry moved.
+func (u *Uploader) park(ctx context.Context, f string, d time.Duration) {
+	warnf(ctx, "Parking %s; trying again in %s", f, d.Round(time.Second))
+	next := time.Now().Add(d)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, p := range u.parked {
+		if pathKey(p.f) == pathKey(f) {
+			p.ctx, p.next = ctx, next
+			return
+		}
+	}
+	u.parked = append(u.parked, &parkedUpload{ctx: ctx, f: f, next: next})
+}
+
+// parkedUntil returns when f, if it is parked, is due to be tried again.
+func (u *Uploader) parkedUntil(f string) (time.Time, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, p := range u.parked {
+		if pathKey(p.f) == pathKey(f) {
+			return p.next, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// processParked starts parked uploads again once they are due, dropping any
+// whose file has gone away.
+func (u *Uploader) processParked(ctx context.Context) {
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		now := time.Now()
+		u.mu.Lock()
+		var due, waiting []*parkedUpload
+		for _, p := range u.parked {
+			if now.Before(p.next) {
+				waiting = append(waiting, p)
+			} else {
+				due = append(due, p)
+			}
+		}
+		u.parked = waiting
+		u.mu.Unlock()
+
+		for _, p := range due {
+			if _, err := os.Stat(p.f); err != nil {
+				continue
+			}
+			infof(p.ctx, "Retrying parked upload of %s", p.f)
+			u.uploads.Add(1)
+			go func(p *parkedUpload) {
+				defer u.uploads.Done()
+				u.upload(u.uploadContext(p.ctx), p.f)
+			}(p)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// temporary is a backend error that says whether it is worth retrying.
+type temporary bool
+
+func (t temporary) Error() string   { return "backend error" }
+func (t temporary) Temporary() bool { return bool(t) }
+
+func TestIsTransient(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"server error", &googleapi.Error{Code: http.StatusServiceUnavailable}, true},
+		{"too many requests", &googleapi.Error{Code: http.StatusTooManyRequests}, true},
+		{"rate limited", &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "userRateLimitExceeded"}}}, true},
+		{"forbidden", &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "insufficientFilePermissions"}}}, false},
+		{"not found", &googleapi.Error{Code: http.StatusNotFound}, false},
+		{"wrapped server error", fmt.Errorf("uploading: %w", &googleapi.Error{Code: http.StatusBadGateway}), true},
+		{"canceled", context.Canceled, false},
+		{"deadline", context.DeadlineExceeded, true},
+		{"temporary backend error", temporary(true), true},
+		{"permanent backend error", temporary(false), false},
+		{"cut off", io.ErrUnexpectedEOF, true},
+		{"reset", &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{"dial", &net.OpError{Op: "dial", Err: errors.New("no route to host")}, true},
+		{"local", os.ErrPermission, false},
+	} {
+		if got := isTransient(tc.err); got != tc.want {
+			t.Errorf("isTransient(%s: %v) = %t, want %t", tc.name, tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	err := &googleapi.Error{Code: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"30"}}}
+	if got := retryAfter(fmt.Errorf("uploading: %w", err)); got != 30*time.Second {
+		t.Errorf("retryAfter = %s, want 30s", got)
+	}
+	if got := retryAfter(&googleapi.Error{Code: http.StatusServiceUnavailable}); got != 0 {
+		t.Errorf("retryAfter without the header = %s, want 0", got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt, step := range map[int]time.Duration{
+		1:  retryBaseDelay,
+		3:  4 * retryBaseDelay,
+		20: retryMaxDelay,
+		40: retryMaxDelay,
+	} {
+		for i := 0; i < 20; i++ {
+			if d := backoff(attempt); d < step/2 || d > step {
+				t.Fatalf("backoff(%d) = %s, want between %s and %s", attempt, d, step/2, step)
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package uploader
+
+import "fmt"
+
+// Route sends files matching Pattern to Folder instead of the output
+// folder. Pattern is a glob, or a regular expression prefixed with "re:";
+// see pattern. Folder is given like the output folder: a name, a path from
+// the Drive root or a folder URL; empty means the output folder.
+//
+// With Convert, matching files are imported as Google Docs, which for
+// scanned PDFs and images means Drive runs OCR on them, in OCRLanguage (an
+// ISO 639-1 code such as "en") if it is set.
+//
+// With Photos, matching files go to the Photos album instead of Drive, and
+// Folder must be empty.
+type Route struct {
+	Pattern     string
+	Folder      string
+	Convert     bool
+	OCRLanguage string
+	Photos      bool
+}
+
+type route struct {
+	pattern     pattern
+	folder      string
+	convert     bool
+	ocrLanguage string
+	photos      bool
+}
+
+func compileRoutes(rs []Route) ([]route, error) {
+	var compiled []route
+	for _, r := range rs {
+		p, err := compilePattern(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %w", r.Folder, err)
+		}
+		if r.Photos && (r.Folder != "" || r.Convert || r.OCRLanguage != "") {
+			return nil, fmt.Errorf("route %s: Photos routes can't have a folder or convert", r.Pattern)
+		}
+		compiled = append(compiled, route{pattern: p, folder: r.Folder, convert: r.Convert || r.OCRLanguage != "", ocrLanguage: r.OCRLanguage, photos: r.Photos})
+	}
+	return compiled, nil
+}
+
+// routeFolders returns the folders named by routes.
+func routeFolders(routes []route) []string {
+	var folders []string
+	for _, r := range routes {
+		if r.folder != "" {
+			folders = append(folders, r.folder)
+		}
+	}
+	return folders
+}
+
+// routeFor returns the first route f matches, or nil.
+func (u *Uploader) routeFor(f string) *route {
+	rel := u.relPath(f)
+	for i := range u.routes {
+		if u.routes[i].pattern.match(rel) {
+			return &u.routes[i]
+		}
+	}
+	return nil
+}
+
+// rootFolder returns the ID of the folder f is uploaded into in account a:
+// that of the route it matches, or the output folder.
+func (u *Uploader) rootFolder(a *account, f string) string {
+	if r := u.routeFor(f); r != nil && r.folder != "" {
+		return a.routeFolders[r.folder]
+	}
+	return a.folderId
+}
+
+// googleDocMimeType is what Drive converts uploads to for OCR.
+const googleDocMimeType = "application/vnd.google-apps.document"
+
+// conversionFor reports whether f is to be imported as a Google Doc, and in
+// which OCR language if one was given.
+func (u *Uploader) conversionFor(f string) (bool, string) {
+	if r := u.routeFor(f); r != nil && r.convert {
+		return true, r.ocrLanguage
+	}
+	return false, ""
+}
+
+// converted reports whether f is imported as a Google Doc.
+func (u *Uploader) converted(f string) bool {
+	convert, _ := u.conversionFor(f)
+	return convert
+}
+
+func ocrLanguageNote(lang string) string {
+	if lang == "" {
+		return ""
+	}
+	return " with OCR in " + lang
+}
@@ -0,0 +1,222 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Schedule limits uploads to certain times of the week, in local time. One
+// Schedule may be shared by several Uploaders.
+type Schedule struct {
+	windows []period
+	quiet   []period
+
+	mu    sync.Mutex
+	held  bool
+	until time.Time
+}
+
+// period is a time of day on some days of the week. One that ends before it
+// starts runs past midnight into the next day.
+type period struct {
+	days       [7]bool // by time.Weekday
+	start, end time.Duration
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parsePeriod parses a period such as "22:00-06:00", "Sat 10:00-12:00" or
+// "Mon-Fri 09:00-17:30".
+func parsePeriod(s string) (period, error) {
+	var p period
+	fields := strings.Fields(s)
+	switch len(fields) {
+	case 1:
+		for i := range p.days {
+			p.days[i] = true
+		}
+	case 2:
+		first, last := fields[0], fields[0]
+		if i := strings.Index(first, "-"); i >= 0 {
+			first, last = first[:i], first[i+1:]
+		}
+		from, ok := weekdays[strings.ToLower(first)]
+		to, ok2 := weekdays[strings.ToLower(last)]
+		if !ok || !ok2 {
+			return p, fmt.Errorf("bad days %q in %q, want e.g. Sat or Mon-Fri", fields[0], s)
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			p.days[d] = true
+			if d == to {
+				break
+			}
+		}
+	default:
+		return p, fmt.Errorf("bad period %q, want e.g. 22:00-06:00 or Mon-Fri 09:00-17:30", s)
+	}
+	times := strings.SplitN(fields[len(fields)-1], "-", 2)
+	if len(times) != 2 {
+		return p, fmt.Errorf("bad times in %q, want e.g. 22:00-06:00", s)
+	}
+	var err error
+	if p.start, err = parseClock(times[0]); err != nil {
+		return p, fmt.Errorf("bad start in %q: %w", s, err)
+	}
+	if p.end, err = parseClock(times[1]); err != nil {
+		return p, fmt.Errorf("bad end in %q: %w", s, err)
+	}
+	if p.start == p.end || p.start == 24*time.Hour {
+		return p, fmt.Errorf("empty period %q", s)
+	}
+	return p, nil
+}
+
+// parseClock parses a time of day like "06:00", or "24:00" for midnight at
+// the end of the day.
+func parseClock(s string) (time.Duration, error) {
+	if s == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (p period) contains(t time.Time) bool {
+	h, m, s := t.Clock()
+	tod := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+	wd := t.Weekday()
+	if p.start < p.end {
+		return p.days[wd] && tod >= p.start && tod < p.end
+	}
+	return (p.days[wd] && tod >= p.start) || (p.days[(wd+6)%7] && tod < p.end)
+}
+
+func parsePeriods(specs []string) ([]period, error) {
+	var ps []period
+	for _, spec := range specs {
+		for _, s := range strings.Split(spec, ",") {
+			if s = strings.TrimSpace(s); s == "" {
+				continue
+			}
+			p, err := parsePeriod(s)
+			if err != nil {
+				return nil, err
+			}
+			ps = append(ps, p)
+		}
+	}
+	return ps, nil
+}
+
+// NewSchedule returns a Schedule allowing uploads only within windows,
+// unless there are none, and never within quiet. Each is a list of
+// comma-separated periods such as "22:00-06:00" or "Mon-Fri 09:00-17:30".
+func NewSchedule(windows, quiet []string) (*Schedule, error) {
+	s := &Schedule{}
+	var err error
+	if s.windows, err = parsePeriods(windows); err != nil {
+		return nil, err
+	}
+	if s.quiet, err = parsePeriods(quiet); err != nil {
+		return nil, err
+	}
+	if now := time.Now(); !s.open(now) && s.next(now).IsZero() {
+		return nil, fmt.Errorf("the upload schedule never allows uploads")
+	}
+	return s, nil
+}
+
+// open reports whether uploads may start at t.
+func (s *Schedule) open(t time.Time) bool {
+	in := len(s.windows) == 0
+	for _, p := range s.windows {
+		in = in || p.contains(t)
+	}
+	if !in {
+		return false
+	}
+	for _, p := range s.quiet {
+		if p.contains(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// next returns the first time after t that uploads may start, or the zero
+// time if they never may. That is always where a period starts or ends.
+func (s *Schedule) next(t time.Time) time.Time {
+	var bounds []time.Time
+	y, m, d := t.Date()
+	for i := 0; i <= 8; i++ {
+		for _, p := range append(append([]period(nil), s.windows...), s.quiet...) {
+			for _, b := range []time.Duration{p.start, p.end} {
+				c := time.Date(y, m, d+i, int(b/time.Hour), int(b%time.Hour/time.Minute), 0, 0, t.Location())
+				if c.After(t) {
+					bounds = append(bounds, c)
+				}
+			}
+		}
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i].Before(bounds[j]) })
+	for _, b := range bounds {
+		if s.open(b) {
+			return b
+		}
+	}
+	return time.Time{}
+}
+
+// heldUntil returns when uploads held back by the schedule resume, or the
+// zero time if none are.
+func (s *Schedule) heldUntil() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.held || !s.until.After(time.Now()) {
+		return time.Time{}
+	}
+	return s.until
+}
+
+// waitForSchedule blocks while the schedule doesn't allow uploads to start.
+// Uploads already running when it closes are finished.
+func (u *Uploader) waitForSchedule(ctx context.Context) error {
+	s := u.opts.Schedule
+	for {
+		now := time.Now()
+		s.mu.Lock()
+		if s.open(now) {
+			if s.held {
+				infof(ctx, "Upload schedule open; resuming uploads")
+				s.held = false
+			}
+			s.mu.Unlock()
+			return nil
+		}
+		resume := s.next(now)
+		if !s.held {
+			s.held = true
+			infof(ctx, "Outside the upload schedule; holding uploads until %s", resume.Format(time.RFC3339))
+		}
+		s.until = resume
+		s.mu.Unlock()
+		// Check at least hourly in case the clock jumps.
+		d := time.Until(resume)
+		if d > time.Hour {
+			d = time.Hour
+		}
+		if err := sleep(ctx, d); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/gdrive"
+	"github.com/dknowles2/gdrive_sync/statefile"
+	"github.com/dustin/go-humanize"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// Sessions remembers the Drive resumable upload sessions of large files
+// being uploaded, in a state file, so an upload interrupted by a crash or
+// restart continues where it left off. One Sessions may be shared by several
+// Uploaders.
+type Sessions struct {
+	path string
+
+	mu       sync.Mutex
+	sessions map[string]session // by pathKey
+}
+
+type session struct {
+	URI     string    `json:"uri"`
+	Account string    `json:"account"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	// Offset is how far the upload had got when last saved. It is only a
+	// hint: Drive is asked for the real offset before resuming.
+	Offset int64 `json:"offset"`
+}
+
+// NewSessions returns a Sessions keeping its state in the file at path.
+func NewSessions(path string) (*Sessions, error) {
+	s := &Sessions{path: path, sessions: make(map[string]session)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read upload sessions: %w", err)
+	}
+	// Losing the sessions only costs re-sending, so a file torn by a crash
+	// mid-write isn't fatal.
+	if err := json.Unmarshal(data, &s.sessions); err != nil {
+		warnf(context.Background(), "Ignoring unreadable upload sessions %s: %s", path, err)
+		s.sessions = make(map[string]session)
+	}
+	return s, nil
+}
+
+// get returns the saved session for f if it was started on account for the
+// file as it is now.
+func (s *Sessions) get(f, account string, fi os.FileInfo) (session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss, ok := s.sessions[pathKey(f)]
+	if !ok || ss.Account != account || ss.Size != fi.Size() || !ss.ModTime.Equal(fi.ModTime()) {
+		return session{}, false
+	}
+	return ss, true
+}
+
+func (s *Sessions) put(f string, ss session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[pathKey(f)] = ss
+	return s.saveLocked()
+}
+
+func (s *Sessions) forget(f string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[pathKey(f)]; !ok {
+		return nil
+	}
+	delete(s.sessions, pathKey(f))
+	return s.saveLocked()
+}
+
+func (s *Sessions) saveLocked() error {
+	data, err := json.Marshal(s.sessions)
+	if err != nil {
+		return err
+	}
+	if err := statefile.Write(s.path, data, 0600); err != nil {
+		return fmt.Errorf("unable to write upload sessions: %w", err)
+	}
+	return nil
+}
+
+// uploadFields is what Drive returns about an uploaded file.
+const uploadFields = "id,name,size,md5Checksum"
+
+// resumableUpload sends f to Drive through a resumable session saved in
+// Sessions, continuing the saved one if there is one, and feeds every byte
+// of the file to hash.
+func (u *Uploader) resumableUpload(ctx context.Context, a *account, name string, f *os.File, fi os.FileInfo, meta *drive.File, chunkSize int, progress func(int64, int64), hash io.Writer) (*drive.File, error) {
+	size := fi.Size()
+	s, offset, df := u.resumeSession(ctx, a, name, fi)
+	if s == nil {
+		var err error
+		if s, err = gdrive.StartUpload(ctx, a.drive, meta, size, uploadFields); err != nil {
+			return nil, err
+		}
+		u.saveSession(ctx, name, a, fi, s, 0)
+	}
+	// Drive already has the start of the file, but it still has to be
+	// hashed.
+	if _, err := io.CopyN(hash, f, offset); err != nil {
+		return nil, err
+	}
+	body := io.TeeReader(f, hash)
+	buf := make([]byte, chunkSize)
+	for df == nil {
+		chunk := buf
+		if int64(len(chunk)) > size-offset {
+			chunk = chunk[:size-offset]
+		}
+		if _, err := io.ReadFull(body, chunk); err != nil {
+			return nil, err
+		}
+		// Drive may keep less than it was sent; send the rest again.
+		for sent := int64(0); sent < int64(len(chunk)) && df == nil; {
+			next, done, err := s.Send(ctx, bytes.NewReader(chunk[sent:]), offset+sent, int64(len(chunk))-sent)
+			if err == gdrive.ErrUploadExpired {
+				u.opts.Sessions.forget(name)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if next <= offset+sent && done == nil {
+				return nil, fmt.Errorf("upload of %s made no progress at byte %d", name, offset+sent)
+			}
+			sent, df = next-offset, done
+		}
+		offset += int64(len(chunk))
+		progress(offset, size)
+		if df == nil {
+			u.saveSession(ctx, name, a, fi, s, offset)
+		}
+	}
+	if err := u.opts.Sessions.forget(name); err != nil {
+		errorf(ctx, "failed to forget upload session for %s: %s", name, err)
+	}
+	return df, nil
+}
+
+// resumeSession returns the saved session for f, if Drive still has it, and
+// how many bytes Drive has received. If the upload completed before the
+// process stopped, the created file is returned instead.
+func (u *Uploader) resumeSession(ctx context.Context, a *account, f string, fi os.FileInfo) (*gdrive.UploadSession, int64, *drive.File) {
+	ss, ok := u.opts.Sessions.get(f, a.name, fi)
+	if !ok {
+		return nil, 0, nil
+	}
+	s, err := gdrive.ResumeUpload(a.drive, ss.URI, ss.Size)
+	if err != nil {
+		return nil, 0, nil
+	}
+	offset, df, err := s.Offset(ctx)
+	if err != nil {
+		warnf(ctx, "Restarting upload of %s: unable to resume session: %s", f, err)
+		u.opts.Sessions.forget(f)
+		return nil, 0, nil
+	}
+	infof(ctx, "Resuming upload of %s at %s", f, humanize.Bytes(uint64(offset)))
+	return s, offset, df
+}
+
+func (u *Uploader) saveSession(ctx context.Context, f string, a *account, fi os.FileInfo, s *gdrive.UploadSession, offset int64) {
+	err := u.opts.Sessions.put(f, session{URI: s.URI, Account: a.name, Size: fi.Size(), ModTime: fi.ModTime(), Offset: offset})
+	if err != nil {
+		errorf(ctx, "failed to save upload session for %s: %s", f, err)
+	}
+}
+
+// resumableChunkSize returns the chunk size for a resumable session: the
+// configured one rounded up to the multiple Drive requires, or the Drive
+// client's default when uploads aren't chunked.
+func resumableChunkSize(chunkSize int) int {
+	if chunkSize <= 0 {
+		return googleapi.DefaultUploadChunkSize
+	}
+	const m = googleapi.MinUploadChunkSize
+	return (chunkSize + m - 1) / m * m
+}
@@ -0,0 +1,108 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Anyone is the Permission email that shares files with anyone who has the
+// link.
+const Anyone = "anyone"
+
+// shareRoles are the roles files may be shared with.
+var shareRoles = map[string]bool{"reader": true, "commenter": true, "writer": true}
+
+// Permission shares uploaded files matching Pattern (as for Route; empty
+// matches every file) with Email, or with anyone who has the link if Email
+// is Anyone, in Role: "reader" (the default), "commenter" or "writer".
+type Permission struct {
+	Pattern string
+	Email   string
+	Role    string
+}
+
+type permission struct {
+	pattern *pattern // nil matches every file
+	email   string
+	role    string
+}
+
+// compilePermissions compiles ps, preceded by the permissions ShareLink and
+// ShareWith give every file.
+func compilePermissions(opts *Options) ([]permission, error) {
+	ps := opts.Permissions
+	if opts.ShareLink || len(opts.ShareWith) > 0 {
+		var all []Permission
+		if opts.ShareLink {
+			all = append(all, Permission{Email: Anyone, Role: opts.ShareRole})
+		}
+		for _, email := range opts.ShareWith {
+			all = append(all, Permission{Email: email, Role: opts.ShareRole})
+		}
+		ps = append(all, ps...)
+	}
+	var compiled []permission
+	for _, p := range ps {
+		if p.Email == "" {
+			return nil, fmt.Errorf("permission for %q has no email", p.Pattern)
+		}
+		if p.Role == "" {
+			p.Role = "reader"
+		}
+		if !shareRoles[p.Role] {
+			return nil, fmt.Errorf("permission for %s: unknown role %q", p.Email, p.Role)
+		}
+		c := permission{email: p.Email, role: p.Role}
+		if p.Pattern != "" {
+			pat, err := compilePattern(p.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("permission for %s: %w", p.Email, err)
+			}
+			c.pattern = &pat
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// permissionsFor returns the permissions given to f once it is uploaded.
+func (u *Uploader) permissionsFor(f string) []*drive.Permission {
+	rel := u.relPath(f)
+	var perms []*drive.Permission
+	for _, p := range u.permissions {
+		if p.pattern != nil && !p.pattern.match(rel) {
+			continue
+		}
+		if p.email == Anyone {
+			perms = append(perms, &drive.Permission{Type: "anyone", Role: p.role})
+		} else {
+			perms = append(perms, &drive.Permission{Type: "user", Role: p.role, EmailAddress: p.email})
+		}
+	}
+	return perms
+}
+
+// share gives perms on the uploaded file up and returns its link.
+func (u *Uploader) share(ctx context.Context, up *uploaded, perms []*drive.Permission) (string, error) {
+	d, id := up.account.drive, up.file.Id
+	for _, p := range perms {
+		call := d.Permissions.Create(id, p).SupportsAllDrives(true).Context(ctx)
+		if p.Type == "user" {
+			call.SendNotificationEmail(u.opts.ShareNotify)
+		}
+		if _, err := call.Do(); err != nil {
+			who := p.EmailAddress
+			if who == "" {
+				who = "anyone with the link"
+			}
+			return "", fmt.Errorf("unable to share with %s: %w", who, err)
+		}
+	}
+	f, err := d.Files.Get(id).SupportsAllDrives(true).Fields("webViewLink").Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("unable to get link: %w", err)
+	}
+	return f.WebViewLink, nil
+}
@@ -0,0 +1,283 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Stability decides when a file has finished being written and may be
+// uploaded.
+type Stability interface {
+	// Wait blocks until f is complete, failing if it can't tell or ctx is
+	// done.
+	Wait(ctx context.Context, f string) error
+}
+
+// defaultStabilityInterval is how often the strategies look at a file
+// when no Interval is set.
+const defaultStabilityInterval = time.Second
+
+func stabilityInterval(d time.Duration) time.Duration {
+	if d <= 0 {
+		return defaultStabilityInterval
+	}
+	return d
+}
+
+// SizeStable waits for a file's size to stay the same for Checks
+// consecutive looks, Interval apart. It is the default, with ten checks a
+// second apart.
+type SizeStable struct {
+	Interval time.Duration
+	Checks   int
+}
+
+func (s SizeStable) Wait(ctx context.Context, f string) error {
+	checks := s.Checks
+	if checks <= 0 {
+		checks = 10
+	}
+	var size, c int64
+	first := true
+	for {
+		if first {
+			debugf(ctx, "Waiting for %s to stop growing...", f)
+			first = false
+		}
+		fi, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		if size == fi.Size() {
+			if c < int64(checks) {
+				c++
+			} else {
+				return nil
+			}
+		} else {
+			// reset the count, in case the file size temporarily stalled.
+			c = 0
+		}
+		size = fi.Size()
+		if err := sleep(ctx, stabilityInterval(s.Interval)); err != nil {
+			return err
+		}
+	}
+}
+
+// NoOpenHandles waits until no process has the file open, as told by /proc
+// on Linux, lsof on other Unix systems and natively on Windows.
+type NoOpenHandles struct {
+	Interval time.Duration
+}
+
+func (s NoOpenHandles) Wait(ctx context.Context, f string) error {
+	first := true
+	for {
+		if first {
+			debugf(ctx, "Waiting for %s to be closed...", f)
+			first = false
+		}
+		isOpen, err := fileIsOpen(ctx, f)
+		if err != nil {
+			return err
+		}
+		if !isOpen {
+			return nil
+		}
+		if err := sleep(ctx, stabilityInterval(s.Interval)); err != nil {
+			return err
+		}
+	}
+}
+
+// CheckOpenHandles returns an error unless NoOpenHandles works here: a
+// temporary file held open by another process must be seen to be open, and
+// then seen to be closed.
+func CheckOpenHandles(ctx context.Context) error {
+	f, err := ioutil.TempFile("", "gdrive_sync-check-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	// Windows sees this process's own handle; elsewhere this process is
+	// left out, so a child holds the file open.
+	var child *exec.Cmd
+	if runtime.GOOS != "windows" {
+		child = exec.CommandContext(ctx, "sleep", "60")
+		child.Stdin = f
+		if err := child.Start(); err != nil {
+			f.Close()
+			return fmt.Errorf("unable to start a process to hold a file open: %w", err)
+		}
+	}
+	open, err := fileIsOpen(ctx, f.Name())
+	if child != nil {
+		child.Process.Kill()
+		child.Wait()
+	}
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if !open {
+		return errors.New("a file held open by another process was reported closed")
+	}
+	if open, err = fileIsOpen(ctx, f.Name()); err != nil {
+		return err
+	} else if open {
+		return errors.New("a closed file was reported open")
+	}
+	return nil
+}
+
+// Unlocked waits until the file can be locked exclusively, for writers that
+// hold a lock (flock on Unix, a share-deny open on Windows) while writing.
+type Unlocked struct {
+	Interval time.Duration
+}
+
+func (s Unlocked) Wait(ctx context.Context, f string) error {
+	first := true
+	for {
+		locked, err := fileIsLocked(f)
+		if err != nil {
+			return err
+		}
+		if !locked {
+			return nil
+		}
+		if first {
+			debugf(ctx, "Waiting for %s to be unlocked...", f)
+			first = false
+		}
+		if err := sleep(ctx, stabilityInterval(s.Interval)); err != nil {
+			return err
+		}
+	}
+}
+
+// MtimeAge waits until a file was last modified at least Age ago.
+type MtimeAge struct {
+	Age time.Duration
+}
+
+func (s MtimeAge) Wait(ctx context.Context, f string) error {
+	first := true
+	for {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		left := s.Age - time.Since(fi.ModTime())
+		if left <= 0 {
+			return nil
+		}
+		if first {
+			debugf(ctx, "Waiting for %s to go unmodified for %s...", f, s.Age)
+			first = false
+		}
+		if err := sleep(ctx, left); err != nil {
+			return err
+		}
+	}
+}
+
+// Marker waits for a marker file some scanners write once they are done,
+// named by Pattern with "{}" replaced by the file's path, e.g. "{}.done".
+// Unlike a trigger file it is left alone.
+type Marker struct {
+	Pattern  string
+	Interval time.Duration
+}
+
+func (s Marker) Wait(ctx context.Context, f string) error {
+	if !strings.Contains(s.Pattern, "{}") {
+		return fmt.Errorf("marker pattern %q has no {}", s.Pattern)
+	}
+	marker := strings.Replace(s.Pattern, "{}", f, -1)
+	first := true
+	for {
+		if _, err := os.Stat(marker); err == nil {
+			return nil
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		if first {
+			debugf(ctx, "Waiting for %s to appear...", marker)
+			first = false
+		}
+		if err := sleep(ctx, stabilityInterval(s.Interval)); err != nil {
+			return err
+		}
+	}
+}
+
+// stabilityTimeoutError is the error of a file that didn't become stable
+// within Options.StabilityTimeout. The upload is retried later.
+type stabilityTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e *stabilityTimeoutError) Error() string {
+	return fmt.Sprintf("still being written after %s", e.timeout)
+}
+
+func isStabilityTimeout(err error) bool {
+	var e *stabilityTimeoutError
+	return errors.As(err, &e)
+}
+
+// waitStable waits for f to be complete, giving up after StabilityTimeout.
+func (u *Uploader) waitStable(ctx context.Context, f string) error {
+	if u.opts.StabilityTimeout <= 0 {
+		return u.wait(ctx, f)
+	}
+	wctx, cancel := context.WithTimeout(ctx, u.opts.StabilityTimeout)
+	defer cancel()
+	err := u.wait(wctx, f)
+	if err != nil && ctx.Err() == nil && wctx.Err() == context.DeadlineExceeded {
+		return &stabilityTimeoutError{timeout: u.opts.StabilityTimeout}
+	}
+	return err
+}
+
+// isMarker reports whether f is a marker file s waits for, which is never
+// uploaded itself.
+func isMarker(s Stability, f string) bool {
+	switch s := s.(type) {
+	case Marker:
+		i := strings.Index(s.Pattern, "{}")
+		if i < 0 {
+			return false
+		}
+		before, after := s.Pattern[:i], s.Pattern[i+2:]
+		return len(f) > len(before)+len(after) && strings.HasPrefix(f, before) && strings.HasSuffix(f, after)
+	case AllStable:
+		for _, st := range s {
+			if isMarker(st, f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AllStable waits for each of its strategies in turn.
+type AllStable []Stability
+
+func (s AllStable) Wait(ctx context.Context, f string) error {
+	for _, st := range s {
+		if err := st.Wait(ctx, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package uploader
+
+import (
+	"sort"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/gdrive"
+)
+
+// Status is a snapshot of what an Uploader is doing.
+type Status struct {
+	InputDir  string `json:"input_dir"`
+	OutputDir string `json:"output_dir"`
+	Ready     bool   `json:"ready"`
+	// Uploading are the files being sent right now.
+	Uploading []Transfer `json:"uploading"`
+	// Queued are files found but not yet being sent: still being written,
+	// or waiting for an upload slot, the monthly budget or the schedule.
+	Queued []string `json:"queued"`
+	// HeldUntil is when uploads held back by the schedule may start.
+	HeldUntil *time.Time `json:"held_until,omitempty"`
+	// Parked are files that ran out of retries and will be tried again.
+	Parked []string `json:"parked"`
+	// Offline are files waiting for Drive to be reachable again.
+	Offline []string `json:"offline"`
+	// Undeletable are uploaded files that could not be removed.
+	Undeletable []string `json:"undeletable"`
+	// LastUpload is when a file was last uploaded, if one has been.
+	LastUpload *time.Time      `json:"last_upload"`
+	Accounts   []AccountStatus `json:"accounts"`
+}
+
+// Transfer is a file being uploaded.
+type Transfer struct {
+	File    string    `json:"file"`
+	Started time.Time `json:"started"`
+	Bytes   int64     `json:"bytes"`
+	Size    int64     `json:"size"`
+	Percent float64   `json:"percent"`
+}
+
+// AccountStatus reports on the OAuth token of an account uploads go to.
+type AccountStatus struct {
+	Name        string     `json:"name"`
+	TokenExpiry *time.Time `json:"token_expiry,omitempty"`
+	TokenError  string     `json:"token_error,omitempty"`
+}
+
+func (u *Uploader) startTransfer(f string, size int64) *Transfer {
+	t := &Transfer{File: f, Started: time.Now(), Size: size}
+	u.mu.Lock()
+	u.transfers[pathKey(f)] = t
+	u.mu.Unlock()
+	return t
+}
+
+func (u *Uploader) updateTransfer(t *Transfer, bytes int64) {
+	u.mu.Lock()
+	t.Bytes = bytes
+	u.mu.Unlock()
+}
+
+func (u *Uploader) endTransfer(f string) {
+	u.mu.Lock()
+	delete(u.transfers, pathKey(f))
+	u.mu.Unlock()
+}
+
+// Status returns what u is doing. It refreshes account tokens that have
+// expired, so it may make a network request.
+func (u *Uploader) Status() Status {
+	s := Status{
+		InputDir:    u.inputDir,
+		OutputDir:   u.outputDir,
+		Uploading:   []Transfer{},
+		Queued:      []string{},
+		Parked:      []string{},
+		Offline:     []string{},
+		Undeletable: []string{},
+	}
+	u.mu.Lock()
+	s.Ready = u.ready
+	for key := range u.inProgress {
+		if t, ok := u.transfers[key]; ok {
+			c := *t
+			if c.Size > 0 {
+				c.Percent = float64(c.Bytes) * 100 / float64(c.Size)
+			}
+			s.Uploading = append(s.Uploading, c)
+		} else {
+			s.Queued = append(s.Queued, key)
+		}
+	}
+	for _, p := range u.parked {
+		s.Parked = append(s.Parked, p.f)
+	}
+	for f := range u.undeletable {
+		s.Undeletable = append(s.Undeletable, f)
+	}
+	if !u.lastSuccess.IsZero() {
+		t := u.lastSuccess
+		s.LastUpload = &t
+	}
+	u.mu.Unlock()
+	s.Offline = append(s.Offline, u.opts.OfflineQueue.list(u.owns)...)
+	if u.opts.Schedule != nil {
+		if t := u.opts.Schedule.heldUntil(); !t.IsZero() {
+			s.HeldUntil = &t
+		}
+	}
+	sort.Slice(s.Uploading, func(i, j int) bool { return s.Uploading[i].Started.Before(s.Uploading[j].Started) })
+	sort.Strings(s.Queued)
+	sort.Strings(s.Parked)
+	sort.Strings(s.Undeletable)
+
+	u.accounts.mu.Lock()
+	accounts := append([]*account(nil), u.accounts.accounts...)
+	u.accounts.mu.Unlock()
+	for _, a := range accounts {
+		as := AccountStatus{Name: a.name}
+		if exp, err := gdrive.TokenExpiry(a.drive); err != nil {
+			as.TokenError = err.Error()
+		} else if !exp.IsZero() {
+			as.TokenExpiry = &exp
+		}
+		s.Accounts = append(s.Accounts, as)
+	}
+	return s
+}
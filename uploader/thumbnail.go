@@ -0,0 +1,127 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	"google.golang.org/api/drive/v3"
+)
+
+const (
+	// thumbnailSize is the longest side of generated thumbnails. Drive shows
+	// them at up to 220 pixels, so this leaves room for high-DPI screens.
+	thumbnailSize = 512
+	// maxThumbnail is the largest thumbnail Drive accepts.
+	maxThumbnail = 2 << 20
+	// maxThumbnailPixels skips decoding images so large that doing it would
+	// use more memory than is reasonable for a preview.
+	maxThumbnailPixels = 100 << 20
+)
+
+// wantsThumbnail reports whether f matches ThumbnailPatterns.
+func (u *Uploader) wantsThumbnail(f string) bool {
+	base := strings.ToLower(filepath.Base(f))
+	for _, p := range u.opts.ThumbnailPatterns {
+		if ok, _ := filepath.Match(p, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// thumbnail returns a thumbnail content hint for f if it matches
+// ThumbnailPatterns. The image is read from ThumbnailCommand's output if one
+// is configured, for formats Go can't decode, or from the file itself.
+// Failures only mean the file is uploaded without a thumbnail.
+func (u *Uploader) thumbnail(ctx context.Context, f string) *drive.FileContentHintsThumbnail {
+	if !u.wantsThumbnail(f) {
+		return nil
+	}
+	var data []byte
+	var err error
+	if len(u.opts.ThumbnailCommand) > 0 {
+		args := expandCommand(u.opts.ThumbnailCommand, f)
+		data, err = exec.CommandContext(ctx, args[0], args[1:]...).Output()
+	} else {
+		data, err = readImage(f)
+	}
+	if err == nil {
+		data, err = makeThumbnail(data)
+	}
+	if err != nil {
+		warnf(ctx, "failed to make thumbnail of %s: %s", f, err)
+		return nil
+	}
+	return &drive.FileContentHintsThumbnail{
+		Image:    base64.URLEncoding.EncodeToString(data),
+		MimeType: "image/png",
+	}
+}
+
+func readImage(f string) ([]byte, error) {
+	r, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxThumbnailPixels {
+		return nil, fmt.Errorf("image is too large (%dx%d)", cfg.Width, cfg.Height)
+	}
+	if _, err := r.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(r)
+	return buf.Bytes(), err
+}
+
+// makeThumbnail decodes an image and scales it to fit thumbnailSize, as PNG.
+func makeThumbnail(data []byte) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return nil, fmt.Errorf("empty image")
+	}
+	if w > h && w > thumbnailSize {
+		w, h = thumbnailSize, h*thumbnailSize/w
+	} else if h > thumbnailSize {
+		w, h = w*thumbnailSize/h, thumbnailSize
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+	var out bytes.Buffer
+	if err := png.Encode(&out, dst); err != nil {
+		return nil, err
+	}
+	if out.Len() > maxThumbnail {
+		return nil, fmt.Errorf("thumbnail is too large (%d bytes)", out.Len())
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,40 @@
+package uploader
+
+import (
+	"context"
+	"os"
+	"strings"
+)
+
+// isTrigger reports whether f is a trigger file, which is never uploaded.
+func (u *Uploader) isTrigger(f string) bool {
+	return u.opts.TriggerSuffix != "" && strings.HasSuffix(f, u.opts.TriggerSuffix)
+}
+
+// triggered maps a discovered file to the file that should be uploaded.
+// When trigger files are in use only they start uploads, each of its
+// companion; other files are ignored until their trigger appears.
+func (u *Uploader) triggered(f string) (string, bool) {
+	if u.opts.TriggerSuffix == "" {
+		return f, true
+	}
+	if !u.isTrigger(f) {
+		return "", false
+	}
+	target := strings.TrimSuffix(f, u.opts.TriggerSuffix)
+	if _, err := os.Stat(target); err != nil {
+		return "", false
+	}
+	return target, true
+}
+
+// consumeTrigger removes the trigger file for an uploaded file.
+func (u *Uploader) consumeTrigger(ctx context.Context, f string) {
+	if u.opts.TriggerSuffix == "" {
+		return
+	}
+	trigger := f + u.opts.TriggerSuffix
+	if err := os.Remove(trigger); err != nil && !os.IsNotExist(err) {
+		errorf(ctx, "failed to delete trigger file %s: %s", trigger, err)
+	}
+}
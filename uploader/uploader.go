@@ -1,22 +1,53 @@
+// Package uploader watches a directory and uploads the files that appear in
+// it to a Drive folder. It can be embedded in other programs:
+//
+//	service, err := gdrive.New(ctx,
+//		gdrive.WithCredentialsFile("credentials.json"),
+//		gdrive.WithTokenFile("token.json"))
+//	if err != nil {
+//		return err
+//	}
+//	u, err := uploader.Build(dir, "Scans", service,
+//		uploader.WithRecursive(0),
+//		uploader.OnUploadComplete(func(e events.Event) {
+//			log.Printf("uploaded %s", e.File)
+//		}))
+//	if err != nil {
+//		return err
+//	}
+//	return u.Run(ctx)
+//
+// The uploader talks to Drive through a *gdrive.Drive rather than an
+// interface of its own: the Drive client's calls are concrete builder types,
+// and resumable sessions and token status reach past them to its HTTP
+// client. To substitute another Drive, such as an emulator or an in-memory
+// fake in tests, make the service with gdrive.WithHTTPClient and
+// gdrive.WithEndpoint, as gdrive/fakedrive does.
 package uploader
 
 import (
 	"context"
-	"errors"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
 
+	"github.com/dknowles2/gdrive_sync/events"
 	"github.com/dknowles2/gdrive_sync/gdrive"
+	"github.com/dknowles2/gdrive_sync/journal"
+	"github.com/dknowles2/gdrive_sync/manifest"
+	"github.com/dknowles2/gdrive_sync/photos"
 	"github.com/dustin/go-humanize"
 	"github.com/fsnotify/fsnotify"
 	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
 )
 
 var ignoreFiles = map[string]bool{
@@ -25,37 +56,441 @@ var ignoreFiles = map[string]bool{
 
 type waiter func(context.Context, string) error
 
+// Options holds optional Uploader settings. The zero value disables every
+// optional behavior.
+type Options struct {
+	// InactivityAlert is the period after which an alert is raised if no
+	// file has been uploaded, once uploads have been seen on most days of
+	// a week. Zero disables inactivity alerting.
+	InactivityAlert time.Duration
+
+	// Events, if set, receives an event for every pipeline transition.
+	Events events.Sink
+	// Hooks are called with the events of uploads starting, progressing,
+	// completing and failing.
+	Hooks Hooks
+
+	// Photos, if set, receives files routed to it and those that match no
+	// route but whose base name matches one of PhotosPatterns, instead of
+	// the Drive folder.
+	Photos         *photos.Client
+	PhotosPatterns []string
+
+	// OCRCommand, if set, is run against each file before upload and its
+	// output is attached to the Drive file as indexable text.
+	OCRCommand []string
+
+	// PreUploadCommand, if set, is a shell command run on each file before
+	// it is uploaded, e.g. to deskew or virus scan it, which may change the
+	// file in place, and is run again whenever the upload is retried;
+	// PreUploadPolicy says what to do if it fails.
+	// SuccessCommand and FailureCommand are run once each upload succeeds
+	// or fails. They get the file and result in GDRIVE_SYNC_* environment
+	// variables and are killed after HookTimeout (DefaultHookTimeout if
+	// zero).
+	PreUploadCommand string
+	PreUploadPolicy  string
+	SuccessCommand   string
+	FailureCommand   string
+	HookTimeout      time.Duration
+
+	// ThumbnailPatterns are globs of base names, such as formats Drive can't
+	// preview, that get a locally generated thumbnail. The image is decoded
+	// from the file (PNG, JPEG, GIF, TIFF or BMP) or, if ThumbnailCommand is
+	// set, from the PNG or JPEG it writes to stdout.
+	ThumbnailPatterns []string
+	ThumbnailCommand  []string
+
+	// PollInterval, if positive, finds new files by rescanning the watched
+	// directories this often instead of waiting for change notifications,
+	// which network file systems don't deliver for remote changes.
+	PollInterval time.Duration
+
+	// Recursive watches subdirectories of the input directory too, down to
+	// MaxDepth levels (0 means unlimited). Directories whose name or path
+	// relative to the input directory matches one of ExcludeDirs are skipped.
+	Recursive   bool
+	MaxDepth    int
+	ExcludeDirs []string
+
+	// IgnorePatterns are globs of base names that are never uploaded.
+	IgnorePatterns []string
+
+	// Include, if set, restricts uploads to files matching one of its
+	// patterns, and files matching one of Exclude are never uploaded. See
+	// pattern for the syntax.
+	Include []string
+	Exclude []string
+
+	// KeepFiles leaves files in place after uploading them instead of
+	// deleting them. Without a way to tell what was already uploaded, they
+	// are uploaded again on the next start unless SkipInitialUpload is set.
+	KeepFiles bool
+
+	// ArchiveDir, if set, is where uploaded files are moved instead of being
+	// deleted. Subdirectories of the input are recreated within it, below a
+	// folder named by formatting the upload time with ArchiveLayout (a Go
+	// time layout such as "2006/01") if that is set.
+	ArchiveDir    string
+	ArchiveLayout string
+
+	// RemotePathTemplate, if set, is a text/template giving the path beneath
+	// the output folder each file is uploaded to, including its name, e.g.
+	// "{{.Year}}/{{.Month}}/{{.Name}}". See remotePathData for the fields.
+	// Missing folders are created.
+	RemotePathTemplate string
+
+	// Routes send files matching their patterns to other Drive folders,
+	// the first match winning. Files matching none go to the output folder.
+	Routes []Route
+
+	// Destinations are other folders every file is also uploaded to.
+	Destinations []Destination
+
+	// Backends are other storage every file is also copied to. Given no
+	// Drive service, New makes an Uploader that only copies files to them.
+	Backends []Backend
+
+	// Compress compresses files matching its patterns before they are
+	// uploaded, the first match winning.
+	Compress []Compression
+
+	// Stability decides when a file has been completely written. It
+	// defaults to SizeStable. If StabilityTimeout is set, a file still not
+	// complete after that long counts as a failed upload, and is tried
+	// again later.
+	Stability        Stability
+	StabilityTimeout time.Duration
+
+	// Debounce is how long no change events must arrive for a file before
+	// it is handed to the upload queue, so a burst of writes starts one
+	// upload.
+	Debounce time.Duration
+
+	// SkipInitialUpload only uploads files that change after Run starts,
+	// leaving those already in the input directory alone.
+	SkipInitialUpload bool
+
+	// Flatten uploads files from subdirectories straight into the output
+	// folder instead of into matching subfolders.
+	Flatten bool
+
+	// Mirror recreates the directory tree beneath the input directory as
+	// folders beneath the output folder, including directories with nothing
+	// to upload yet. It implies Recursive, and can't be combined with
+	// Flatten or RemotePathTemplate.
+	Mirror bool
+
+	// ChunkSize is the resumable upload chunk size in bytes, which is also
+	// how much of each file is buffered in memory. Zero uses the Drive
+	// client default.
+	ChunkSize int
+
+	// AdaptiveChunkSize tunes the chunk size from measured throughput
+	// instead of using ChunkSize.
+	AdaptiveChunkSize bool
+
+	// MaxConcurrentUploads limits how many files are transferred at once.
+	// Zero means no limit.
+	MaxConcurrentUploads int
+
+	// MaxQueuedFiles limits how many files are in the pipeline at once,
+	// waiting to stabilize or to be uploaded; the files found beyond it
+	// wait, by name only, for room. Zero means no limit.
+	MaxQueuedFiles int
+
+	// CreateOutputDir creates the output folder, and any missing parents of
+	// a slash-separated path, if it doesn't exist.
+	CreateOutputDir bool
+
+	// DryRun goes through discovery, stability checks, routing and
+	// duplicate detection, logging what would be uploaded and removed, but
+	// never writes to Drive or touches local files. The output and route
+	// folders must already exist.
+	DryRun bool
+
+	// OnDuplicate is what to do when the destination folder already has a
+	// file of the same name but different contents: one of DuplicateSkip
+	// (the default), DuplicateRename, DuplicateOverwrite or
+	// DuplicateVersion. Identical copies are never uploaded again.
+	OnDuplicate string
+
+	// FailedDir, if set, is where files are quarantined once they have
+	// failed to upload MaxFailures times in a row (zero means
+	// DefaultMaxFailures), so they stop being retried. Without it, but with
+	// a Journal, they are marked abandoned there and skipped until they
+	// change or are requeued. Until then each failure is retried after a
+	// growing backoff.
+	FailedDir   string
+	MaxFailures int
+
+	// ProgressInterval and ProgressPercent throttle the logging of an
+	// upload's progress to once every ProgressInterval, or whenever another
+	// ProgressPercent of the file has been sent. Zero means
+	// DefaultProgressInterval and DefaultProgressPercent.
+	ProgressInterval time.Duration
+	ProgressPercent  float64
+
+	// MaxAttempts is how many times an upload failing with transient errors
+	// (rate limiting, server errors, dropped connections) is tried, with
+	// exponential backoff, before it is parked to be tried again later.
+	// Zero means DefaultMaxAttempts.
+	MaxAttempts int
+
+	// StallTimeout, if positive, aborts an upload that sends nothing for
+	// this long, and UploadTimeout, if positive, one still not done after
+	// this long, retries included. Either counts as a failed upload.
+	StallTimeout  time.Duration
+	UploadTimeout time.Duration
+
+	// Sessions, if set, saves the resumable upload sessions of files larger
+	// than one chunk so their uploads survive restarts.
+	Sessions *Sessions
+
+	// Manifest, if set, records every successful Drive upload.
+	Manifest *manifest.Manifest
+
+	// Journal, if set, records every upload attempt, and files it shows
+	// were already uploaded are not uploaded again.
+	Journal *journal.Journal
+
+	// Accounts are extra Google accounts to spread Drive uploads across,
+	// chosen by AccountPolicy (RoundRobin by default). With the Fill policy
+	// an account is used until its storage use passes AccountFillThreshold,
+	// a fraction of its quota.
+	Accounts             []Account
+	AccountPolicy        string
+	AccountFillThreshold float64
+
+	// Budget, if set, caps how much is uploaded per calendar month. Once it
+	// is spent uploads wait for the next month.
+	Budget *Budget
+
+	// Schedule, if set, limits when uploads may start. Files found outside
+	// it wait, queued, for it to open; uploads running when it closes are
+	// finished. UploadFile ignores it.
+	Schedule *Schedule
+
+	// TriggerSuffix, if set, holds back each file until a companion file
+	// with this suffix appended (e.g. ".ready") appears. The trigger file is
+	// deleted along with the uploaded file and never uploaded itself.
+	TriggerSuffix string
+
+	// DrainTimeout, if positive, is how long Run waits for running uploads
+	// to finish once its context is done, instead of cancelling them.
+	DrainTimeout time.Duration
+
+	// MountRoot, if set, is a directory such as /media whose mounted
+	// filesystems are watched as they come and go, in addition to the input
+	// directory.
+	MountRoot string
+
+	// ShareLink lets anyone with the link view uploaded files, and
+	// ShareWith shares them with those email addresses, in ShareRole
+	// ("reader", the default, "commenter" or "writer"). Permissions share
+	// the files matching them. ShareNotify has Drive email the people shared
+	// with. The link to a shared file is logged, notified and journaled.
+	ShareLink   bool
+	ShareWith   []string
+	ShareRole   string
+	Permissions []Permission
+	ShareNotify bool
+
+	// OfflineQueue, if set, saves the files whose uploads failed because
+	// Drive was unreachable, so they are still retried when it is back after
+	// a restart. Without it they are only queued in memory.
+	OfflineQueue *OfflineQueue
+}
+
 type Uploader struct {
-	watcher    *fsnotify.Watcher
-	drive      *drive.Service
+	watcher    fileWatcher
+	accounts   *accountPool
 	inputDir   string
 	outputDir  string
-	folderId   string
+	opts       Options
 	wait       waiter
 	mu         sync.Mutex
 	inProgress map[string]bool
+	lastUpload time.Time
+	slots      chan struct{}
+	// queued counts the files in the pipeline while MaxQueuedFiles is set;
+	// backlog holds those waiting for room, also by pathKey in backlogged.
+	queued     int
+	backlog    []backlogged
+	backlogged map[string]bool
+	tuner      *chunkTuner
+	mounts     map[string]bool
+	uploads    sync.WaitGroup
+	drain      context.Context
+	stopDrain  context.CancelFunc
+	ready      bool
+	// Deferred deletions and the files that were given up on.
+	deletes     []*pendingDelete
+	parked      []*parkedUpload
+	failures    map[string]int // failed uploads by pathKey
+	undeletable map[string]string
+
+	// lastSuccess is when a file was last uploaded; zero if none has been.
+	lastSuccess time.Time
+	transfers   map[string]*Transfer // by pathKey
+
+	remotePathTmpl   *template.Template
+	routes           []route
+	include, exclude []pattern
+	ignoreFile       *ignoreFile
+	compressions     []compression
+	permissions      []permission
+	destinations     []*account
+	fanouts          map[string]*fanout // by pathKey
+	hostname         string
+
+	// offline is set while uploads wait in the offline queue for Drive to
+	// be reachable; offlineQueued counts them.
+	offline       bool
+	offlineQueued int
 }
 
-func New(in, out string, d *drive.Service) (*Uploader, error) {
-	w, err := fsnotify.NewWatcher()
+func New(in, out string, d *gdrive.Drive, opts Options) (*Uploader, error) {
+	in = filepath.Clean(shortPath(in))
+	if opts.Mirror {
+		if opts.Flatten || opts.RemotePathTemplate != "" {
+			return nil, fmt.Errorf("mirroring can't be combined with flattening or a remote path template")
+		}
+		opts.Recursive = true
+	}
+	if opts.DryRun {
+		opts.CreateOutputDir = false
+	}
+	if !validPreUploadPolicy(opts.PreUploadPolicy) {
+		return nil, fmt.Errorf("unknown pre-upload command policy %q, want %s, %s or %s", opts.PreUploadPolicy, PreUploadFail, PreUploadSkip, PreUploadIgnore)
+	}
+	if opts.Stability == nil {
+		opts.Stability = SizeStable{}
+	}
+	if opts.OfflineQueue == nil {
+		opts.OfflineQueue, _ = NewOfflineQueue("")
+	}
+	routes, err := compileRoutes(opts.Routes)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create watcher: %w", err)
+		return nil, err
 	}
-	if err := w.Add(in); err != nil {
-		return nil, fmt.Errorf("failed to add watcher for %s: %w", in, err)
+	for _, r := range opts.Routes {
+		if r.Photos && opts.Photos == nil {
+			return nil, fmt.Errorf("route %s sends files to Photos, but there is no Photos album", r.Pattern)
+		}
+	}
+	// Without Drive, files only go to the backends.
+	accounts := &accountPool{}
+	if d == nil {
+		if len(opts.Backends) == 0 {
+			return nil, fmt.Errorf("without Drive there must be a backend to upload to")
+		}
+		if len(opts.Accounts) > 0 || len(opts.Destinations) > 0 || len(routeFolders(routes)) > 0 {
+			return nil, fmt.Errorf("accounts, destinations and route folders need Drive")
+		}
+	} else {
+		folderId, err := outputFolderId(d, out, opts.CreateOutputDir)
+		if err != nil {
+			return nil, err
+		}
+		primary := newAccount(DefaultAccount, d, folderId)
+		if accounts, err = newAccountPool(primary, opts.Accounts, out, routeFolders(routes), opts.CreateOutputDir, opts.AccountPolicy, opts.AccountFillThreshold); err != nil {
+			return nil, err
+		}
+	}
+	destinations, err := newDestinations(opts.Destinations, d, opts, routeFolders(routes))
+	if err != nil {
+		return nil, err
+	}
+	if opts.ArchiveDir != "" {
+		opts.ArchiveDir = filepath.Clean(opts.ArchiveDir)
+		if opts.Recursive && within(in, opts.ArchiveDir) {
+			return nil, fmt.Errorf("archive directory %s must not be inside the watched tree %s", opts.ArchiveDir, in)
+		}
+		if err := mkdirUnlessDryRun(opts, opts.ArchiveDir); err != nil {
+			return nil, fmt.Errorf("failed to create archive directory: %w", err)
+		}
+	}
+	if opts.FailedDir != "" {
+		opts.FailedDir = filepath.Clean(opts.FailedDir)
+		if opts.Recursive && within(in, opts.FailedDir) {
+			return nil, fmt.Errorf("failed directory %s must not be inside the watched tree %s", opts.FailedDir, in)
+		}
+		if err := mkdirUnlessDryRun(opts, opts.FailedDir); err != nil {
+			return nil, fmt.Errorf("failed to create failed directory: %w", err)
+		}
+	}
+	if !validDuplicatePolicy(opts.OnDuplicate) {
+		return nil, fmt.Errorf("unknown duplicate policy %q", opts.OnDuplicate)
 	}
-	folderId, err := gdrive.GetFolderId(d, out)
+	include, err := compilePatterns(opts.Include)
+	if err != nil {
+		return nil, fmt.Errorf("invalid include: %w", err)
+	}
+	exclude, err := compilePatterns(opts.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exclude: %w", err)
+	}
+	compressions, err := compileCompressions(opts.Compress)
+	if err != nil {
+		return nil, err
+	}
+	permissions, err := compilePermissions(&opts)
 	if err != nil {
 		return nil, err
 	}
+	var remotePathTmpl *template.Template
+	if opts.RemotePathTemplate != "" {
+		if remotePathTmpl, err = parseRemotePath(opts.RemotePathTemplate); err != nil {
+			return nil, err
+		}
+	}
+	var w fileWatcher
+	if opts.PollInterval > 0 {
+		w = newPollWatcher(opts.PollInterval)
+	} else if w, err = newFileWatcher(); err != nil {
+		return nil, fmt.Errorf("failed to create watcher: %w", err)
+	}
 	u := &Uploader{
-		watcher:    w,
-		drive:      d,
-		inputDir:   in,
-		outputDir:  out,
-		folderId:   folderId,
-		wait:       waitForFileSizeToStabilize,
-		inProgress: make(map[string]bool),
+		watcher:     w,
+		accounts:    accounts,
+		inputDir:    in,
+		outputDir:   out,
+		opts:        opts,
+		wait:        opts.Stability.Wait,
+		inProgress:  make(map[string]bool),
+		backlogged:  make(map[string]bool),
+		lastUpload:  time.Now(),
+		mounts:      make(map[string]bool),
+		undeletable: make(map[string]string),
+		failures:    make(map[string]int),
+		transfers:   make(map[string]*Transfer),
+
+		remotePathTmpl: remotePathTmpl,
+		routes:         routes,
+		include:        include,
+		exclude:        exclude,
+		ignoreFile:     newIgnoreFile(in),
+		compressions:   compressions,
+		permissions:    permissions,
+		destinations:   destinations,
+		fanouts:        make(map[string]*fanout),
+		hostname:       hostname(),
+	}
+	if opts.AdaptiveChunkSize {
+		u.tuner = newChunkTuner()
+	}
+	if opts.MaxConcurrentUploads > 0 {
+		u.slots = make(chan struct{}, opts.MaxConcurrentUploads)
+	}
+	if opts.Recursive {
+		err = u.addWatchTree(in)
+	} else {
+		err = w.Add(in)
+	}
+	if err != nil {
+		w.Close()
+		return nil, fmt.Errorf("failed to add watcher for %s: %w", in, err)
 	}
 	return u, nil
 }
@@ -65,72 +500,169 @@ func (u *Uploader) Close() {
 }
 
 func (u *Uploader) Run(ctx context.Context) error {
-	if err := u.initialUpload(ctx); err != nil {
-		return err
+	if u.opts.DrainTimeout > 0 {
+		u.drain, u.stopDrain = context.WithCancel(context.Background())
+		defer u.drainUploads()
 	}
+	if u.opts.InactivityAlert > 0 {
+		go u.monitorInactivity(ctx)
+	}
+	if u.opts.MountRoot != "" {
+		go u.watchMounts(ctx)
+	}
+	go u.processDeletes(ctx)
+	go u.processParked(ctx)
+	go u.processOffline(ctx)
+	u.mirrorDirs(ctx, u.inputDir)
+	if !u.opts.SkipInitialUpload {
+		if err := u.initialUpload(ctx); err != nil {
+			return err
+		}
+	}
+	u.mu.Lock()
+	u.ready = true
+	u.mu.Unlock()
 	return u.watch(ctx)
 }
 
 func (u *Uploader) initialUpload(ctx context.Context) error {
-	log.Printf("Looking for files already in %s...", u.inputDir)
-	files, err := ioutil.ReadDir(u.inputDir)
-	if err != nil {
-		return fmt.Errorf("failed to list directory contents: %w", err)
-	}
-	for _, f := range files {
+	infof(ctx, "Looking for files already in %s...", u.inputDir)
+	err := u.walkFiles(u.inputDir, func(name string, fi os.FileInfo) error {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
 			// carry on
 		}
-		if shouldIgnore(f.Name()) {
-			continue
-		}
-		go u.upload(ctx, filepath.Join(u.inputDir, f.Name()))
+		u.discover(ctx, name, fi.Size(), "Found existing file: %s")
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list directory contents: %w", err)
 	}
 	return nil
 }
 
+// discover starts the upload pipeline for a newly found file under a fresh
+// correlation ID.
+func (u *Uploader) discover(ctx context.Context, name string, size int64, msg string) {
+	target, ok := u.triggered(name)
+	if !ok {
+		return
+	}
+	if target != name {
+		fi, err := os.Stat(target)
+		if err != nil {
+			return
+		}
+		name, size = target, fi.Size()
+	}
+	ctx = withCorrelationId(ctx, newCorrelationId())
+	infof(ctx, msg, name)
+	u.emit(ctx, events.Event{Type: events.Discovered, File: name, Size: size})
+	u.start(ctx, name)
+}
+
+// uploadOps are the events that start an upload. Files moved in with mv
+// only get a Create (some platforms report a Rename of the new name), as
+// do trigger files created empty; the stability checks in upload hold back
+// files still being written.
+const uploadOps = fsnotify.Create | fsnotify.Write | fsnotify.Rename
+
 func (u *Uploader) watch(ctx context.Context) error {
+	d := newDebouncer(u.opts.Debounce, func(name string) {
+		if ctx.Err() != nil {
+			return
+		}
+		if fi, err := os.Stat(name); err != nil || fi.IsDir() {
+			// File has already been removed or renamed away; ignore.
+			return
+		}
+		u.mu.Lock()
+		inProgress := u.inProgress[pathKey(name)]
+		u.mu.Unlock()
+		if !inProgress {
+			u.discover(ctx, name, 0, "Found new file: %s")
+		}
+	})
+	defer d.stop()
 	first := true
 	last := time.Now()
 	for {
 		if first || time.Since(last) > 1*time.Second {
-			log.Printf("Waiting for new files in %s...", u.inputDir)
+			infof(ctx, "Waiting for new files in %s...", u.inputDir)
 		}
 		first = false
 		last = time.Now()
 
 		select {
-		case event, ok := <-u.watcher.Events:
+		case event, ok := <-u.watcher.Events():
 			if !ok {
 				// channel closed, exit cleanly
 				return nil
 			}
-			u.mu.Lock()
-			inProgress := u.inProgress[event.Name]
-			u.mu.Unlock()
-			if inProgress || event.Op&fsnotify.Write != fsnotify.Write || shouldIgnore(event.Name) {
+			if u.opts.Recursive && event.Op&fsnotify.Create == fsnotify.Create {
+				if fi, err := os.Stat(event.Name); err == nil && fi.IsDir() {
+					u.addDir(ctx, event.Name)
+					continue
+				}
+			}
+			if u.ignoreFile.is(event.Name) {
+				u.ignoreFile.load()
 				continue
 			}
-			if _, err := os.Stat(event.Name); os.IsNotExist(err) {
-				// File has already been removed; ignore.
+			if event.Op&uploadOps == 0 || u.ignored(event.Name) {
 				continue
 			}
-			log.Printf("Found new file: %s", event.Name)
-			go u.upload(ctx, event.Name)
-		case err, ok := <-u.watcher.Errors:
+			d.add(event.Name)
+		case err, ok := <-u.watcher.Errors():
 			if !ok {
 				return err
 			}
-			log.Printf("error: %s", err)
+			errorf(ctx, "error: %s", err)
 		case <-ctx.Done():
 			return ctx.Err()
 		}
 	}
 }
 
+// ignored reports whether f should never be uploaded: hidden and system
+// files, files whose base name matches one of IgnorePatterns, Marker files,
+// those the input directory's ignore file lists, and those Include and
+// Exclude filter out. A trigger file is ignored along with its companion.
+func (u *Uploader) ignored(f string) bool {
+	if shouldIgnore(f) {
+		return true
+	}
+	base := filepath.Base(f)
+	for _, p := range u.opts.IgnorePatterns {
+		if ok, _ := filepath.Match(p, base); ok {
+			return true
+		}
+	}
+	if isMarker(u.opts.Stability, f) {
+		return true
+	}
+	if u.isTrigger(f) {
+		f = strings.TrimSuffix(f, u.opts.TriggerSuffix)
+	}
+	rel := u.relPath(f)
+	if u.ignoreFile.ignored(rel, false) {
+		return true
+	}
+	for _, p := range u.exclude {
+		if p.match(rel) {
+			return true
+		}
+	}
+	for _, p := range u.include {
+		if p.match(rel) {
+			return false
+		}
+	}
+	return len(u.include) > 0
+}
+
 func shouldIgnore(f string) bool {
 	baseFile := filepath.Base(f)
 	return ignoreFiles[baseFile] || strings.HasPrefix(baseFile, ".")
@@ -145,132 +677,533 @@ func sleep(ctx context.Context, t time.Duration) error {
 	}
 }
 
-func waitForFileSizeToStabilize(ctx context.Context, f string) error {
-	var s, c int64
-	first := true
-	for {
-		if first {
-			log.Printf("Waiting for %s to stop growing...", f)
-			first = false
+func (u *Uploader) upload(ctx context.Context, f string) {
+	u.process(ctx, f)
+}
+
+// process takes f through the whole pipeline: waiting for it to be
+// complete, sending it and removing or keeping it. The error is that of a
+// failed wait or transfer, which have already been logged.
+func (u *Uploader) process(ctx context.Context, f string) error {
+	u.mu.Lock()
+	key := pathKey(f)
+	if u.inProgress[key] {
+		u.mu.Unlock()
+		return nil
+	}
+	u.inProgress[key] = true
+	u.mu.Unlock()
+
+	queued, offline := false, false
+	defer func() {
+		// Anything but waiting for the network takes f off the offline
+		// queue, unless we're shutting down.
+		if !offline && ctx.Err() == nil {
+			u.dequeueOffline(ctx, f)
+		}
+		if queued {
+			return
+		}
+		u.mu.Lock()
+		delete(u.inProgress, key)
+		u.mu.Unlock()
+	}()
+
+	// A trigger file already says the file is complete.
+	if u.opts.TriggerSuffix == "" {
+		u.emit(ctx, events.Event{Type: events.Waiting, File: f})
+		if err := u.waitStable(ctx, f); err != nil {
+			errorf(ctx, "failed waiting for file %s: %s", f, err)
+			u.emitFailure(ctx, f, err)
+			if isStabilityTimeout(err) {
+				u.failed(ctx, f, err)
+			}
+			return err
 		}
+	}
+
+	if u.abandonedBefore(ctx, f) || u.backingOff(ctx, f) {
+		return nil
+	}
+	if u.uploadedBefore(ctx, f) {
+		if u.opts.DryRun {
+			u.dryRunFinish(ctx, f)
+			return nil
+		}
+		queued = u.finish(ctx, f)
+		return nil
+	}
+	if ok, err := u.preUpload(ctx, f); !ok {
+		return err
+	}
+
+	var size int64
+	if u.opts.Budget != nil {
 		fi, err := os.Stat(f)
 		if err != nil {
+			errorf(ctx, "failed to stat file %s: %s", f, err)
+			u.emitFailure(ctx, f, err)
 			return err
 		}
-		if s == fi.Size() {
-			if c < 10 {
-				c++
-			} else {
-				return nil
-			}
-		} else {
-			// reset the count, in case the file size temporarily stalled.
-			c = 0
+		size = fi.Size()
+		if err := u.waitForBudget(ctx, f, size); err != nil {
+			return err
 		}
-		s = fi.Size()
-		if err := sleep(ctx, 1*time.Second); err != nil {
+	}
+	if err := u.acquireSlot(ctx); err != nil {
+		return err
+	}
+	// Checked holding the slot, so that no more uploads start when the
+	// schedule opens than there are slots.
+	if u.opts.Schedule != nil {
+		if err := u.waitForSchedule(ctx); err != nil {
+			u.releaseSlot()
 			return err
 		}
 	}
-	return nil // unreachable
+	if u.opts.DryRun {
+		err := u.dryRun(ctx, f)
+		u.releaseSlot()
+		if err != nil {
+			errorf(ctx, "DRY RUN: failed to check %s: %s", f, err)
+			return err
+		}
+		u.dryRunFinish(ctx, f)
+		return nil
+	}
+	err := u.transfer(ctx, f)
+	u.releaseSlot()
+	if err != nil {
+		// Failures while shutting down, or while the network is down,
+		// aren't the file's fault.
+		if ctx.Err() == nil {
+			if offline = isOffline(err); offline {
+				u.queueOffline(ctx, f, err)
+			} else {
+				u.failed(ctx, f, err)
+			}
+		}
+		return err
+	}
+	u.succeeded(f)
+	if u.opts.Budget != nil {
+		u.opts.Budget.spend(size)
+	}
+
+	queued = u.finish(ctx, f)
+	return nil
+}
+
+// finish keeps or removes f once it is in Drive, reporting whether its removal
+// was queued for retry.
+func (u *Uploader) finish(ctx context.Context, f string) bool {
+	if u.opts.KeepFiles {
+		u.consumeTrigger(ctx, f)
+		return false
+	}
+	return u.remove(ctx, f)
 }
 
-func waitForFileToClose(ctx context.Context, f string) error {
-	first := true
-	for {
-		if first {
-			log.Printf("Waiting for %s to be closed...", f)
-			first = false
+// acquireSlot blocks until fewer than MaxConcurrentUploads transfers are
+// running.
+func (u *Uploader) acquireSlot(ctx context.Context) error {
+	if u.slots == nil {
+		return nil
+	}
+	select {
+	case u.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (u *Uploader) releaseSlot() {
+	if u.slots != nil {
+		<-u.slots
+	}
+}
+
+// UploadFile uploads f to its destination without waiting for it to
+// stabilize and without removing it afterwards.
+func (u *Uploader) UploadFile(ctx context.Context, f string) error {
+	if correlationId(ctx) == "" {
+		ctx = withCorrelationId(ctx, newCorrelationId())
+	}
+	return u.transfer(ctx, f)
+}
+
+// transfer sends f to Photos or Drive. Failures are logged and emitted before
+// being returned.
+func (u *Uploader) transfer(ctx context.Context, f string) error {
+	j := u.journalEntry(f)
+	u.journal(ctx, j, journal.Started)
+	send := func(ctx context.Context) error {
+		if u.isPhoto(f) {
+			item, err := u.uploadPhoto(ctx, f)
+			if err != nil {
+				return fmt.Errorf("uploading to Photos: %w", err)
+			}
+			u.emit(ctx, events.Event{Type: events.Uploaded, File: f, PhotosItemId: item.Id})
+			j.PhotosItemId = item.Id
+			u.journal(ctx, j, journal.Uploaded)
+			return nil
 		}
-		isOpen, err := fileIsOpen(ctx, f)
+		r, copies, err := u.uploadEverywhere(ctx, f)
 		if err != nil {
 			return err
 		}
-		if !isOpen {
+		j.Destinations = copies
+		if r == nil {
+			u.emit(ctx, events.Event{Type: events.Uploaded, File: f, Size: j.Size})
+			u.journal(ctx, j, journal.Uploaded)
 			return nil
 		}
-		if err := sleep(ctx, 1*time.Second); err != nil {
-			return err
+		// The upload worked, so failing to share it isn't worth sending it
+		// again for.
+		if perms := u.permissionsFor(f); len(perms) > 0 {
+			if j.Link, err = u.share(ctx, r, perms); err != nil {
+				warnf(ctx, "failed to share %s: %s", f, err)
+			} else {
+				infof(ctx, "Shared %s: %s", f, j.Link)
+			}
+		}
+		u.emit(ctx, events.Event{Type: events.Uploaded, File: f, Size: r.file.Size, DriveFileId: r.file.Id, Link: j.Link})
+		u.record(ctx, f, r)
+		j.MD5, j.DriveFileId = r.md5, r.file.Id
+		if r.compression != "" {
+			j.Compression, j.UploadedSize, j.OriginalMD5 = r.compression, r.file.Size, r.originalMD5
 		}
+		u.journal(ctx, j, journal.Uploaded)
+		return nil
 	}
-	return nil // unreachable
-}
-
-func fileIsOpen(ctx context.Context, f string) (bool, error) {
-	lsof, err := exec.LookPath("lsof")
+	err := u.withDeadline(ctx, func(ctx context.Context) error {
+		return u.retryTransient(ctx, f, func() error {
+			return u.retryLocked(ctx, f, func() error { return u.watchStall(ctx, send) })
+		})
+	})
 	if err != nil {
-		return false, err
-	}
-	_, err = exec.CommandContext(ctx, lsof, "-w", "-F", "p", f).Output()
-	if err == nil {
-		return true, nil
-	}
-	var exitErr *exec.ExitError
-	if errors.As(err, &exitErr) {
-		// lsof will return an error return code and an empty stderr if the file is not open.
-		if string(exitErr.Stderr) == "" {
-			return false, nil
+		errorf(ctx, "failed to upload file %s: %s", f, err)
+		u.emitFailure(ctx, f, err)
+		j.Error = err.Error()
+		u.journal(ctx, j, journal.Failed)
+		// Uploads cut short by shutting down or losing the network will be
+		// tried again.
+		if ctx.Err() == nil && !isOffline(err) {
+			u.postUpload(ctx, f, j, err)
 		}
-		return false, err
+		return err
 	}
-	return false, err
+	u.postUpload(ctx, f, j, nil)
+	u.mu.Lock()
+	u.lastUpload = time.Now()
+	u.lastSuccess = u.lastUpload
+	u.mu.Unlock()
+	return nil
 }
 
-func (u *Uploader) upload(ctx context.Context, f string) {
-	u.mu.Lock()
-	if u.inProgress[f] {
+// record appends the upload of f to the manifest, if one is configured.
+func (u *Uploader) record(ctx context.Context, f string, up *uploaded) {
+	if u.opts.Manifest == nil {
 		return
 	}
-	u.inProgress[f] = true
-	u.mu.Unlock()
+	df := up.file
+	r := manifest.Record{
+		Path:        f,
+		Name:        df.Name,
+		Size:        df.Size,
+		MD5:         up.md5,
+		SHA256:      up.sha256,
+		DriveFileId: df.Id,
+		FolderId:    up.folderId,
+	}
+	if u.accounts.multi() {
+		r.Account = up.account.name
+	}
+	if up.compression != "" {
+		r.OriginalMD5 = up.originalMD5
+	}
+	if err := u.opts.Manifest.Append(r); err != nil {
+		errorf(ctx, "failed to record %s in manifest: %s", f, err)
+	}
+}
 
-	defer func() {
-		u.mu.Lock()
-		u.inProgress[f] = false
-		u.mu.Unlock()
-	}()
+func (u *Uploader) emit(ctx context.Context, e events.Event) {
+	u.dispatch(ctx, e, nil)
+}
 
-	if err := u.wait(ctx, f); err != nil {
-		log.Printf("failed waiting for file %s: %s", f, err)
-		return
+func (u *Uploader) emitFailure(ctx context.Context, f string, err error) {
+	u.dispatch(ctx, events.Event{Type: events.Failed, File: f, Error: err.Error()}, err)
+}
+
+// dispatch sends e to the sink and hooks. err is the error a Failed event
+// is for.
+func (u *Uploader) dispatch(ctx context.Context, e events.Event, err error) {
+	e.Id = correlationId(ctx)
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if u.opts.Events != nil {
+		u.opts.Events.Emit(e)
 	}
+	u.opts.Hooks.call(e, err)
+}
 
-	if err := u.doUpload(ctx, f); err != nil {
-		log.Printf("failed to upload file %s: %s", f, err)
-		return
+// recordMD5 adds the hex MD5 sum of the local file to the provenance of the
+// Drive file id, when it wasn't known before the upload. Failing to is only
+// logged, since the file is uploaded either way.
+func (u *Uploader) recordMD5(ctx context.Context, a *account, id, sum string) {
+	update := &drive.File{AppProperties: map[string]string{gdrive.AppPropertyMD5: sum}}
+	if _, err := a.drive.Files.Update(id, update).SupportsAllDrives(true).Fields("id").Context(ctx).Do(); err != nil {
+		errorf(ctx, "failed to record the checksum of file %s in Drive: %s", id, err)
 	}
+}
 
-	log.Printf("Removing %s", f)
-	if err := os.Remove(f); err != nil {
-		log.Printf("failed to delete file %s: %s", f, err)
-		return
+// hostname returns the name of this machine, or "" if it has none.
+func hostname() string {
+	h, err := os.Hostname()
+	if err != nil {
+		return ""
 	}
+	return h
 }
 
-func (u *Uploader) doUpload(ctx context.Context, name string) error {
-	log.Printf("Uploading file: %s", name)
+// provenance returns the appProperties recorded on an uploaded file, so that
+// restore can put it back where it was found and it can be recognized
+// later, whatever becomes of its name. sum is the hex MD5 of the local file,
+// if it is known yet.
+func (u *Uploader) provenance(name string, fi os.FileInfo, sum string) map[string]string {
+	props := map[string]string{
+		gdrive.AppPropertyUploader: gdrive.AppName,
+		gdrive.AppPropertyMtime:    fi.ModTime().UTC().Format(time.RFC3339),
+		gdrive.AppPropertyVersion:  gdrive.AppVersion(),
+		gdrive.AppPropertyUploaded: time.Now().UTC().Format(time.RFC3339),
+	}
+	if sum != "" {
+		props[gdrive.AppPropertyMD5] = sum
+	}
+	if u.hostname != "" && len(gdrive.AppPropertyHost)+len(u.hostname) <= gdrive.MaxAppPropertySize {
+		props[gdrive.AppPropertyHost] = u.hostname
+	}
+	if rel, err := filepath.Rel(u.rootOf(filepath.Dir(name)), name); err == nil {
+		rel = filepath.ToSlash(rel)
+		// Paths too long for an appProperty are left out rather than cut.
+		if len(gdrive.AppPropertyPath)+len(rel) <= gdrive.MaxAppPropertySize {
+			props[gdrive.AppPropertyPath] = rel
+		}
+	}
+	return props
+}
+
+// uploaded describes a file uploaded to Drive.
+type uploaded struct {
+	file     *drive.File
+	account  *account
+	folderId string
+	// Checksums of the bytes that were sent, hex encoded.
+	md5, sha256 string
+	// replaced is set when an existing file's contents were replaced
+	// rather than a new file created.
+	replaced bool
+
+	// compression is the format the file was compressed in before being
+	// sent, if it was, and originalMD5 the checksum of the local file.
+	compression, originalMD5 string
+}
 
+// doUpload uploads name to dest, or with dest nil to the account the pool
+// picks.
+func (u *Uploader) doUpload(ctx context.Context, name string, dest *account) (*uploaded, error) {
 	f, err := os.Open(name)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer f.Close()
 
 	fi, err := f.Stat()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	// The remote name and provenance describe the original, but everything
+	// else the bytes sent.
+	orig := fi
+	convert, ocrLanguage := u.conversionFor(name)
+	format, origMD5 := u.compressionFor(name), ""
+	// Drive can only convert the original.
+	if convert {
+		format = ""
+	}
+	if format != "" {
+		cf, sum, err := compressFile(f, fi, filepath.Base(name), format)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress %s: %w", name, err)
+		}
+		defer removeTemp(cf)
+		if fi, err = cf.Stat(); err != nil {
+			return nil, err
+		}
+		infof(ctx, "Compressed %s with %s from %s to %s", name, format, humanize.Bytes(uint64(orig.Size())), humanize.Bytes(uint64(fi.Size())))
+		f, origMD5 = cf, sum
+	}
+	a := dest
+	switch {
+	case dest != nil:
+		infof(ctx, "Uploading file: %s to %s", name, a.name)
+	case u.accounts.multi():
+		a = u.accounts.pick(ctx, fi.Size())
+		infof(ctx, "Uploading file: %s to account %s", name, a.name)
+	default:
+		a = u.accounts.pick(ctx, fi.Size())
+		infof(ctx, "Uploading file: %s", name)
 	}
+	u.emit(ctx, events.Event{Type: events.Uploading, File: name, Size: fi.Size()})
+	t := u.startTransfer(name, fi.Size())
+	defer u.endTransfer(name)
 
+	dir, base, err := u.remotePath(name, orig)
+	if err != nil {
+		return nil, err
+	}
+	if format != "" {
+		base += compressedTypes[format].ext
+	}
+	parent, err := u.folderFor(ctx, a, u.rootFolder(a, name), dir)
+	if err != nil {
+		return nil, err
+	}
+	dup, err := u.checkDuplicate(ctx, a, parent, base, f)
+	if err != nil {
+		return nil, err
+	}
+	if origMD5 == "" {
+		origMD5 = dup.md5
+	}
+	if dup.same != nil {
+		infof(ctx, "%s is already in Drive as %s; not uploading it again", name, dup.same.Id)
+		r, err := alreadyUploaded(a, parent, dup.same, f)
+		if r != nil {
+			r.compression, r.originalMD5 = format, origMD5
+		}
+		return r, err
+	}
 	driveFile := &drive.File{
-		Name:    filepath.Base(name),
-		Parents: []string{u.folderId},
+		Name:          dup.name,
+		Parents:       []string{parent},
+		AppProperties: u.provenance(name, orig, origMD5),
+		// So files sort by when they were scanned, not uploaded.
+		ModifiedTime: orig.ModTime().UTC().Format(time.RFC3339Nano),
+	}
+	if created, ok := birthTime(name, orig); ok {
+		driveFile.CreatedTime = created.UTC().Format(time.RFC3339Nano)
+	}
+	// Drive would otherwise guess from the name, or settle on
+	// application/octet-stream, and not preview the file.
+	var contentType string
+	if format != "" {
+		contentType = compressedTypes[format].mime
+		driveFile.AppProperties[gdrive.AppPropertyCompression] = format
+	} else if contentType, err = detectMimeType(f, name); err != nil {
+		return nil, err
+	}
+	driveFile.MimeType = contentType
+	if convert {
+		infof(ctx, "Converting %s to a Google Doc%s", name, ocrLanguageNote(ocrLanguage))
+		driveFile.MimeType = googleDocMimeType
 	}
-	progress := func(now, size int64) {
-		log.Printf("uploaded %s/%s of %s", humanize.Bytes(uint64(now)), humanize.Bytes(uint64(size)), name)
+	text, thumb := u.extractText(ctx, name), u.thumbnail(ctx, name)
+	if text != "" || thumb != nil {
+		driveFile.ContentHints = &drive.FileContentHints{IndexableText: text, Thumbnail: thumb}
+	}
+	chunkSize := u.opts.ChunkSize
+	if u.tuner != nil {
+		chunkSize = u.tuner.chunkSize()
+	}
+	size := fi.Size()
+	last, lastTime := int64(0), time.Now()
+	meter := u.newProgressMeter(size)
+	progress := func(now, _ int64) {
+		// The Drive client doesn't know the size of a streamed upload.
+		if meter.update(now) {
+			debugf(ctx, "uploaded %s of %s", meter, name)
+		}
+		u.emit(ctx, events.Event{Type: events.Progress, File: name, Bytes: now, Size: size})
+		u.updateTransfer(t, now)
+		progressed(ctx)
+		if u.tuner != nil && now-last == int64(chunkSize) {
+			u.tuner.observe(chunkSize, time.Since(lastTime))
+		}
+		last, lastTime = now, time.Now()
+	}
+	mediaOpts := []googleapi.MediaOption{googleapi.ContentType(contentType)}
+	if chunkSize > 0 {
+		mediaOpts = append(mediaOpts, googleapi.ChunkSize(chunkSize))
+	}
+	// Hash the file as it's sent rather than reading it again afterwards.
+	// The Drive client buffers each chunk for retries, so every byte passes
+	// through exactly once.
+	md5sum, sha := md5.New(), sha256.New()
+	hash := io.MultiWriter(md5sum, sha)
+	progressed(ctx)
+	var df *drive.File
+	if chunk := resumableChunkSize(chunkSize); dup.replace != nil {
+		if u.opts.OnDuplicate == DuplicateVersion {
+			if err := keepRevision(ctx, a, dup.replace); err != nil {
+				return nil, err
+			}
+		}
+		infof(ctx, "Replacing the contents of %s in Drive", dup.replace.Name)
+		update := &drive.File{AppProperties: driveFile.AppProperties, ContentHints: driveFile.ContentHints, ModifiedTime: driveFile.ModifiedTime}
+		call := a.drive.Files.Update(dup.replace.Id, update).
+			SupportsAllDrives(true).
+			Media(&progressReader{ctx, io.TeeReader(f, hash)}, mediaOpts...).
+			Context(ctx).
+			ProgressUpdater(progress).
+			Fields("id", "name", "size", "md5Checksum")
+		if ocrLanguage != "" {
+			call.OcrLanguage(ocrLanguage)
+		}
+		df, err = call.Do()
+	} else if u.opts.Sessions != nil && size > int64(chunk) && !convert {
+		df, err = u.resumableUpload(ctx, a, name, f, fi, driveFile, chunk, progress, hash)
+	} else {
+		body := &progressReader{ctx, io.TeeReader(f, hash)}
+		call := a.drive.Files.Create(driveFile).
+			SupportsAllDrives(true).
+			Media(body, mediaOpts...).
+			Context(ctx).
+			ProgressUpdater(progress).
+			Fields("id", "name", "size", "md5Checksum")
+		if ocrLanguage != "" {
+			call.OcrLanguage(ocrLanguage)
+		}
+		df, err = call.Do()
+	}
+	if err != nil && u.tuner != nil && isTimeout(err) {
+		u.tuner.timedOut()
 	}
-	_, err = u.drive.Files.Create(driveFile).ResumableMedia(ctx, f, fi.Size(), "").ProgressUpdater(progress).Do()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	sum := hex.EncodeToString(md5sum.Sum(nil))
+	if origMD5 == "" {
+		// Only known now that the file has been read to send it.
+		origMD5 = sum
+		u.recordMD5(ctx, a, df.Id, sum)
+	}
+	r := &uploaded{
+		file:     df,
+		account:  a,
+		folderId: parent,
+		md5:      sum,
+		sha256:   hex.EncodeToString(sha.Sum(nil)),
+		replaced: dup.replace != nil,
+
+		compression: format,
+		originalMD5: origMD5,
+	}
+	// A Google Doc has no checksum to match the bytes sent.
+	if convert {
+		r.md5, r.sha256 = "", ""
+	}
+	return r, nil
 }
@@ -0,0 +1,212 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dknowles2/gdrive_sync/gdrive"
+	"github.com/dknowles2/gdrive_sync/gdrive/fakedrive"
+)
+
+// newTestUploader returns an Uploader from a temporary input directory to
+// the folder "out" in a fake Drive.
+func newTestUploader(t *testing.T, opts Options) (*Uploader, *fakedrive.Server, string, string) {
+	t.Helper()
+	in, err := ioutil.TempDir("", "uploader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(in) })
+	fd := fakedrive.New()
+	t.Cleanup(fd.Close)
+	out := fd.AddFolder(fakedrive.RootId, "out")
+	d, err := fd.Service(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := New(in, "out", d, opts)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	return u, fd, in, out
+}
+
+func writeTestFile(t *testing.T, dir, name string, content []byte) string {
+	t.Helper()
+	f := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(f, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// testContent returns n bytes that differ from chunk to chunk.
+func testContent(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i / 1000)
+	}
+	return b
+}
+
+// checkUploaded fails the test unless folder holds exactly one file, named
+// name and holding content.
+func checkUploaded(t *testing.T, fd *fakedrive.Server, folder, name string, content []byte) {
+	t.Helper()
+	children := fd.Children(folder)
+	if len(children) != 1 {
+		t.Fatalf("got %d files in Drive, want 1", len(children))
+	}
+	df, ok := fd.Find(folder, name)
+	if !ok {
+		t.Fatalf("%s not found in Drive", name)
+	}
+	got, _ := fd.Content(df.Id)
+	if !bytes.Equal(got, content) {
+		t.Errorf("uploaded %d bytes, want the %d of the file", len(got), len(content))
+	}
+}
+
+// countRequests counts the requests fd served with the given method and
+// path prefix.
+func countRequests(fd *fakedrive.Server, prefix string) int {
+	n := 0
+	for _, r := range fd.Requests() {
+		if strings.HasPrefix(r, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestUploadFile(t *testing.T) {
+	u, fd, in, out := newTestUploader(t, Options{})
+	content := []byte("hello, drive")
+	f := writeTestFile(t, in, "hello.txt", content)
+	if err := u.UploadFile(context.Background(), f); err != nil {
+		t.Fatalf("UploadFile: %s", err)
+	}
+	checkUploaded(t, fd, out, "hello.txt", content)
+	df, _ := fd.Find(out, "hello.txt")
+	if df.AppProperties[gdrive.AppPropertyMD5] == "" {
+		t.Errorf("uploaded file has no %s app property", gdrive.AppPropertyMD5)
+	}
+
+	// The same contents again are recognised as a duplicate.
+	before := countRequests(fd, "POST /upload/")
+	if err := u.UploadFile(context.Background(), f); err != nil {
+		t.Fatalf("UploadFile again: %s", err)
+	}
+	if n := countRequests(fd, "POST /upload/") - before; n != 0 {
+		t.Errorf("uploading the same file again sent %d uploads, want 0", n)
+	}
+	checkUploaded(t, fd, out, "hello.txt", content)
+}
+
+func TestUploadFileRetries(t *testing.T) {
+	defer func(d time.Duration) { retryBaseDelay = d }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+	u, fd, in, out := newTestUploader(t, Options{MaxAttempts: 3})
+	content := []byte("retried")
+	f := writeTestFile(t, in, "retried.txt", content)
+
+	fd.FailNext(http.StatusServiceUnavailable, 2)
+	if err := u.UploadFile(context.Background(), f); err != nil {
+		t.Fatalf("UploadFile: %s", err)
+	}
+	checkUploaded(t, fd, out, "retried.txt", content)
+}
+
+func TestUploadFileGivesUp(t *testing.T) {
+	defer func(d time.Duration) { retryBaseDelay = d }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+	u, fd, in, out := newTestUploader(t, Options{MaxAttempts: 2})
+	f := writeTestFile(t, in, "failed.txt", []byte("failed"))
+
+	fd.FailNext(http.StatusServiceUnavailable, 10)
+	err := u.UploadFile(context.Background(), f)
+	if err == nil {
+		t.Fatal("UploadFile succeeded with Drive failing")
+	}
+	var exhausted *exhaustedError
+	if !errors.As(err, &exhausted) || exhausted.attempts != 2 {
+		t.Errorf("UploadFile = %q, want it to give up after 2 attempts", err)
+	}
+	if n := len(fd.Children(out)); n != 0 {
+		t.Errorf("got %d files in Drive, want none", n)
+	}
+}
+
+func TestUploadFileResumes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sessions, err := NewSessions(filepath.Join(dir, "sessions.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const chunk = 256 << 10
+	u, fd, in, out := newTestUploader(t, Options{Sessions: sessions, ChunkSize: chunk, MaxAttempts: 1})
+	content := testContent(3*chunk + 100)
+	f := writeTestFile(t, in, "big.bin", content)
+
+	// Cut the upload off after its first chunk.
+	chunks := 0
+	fd.Fault = func(r *http.Request) int {
+		if r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/upload/resumable/") {
+			if chunks++; chunks > 1 {
+				return http.StatusBadRequest
+			}
+		}
+		return 0
+	}
+	if err := u.UploadFile(context.Background(), f); err == nil {
+		t.Fatal("UploadFile succeeded with the upload cut off")
+	}
+	if n := len(fd.Children(out)); n != 0 {
+		t.Fatalf("got %d files in Drive after the cut-off upload, want none", n)
+	}
+
+	// A new Uploader, as after a restart, picks up the saved session.
+	fd.Fault = nil
+	if sessions, err = NewSessions(filepath.Join(dir, "sessions.json")); err != nil {
+		t.Fatal(err)
+	}
+	d, err := fd.Service(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err = New(in, "out", d, Options{Sessions: sessions, ChunkSize: chunk})
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	if err := u.UploadFile(context.Background(), f); err != nil {
+		t.Fatalf("UploadFile resuming: %s", err)
+	}
+	checkUploaded(t, fd, out, "big.bin", content)
+	if n := countRequests(fd, "POST /upload/drive/v3/files"); n != 1 {
+		t.Errorf("started %d upload sessions, want 1", n)
+	}
+	if _, ok := sessions.get(f, u.accounts.accounts[0].name, mustStat(t, f)); ok {
+		t.Error("the finished upload's session is still saved")
+	}
+}
+
+func mustStat(t *testing.T, f string) os.FileInfo {
+	t.Helper()
+	fi, err := os.Stat(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi
+}
@@ -0,0 +1,126 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// stalledError means an upload was aborted for making no progress.
+type stalledError struct {
+	after time.Duration
+}
+
+func (e *stalledError) Error() string {
+	return fmt.Sprintf("upload stalled: no progress for %s", e.after)
+}
+
+// deadlineError means an upload was aborted for taking longer than
+// UploadTimeout.
+type deadlineError struct {
+	after time.Duration
+}
+
+func (e *deadlineError) Error() string {
+	return fmt.Sprintf("upload timed out after %s", e.after)
+}
+
+// watchdog cancels an upload that stops making progress once it has begun
+// sending the file.
+type watchdog struct {
+	mu      sync.Mutex
+	armed   bool
+	last    time.Time
+	stalled bool
+}
+
+type watchdogKey struct{}
+
+// progressed tells the watchdog of the upload ctx is for, if it has one,
+// that the upload is sending the file and hasn't stalled. The first call
+// arms it, so checking for duplicates, OCR and the like don't count.
+func progressed(ctx context.Context) {
+	w, _ := ctx.Value(watchdogKey{}).(*watchdog)
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	w.armed, w.last = true, time.Now()
+	w.mu.Unlock()
+}
+
+// watchStall calls fn with a context that is cancelled if the upload stalls
+// for StallTimeout, returning a *stalledError if it was.
+func (u *Uploader) watchStall(ctx context.Context, fn func(ctx context.Context) error) error {
+	d := u.opts.StallTimeout
+	if d <= 0 {
+		return fn(ctx)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := &watchdog{}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		interval := d / 4
+		if interval <= 0 {
+			interval = d
+		}
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+			case <-done:
+				return
+			}
+			w.mu.Lock()
+			w.stalled = w.armed && time.Since(w.last) >= d
+			w.mu.Unlock()
+			if w.stalled {
+				cancel()
+				return
+			}
+		}
+	}()
+	err := fn(context.WithValue(ctx, watchdogKey{}, w))
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err != nil && w.stalled {
+		return &stalledError{after: d}
+	}
+	return err
+}
+
+// withDeadline calls fn with a context that is cancelled after
+// UploadTimeout, returning a *deadlineError if it was.
+func (u *Uploader) withDeadline(ctx context.Context, fn func(ctx context.Context) error) error {
+	d := u.opts.UploadTimeout
+	if d <= 0 {
+		return fn(ctx)
+	}
+	dctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	err := fn(dctx)
+	if err != nil && ctx.Err() == nil && errors.Is(dctx.Err(), context.DeadlineExceeded) {
+		return &deadlineError{after: d}
+	}
+	return err
+}
+
+// progressReader reports reads of an upload's contents to its watchdog.
+type progressReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		progressed(r.ctx)
+	}
+	return n, err
+}
@@ -0,0 +1,52 @@
+package uploader
+
+import "github.com/fsnotify/fsnotify"
+
+// fileWatcher reports changes to files in the directories added to it. Only
+// direct children of an added directory are reported.
+type fileWatcher interface {
+	Add(name string) error
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+	Close() error
+}
+
+// fsnotifyWatcher is the inotify/kqueue/ReadDirectoryChangesW implementation.
+// Directories are watched by their long path on Windows, and event names are
+// converted back so they match the paths the Uploader walks.
+type fsnotifyWatcher struct {
+	w      *fsnotify.Watcher
+	events chan fsnotify.Event
+	done   chan struct{}
+}
+
+func newFsnotifyWatcher() (fileWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	fw := &fsnotifyWatcher{w: w, events: make(chan fsnotify.Event), done: make(chan struct{})}
+	go fw.forward()
+	return fw, nil
+}
+
+func (w *fsnotifyWatcher) forward() {
+	defer close(w.events)
+	for e := range w.w.Events {
+		e.Name = shortPath(e.Name)
+		select {
+		case w.events <- e:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *fsnotifyWatcher) Add(name string) error         { return w.w.Add(longPath(name)) }
+func (w *fsnotifyWatcher) Events() <-chan fsnotify.Event { return w.events }
+func (w *fsnotifyWatcher) Errors() <-chan error          { return w.w.Errors }
+
+func (w *fsnotifyWatcher) Close() error {
+	close(w.done)
+	return w.w.Close()
+}
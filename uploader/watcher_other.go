@@ -0,0 +1,8 @@
+//go:build !darwin || !cgo
+// +build !darwin !cgo
+
+package uploader
+
+func newFileWatcher() (fileWatcher, error) {
+	return newFsnotifyWatcher()
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+
+	"github.com/dknowles2/gdrive_sync/gdrive"
+	"github.com/dknowles2/gdrive_sync/journal"
+	"github.com/dknowles2/gdrive_sync/manifest"
+	"github.com/dknowles2/gdrive_sync/uploader"
+	"google.golang.org/api/googleapi"
+)
+
+// verify checks that every file recorded in the manifest, or failing that
+// the journal, still exists in Drive with the size and checksum it was
+// uploaded with. With -archive it compares an archive directory against the
+// output folder instead, as fsck does.
+func verify(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	reuploadFrom := fs.String("reupload_from", "", "Local archive directory to re-upload missing or modified files from; needs --manifest_file")
+	archive := fs.String("archive", "", "Compare this local archive directory against --output_dir by name, size and MD5 instead of checking recorded uploads")
+	fs.Parse(args)
+
+	if *archive != "" {
+		return fsck(ctx, []string{*archive})
+	}
+	var records []manifest.Record
+	var err error
+	switch {
+	case *manifestFile != "":
+		if records, err = manifest.Load(*manifestFile); err != nil {
+			return err
+		}
+		records = latestRecords(records)
+	case *journalFile != "" && *reuploadFrom == "":
+		if records, err = journalRecords(*journalFile); err != nil {
+			return err
+		}
+	case *journalFile != "":
+		return errors.New("--reupload_from needs --manifest_file")
+	default:
+		return errors.New("--manifest_file or --journal_file is required")
+	}
+	services, err := accountServices(ctx)
+	if err != nil {
+		return err
+	}
+	// Re-uploads go through the pipeline, which records them in the
+	// manifest.
+	var us []*uploader.Uploader
+	if *reuploadFrom != "" {
+		var cleanup func()
+		if us, cleanup, err = newUploaders(ctx); err != nil {
+			return err
+		}
+		defer cleanup()
+	}
+
+	var ok, drifted int
+	for _, r := range records {
+		service, found := services[r.Account]
+		if !found {
+			log.Printf("Skipping %s: account %q is not in --accounts", r.Name, r.Account)
+			continue
+		}
+		status, err := checkRecord(service, r)
+		if err != nil {
+			return err
+		}
+		if status == "deleted" && *manifestFile == "" {
+			// The journal doesn't say which account uploaded the file.
+			if status, service, err = checkOtherAccounts(services, service, r); err != nil {
+				return err
+			}
+		}
+		if status == "" {
+			ok++
+			continue
+		}
+		drifted++
+		fmt.Printf("%s\t%s\t%s\n", status, r.DriveFileId, r.Name)
+		if us != nil {
+			if err := reuploadRecord(ctx, us, r, *reuploadFrom); err != nil {
+				log.Printf("failed to re-upload %s: %s", r.Name, err)
+			}
+		}
+	}
+	log.Printf("%d files verified, %d drifted", ok, drifted)
+	if drifted > 0 && us == nil {
+		return fmt.Errorf("%d files drifted", drifted)
+	}
+	return nil
+}
+
+// journalRecords returns the last Drive upload of each path in the journal
+// at path as a manifest record, without the folder and account the journal
+// doesn't keep.
+func journalRecords(path string) ([]manifest.Record, error) {
+	entries, err := journal.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	last := make(map[string]int)
+	var records []manifest.Record
+	for _, e := range entries {
+		// Files sent to Google Photos have no Drive ID to check.
+		if e.Status != journal.Uploaded || e.DriveFileId == "" {
+			continue
+		}
+		r := manifest.Record{
+			Time:        e.Time,
+			Path:        e.Path,
+			Name:        filepath.Base(e.Path),
+			Size:        e.Size,
+			MD5:         e.MD5,
+			DriveFileId: e.DriveFileId,
+		}
+		if e.Compression != "" {
+			r.Size = e.UploadedSize
+		}
+		if i, ok := last[e.Path]; ok {
+			records[i] = r
+			continue
+		}
+		last[e.Path] = len(records)
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// checkOtherAccounts looks for r, not found through tried, with the other
+// accounts, returning the status through the first that can see it.
+func checkOtherAccounts(services map[string]*gdrive.Drive, tried *gdrive.Drive, r manifest.Record) (string, *gdrive.Drive, error) {
+	for _, d := range services {
+		if d == tried {
+			continue
+		}
+		status, err := checkRecord(d, r)
+		if err != nil || status != "deleted" {
+			return status, d, err
+		}
+	}
+	return "deleted", tried, nil
+}
+
+// latestRecords drops records superseded by a later upload of the same path.
+func latestRecords(records []manifest.Record) []manifest.Record {
+	last := make(map[string]int)
+	for i, r := range records {
+		last[r.Path] = i
+	}
+	var out []manifest.Record
+	for i, r := range records {
+		if last[r.Path] == i {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// checkRecord returns "" if the file is intact, or a short description of the
+// drift otherwise.
+func checkRecord(d *gdrive.Drive, r manifest.Record) (string, error) {
+	f, err := d.Files.Get(r.DriveFileId).SupportsAllDrives(true).Fields("id", "size", "md5Checksum", "trashed").Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return "deleted", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to get %s: %w", r.Name, err)
+	}
+	switch {
+	case f.Trashed:
+		return "trashed", nil
+	case r.Size > 0 && f.Size != r.Size:
+		return "resized", nil
+	case r.MD5 != "" && f.Md5Checksum != r.MD5:
+		return "modified", nil
+	}
+	return "", nil
+}
+
+// reuploadRecord uploads the archived copy of r in the archive at dir again,
+// through the Uploader of the pair r came from.
+func reuploadRecord(ctx context.Context, us []*uploader.Uploader, r manifest.Record, dir string) error {
+	u, err := uploaderFor(us, r.Path)
+	if err != nil {
+		return err
+	}
+	sum := r.MD5
+	if r.OriginalMD5 != "" {
+		sum = r.OriginalMD5
+	}
+	p, err := u.FindArchived(dir, r.Path, r.Time, sum)
+	if err != nil {
+		return err
+	}
+	if err := u.UploadFile(ctx, p); err != nil {
+		return err
+	}
+	log.Printf("Re-uploaded %s from %s", r.Name, p)
+	return nil
+}
+
+// uploaderFor returns the Uploader of the pair whose input or archive
+// directory holds f.
+func uploaderFor(us []*uploader.Uploader, f string) (*uploader.Uploader, error) {
+	for _, u := range us {
+		if u.Owns(f) {
+			return u, nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not in the input or archive directory of any pair", f)
+}